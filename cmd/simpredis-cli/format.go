@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 和redis-cli一样输出回复：字符串加引号，整数前加(integer)，数组的元素带有序号，嵌套的数组缩进显示。
+// RESP3的Map显示为"1# key => value"，Set的序号使用~
+func formatReply(reply parser.RespData) string {
+	var sb strings.Builder
+	writeReply(&sb, reply, "")
+	return sb.String()
+}
+
+// indent 是嵌套数组第二个元素开始需要的缩进
+func writeReply(sb *strings.Builder, reply parser.RespData, indent string) {
+	switch r := reply.(type) {
+	case *parser.String:
+		sb.WriteString(r.Arg)
+		sb.WriteByte('\n')
+	case *parser.Error:
+		sb.WriteString("(error) ")
+		sb.WriteString(r.Arg)
+		sb.WriteByte('\n')
+	case *parser.Integer:
+		sb.WriteString("(integer) ")
+		sb.WriteString(strconv.FormatInt(r.Arg, 10))
+		sb.WriteByte('\n')
+	case *parser.BulkString:
+		writeBulk(sb, r.Arg)
+	case *parser.NullArray:
+		sb.WriteString("(nil)\n")
+	case *parser.Array:
+		writeElems(sb, len(r.Args), indent, ')', "(empty array)", func(i int, indent string) {
+			writeBulk(sb, r.Args[i])
+		})
+	case *parser.MultiBulk:
+		writeElems(sb, len(r.Args), indent, ')', "(empty array)", func(i int, indent string) {
+			writeReply(sb, r.Args[i], indent)
+		})
+	case *parser.Push:
+		writeElems(sb, len(r.Args), indent, ')', "(empty push)", func(i int, indent string) {
+			writeReply(sb, r.Args[i], indent)
+		})
+	case *parser.Set:
+		writeElems(sb, len(r.Args), indent, '~', "(empty set)", func(i int, indent string) {
+			writeReply(sb, r.Args[i], indent)
+		})
+	case *parser.Map:
+		writeElems(sb, len(r.Keys), indent, '#', "(empty hash)", func(i int, indent string) {
+			// key之后的换行替换为=>，value和key使用相同的缩进
+			var key strings.Builder
+			writeReply(&key, r.Keys[i], indent)
+			sb.WriteString(strings.TrimSuffix(key.String(), "\n"))
+			sb.WriteString(" => ")
+			writeReply(sb, r.Values[i], indent)
+		})
+	case *parser.Double:
+		sb.WriteString("(double) ")
+		sb.WriteString(formatDouble(r.Arg))
+		sb.WriteByte('\n')
+	case *parser.Boolean:
+		if r.Arg {
+			sb.WriteString("(true)\n")
+		} else {
+			sb.WriteString("(false)\n")
+		}
+	case *parser.Null:
+		sb.WriteString("(nil)\n")
+	case *parser.BigNumber:
+		sb.WriteString("(big number) ")
+		sb.WriteString(r.Arg.String())
+		sb.WriteByte('\n')
+	case *parser.Verbatim:
+		sb.Write(r.Arg)
+		sb.WriteByte('\n')
+	default:
+		sb.Write(reply.Serialize())
+	}
+}
+
+// 和服务端的编码相同，无穷大显示为inf和-inf
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func writeBulk(sb *strings.Builder, arg []byte) {
+	if arg == nil {
+		sb.WriteString("(nil)\n")
+		return
+	}
+	sb.WriteString(quote(arg))
+	sb.WriteByte('\n')
+}
+
+// 序号按照最大序号的宽度右对齐，后面是sep，元素从序号之后开始输出。没有元素时输出empty
+func writeElems(sb *strings.Builder, n int, indent string, sep byte, empty string, elem func(i int, indent string)) {
+	if n == 0 {
+		sb.WriteString(empty)
+		sb.WriteByte('\n')
+		return
+	}
+	width := len(strconv.Itoa(n))
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(indent)
+		}
+		num := strconv.Itoa(i + 1)
+		prefix := strings.Repeat(" ", width-len(num)) + num + string(sep) + " "
+		sb.WriteString(prefix)
+		elem(i, indent+strings.Repeat(" ", len(prefix)))
+	}
+}
+
+// 用双引号包裹，不可打印的字符转义为\xHH
+func quote(arg []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range arg {
+		switch c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\a':
+			sb.WriteString("\\a")
+		case '\b':
+			sb.WriteString("\\b")
+		default:
+			if c < ' ' || c > '~' {
+				sb.WriteString("\\x")
+				sb.WriteByte("0123456789abcdef"[c>>4])
+				sb.WriteByte("0123456789abcdef"[c&0xf])
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// 输出不是终端时使用原始格式，方便脚本处理：不加引号和类型，数组每个元素一行
+func formatRaw(reply parser.RespData) string {
+	var sb strings.Builder
+	writeRaw(&sb, reply)
+	sb.WriteByte('\n')
+	return sb.String()
+}
+
+func writeRaw(sb *strings.Builder, reply parser.RespData) {
+	switch r := reply.(type) {
+	case *parser.String:
+		sb.WriteString(r.Arg)
+	case *parser.Error:
+		sb.WriteString(r.Arg)
+	case *parser.Integer:
+		sb.WriteString(strconv.FormatInt(r.Arg, 10))
+	case *parser.BulkString:
+		sb.Write(r.Arg)
+	case *parser.NullArray:
+	case *parser.Array:
+		for i, arg := range r.Args {
+			if i > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.Write(arg)
+		}
+	case *parser.MultiBulk:
+		writeRawElems(sb, r.Args)
+	case *parser.Push:
+		writeRawElems(sb, r.Args)
+	case *parser.Set:
+		writeRawElems(sb, r.Args)
+	case *parser.Map:
+		// 和redis-cli一样，key和value各占一行
+		elems := make([]parser.RespData, 0, len(r.Keys)*2)
+		for i := range r.Keys {
+			elems = append(elems, r.Keys[i], r.Values[i])
+		}
+		writeRawElems(sb, elems)
+	case *parser.Double:
+		sb.WriteString(formatDouble(r.Arg))
+	case *parser.Boolean:
+		if r.Arg {
+			sb.WriteString("(true)")
+		} else {
+			sb.WriteString("(false)")
+		}
+	case *parser.Null:
+	case *parser.BigNumber:
+		sb.WriteString(r.Arg.String())
+	case *parser.Verbatim:
+		sb.Write(r.Arg)
+	default:
+		sb.Write(reply.Serialize())
+	}
+}
+
+func writeRawElems(sb *strings.Builder, elems []parser.RespData) {
+	for i, elem := range elems {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		writeRaw(sb, elem)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+func TestFormatReply(t *testing.T) {
+	nested := parser.NewMultiBulk([]parser.RespData{
+		parser.NewBulkString([]byte("a")),
+		parser.NewArray([][]byte{[]byte("b"), nil}),
+		parser.NewInteger(3),
+	})
+	resp3Map := parser.NewMap()
+	resp3Map.Add(parser.NewBulkString([]byte("a")), parser.NewInteger(1))
+	resp3Map.Add(parser.NewBulkString([]byte("b")), parser.NewSet([]parser.RespData{parser.NewDouble(1.5), parser.NewNull()}))
+	many := make([][]byte, 10)
+	for i := range many {
+		many[i] = []byte{'0' + byte(i)}
+	}
+	cases := []struct {
+		reply parser.RespData
+		want  string
+		raw   string
+	}{
+		{parser.NewString("OK"), "OK\n", "OK\n"},
+		{parser.NewError("ERR wrong"), "(error) ERR wrong\n", "ERR wrong\n"},
+		{parser.NewInteger(1), "(integer) 1\n", "1\n"},
+		{parser.NewBulkString(nil), "(nil)\n", "\n"},
+		{parser.NewBulkString([]byte("a\"b\n\x00")), "\"a\\\"b\\n\\x00\"\n", "a\"b\n\x00\n"},
+		{parser.NewArray(nil), "(empty array)\n", "\n"},
+		{nested, "1) \"a\"\n2) 1) \"b\"\n   2) (nil)\n3) (integer) 3\n", "a\nb\n\n3\n"},
+		{resp3Map, "1# \"a\" => (integer) 1\n2# \"b\" => 1~ (double) 1.5\n   2~ (nil)\n", "a\n1\nb\n1.5\n\n"},
+		{parser.NewMap(), "(empty hash)\n", "\n"},
+		{parser.NewSet(nil), "(empty set)\n", "\n"},
+		{parser.NewDouble(math.Inf(-1)), "(double) -inf\n", "-inf\n"},
+		{parser.NewBoolean(true), "(true)\n", "(true)\n"},
+		{parser.NewNull(), "(nil)\n", "\n"},
+		{parser.NewBigNumber(big.NewInt(12)), "(big number) 12\n", "12\n"},
+		{parser.NewVerbatim("txt", []byte("a b")), "a b\n", "a b\n"},
+		{parser.NewArray(many), " 1) \"0\"\n 2) \"1\"\n 3) \"2\"\n 4) \"3\"\n 5) \"4\"\n 6) \"5\"\n 7) \"6\"\n 8) \"7\"\n 9) \"8\"\n10) \"9\"\n", "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n"},
+	}
+	for _, c := range cases {
+		if got := formatReply(c.reply); got != c.want {
+			t.Logf("formatReply(%q): want %q, got %q", c.reply.Serialize(), c.want, got)
+			t.Fail()
+		}
+		if got := formatRaw(c.reply); got != c.raw {
+			t.Logf("formatRaw(%q): want %q, got %q", c.reply.Serialize(), c.raw, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// simpredis-cli 是simpredis的命令行客户端，用法和redis-cli类似：
+//
+//	simpredis-cli [-h host] [-p port] [-a password] [-n db] [cmd [arg ...]]
+//
+// 不带命令时进入交互模式，带命令时执行一次后退出，--pipe从标准输入读取RESP数据批量导入
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/client"
+)
+
+const historyFile = ".simpredis_cli_history"
+
+type cli struct {
+	host     string
+	port     int
+	password string
+	db       int
+	protocol int // 通过hello协商的协议版本，重新连接后保持不变
+
+	client *client.Client
+	raw    bool // 输出是否使用原始格式
+	out    io.Writer
+}
+
+func main() {
+	c := &cli{out: os.Stdout}
+	flag.StringVar(&c.host, "h", "127.0.0.1", "server hostname")
+	flag.IntVar(&c.port, "p", 7000, "server port")
+	flag.StringVar(&c.password, "a", "", "password to use when connecting to the server")
+	flag.IntVar(&c.db, "n", 0, "database number")
+	pipe := flag.Bool("pipe", false, "transfer raw RESP protocol from stdin to server")
+	flag.Parse()
+
+	if *pipe {
+		stats, err := runPipe(c.addr(), c.password, c.db, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERR %v\n", err)
+			os.Exit(1)
+		}
+		if stats.errors > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	c.raw = !isTerminal(int(os.Stdout.Fd()))
+	c.connect()
+	defer c.client.Close()
+
+	// 一次性模式，用于脚本
+	if flag.NArg() > 0 {
+		args := make([][]byte, 0, flag.NArg())
+		for _, arg := range flag.Args() {
+			args = append(args, []byte(arg))
+		}
+		if !c.exec(args) {
+			os.Exit(1)
+		}
+		return
+	}
+	c.repl()
+}
+
+func (c *cli) addr() string {
+	return net.JoinHostPort(c.host, strconv.Itoa(c.port))
+}
+
+func (c *cli) connect() {
+	if c.client != nil {
+		c.client.Close()
+	}
+	// 交互模式下命令可能长时间阻塞，不设置读超时，也不自动重试
+	c.client = client.NewClient(&client.Options{
+		Addr:        c.addr(),
+		Password:    c.password,
+		DB:          c.db,
+		Protocol:    c.protocol,
+		ReadTimeout: -1,
+		PoolSize:    1,
+		MaxRetries:  -1,
+	})
+}
+
+func (c *cli) prompt() string {
+	if c.db != 0 {
+		return fmt.Sprintf("%s[%d]> ", c.addr(), c.db)
+	}
+	return c.addr() + "> "
+}
+
+// 执行一条命令并输出回复，连接失败或者回复无法解析时返回false
+func (c *cli) exec(args [][]byte) bool {
+	cmd := make([]any, len(args))
+	for i, arg := range args {
+		cmd[i] = arg
+	}
+	reply, err := c.client.Do(context.Background(), cmd...)
+	if err != nil {
+		var e client.Error
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &e):
+			reply = parser.NewError(string(e))
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			fmt.Fprintf(c.out, "Could not connect to simpredis at %s: %v\n", c.addr(), err)
+			return false
+		default:
+			// 连接已经建立，读写或者解析回复时出错
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+			return false
+		}
+	}
+	c.print(reply)
+
+	// 切换数据库或者协议后重新建立连接，使连接池中的连接都使用新的设置
+	if _, ok := reply.(*parser.Error); ok || len(args) < 2 {
+		return true
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "select":
+		if db, err := strconv.Atoi(string(args[1])); err == nil && len(args) == 2 {
+			c.db = db
+			c.connect()
+		}
+	case "hello":
+		if protocol, err := strconv.Atoi(string(args[1])); err == nil {
+			c.protocol = protocol
+			c.connect()
+		}
+	}
+	return true
+}
+
+func (c *cli) print(reply parser.RespData) {
+	if c.raw {
+		io.WriteString(c.out, formatRaw(reply))
+	} else {
+		io.WriteString(c.out, formatReply(reply))
+	}
+}
+
+// 交互模式，标准输入是终端时支持行编辑和历史记录
+func (c *cli) repl() {
+	readLine := c.lineReader()
+	for {
+		line, err := readLine(c.prompt())
+		if err == errInterrupt {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.EqualFold(line, "quit") || strings.EqualFold(line, "exit") {
+			return
+		}
+		args, err := client.SplitArgs(line)
+		if err != nil {
+			fmt.Fprintln(c.out, "Invalid argument(s)")
+			continue
+		}
+		if len(args) > 0 {
+			c.exec(args)
+		}
+	}
+}
+
+func (c *cli) lineReader() func(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !isTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(nil, 512*1024*1024)
+		return func(string) (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+	}
+
+	editor := newLineEditor(os.Stdin, os.Stdout)
+	if home, err := os.UserHomeDir(); err == nil {
+		editor.loadHistory(filepath.Join(home, historyFile))
+	}
+	return func(prompt string) (string, error) {
+		restore, err := makeRaw(fd)
+		if err != nil {
+			return "", err
+		}
+		line, err := editor.readLine(prompt)
+		restore()
+		if err == nil && strings.TrimSpace(line) != "" {
+			editor.saveHistory(line)
+		}
+		return line, err
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/HK40404/simpredis/redis/database"
+	handler "github.com/HK40404/simpredis/redis/server"
+	"github.com/HK40404/simpredis/utils/client"
+)
+
+// 启动一个进程内的simpredis，返回地址
+func startServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	h := handler.NewHandler(database.NewDBEngine())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func newTestCli(t *testing.T, addr string) (*cli, *bytes.Buffer) {
+	host, port, _ := net.SplitHostPort(addr)
+	out := &bytes.Buffer{}
+	c := &cli{host: host, out: out}
+	c.port, _ = strconv.Atoi(port)
+	c.connect()
+	t.Cleanup(func() { c.client.Close() })
+	return c, out
+}
+
+func TestExec(t *testing.T) {
+	c, out := newTestCli(t, startServer(t))
+	run := func(line string) string {
+		out.Reset()
+		if !c.exec(client.LineToArgs(line)) {
+			t.Logf("%s: %q", line, out.String())
+			t.Fail()
+		}
+		return out.String()
+	}
+
+	if got := run("hello 3"); !strings.HasPrefix(got, "1# \"server\" => \"simpredis\"\n") || !strings.Contains(got, "# \"proto\" => (integer) 3\n") {
+		t.Logf("wrong hello reply %q", got)
+		t.Fail()
+	}
+	run("hset h f v")
+	run("sadd s a")
+	run("zadd z 1.5 m")
+	// select之后重新连接，仍然使用RESP3
+	run("select 1")
+	run("select 0")
+	cases := []struct{ line, want string }{
+		{"hgetall h", "1# \"f\" => \"v\"\n"},
+		{"smembers s", "1~ \"a\"\n"},
+		{"zscore z m", "(double) 1.5\n"},
+		{"get nokey", "(nil)\n"},
+		{"hgetall nokey", "(empty hash)\n"},
+		{"lpush h x", "(error) WRONGTYPE Operation against a key holding the wrong kind of value\n"},
+	}
+	for _, test := range cases {
+		if got := run(test.line); got != test.want {
+			t.Logf("%s: want %q, got %q", test.line, test.want, got)
+			t.Fail()
+		}
+	}
+	if got := run("hello 2"); !strings.Contains(got, "\"proto\"\n") || run("hgetall h") != "1) \"f\"\n2) \"v\"\n" {
+		t.Log("hello 2 should switch back to RESP2")
+		t.Fail()
+	}
+}
+
+// 只有建立连接失败时才提示无法连接，回复无法解析时输出错误
+func TestExecErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("?bad\r\n"))
+		}
+	}()
+	c, out := newTestCli(t, ln.Addr().String())
+	if c.exec(client.LineToArgs("ping")) || out.String() != "Error: Protocol error: unknown data type\n" {
+		t.Logf("wrong output %q", out.String())
+		t.Fail()
+	}
+
+	ln.Close()
+	out.Reset()
+	if c.exec(client.LineToArgs("ping")) || !strings.HasPrefix(out.String(), "Could not connect to simpredis at "+ln.Addr().String()) {
+		t.Logf("wrong output %q", out.String())
+		t.Fail()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 批量导入的结果
+type pipeStats struct {
+	errors  int
+	replies int
+}
+
+// runPipe 把in中的RESP数据原样发送给服务端，同时读取并统计回复，错误回复输出到out。
+// 数据发送完后再发送一条echo命令，收到它的回复说明之前的命令都已经执行完
+func runPipe(addr, password string, db int, in io.Reader, out io.Writer) (pipeStats, error) {
+	var stats pipeStats
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return stats, err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// 认证和选择数据库的回复不计入统计
+	var setup [][]byte
+	if password != "" {
+		setup = append(setup, parser.NewArray([][]byte{[]byte("auth"), []byte(password)}).Serialize())
+	}
+	if db != 0 {
+		setup = append(setup, parser.NewArray([][]byte{[]byte("select"), []byte(fmt.Sprint(db))}).Serialize())
+	}
+	for _, cmd := range setup {
+		if _, err := conn.Write(cmd); err != nil {
+			return stats, err
+		}
+		reply, err := parser.ReadReply(reader)
+		if err != nil {
+			return stats, err
+		}
+		if e, ok := reply.(*parser.Error); ok {
+			return stats, fmt.Errorf("%s", e.Arg)
+		}
+	}
+
+	marker := make([]byte, 20)
+	if _, err := rand.Read(marker); err != nil {
+		return stats, err
+	}
+	marker = []byte(hex.EncodeToString(marker))
+
+	// 一边发送一边读取回复，防止双方的缓冲区都写满后互相等待
+	out = &syncWriter{w: out}
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, in)
+		if err == nil {
+			fmt.Fprintln(out, "All data transferred. Waiting for the last reply...")
+			_, err = conn.Write(parser.NewArray([][]byte{[]byte("echo"), marker}).Serialize())
+		}
+		writeErr <- err
+	}()
+
+	for {
+		reply, err := parser.ReadReply(reader)
+		if err != nil {
+			select {
+			case werr := <-writeErr:
+				if werr != nil {
+					return stats, werr
+				}
+			default:
+			}
+			return stats, err
+		}
+		if bs, ok := reply.(*parser.BulkString); ok && bytes.Equal(bs.Arg, marker) {
+			fmt.Fprintln(out, "Last reply received from server.")
+			break
+		}
+		if e, ok := reply.(*parser.Error); ok {
+			fmt.Fprintln(out, e.Arg)
+			stats.errors++
+		}
+		stats.replies++
+	}
+	if err := <-writeErr; err != nil {
+		return stats, err
+	}
+	fmt.Fprintf(out, "errors: %d, replies: %d\n", stats.errors, stats.replies)
+	return stats, nil
+}
+
+// 发送和接收的goroutine都会输出信息
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (sw *syncWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.w.Write(p)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+func TestPipe(t *testing.T) {
+	addr := startServer(t)
+	var in bytes.Buffer
+	n := 10000
+	for i := 0; i < n; i++ {
+		in.Write(parser.NewArray([][]byte{[]byte("rpush"), []byte("list"), bytes.Repeat([]byte("x"), 100)}).Serialize())
+	}
+	in.Write(parser.NewArray([][]byte{[]byte("incr"), []byte("list")}).Serialize())
+
+	var out bytes.Buffer
+	stats, err := runPipe(addr, "", 0, &in, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.replies != n+1 || stats.errors != 1 {
+		t.Logf("want %d replies and 1 error, got %+v", n+1, stats)
+		t.Fail()
+	}
+	if !strings.Contains(out.String(), "WRONGTYPE") || !strings.HasSuffix(out.String(), "errors: 1, replies: 10001\n") {
+		t.Logf("wrong output %q", out.String())
+		t.Fail()
+	}
+}
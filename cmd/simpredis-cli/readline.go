@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// 按下Ctrl-C时返回，调用方放弃当前输入
+var errInterrupt = errors.New("interrupt")
+
+const maxHistory = 1000
+
+// 控制键
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlH     = 8
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// lineEditor 是一个简单的行编辑器，支持光标移动、常用的emacs快捷键和历史记录。
+// 终端需要处于raw模式，由editor自己负责回显
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	history     []string
+	historyFile string
+}
+
+func newLineEditor(in io.Reader, out io.Writer) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out}
+}
+
+// 从文件中加载历史记录，之后输入的每一行都会追加到文件中
+func (e *lineEditor) loadHistory(filename string) {
+	e.historyFile = filename
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.addHistory(line)
+		}
+	}
+}
+
+func (e *lineEditor) addHistory(line string) {
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+func (e *lineEditor) saveHistory(line string) {
+	e.addHistory(line)
+	if e.historyFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
+
+// 读取一行输入，空行上按Ctrl-D返回io.EOF
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var line []rune
+	pos := 0
+	// 浏览历史时，下标等于len(history)表示正在编辑的新行
+	index := len(e.history)
+	editing := ""
+
+	refresh := func() {
+		// 回到行首重新输出整行，再把光标移动到正确的位置
+		buf := "\r" + prompt + string(line) + "\x1b[K\r"
+		if n := len([]rune(prompt)) + pos; n > 0 {
+			buf += "\x1b[" + itoa(n) + "C"
+		}
+		io.WriteString(e.out, buf)
+	}
+	showHistory := func(i int) {
+		if index == len(e.history) {
+			editing = string(line)
+		}
+		index = i
+		if index == len(e.history) {
+			line = []rune(editing)
+		} else {
+			line = []rune(e.history[index])
+		}
+		pos = len(line)
+		refresh()
+	}
+
+	io.WriteString(e.out, prompt)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				io.WriteString(e.out, "\r\n")
+				return string(line), nil
+			}
+			return "", err
+		}
+
+		switch r {
+		case keyEnter, '\n':
+			io.WriteString(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			io.WriteString(e.out, "^C\r\n")
+			return "", errInterrupt
+		case keyCtrlD:
+			if len(line) == 0 {
+				io.WriteString(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+			}
+		case keyBackspace, keyCtrlH:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(line)
+		case keyCtrlB:
+			if pos > 0 {
+				pos--
+			}
+		case keyCtrlF:
+			if pos < len(line) {
+				pos++
+			}
+		case keyCtrlK:
+			line = line[:pos]
+		case keyCtrlU:
+			line = line[pos:]
+			pos = 0
+		case keyCtrlW:
+			// 删除光标前的一个单词
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+		case keyCtrlL:
+			io.WriteString(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			if index > 0 {
+				showHistory(index - 1)
+			}
+			continue
+		case keyCtrlN:
+			if index < len(e.history) {
+				showHistory(index + 1)
+			}
+			continue
+		case keyEscape:
+			switch e.readEscape() {
+			case 'A':
+				if index > 0 {
+					showHistory(index - 1)
+				}
+				continue
+			case 'B':
+				if index < len(e.history) {
+					showHistory(index + 1)
+				}
+				continue
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(line)
+			case '~':
+				// Delete键
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		refresh()
+	}
+}
+
+// 解析ESC之后的控制序列，返回代表按键的字符。Home和End有多种编码，统一为H和F，Delete为~
+func (e *lineEditor) readEscape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	b, err = e.in.ReadByte()
+	if err != nil {
+		return 0
+	}
+	if b < '0' || b > '9' {
+		return b
+	}
+	// 形如ESC [ 3 ~ 的序列
+	num := b
+	if b, err = e.in.ReadByte(); err != nil || b != '~' {
+		return 0
+	}
+	switch num {
+	case '1', '7':
+		return 'H'
+	case '4', '8':
+		return 'F'
+	case '3':
+		return '~'
+	}
+	return 0
+}
+
+func itoa(n int) string {
+	var buf [20]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = byte('0' + n%10)
+		n /= 10
+		if n == 0 {
+			break
+		}
+	}
+	return string(buf[i:])
+}
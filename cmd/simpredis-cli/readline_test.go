@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	input := "get k\r" + // 普通输入
+		"set\x1b[Dt\r" + // 左移后插入
+		"a b c\x17\x17x\r" + // Ctrl-W删除单词
+		"\x1b[A\x1b[A\r" + // 向上翻历史
+		"abc\x01\x0b\r" + // Ctrl-A后Ctrl-K清空
+		"\x03" + // Ctrl-C
+		"\x04" // 空行上的Ctrl-D
+	e := newLineEditor(strings.NewReader(input), io.Discard)
+	want := []string{"get k", "sett", "a x", "sett", ""}
+	for _, w := range want {
+		line, err := e.readLine("> ")
+		if err != nil || line != w {
+			t.Logf("want %q, got %q %v", w, line, err)
+			t.Fail()
+		}
+		if line != "" {
+			e.addHistory(line)
+		}
+	}
+	if _, err := e.readLine("> "); err != errInterrupt {
+		t.Logf("want interrupt, got %v", err)
+		t.Fail()
+	}
+	if _, err := e.readLine("> "); err != io.EOF {
+		t.Logf("want EOF, got %v", err)
+		t.Fail()
+	}
+}
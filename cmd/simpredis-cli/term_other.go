@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// 其他平台不支持行编辑，按普通输入逐行读取
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw mode is not supported")
+}
@@ -1,18 +1,31 @@
 package main
 
 import (
+	"os"
+
+	"github.com/HK40404/simpredis/redis/database"
 	handler "github.com/HK40404/simpredis/redis/server"
 	"github.com/HK40404/simpredis/server"
 	"github.com/HK40404/simpredis/utils/config"
 	"github.com/HK40404/simpredis/utils/logger"
 )
 
+var redisServer *handler.RedisServer
+
 func Initialize() {
 	config.LoadConfig("simpredis.conf")
 	logger.Init()
+
+	engine := database.NewDBEngine()
+	// 在开始接受连接之前恢复数据
+	if err := engine.InitPersistence(); err != nil {
+		logger.Error("Fail to load data: %v", err)
+		os.Exit(1)
+	}
+	redisServer = handler.NewHandler(engine)
 }
 
 func main() {
 	Initialize()
-	server.ListenAndServe(config.Cfg, handler.NewHandler())
+	server.ListenAndServe(config.Cfg, redisServer)
 }
@@ -0,0 +1,270 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/config"
+	"github.com/HK40404/simpredis/utils/logger"
+)
+
+const (
+	FsyncAlways   = "always"
+	FsyncEverySec = "everysec"
+	FsyncNo       = "no"
+)
+
+// Aof 以RESP格式追加记录所有执行成功的写命令
+type Aof struct {
+	file     *os.File
+	filename string
+	fsync    string
+	mu       sync.Mutex
+	selected int // 文件中最后一条select选择的数据库，-1表示需要重新select
+
+	size              atomic.Int64 // 当前aof文件大小
+	baseSize          int64        // 上次重写后的文件大小，用于判断是否需要自动重写
+	rewritePercentage int
+	rewriteMinSize    int64
+	rewriting         atomic.Bool
+	rewriteBuf        *bytes.Buffer // 重写期间执行的命令，重写结束后追加到新文件
+
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewAof(filename, fsync string) (*Aof, error) {
+	switch fsync {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	default:
+		return nil, errors.New("invalid appendfsync: " + fsync)
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	aof := &Aof{
+		file:     file,
+		filename: filename,
+		fsync:    fsync,
+		selected: -1,
+		baseSize: info.Size(),
+		closeCh:  make(chan struct{}),
+	}
+	aof.size.Store(info.Size())
+	if fsync == FsyncEverySec {
+		aof.wg.Add(1)
+		go aof.fsyncEverySec()
+	}
+	return aof, nil
+}
+
+func (aof *Aof) fsyncEverySec() {
+	defer aof.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			aof.mu.Lock()
+			if err := aof.file.Sync(); err != nil {
+				logger.Error("Fail to fsync aof file: %v", err)
+			}
+			aof.mu.Unlock()
+		case <-aof.closeCh:
+			return
+		}
+	}
+}
+
+// 追加db上执行的命令，和上一条命令的数据库不同时先追加select。db小于0表示命令不属于某个数据库
+func (aof *Aof) Append(db int, args [][]byte) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	var data []byte
+	if db >= 0 && db != aof.selected {
+		data = parser.NewArray([][]byte{[]byte("select"), []byte(strconv.Itoa(db))}).AppendTo(data)
+		aof.selected = db
+	}
+	data = parser.NewArray(args).AppendTo(data)
+	if _, err := aof.file.Write(data); err != nil {
+		logger.Error("Fail to write aof file: %v", err)
+		return
+	}
+	aof.size.Add(int64(len(data)))
+	if aof.rewriteBuf != nil {
+		aof.rewriteBuf.Write(data)
+	}
+	if aof.fsync == FsyncAlways {
+		if err := aof.file.Sync(); err != nil {
+			logger.Error("Fail to fsync aof file: %v", err)
+		}
+	}
+}
+
+// 文件大小超过min-size，且比上次重写后增长了percentage%时自动重写
+func (aof *Aof) needRewrite() bool {
+	if aof.rewritePercentage <= 0 || aof.rewriting.Load() {
+		return false
+	}
+	size := aof.size.Load()
+	if size < aof.rewriteMinSize {
+		return false
+	}
+	aof.mu.Lock()
+	base := aof.baseSize
+	aof.mu.Unlock()
+	if base == 0 {
+		base = 1
+	}
+	growth := (size - base) * 100 / base
+	return growth >= int64(aof.rewritePercentage)
+}
+
+// 关闭时会等待正在进行的重写完成
+func (aof *Aof) Close() {
+	close(aof.closeCh)
+	aof.wg.Wait()
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if err := aof.file.Sync(); err != nil {
+		logger.Error("Fail to fsync aof file: %v", err)
+	}
+	aof.file.Close()
+}
+
+// 根据配置开启aof，开启前先重放已有的aof文件恢复数据
+func (engine *DBEngine) InitAof() error {
+	if config.Cfg.AppendOnly != "yes" {
+		return nil
+	}
+
+	filename := config.Cfg.AppendFilename
+	if err := engine.LoadAof(filename); err != nil {
+		return err
+	}
+	aof, err := NewAof(filename, config.Cfg.AppendFsync)
+	if err != nil {
+		return err
+	}
+	aof.rewritePercentage, err = strconv.Atoi(config.Cfg.AutoAofRewritePercentage)
+	if err != nil {
+		logger.Warn("Invalid auto-aof-rewrite-percentage from config, set auto-aof-rewrite-percentage = 100")
+		aof.rewritePercentage = 100
+	}
+	aof.rewriteMinSize, err = config.ParseMemory(config.Cfg.AutoAofRewriteMinSize)
+	if err != nil {
+		logger.Warn("Invalid auto-aof-rewrite-min-size from config, set auto-aof-rewrite-min-size = 64mb")
+		aof.rewriteMinSize = 64 << 20
+	}
+	engine.aof = aof
+	return nil
+}
+
+// 记录从文件中读出的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 重放aof文件中的命令，文件不存在时直接返回
+func (engine *DBEngine) LoadAof(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	count := 0
+	// 已经完整读出的命令在文件中的结束位置
+	var validSize int64
+	session := &Session{}
+	counter := &countingReader{r: file}
+	reader := parser.NewReader(counter)
+	for {
+		args, err := reader.ReadCommand()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if counter.n > validSize {
+				// 最后一条命令没有写完整，截断掉，避免之后追加的命令无法解析
+				logger.Warn("Aof file %s is truncated, ignore the last command", filename)
+				if err := os.Truncate(filename, validSize); err != nil {
+					return err
+				}
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		validSize = counter.n - int64(reader.Buffered())
+		if reply, ok := engine.Exec(session, args).(*parser.Error); ok {
+			logger.Warn("Replay aof command %s failed: %s", args[0], reply.Arg)
+		}
+		count++
+	}
+	logger.Info("Load %d commands from aof file %s", count, filename)
+	return nil
+}
+
+// 记录执行成功的写命令：计入自上次保存后的修改次数，并写入aof。db小于0表示命令不属于某个数据库
+func (engine *DBEngine) propagate(db int, args [][]byte) {
+	engine.dirty.Add(1)
+	if engine.aof == nil {
+		return
+	}
+	engine.aof.Append(db, args)
+	if engine.aof.needRewrite() {
+		if err := engine.BgRewriteAof(); err == nil {
+			logger.Info("Starting automatic rewriting of aof file")
+		}
+	}
+}
+
+func (db *DB) propagate(args [][]byte) {
+	db.engine.propagate(db.index, args)
+}
+
+// 相对过期时间需要转换为绝对时间，防止重放时延长key的生命周期
+func (db *DB) propagateExpire(key string) {
+	t, ok := db.ttldb.Get(key)
+	if !ok {
+		return
+	}
+	db.propagate([][]byte{
+		[]byte("pexpireat"),
+		[]byte(key),
+		[]byte(strconv.FormatInt(t.(int64), 10)),
+	})
+}
+
+// 整体替换了一个key的值：先删除旧值，再用重写aof的方式记录新值和过期时间
+func (db *DB) propagateValue(key string, value any) {
+	db.propagate([][]byte{[]byte("del"), []byte(key)})
+	for _, cmd := range rewriteCommands(&snapshotEntry{key: key, value: value}) {
+		db.propagate(cmd)
+	}
+	db.propagateExpire(key)
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/HK40404/simpredis/redis/rdb"
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/logger"
+)
+
+var (
+	ErrAofDisabled       = errors.New("append only file is not enabled")
+	ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+)
+
+// 在后台根据当前数据库重写aof，去掉冗余的命令
+func (engine *DBEngine) BgRewriteAof() error {
+	aof := engine.aof
+	if aof == nil {
+		return ErrAofDisabled
+	}
+	if !aof.rewriting.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
+	}
+	aof.wg.Add(1)
+	go func() {
+		defer aof.wg.Done()
+		defer aof.rewriting.Store(false)
+		if err := engine.rewriteAof(aof); err != nil {
+			logger.Error("Fail to rewrite aof: %v", err)
+			return
+		}
+		logger.Info("Background aof rewrite finished successfully")
+	}()
+	return nil
+}
+
+func (engine *DBEngine) rewriteAof(aof *Aof) error {
+	// 拍快照的同时开始缓存新的命令，保证新文件 = 快照 + 缓存
+	entries := engine.snapshot(aof.startRewrite)
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(aof.filename), "temp-rewriteaof-*.aof")
+	if err != nil {
+		aof.abortRewrite()
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	selected := -1
+	for _, entry := range entries {
+		if entry.db != selected {
+			selected = entry.db
+			cmd := [][]byte{[]byte("select"), []byte(strconv.Itoa(selected))}
+			if _, err = writer.Write(parser.NewArray(cmd).Serialize()); err != nil {
+				break
+			}
+		}
+		for _, cmd := range rewriteCommands(entry) {
+			if _, err = writer.Write(parser.NewArray(cmd).Serialize()); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = aof.finishRewrite(tmpFile)
+	}
+	if err != nil {
+		aof.abortRewrite()
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
+
+// 每个key只需要一条写命令加上可能的一条pexpireat，stream每个entry一条xadd再加上元信息和消费组
+func rewriteCommands(entry *snapshotEntry) [][][]byte {
+	key := []byte(entry.key)
+	cmds := make([][][]byte, 0, 2)
+	switch v := entry.value.(type) {
+	case []byte:
+		cmds = append(cmds, [][]byte{[]byte("set"), key, v})
+	case [][]byte:
+		cmd := make([][]byte, 0, len(v)+2)
+		cmd = append(cmd, []byte("rpush"), key)
+		cmd = append(cmd, v...)
+		cmds = append(cmds, cmd)
+	case []string:
+		cmd := make([][]byte, 0, len(v)+2)
+		cmd = append(cmd, []byte("sadd"), key)
+		for _, m := range v {
+			cmd = append(cmd, []byte(m))
+		}
+		cmds = append(cmds, cmd)
+	case map[string]string:
+		cmd := make([][]byte, 0, len(v)*2+2)
+		cmd = append(cmd, []byte("hmset"), key)
+		for field, value := range v {
+			cmd = append(cmd, []byte(field), []byte(value))
+		}
+		cmds = append(cmds, cmd)
+	case map[string]float64:
+		cmd := make([][]byte, 0, len(v)*2+2)
+		cmd = append(cmd, []byte("zadd"), key)
+		for member, score := range v {
+			cmd = append(cmd, formatScore(score), []byte(member))
+		}
+		cmds = append(cmds, cmd)
+	case *rdb.Stream:
+		cmds = append(cmds, rewriteStream(key, v)...)
+	}
+	if entry.expireAt > 0 {
+		ts := []byte(strconv.FormatInt(entry.expireAt, 10))
+		cmds = append(cmds, [][]byte{[]byte("pexpireat"), key, ts})
+	}
+	return cmds
+}
+
+// 需要在持有数据库所有锁时调用
+func (aof *Aof) startRewrite() {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.rewriteBuf = &bytes.Buffer{}
+	// 新文件最后选择的数据库不确定，缓存的命令要从select开始
+	aof.selected = -1
+}
+
+func (aof *Aof) abortRewrite() {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.rewriteBuf = nil
+}
+
+// 追加重写期间缓存的命令，然后用新文件原子地替换旧文件
+func (aof *Aof) finishRewrite(tmpFile *os.File) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if _, err := tmpFile.Write(aof.rewriteBuf.Bytes()); err != nil {
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile.Name(), aof.filename); err != nil {
+		return err
+	}
+	aof.rewriteBuf = nil
+
+	// 旧的文件描述符仍指向被替换掉的文件，需要重新打开
+	aof.file.Close()
+	file, err := os.OpenFile(aof.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	aof.file = file
+	aof.size.Store(info.Size())
+	aof.baseSize = info.Size()
+	return nil
+}
+
+// 空的stream也需要保留，先添加一个entry再裁剪掉，最后用xsetid恢复元信息
+func rewriteStream(key []byte, s *rdb.Stream) [][][]byte {
+	cmds := make([][][]byte, 0, len(s.Entries)+1)
+	if len(s.Entries) == 0 {
+		cmds = append(cmds, [][]byte{[]byte("xadd"), key, []byte("maxlen"), []byte("0"), []byte("0-1"), {}, {}})
+	}
+	for _, e := range s.Entries {
+		cmd := make([][]byte, 0, len(e.Fields)+3)
+		cmd = append(cmd, []byte("xadd"), key, []byte(StreamID(e.ID).String()))
+		cmds = append(cmds, append(cmd, e.Fields...))
+	}
+	cmds = append(cmds, [][]byte{
+		[]byte("xsetid"), key, []byte(StreamID(s.LastID).String()),
+		[]byte("entriesadded"), []byte(strconv.FormatUint(s.EntriesAdded, 10)),
+		[]byte("maxdeletedid"), []byte(StreamID(s.MaxDeletedID).String()),
+	})
+
+	// 消费组：创建消费组和消费者，再把待确认的entry强制认领给对应的消费者
+	for _, g := range s.Groups {
+		lastID := StreamID(g.LastID)
+		cmds = append(cmds, [][]byte{
+			[]byte("xgroup"), []byte("create"), key, []byte(g.Name), []byte(lastID.String()),
+			[]byte("entriesread"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+		})
+		pending := make(map[rdb.StreamID]rdb.StreamPending, len(g.Pending))
+		for _, p := range g.Pending {
+			pending[p.ID] = p
+		}
+		for _, c := range g.Consumers {
+			cmds = append(cmds, [][]byte{[]byte("xgroup"), []byte("createconsumer"), key, []byte(g.Name), []byte(c.Name)})
+			for _, id := range c.Pending {
+				if p, ok := pending[id]; ok {
+					cmds = append(cmds, claimCommand(key, g.Name, c.Name, StreamID(id), p.DeliveryTime, p.DeliveryCount, lastID))
+				}
+			}
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+func waitRewrite(t *testing.T, aof *Aof) {
+	deadline := time.Now().Add(5 * time.Second)
+	for aof.rewriting.Load() {
+		if time.Now().After(deadline) {
+			t.Log("aof rewrite timeout")
+			t.FailNow()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBgRewriteAof(t *testing.T) {
+	filename := setAofConfig(t, FsyncNo)
+
+	engine := NewDBEngine()
+	if reply, ok := engine.ExecCmd(LineToArgs("bgrewriteaof")).(*parser.Error); !ok {
+		t.Logf("rewrite should fail when aof is disabled, got %v", reply)
+		t.Fail()
+	}
+	if err := engine.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for i := 0; i < 1000; i++ {
+		engine.ExecCmd(LineToArgs("incr counter"))
+		engine.ExecCmd(LineToArgs("lpush l " + strconv.Itoa(i)))
+		engine.ExecCmd(LineToArgs("rpop l"))
+	}
+	engine.ExecCmd(LineToArgs("rpush l a b c"))
+	engine.ExecCmd(LineToArgs("sadd s a b c"))
+	engine.ExecCmd(LineToArgs("hmset h f1 v1 f2 v2"))
+	engine.ExecCmd(LineToArgs("zadd z 1.5 a -inf b 3 c"))
+	engine.ExecCmd(LineToArgs("xadd x 1-0 f v"))
+	engine.ExecCmd(LineToArgs("xadd x 2-0 f v"))
+	engine.ExecCmd(LineToArgs("xgroup create x g 0"))
+	engine.ExecCmd(LineToArgs("xreadgroup group g c count 1 streams x >"))
+	engine.ExecCmd(LineToArgs("xgroup createconsumer x g idle"))
+	engine.ExecCmd(LineToArgs("xdel x 2-0"))
+	engine.ExecCmd(LineToArgs("xadd ex maxlen 0 5-0 f v"))
+	engine.ExecCmd(LineToArgs("set tmp v ex 100"))
+	before, _ := os.Stat(filename)
+
+	reply := engine.ExecCmd(LineToArgs("bgrewriteaof"))
+	if _, ok := reply.(*parser.String); !ok {
+		t.Logf("fail to start rewrite: %v", reply)
+		t.FailNow()
+	}
+	// 重写期间的命令也要保留下来
+	engine.ExecCmd(LineToArgs("incr counter"))
+	engine.ExecCmd(LineToArgs("set during rewrite"))
+	waitRewrite(t, engine.aof)
+	engine.ExecCmd(LineToArgs("set after rewrite"))
+	engine.Close()
+
+	after, _ := os.Stat(filename)
+	if after.Size() >= before.Size() {
+		t.Logf("aof should be compacted, before %d, after %d", before.Size(), after.Size())
+		t.Fail()
+	}
+
+	loaded := NewDBEngine()
+	if err := loaded.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	if string(loaded.ExecCmd(LineToArgs("get counter")).(*parser.BulkString).Arg) != "1001" {
+		t.Fail()
+	}
+	if loaded.ExecCmd(LineToArgs("llen l")).(*parser.Integer).Arg != 3 {
+		t.Fail()
+	}
+	if loaded.ExecCmd(LineToArgs("scard s")).(*parser.Integer).Arg != 3 {
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("hget h f2")).(*parser.BulkString).Arg) != "v2" {
+		t.Fail()
+	}
+	if zrange := bulkArgs(loaded.ExecCmd(LineToArgs("zrange z 0 -1 withscores"))); string(bytes.Join(zrange, []byte(" "))) != "b -inf a 1.5 c 3" {
+		t.Logf("wrong zset %s", zrange)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xrange x - +"))); got != "1-0 f v" {
+		t.Logf("wrong stream %s", got)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xpending x g"))); got != "1 1-0 1-0 c 1" {
+		t.Logf("wrong pending entries %s", got)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xinfo groups x"))); !strings.HasPrefix(got, "name g consumers 2 pending 1 last-delivered-id 1-0 entries-read 1") {
+		t.Logf("wrong consumer group %s", got)
+		t.Fail()
+	}
+	// 最大ID在删除和裁剪之后也要保留
+	if _, ok := loaded.ExecCmd(LineToArgs("xadd x 2-0 f v")).(*parser.Error); !ok {
+		t.Fail()
+	}
+	if _, ok := loaded.ExecCmd(LineToArgs("xadd ex 5-0 f v")).(*parser.Error); !ok || loaded.ExecCmd(LineToArgs("type ex")).(*parser.String).Arg != "stream" {
+		t.Log("empty stream should be kept")
+		t.Fail()
+	}
+	if ttl := loaded.ExecCmd(LineToArgs("ttl tmp")).(*parser.Integer).Arg; ttl < 98 || ttl > 100 {
+		t.Log(ttl)
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("get during")).(*parser.BulkString).Arg) != "rewrite" {
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("get after")).(*parser.BulkString).Arg) != "rewrite" {
+		t.Fail()
+	}
+}
+
+func TestAutoRewriteAof(t *testing.T) {
+	filename := setAofConfig(t, FsyncNo)
+	config.Cfg.AutoAofRewritePercentage = "100"
+	config.Cfg.AutoAofRewriteMinSize = "4kb"
+
+	engine := NewDBEngine()
+	if err := engine.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	for i := 0; i < 1000; i++ {
+		engine.ExecCmd(LineToArgs("incr counter"))
+	}
+	waitRewrite(t, engine.aof)
+	engine.Close()
+
+	info, _ := os.Stat(filename)
+	if info.Size() >= 1000*30 {
+		t.Logf("aof should be rewritten automatically, size %d", info.Size())
+		t.Fail()
+	}
+	loaded := NewDBEngine()
+	if err := loaded.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	if string(loaded.ExecCmd(LineToArgs("get counter")).(*parser.BulkString).Arg) != "1000" {
+		t.Fail()
+	}
+}
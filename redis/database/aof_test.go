@@ -0,0 +1,228 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+func setAofConfig(t *testing.T, fsync string) string {
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	old := *config.Cfg
+	config.Cfg.AppendOnly = "yes"
+	config.Cfg.AppendFilename = filename
+	config.Cfg.AppendFsync = fsync
+	t.Cleanup(func() { *config.Cfg = old })
+	return filename
+}
+
+func TestAofReplay(t *testing.T) {
+	filename := setAofConfig(t, FsyncAlways)
+
+	engine := NewDBEngine()
+	if err := engine.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	cmds := []string{
+		"set str v",
+		"set tmp v ex 100",
+		"setex tmp2 100 v",
+		"set gone v",
+		"expire gone 0",
+		"incr counter",
+		"incrby counter 10",
+		"lpush l 1 2 3",
+		"rpop l",
+		"sadd s a b c",
+		"spop s",
+		"hset h f v",
+		"hmset h f1 v1 f2 v2",
+		"hdel h f",
+		"set old v",
+		"expire old 100",
+		"rename old new",
+		"get str",
+		"set nx v nx",
+		"set nx v2 nx",
+	}
+	for _, cmd := range cmds {
+		engine.ExecCmd(LineToArgs(cmd))
+	}
+	engine.Close()
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	// 相对过期时间都应该被改写为绝对时间，读命令不应被记录
+	if strings.Contains(string(content), "\r\nexpire\r\n") ||
+		strings.Contains(string(content), "setex") ||
+		strings.Contains(string(content), "spop") ||
+		strings.Contains(string(content), "\r\nget\r\n") {
+		t.Logf("aof contains unexpected command: %q", content)
+		t.Fail()
+	}
+
+	loaded := NewDBEngine()
+	if err := loaded.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+
+	checks := []string{
+		"get str", "get counter", "lrange l 0 -1", "smembers s",
+		"hgetall h", "get new", "get old", "get gone", "get nx",
+	}
+	for _, cmd := range checks {
+		want := engine.ExecCmd(LineToArgs(cmd)).Serialize()
+		got := loaded.ExecCmd(LineToArgs(cmd)).Serialize()
+		if strings.HasPrefix(cmd, "smembers") || strings.HasPrefix(cmd, "hgetall") {
+			// 集合和哈希表的输出顺序不固定，只比较长度
+			if len(want) != len(got) {
+				t.Logf("%s: want %q, got %q", cmd, want, got)
+				t.Fail()
+			}
+			continue
+		}
+		if string(want) != string(got) {
+			t.Logf("%s: want %q, got %q", cmd, want, got)
+			t.Fail()
+		}
+	}
+
+	for _, key := range []string{"tmp", "tmp2", "new"} {
+		reply := loaded.ExecCmd(LineToArgs("ttl " + key))
+		if ttl := reply.(*parser.Integer).Arg; ttl < 98 || ttl > 100 {
+			t.Logf("ttl of %s should be kept after replay, got %d", key, ttl)
+			t.Fail()
+		}
+	}
+
+	// 新的写命令继续追加到aof
+	loaded.ExecCmd(LineToArgs("set after load"))
+	loaded.Close()
+	again := NewDBEngine()
+	if err := again.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer again.Close()
+	if string(again.ExecCmd(LineToArgs("get after")).(*parser.BulkString).Arg) != "load" {
+		t.Fail()
+	}
+}
+
+func TestAofTruncated(t *testing.T) {
+	// 内联命令和大写的命令名在文件中的长度和重新编码之后不同
+	head := "set k v\r\n*3\r\n$3\r\nSET\r\n$2\r\nk1\r\n$2\r\nv1\r\n"
+	tails := []string{
+		"*3\r\n$3\r\nset\r\n$2\r\nk2",
+		"*3\r\n$3\r\nset\r\n$2",
+		"*3\r\n$3\r",
+		"*3",
+		"set k2",
+		"",
+	}
+	for _, tail := range tails {
+		filename := setAofConfig(t, FsyncNo)
+		if err := os.WriteFile(filename, []byte(head+tail), 0644); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		engine := NewDBEngine()
+		if err := engine.InitAof(); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if string(engine.ExecCmd(LineToArgs("get k")).(*parser.BulkString).Arg) != "v" ||
+			string(engine.ExecCmd(LineToArgs("get k1")).(*parser.BulkString).Arg) != "v1" ||
+			engine.ExecCmd(LineToArgs("get k2")).(*parser.BulkString).Arg != nil {
+			t.Logf("tail %q: wrong value", tail)
+			t.Fail()
+		}
+		if data, _ := os.ReadFile(filename); string(data) != head {
+			t.Logf("tail %q: aof should be truncated to %q, got %q", tail, head, data)
+			t.Fail()
+		}
+
+		// 截断不完整的命令后，新追加的命令可以被正常重放
+		engine.ExecCmd(LineToArgs("set k3 v3"))
+		engine.Close()
+		loaded := NewDBEngine()
+		if err := loaded.InitAof(); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if string(loaded.ExecCmd(LineToArgs("get k3")).(*parser.BulkString).Arg) != "v3" {
+			t.Logf("tail %q: appended command is lost", tail)
+			t.Fail()
+		}
+		loaded.Close()
+	}
+}
+
+func TestAofInvalidFsync(t *testing.T) {
+	setAofConfig(t, "sometimes")
+	engine := NewDBEngine()
+	if err := engine.InitAof(); err == nil {
+		t.Log("invalid appendfsync should be rejected")
+		t.Fail()
+	}
+}
+
+// 不同数据库的命令用select区分，重写后也要保持
+func TestAofMultiDB(t *testing.T) {
+	setAofConfig(t, FsyncNo)
+	engine := NewDBEngine()
+	if err := engine.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	session := &Session{}
+	lines := []string{
+		"set k v0", "select 1", "set k v1", "rpush l a b",
+		"select 2", "set k v2", "move k 3", "swapdb 0 1", "select 0", "set x 1",
+	}
+	for _, line := range lines {
+		execIn(engine, session, line)
+	}
+	engine.ExecCmd(LineToArgs("bgrewriteaof"))
+	waitRewrite(t, engine.aof)
+	// 重写之后的命令在新文件中也要选择正确的数据库
+	execIn(engine, &Session{DB: 4}, "set y 4")
+	engine.Close()
+
+	loaded := NewDBEngine()
+	if err := loaded.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	want := map[int]map[string]string{
+		0: {"k": "v1", "x": "1"},
+		1: {"k": "v0"},
+		2: {"k": "(nil)"},
+		3: {"k": "v2"},
+		4: {"y": "4"},
+	}
+	for db, kvs := range want {
+		for k, v := range kvs {
+			if got := getIn(loaded, db, k); got != v {
+				t.Logf("db %d key %s: want %s, got %s", db, k, v, got)
+				t.Fail()
+			}
+		}
+	}
+	if loaded.Exec(&Session{DB: 0}, LineToArgs("llen l")).(*parser.Integer).Arg != 2 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 用于生成阻塞超时任务在时间轮上的key
+var blockID atomic.Int64
+
+// blockingKeys 记录阻塞等待key的连接，按数据库下标和key保存，swapdb之后仍然等待同一个下标
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[int]map[string]map[chan struct{}]struct{}
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{waiters: make(map[int]map[string]map[chan struct{}]struct{})}
+}
+
+func (b *blockingKeys) add(index int, keys []string, ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	db, ok := b.waiters[index]
+	if !ok {
+		db = make(map[string]map[chan struct{}]struct{})
+		b.waiters[index] = db
+	}
+	for _, key := range keys {
+		chs, ok := db[key]
+		if !ok {
+			chs = make(map[chan struct{}]struct{})
+			db[key] = chs
+		}
+		chs[ch] = struct{}{}
+	}
+}
+
+func (b *blockingKeys) remove(index int, keys []string, ch chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	db := b.waiters[index]
+	for _, key := range keys {
+		delete(db[key], ch)
+		if len(db[key]) == 0 {
+			delete(db, key)
+		}
+	}
+	if len(db) == 0 {
+		delete(b.waiters, index)
+	}
+}
+
+// 通知等待key的连接重新检查，通道有缓冲，不会阻塞
+func notify(chs map[chan struct{}]struct{}) {
+	for ch := range chs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *blockingKeys) signal(index int, key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	notify(b.waiters[index][key])
+}
+
+// 通知数据库上所有等待的连接，用于swapdb
+func (b *blockingKeys) signalAll(index int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, chs := range b.waiters[index] {
+		notify(chs)
+	}
+}
+
+// key有新数据时唤醒阻塞的连接，需要在修改数据之后调用
+func (db *DB) signalKey(key string) {
+	db.engine.blocked.signal(db.index, key)
+}
+
+// 阻塞执行命令：check在持有dbsMu读锁时执行，返回nil表示还没有数据。
+// 没有数据时等待keys被通知之后再次执行check，timeout为0表示一直等待，超时、连接断开或者关闭时返回nil
+func (engine *DBEngine) blockingExec(session *Session, keys []string, timeout time.Duration, check func(db *DB) parser.RespData) parser.RespData {
+	ch := make(chan struct{}, 1)
+	var timeoutCh chan struct{}
+	index := session.DB
+	for {
+		// 先登记再检查，检查之后添加的数据一定会通知到
+		engine.dbsMu.RLock()
+		engine.blocked.add(index, keys, ch)
+		reply := check(engine.dbs[index])
+		engine.dbsMu.RUnlock()
+		if reply != nil {
+			engine.blocked.remove(index, keys, ch)
+			return reply
+		}
+
+		if timeoutCh == nil {
+			timeoutCh = make(chan struct{})
+			if timeout > 0 {
+				taskKey := "block-" + strconv.FormatInt(blockID.Add(1), 10)
+				engine.tw.AddTask(taskKey, timeout, func() { close(timeoutCh) })
+				defer engine.tw.RemoveTask(taskKey)
+			}
+			// 阻塞前先发送连接缓冲的回复
+			if session.BeforeBlock != nil {
+				session.BeforeBlock()
+			}
+			if session.AfterBlock != nil {
+				defer session.AfterBlock()
+			}
+		}
+		select {
+		case <-ch:
+			engine.blocked.remove(index, keys, ch)
+		case <-timeoutCh:
+			engine.blocked.remove(index, keys, ch)
+			return nil
+		case <-session.Closed:
+			engine.blocked.remove(index, keys, ch)
+			return nil
+		case <-engine.closeCh:
+			engine.blocked.remove(index, keys, ch)
+			return nil
+		}
+	}
+}
@@ -7,7 +7,8 @@ import (
 
 var CmdTable = make(map[string]CmdFuc)
 
-type CmdFuc func(db *DBEngine, array [][]byte) parser.RespData
+// 在连接选择的数据库上执行的命令
+type CmdFuc func(db *DB, array [][]byte) parser.RespData
 
 func RegisterCmd(cmd string, fun CmdFuc) {
 	if _, ok := CmdTable[cmd]; ok {
@@ -16,3 +17,16 @@ func RegisterCmd(cmd string, fun CmdFuc) {
 	}
 	CmdTable[cmd] = fun
 }
+
+var EngineCmdTable = make(map[string]EngineCmdFunc)
+
+// 不属于某个数据库的命令，如select、swapdb和持久化相关的命令，执行时不持有dbsMu
+type EngineCmdFunc func(engine *DBEngine, session *Session, array [][]byte) parser.RespData
+
+func RegisterEngineCmd(cmd string, fun EngineCmdFunc) {
+	if _, ok := EngineCmdTable[cmd]; ok {
+		logger.Error("this cmd has been registered!")
+		return
+	}
+	EngineCmdTable[cmd] = fun
+}
@@ -2,18 +2,21 @@ package database
 
 import (
 	"math"
+	"math/bits"
 	"sync"
+	"sync/atomic"
 
 	"github.com/HK40404/simpredis/utils/hash"
 )
 
 type ConcurrentMap struct {
 	table []*Shard
-	count int
+	count atomic.Int64 // 不同shard的修改可能同时进行
 }
 
 type Shard struct {
 	m     map[string]any
+	index *scanIndex // 只有需要scan的map才有
 	mutex sync.RWMutex
 }
 
@@ -38,7 +41,6 @@ func NewConcurrentMap(shardCount int) *ConcurrentMap {
 	shardCount = GetCompacity(shardCount)
 	conmap := &ConcurrentMap{
 		table: make([]*Shard, shardCount),
-		count: 0,
 	}
 	for i := range conmap.table {
 		conmap.table[i] = &Shard{m: make(map[string]any)}
@@ -46,6 +48,35 @@ func NewConcurrentMap(shardCount int) *ConcurrentMap {
 	return conmap
 }
 
+// 每个shard额外保存按哈希值排列的索引，支持Scan
+func NewScanMap(shardCount int) *ConcurrentMap {
+	conmap := NewConcurrentMap(shardCount)
+	for _, table := range conmap.table {
+		table.index = newScanIndex()
+	}
+	return conmap
+}
+
+func (table *Shard) set(key string, value any) bool {
+	_, ok := table.m[key]
+	table.m[key] = value
+	if !ok && table.index != nil {
+		table.index.add(key)
+	}
+	return !ok
+}
+
+func (table *Shard) del(key string) bool {
+	_, ok := table.m[key]
+	if ok {
+		delete(table.m, key)
+		if table.index != nil {
+			table.index.remove(key)
+		}
+	}
+	return ok
+}
+
 // 将key hash成map索引
 func (conmap *ConcurrentMap) spread(key string) int {
 	hash := hash.Fnv(key)
@@ -70,11 +101,8 @@ func (conmap *ConcurrentMap) Set(key string, value any) {
 	table := conmap.table[idx]
 	table.mutex.Lock()
 	defer table.mutex.Unlock()
-	if _, ok := table.m[key]; ok {
-		table.m[key] = value
-	} else {
-		table.m[key] = value
-		conmap.count++
+	if table.set(key, value) {
+		conmap.count.Add(1)
 	}
 }
 
@@ -83,10 +111,9 @@ func (conmap *ConcurrentMap) Del(key string) {
 	table := conmap.table[idx]
 	table.mutex.Lock()
 	defer table.mutex.Unlock()
-	if _, ok := table.m[key]; ok {
-		conmap.count--
+	if table.del(key) {
+		conmap.count.Add(-1)
 	}
-	delete(table.m, key)
 }
 
 func (conmap *ConcurrentMap) GetWithLock(key string) (any, bool) {
@@ -102,21 +129,106 @@ func (conmap *ConcurrentMap) GetWithLock(key string) (any, bool) {
 func (conmap *ConcurrentMap) SetWithLock(key string, value any) {
 	idx := conmap.spread(key)
 	table := conmap.table[idx]
-	if _, ok := table.m[key]; ok {
-		table.m[key] = value
-	} else {
-		table.m[key] = value
-		conmap.count++
+	if table.set(key, value) {
+		conmap.count.Add(1)
 	}
 }
 
 func (conmap *ConcurrentMap) DelWithLock(key string) bool {
 	idx := conmap.spread(key)
 	table := conmap.table[idx]
-	_, ok := table.m[key]
+	ok := table.del(key)
 	if ok {
-		conmap.count--
-		delete(table.m, key)
+		conmap.count.Add(-1)
 	}
 	return ok
 }
+
+// 遍历所有shard，f返回false时停止遍历
+func (conmap *ConcurrentMap) ForEach(f func(key string, value any) bool) {
+	for i := range conmap.table {
+		if !conmap.ForEachInShard(i, f) {
+			return
+		}
+	}
+}
+
+// 遍历第i个shard，f返回false时停止遍历并返回false
+func (conmap *ConcurrentMap) ForEachInShard(i int, f func(key string, value any) bool) bool {
+	table := conmap.table[i]
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	for k, v := range table.m {
+		if !f(k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SampleShard 返回第i个shard中最多n个元素，map的遍历起点是随机的，可以作为抽样
+func (conmap *ConcurrentMap) SampleShard(i, n int) map[string]any {
+	table := conmap.table[i]
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	sample := make(map[string]any, n)
+	for k, v := range table.m {
+		if len(sample) >= n {
+			break
+		}
+		sample[k] = v
+	}
+	return sample
+}
+
+func (conmap *ConcurrentMap) ShardCount() int {
+	return len(conmap.table)
+}
+
+func (conmap *ConcurrentMap) Len() int {
+	return int(conmap.count.Load())
+}
+
+// 清空所有shard，返回删除的key的数量
+func (conmap *ConcurrentMap) Clear() int {
+	removed := 0
+	for _, table := range conmap.table {
+		table.mutex.Lock()
+		removed += len(table.m)
+		conmap.count.Add(-int64(len(table.m)))
+		table.m = make(map[string]any)
+		if table.index != nil {
+			table.index = newScanIndex()
+		}
+		table.mutex.Unlock()
+	}
+	return removed
+}
+
+// Scan 从cursor开始遍历，返回大约count个元素和下一次的cursor，返回的cursor为0表示遍历结束。
+// cursor的高位是shard的下标，低位是shard内按哈希值遍历到的位置，需要用NewScanMap创建
+// SetWithLock等写操作不获取shard的锁，遍历每个shard时持有lock中对应的读锁，lock的数量需要和shard相同
+func (conmap *ConcurrentMap) Scan(cursor uint64, count int, lock *ItemsLock) ([]scanEntry, uint64) {
+	shardBits := uint(bits.TrailingZeros(uint(len(conmap.table))))
+	hashBits := 64 - shardBits
+	idx, pos := cursor>>hashBits, cursor&(1<<hashBits-1)
+	result := make([]scanEntry, 0, count)
+	for ; idx < uint64(len(conmap.table)); idx, pos = idx+1, 0 {
+		if len(result) >= count {
+			return result, idx << hashBits
+		}
+		table := conmap.table[idx]
+		lock.RLockShard(int(idx))
+		table.mutex.RLock()
+		// 索引使用完整的64位哈希值，只取高hashBits位作为cursor
+		next, done := table.index.scan(pos<<shardBits, count-len(result), func(key string) {
+			result = append(result, scanEntry{key: key, value: table.m[key]})
+		})
+		table.mutex.RUnlock()
+		lock.RUnLockShard(int(idx))
+		if !done {
+			return result, idx<<hashBits | next>>shardBits
+		}
+	}
+	return result, 0
+}
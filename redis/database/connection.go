@@ -4,7 +4,7 @@ import (
 	parser "github.com/HK40404/simpredis/redis/resp"
 )
 
-func ExecPing(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecPing(db *DB, args [][]byte) parser.RespData {
 	switch len(args) {
 	case 1:
 		return parser.NewString("PONG")
@@ -15,7 +15,7 @@ func ExecPing(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 }
 
-func ExecEcho(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecEcho(db *DB, args [][]byte) parser.RespData {
 	switch len(args) {
 	case 2:
 		return parser.NewBulkString(args[1])
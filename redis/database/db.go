@@ -3,6 +3,8 @@ package database
 import (
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	parser "github.com/HK40404/simpredis/redis/resp"
@@ -11,74 +13,174 @@ import (
 	"github.com/HK40404/simpredis/utils/timewheel"
 )
 
+// 时间轮的精度
+const timeWheelInterval = 10 * time.Millisecond
+
 const (
 	NOEXPIRED = iota
 	EXPIRED
 	NOEXIST
 )
 
+// DB 是一个逻辑数据库，通过select切换
+type DB struct {
+	index  int            // 在engine.dbs中的下标，swapdb之后会改变
+	data   *dataMap       // 实际存储数据的db
+	ttldb  *ConcurrentMap // 保存item过期时间的db，值为unix毫秒时间戳
+	lock   *ItemsLock     // 可以锁多个item的锁，用于原子性修改多个值
+	engine *DBEngine
+}
+
 type DBEngine struct {
-	db    *ConcurrentMap // 实际存储数据的db
-	ttldb *ConcurrentMap // 保存item过期时间的db
-	lock  *ItemsLock     // 可以锁多个item的锁，用于原子性修改多个值
+	dbs   []*DB
+	dbsMu sync.RWMutex // 执行命令时持有读锁，swapdb交换数据库时持有写锁
+	aof   *Aof         // 为nil时表示没有开启aof
+
+	dirty      atomic.Int64 // 上次保存rdb之后的修改次数
+	lastSave   atomic.Int64 // 上次成功保存rdb的unix时间
+	saving     atomic.Bool
+	saveParams []saveParam
+	snapshotMu sync.Mutex // 同一时间只有一个快照，bgsave和aof重写依次拍快照
+
+	clock timewheel.Clock
+	tw    *timewheel.TimeWheel // 定期删除等定时任务
+
+	blocked *blockingKeys // 阻塞等待key的连接
+
+	expiredKeys          atomic.Int64 // 过期删除的key的数量
+	expireCycleTime      atomic.Int64 // 定期删除累计使用的时间（纳秒）
+	expireTimeCapReached atomic.Int64 // 定期删除因为超过时间限制停下的次数
+
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	bgWg      sync.WaitGroup // 等待后台保存等任务结束
+}
+
+// Session 保存连接选择的数据库，由RedisServer为每个连接创建
+type Session struct {
+	DB          int
+	BeforeBlock func()          // 命令阻塞等待之前调用，用于发送缓冲的回复
+	AfterBlock  func()          // 阻塞等待结束之后调用
+	Closed      <-chan struct{} // 连接断开时关闭，阻塞的命令不再等待
 }
 
 func NewDBEngine() *DBEngine {
+	return newDBEngine(timewheel.RealClock{})
+}
+
+// 测试时可以传入手动推进的时钟
+func newDBEngine(clock timewheel.Clock) *DBEngine {
 	shardCount, err := strconv.Atoi(config.Cfg.ShardCount)
 	if err != nil {
 		logger.Warn("Invalid shardcount from config, set shardcount = 16")
 		shardCount = 16
 	}
-	return &DBEngine{
-		db:    NewConcurrentMap(shardCount),
-		ttldb: NewConcurrentMap(shardCount),
-		lock:  NewItemsLock(shardCount),
+	dbCount, err := strconv.Atoi(config.Cfg.Databases)
+	if err != nil || dbCount <= 0 {
+		logger.Warn("Invalid databases from config, set databases = 16")
+		dbCount = 16
+	}
+	engine := &DBEngine{
+		dbs:     make([]*DB, dbCount),
+		clock:   clock,
+		tw:      timewheel.New(timeWheelInterval, clock),
+		blocked: newBlockingKeys(),
+		closeCh: make(chan struct{}),
+	}
+	for i := range engine.dbs {
+		db := &DB{
+			index:  i,
+			ttldb:  NewConcurrentMap(shardCount),
+			lock:   NewItemsLock(shardCount),
+			engine: engine,
+		}
+		db.data = &dataMap{ConcurrentMap: NewScanMap(shardCount), db: db}
+		engine.dbs[i] = db
+	}
+	engine.lastSave.Store(time.Now().Unix())
+	engine.scheduleExpireCycle(&expireCursor{})
+	engine.tw.Start()
+	return engine
+}
+
+// 恢复数据并开启持久化，开启aof时优先从aof恢复
+func (engine *DBEngine) InitPersistence() error {
+	if config.Cfg.AppendOnly == "yes" {
+		if err := engine.InitAof(); err != nil {
+			return err
+		}
+	} else if err := engine.LoadRdb(config.Cfg.DBFilename); err != nil {
+		return err
+	}
+
+	params, err := parseSaveParams(config.Cfg.Save)
+	if err != nil {
+		return err
 	}
+	engine.saveParams = params
+	if len(params) > 0 {
+		engine.bgWg.Add(1)
+		go engine.saveCron()
+	}
+	return nil
 }
 
+// 关闭前等待后台任务结束，配置了save时再保存一次rdb
+func (engine *DBEngine) Close() {
+	engine.closeOnce.Do(func() {
+		close(engine.closeCh)
+		engine.tw.Stop()
+		engine.bgWg.Wait()
+		if len(engine.saveParams) > 0 {
+			if err := engine.SaveRdb(config.Cfg.DBFilename); err != nil {
+				logger.Error("Fail to save rdb before shutdown: %v", err)
+			}
+		}
+		if engine.aof != nil {
+			engine.aof.Close()
+			engine.aof = nil
+		}
+	})
+}
+
+// 在0号数据库执行命令，不需要保存连接状态时使用
 func (engine *DBEngine) ExecCmd(array [][]byte) parser.RespData {
+	return engine.Exec(&Session{}, array)
+}
+
+// 在session选择的数据库执行命令
+func (engine *DBEngine) Exec(session *Session, array [][]byte) parser.RespData {
 	cmd := strings.ToLower(string(array[0]))
+	if execFunc, ok := EngineCmdTable[cmd]; ok {
+		return execFunc(engine, session, array)
+	}
 	execFunc, ok := CmdTable[cmd]
 	if !ok {
 		return parser.NewError("Unsupported command")
 	}
-	return execFunc(engine, array)
+	engine.dbsMu.RLock()
+	defer engine.dbsMu.RUnlock()
+	return execFunc(engine.dbs[session.DB], array)
 }
 
-func (engine *DBEngine) SetTTL(key string, delayTime time.Duration) bool {
+// SetTTL 设置key在delayTime之后过期
+func (db *DB) SetTTL(key string, delayTime time.Duration) bool {
 	if delayTime < time.Duration(0) {
 		return false
 	}
-
-	_, exist := engine.ttldb.Get(key)
-	if !exist {
-		engine.ttldb.count++
-	}
-	engine.ttldb.Set(key, time.Now().Add(delayTime).Unix())
-	// 要先把之前的定时任务删除
-	timewheel.Tw.RemoveTask(key)
-	job := func() {
-		engine.lock.Lock(key)
-		defer engine.lock.UnLock(key)
-		engine.db.DelWithLock(key)
-		engine.delTTL(key)
-	}
-	timewheel.Tw.AddTask(key, delayTime, job)
-	return true
+	return db.SetExpireAt(key, db.mstime()+delayTime.Milliseconds())
 }
 
-func (engine *DBEngine) CancelTTL(key string) bool {
-	if engine.delTTL(key) {
-		timewheel.Tw.RemoveTask(key)
-		return true
-	}
-	return false
+// SetExpireAt 设置key的过期时间，expireAt是unix毫秒时间戳。过期的key由惰性删除和定期删除清理
+func (db *DB) SetExpireAt(key string, expireAt int64) bool {
+	db.ttldb.Set(key, expireAt)
+	return true
 }
 
-func (engine *DBEngine) delTTL(key string) bool {
-	if _, ok := engine.ttldb.Get(key); ok {
-		engine.ttldb.count--
-		engine.ttldb.Del(key)
+// CancelTTL 删除key的过期时间，没有过期时间时返回false
+func (db *DB) CancelTTL(key string) bool {
+	if _, ok := db.ttldb.Get(key); ok {
+		db.ttldb.Del(key)
 		return true
 	}
 	return false
@@ -0,0 +1,360 @@
+package database
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/HK40404/simpredis/redis/rdb"
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 返回序列化后的value，key不存在时返回nil
+func ExecDump(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return parser.MakeNullBulkReply()
+	}
+	value := copyValue(item)
+	if value == nil {
+		return parser.NewError("ERR unsupported value type")
+	}
+	return parser.NewBulkString(rdb.Dump(value))
+}
+
+// restore key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds]
+// ttl为毫秒，0表示没有过期时间；没有淘汰策略，IDLETIME只做检查
+func ExecRestore(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	ttl, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return parser.NewError("Value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return parser.NewError("ERR Invalid TTL value, must be >= 0")
+	}
+
+	replace, absttl := false, false
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absttl = true
+		case "idletime":
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			i++
+			idle, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			if idle < 0 {
+				return parser.NewError("ERR Invalid IDLETIME value, must be >= 0")
+			}
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	value, err := rdb.Restore(args[3])
+	if err != nil {
+		return parser.NewError("ERR " + err.Error())
+	}
+	item := newItem(value)
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	_, exist := db.data.GetWithLock(key)
+	if exist && !replace {
+		return parser.NewError("BUSYKEY Target key name already exists.")
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		if absttl {
+			expireAt = time.UnixMilli(ttl)
+		} else {
+			expireAt = db.engine.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		// 已经过期的key不需要创建，但仍然会替换掉旧值
+		if !expireAt.After(db.engine.clock.Now()) {
+			if exist {
+				db.data.DelWithLock(key)
+				db.CancelTTL(key)
+				db.propagate([][]byte{[]byte("del"), args[1]})
+			}
+			return parser.MakeOKReply()
+		}
+	}
+
+	db.data.SetWithLock(key, item)
+	db.CancelTTL(key)
+	if ttl > 0 {
+		db.SetExpireAt(key, expireAt.UnixMilli())
+	}
+	db.propagateValue(key, value)
+	return parser.MakeOKReply()
+}
+
+// migrate host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key [key ...]]
+// 用restore把key发送到目标实例，成功后删除本地的key
+// 持有锁时只序列化key，连接目标实例和等待回复时不持有任何锁，收到回复后再加锁删除迁移成功的key
+func ExecMigrate(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) < 6 {
+		return parser.NewError("Invalid command format")
+	}
+	host, port := string(args[1]), string(args[2])
+	destDB, err := strconv.Atoi(string(args[4]))
+	if err != nil {
+		return parser.NewError("Value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(args[5]), 10, 64)
+	if err != nil {
+		return parser.NewError("Value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	copyKeys, replace := false, false
+	var auth [][]byte
+	keys := []string{string(args[3])}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			auth = [][]byte{[]byte("auth"), args[i+1]}
+			i++
+		case "auth2":
+			if i+2 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			auth = [][]byte{[]byte("auth"), args[i+1], args[i+2]}
+			i += 2
+		case "keys":
+			if len(args[3]) != 0 {
+				return parser.NewError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			keys = keys[:0]
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	cmds := make([][][]byte, 0, len(keys)+2)
+	if auth != nil {
+		cmds = append(cmds, auth)
+	}
+	if destDB != 0 {
+		cmds = append(cmds, [][]byte{[]byte("select"), args[4]})
+	}
+	restoreStart := len(cmds)
+	db, migrated := engine.dumpKeys(session, keys)
+	if len(migrated) == 0 {
+		return parser.NewString("NOKEY")
+	}
+	for _, m := range migrated {
+		cmd := [][]byte{[]byte("restore"), []byte(m.key), []byte(strconv.FormatInt(m.ttl, 10)), m.payload}
+		if replace {
+			cmd = append(cmd, []byte("replace"))
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	replies, err := sendCommands(net.JoinHostPort(host, port), cmds, time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return parser.NewError("IOERR error or timeout with target instance: " + err.Error())
+	}
+
+	var errReply *parser.Error
+	restored := make([]*migratedKey, 0, len(migrated))
+	for i, reply := range replies {
+		if e, ok := reply.(*parser.Error); ok {
+			if i < restoreStart {
+				return parser.NewError("ERR Target instance replied with error: " + e.Arg)
+			}
+			errReply = e
+			continue
+		}
+		if i >= restoreStart {
+			restored = append(restored, migrated[i-restoreStart])
+		}
+	}
+	// 目标实例恢复成功的key才从本地删除
+	if !copyKeys && len(restored) > 0 {
+		engine.deleteMigrated(db, restored)
+	}
+	if errReply != nil {
+		return parser.NewError("ERR Target instance replied with error: " + errReply.Arg)
+	}
+	return parser.MakeOKReply()
+}
+
+// 序列化时key的状态，删除前用来判断迁移期间key是否被修改过
+type migratedKey struct {
+	key      string
+	item     any
+	expireAt int64 // 0表示没有过期时间
+	ttl      int64
+	value    any // copyValue的结果
+	payload  []byte
+}
+
+// 持有锁时序列化存在的key，返回key所在的数据库。swapdb之后数据库的下标会变化，删除时使用同一个DB
+func (engine *DBEngine) dumpKeys(session *Session, keys []string) (*DB, []*migratedKey) {
+	engine.dbsMu.RLock()
+	defer engine.dbsMu.RUnlock()
+	db := engine.dbs[session.DB]
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
+
+	migrated := make([]*migratedKey, 0, len(keys))
+	for _, key := range keys {
+		item, ok := db.data.GetWithLock(key)
+		if !ok {
+			continue
+		}
+		value := copyValue(item)
+		if value == nil {
+			continue
+		}
+		m := &migratedKey{key: key, item: item, value: value}
+		if t, ok := db.ttldb.Get(key); ok {
+			m.expireAt = t.(int64)
+			m.ttl = m.expireAt - db.mstime()
+			if m.ttl <= 0 {
+				continue
+			}
+		}
+		m.payload = rdb.Dump(value)
+		migrated = append(migrated, m)
+	}
+	return db, migrated
+}
+
+// 删除迁移成功的key。等待目标实例时没有持有锁，期间被修改过的key保留在本地，防止丢失新的写入
+func (engine *DBEngine) deleteMigrated(db *DB, migrated []*migratedKey) {
+	keys := make([]string, 0, len(migrated))
+	for _, m := range migrated {
+		keys = append(keys, m.key)
+	}
+	engine.dbsMu.RLock()
+	defer engine.dbsMu.RUnlock()
+	db.lock.Locks(keys)
+	defer db.lock.UnLocks(keys)
+
+	for _, m := range migrated {
+		if !db.unchangedSince(m) {
+			continue
+		}
+		db.data.DelWithLock(m.key)
+		db.CancelTTL(m.key)
+		db.propagate([][]byte{[]byte("del"), []byte(m.key)})
+	}
+}
+
+// 比较item、过期时间和内容。list、set等类型是原地修改的，只比较item不能发现修改
+func (db *DB) unchangedSince(m *migratedKey) bool {
+	item, ok := db.data.GetWithLock(m.key)
+	if !ok || !sameItem(item, m.item) {
+		return false
+	}
+	var expireAt int64
+	if t, ok := db.ttldb.Get(m.key); ok {
+		expireAt = t.(int64)
+	}
+	if expireAt != m.expireAt {
+		return false
+	}
+	return sameValue(copyValue(item), m.value)
+}
+
+// set的成员来自map，顺序不固定，排序之后再比较
+func sameValue(a, b any) bool {
+	if x, ok := a.([]string); ok {
+		if y, ok := b.([]string); ok {
+			sort.Strings(x)
+			sort.Strings(y)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// 字符串是[]byte，不能直接用==比较，比较底层数组和长度
+func sameItem(a, b any) bool {
+	if x, ok := a.([]byte); ok {
+		y, ok := b.([]byte)
+		return ok && len(x) == len(y) && unsafe.SliceData(x) == unsafe.SliceData(y)
+	}
+	if _, ok := b.([]byte); ok {
+		return false
+	}
+	return a == b
+}
+
+// 连接addr并用pipeline发送命令，返回每条命令的回复
+func sendCommands(addr string, cmds [][][]byte, timeout time.Duration) ([]parser.RespData, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	ch := parser.ParseStream(conn)
+	defer func() {
+		conn.Close()
+		// 关闭连接后解析的goroutine会读到错误并关闭ch
+		for range ch {
+		}
+	}()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	for _, cmd := range cmds {
+		if _, err := conn.Write(parser.NewArray(cmd).Serialize()); err != nil {
+			return nil, err
+		}
+	}
+	replies := make([]parser.RespData, 0, len(cmds))
+	for range cmds {
+		payload, ok := <-ch
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if payload.Err != nil {
+			return nil, payload.Err
+		}
+		replies = append(replies, payload.Data)
+	}
+	return replies, nil
+}
+
+func init() {
+	RegisterCmd("dump", ExecDump)
+	RegisterCmd("restore", ExecRestore)
+	RegisterEngineCmd("migrate", ExecMigrate)
+}
@@ -0,0 +1,344 @@
+package database
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HK40404/simpredis/redis/rdb"
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/crc64"
+)
+
+func dumpKey(t *testing.T, engine *DBEngine, key string) []byte {
+	reply, ok := engine.ExecCmd(LineToArgs("dump " + key)).(*parser.BulkString)
+	if !ok || reply.Arg == nil {
+		t.Logf("fail to dump %s", key)
+		t.FailNow()
+	}
+	return reply.Arg
+}
+
+func restoreArgs(key, ttl string, payload []byte, options ...string) [][]byte {
+	args := [][]byte{[]byte("restore"), []byte(key), []byte(ttl), payload}
+	for _, option := range options {
+		args = append(args, []byte(option))
+	}
+	return args
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("set str v"))
+	engine.ExecCmd(LineToArgs("rpush l 1 2 3"))
+	engine.ExecCmd(LineToArgs("sadd s a b"))
+	engine.ExecCmd(LineToArgs("hset h f v"))
+	engine.ExecCmd(LineToArgs("zadd z 1 a 2 b"))
+	engine.ExecCmd(LineToArgs("xadd x 1-0 f v"))
+	engine.ExecCmd(LineToArgs("xgroup create x g 0"))
+	engine.ExecCmd(LineToArgs("xreadgroup group g c streams x >"))
+	if reply := engine.ExecCmd(LineToArgs("dump nokey")).(*parser.BulkString); reply.Arg != nil {
+		t.Log("dump a missing key should return nil")
+		t.Fail()
+	}
+
+	target := NewDBEngine()
+	for _, key := range []string{"str", "l", "s", "h", "z", "x"} {
+		reply := target.ExecCmd(restoreArgs(key, "0", dumpKey(t, engine, key)))
+		if _, ok := reply.(*parser.String); !ok {
+			t.Logf("fail to restore %s: %s", key, reply.Serialize())
+			t.Fail()
+		}
+	}
+	if string(target.ExecCmd(LineToArgs("get str")).(*parser.BulkString).Arg) != "v" ||
+		string(target.ExecCmd(LineToArgs("lindex l 2")).(*parser.BulkString).Arg) != "3" ||
+		target.ExecCmd(LineToArgs("scard s")).(*parser.Integer).Arg != 2 ||
+		string(target.ExecCmd(LineToArgs("hget h f")).(*parser.BulkString).Arg) != "v" ||
+		target.ExecCmd(LineToArgs("zscore z b")).(*parser.Double).Arg != 2 ||
+		streamReply(target.ExecCmd(LineToArgs("xrange x - +"))) != "1-0 f v" ||
+		streamReply(target.ExecCmd(LineToArgs("xpending x g"))) != "1 1-0 1-0 c 1" {
+		t.Log("wrong restored value")
+		t.Fail()
+	}
+	if target.ExecCmd(LineToArgs("ttl str")).(*parser.Integer).Arg != -1 {
+		t.Log("restored key should not have ttl")
+		t.Fail()
+	}
+
+	payload := dumpKey(t, engine, "str")
+	reply, ok := target.ExecCmd(restoreArgs("l", "0", payload)).(*parser.Error)
+	if !ok || reply.Arg != "BUSYKEY Target key name already exists." {
+		t.Log("restore to an existing key should fail without replace")
+		t.Fail()
+	}
+	target.ExecCmd(restoreArgs("l", "100000", payload, "replace"))
+	if ttl := target.ExecCmd(LineToArgs("ttl l")).(*parser.Integer).Arg; ttl < 98 || ttl > 100 {
+		t.Logf("wrong ttl %d", ttl)
+		t.Fail()
+	}
+	absttl := strconv.FormatInt(time.Now().Add(200*time.Second).UnixMilli(), 10)
+	target.ExecCmd(restoreArgs("abs", absttl, payload, "absttl", "idletime", "10"))
+	if ttl := target.ExecCmd(LineToArgs("ttl abs")).(*parser.Integer).Arg; ttl < 198 || ttl > 200 {
+		t.Logf("wrong ttl %d", ttl)
+		t.Fail()
+	}
+
+	// 已经过期的绝对时间会删除旧值
+	target.ExecCmd(restoreArgs("h", "1000", payload, "replace", "absttl"))
+	if target.ExecCmd(LineToArgs("exists h")).(*parser.Integer).Arg != 0 {
+		t.Log("expired key should not be restored")
+		t.Fail()
+	}
+
+	payload[0] ^= 0xff
+	if _, ok := target.ExecCmd(restoreArgs("bad", "0", payload)).(*parser.Error); !ok {
+		t.Log("corrupted payload should fail")
+		t.Fail()
+	}
+	if _, ok := target.ExecCmd(restoreArgs("bad", "-1", payload)).(*parser.Error); !ok {
+		t.Log("negative ttl should fail")
+		t.Fail()
+	}
+
+	// 校验和正确但是长度字段过大的payload
+	values := [][]byte{
+		{rdb.TypeString, 0x81, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'},
+		{rdb.TypeList, 0x80, 0x7f, 0xff, 0xff, 0xff, 0x01, 'a'},
+		{rdb.TypeString, 0xc3, 0x02, 0x80, 0x7f, 0xff, 0xff, 0xff, 0x00, 'a'},
+	}
+	for _, value := range values {
+		payload := binary.LittleEndian.AppendUint16(value, rdb.Version)
+		payload = binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload))
+		reply, ok := target.ExecCmd(restoreArgs("bad", "0", payload)).(*parser.Error)
+		if !ok || reply.Arg != "ERR "+rdb.ErrInvalidPayload.Error() {
+			t.Logf("oversized payload %v: got %v", value, reply)
+			t.Fail()
+		}
+	}
+}
+
+// 用engine在随机端口上处理命令，返回端口
+func serveEngine(t *testing.T, engine *DBEngine) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					reply := engine.ExecCmd(payload.Data.(*parser.Array).Args)
+					conn.Write(reply.Serialize())
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func TestMigrate(t *testing.T) {
+	target := NewDBEngine()
+	port := serveEngine(t, target)
+
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("set a 1"))
+	engine.ExecCmd(LineToArgs("set b 2 ex 100"))
+	engine.ExecCmd(LineToArgs("sadd c x y"))
+
+	reply := engine.ExecCmd(LineToArgs("migrate 127.0.0.1 " + port + " a 0 1000"))
+	if _, ok := reply.(*parser.String); !ok {
+		t.Logf("fail to migrate: %s", reply.Serialize())
+		t.FailNow()
+	}
+	if engine.ExecCmd(LineToArgs("exists a")).(*parser.Integer).Arg != 0 ||
+		string(target.ExecCmd(LineToArgs("get a")).(*parser.BulkString).Arg) != "1" {
+		t.Log("key should be moved to target")
+		t.Fail()
+	}
+
+	// KEYS选项需要key参数为空字符串
+	args := LineToArgs("migrate 127.0.0.1 " + port + " _ 0 1000 copy keys b c nokey")
+	args[3] = []byte{}
+	if reply := engine.ExecCmd(args); reply.(*parser.String).Arg != "OK" {
+		t.Fail()
+	}
+	if engine.ExecCmd(LineToArgs("exists b")).(*parser.Integer).Arg != 1 ||
+		engine.ExecCmd(LineToArgs("exists c")).(*parser.Integer).Arg != 1 {
+		t.Log("keys should be kept locally with copy")
+		t.Fail()
+	}
+	if ttl := target.ExecCmd(LineToArgs("ttl b")).(*parser.Integer).Arg; ttl < 97 || ttl > 100 {
+		t.Logf("ttl should be migrated, got %d", ttl)
+		t.Fail()
+	}
+	if target.ExecCmd(LineToArgs("scard c")).(*parser.Integer).Arg != 2 {
+		t.Fail()
+	}
+
+	// 目标已存在的key需要replace
+	reply = engine.ExecCmd(LineToArgs("migrate 127.0.0.1 " + port + " c 0 1000"))
+	if _, ok := reply.(*parser.Error); !ok || engine.ExecCmd(LineToArgs("exists c")).(*parser.Integer).Arg != 1 {
+		t.Log("failed key should be kept locally")
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("migrate 127.0.0.1 " + port + " c 0 1000 replace"))
+	if engine.ExecCmd(LineToArgs("exists c")).(*parser.Integer).Arg != 0 {
+		t.Fail()
+	}
+
+	if reply := engine.ExecCmd(LineToArgs("migrate 127.0.0.1 " + port + " nokey 0 1000")); reply.(*parser.String).Arg != "NOKEY" {
+		t.Fail()
+	}
+	if _, ok := engine.ExecCmd(LineToArgs("migrate 127.0.0.1 1 b 0 100")).(*parser.Error); !ok {
+		t.Log("migrate to a closed port should fail")
+		t.Fail()
+	}
+}
+
+// 等待目标实例回复时不持有锁，其他命令可以访问正在迁移的key，swapdb也不会被阻塞
+func TestMigrateUnlocked(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer listener.Close()
+	// 目标实例接受连接但是不回复
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("set a 1"))
+	replies := make(chan parser.RespData)
+	go func() {
+		replies <- engine.ExecCmd(LineToArgs("migrate 127.0.0.1 " + port + " a 0 2000"))
+	}()
+	conn := <-accepted
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		engine.ExecCmd(LineToArgs("set a 2"))
+		engine.ExecCmd(LineToArgs("swapdb 0 1"))
+		engine.ExecCmd(LineToArgs("swapdb 0 1"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Log("commands should not wait for migrate")
+		t.Fail()
+	}
+	conn.Close()
+	if _, ok := (<-replies).(*parser.Error); !ok {
+		t.Log("migrate should fail when the target closes the connection")
+		t.Fail()
+	}
+	if string(engine.ExecCmd(LineToArgs("get a")).(*parser.BulkString).Arg) != "2" {
+		t.Log("failed key should be kept locally")
+		t.Fail()
+	}
+}
+
+// 迁移期间被修改的key保留在本地，没有修改的key正常删除
+func TestMigrateConcurrentWrite(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer listener.Close()
+	// 目标实例收到所有restore之后等待测试修改key，再全部回复成功
+	received := make(chan struct{})
+	reply := make(chan struct{})
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ch := parser.ParseStream(conn)
+		for i := 0; i < 4; i++ {
+			if payload := <-ch; payload == nil || payload.Err != nil {
+				return
+			}
+		}
+		close(received)
+		<-reply
+		for i := 0; i < 4; i++ {
+			conn.Write(parser.MakeOKReply().Serialize())
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("mset a 1 b 1 d 1"))
+	engine.ExecCmd(LineToArgs("rpush l x"))
+	args := LineToArgs("migrate 127.0.0.1 " + port + " _ 0 2000 keys a b l d")
+	args[3] = []byte{}
+	replies := make(chan parser.RespData)
+	go func() {
+		replies <- engine.ExecCmd(args)
+	}()
+	<-received
+	engine.ExecCmd(LineToArgs("set a 2"))
+	engine.ExecCmd(LineToArgs("rpush l y"))
+	engine.ExecCmd(LineToArgs("expire d 100"))
+	close(reply)
+	if r, ok := (<-replies).(*parser.String); !ok || r.Arg != "OK" {
+		t.Log("migrate should succeed")
+		t.FailNow()
+	}
+	if string(engine.ExecCmd(LineToArgs("get a")).(*parser.BulkString).Arg) != "2" {
+		t.Log("key written during migrate should be kept")
+		t.Fail()
+	}
+	if engine.ExecCmd(LineToArgs("llen l")).(*parser.Integer).Arg != 2 {
+		t.Log("list modified in place during migrate should be kept")
+		t.Fail()
+	}
+	if engine.ExecCmd(LineToArgs("exists d")).(*parser.Integer).Arg != 1 {
+		t.Log("key with a new ttl should be kept")
+		t.Fail()
+	}
+	if engine.ExecCmd(LineToArgs("exists b")).(*parser.Integer).Arg != 0 {
+		t.Log("unchanged key should be deleted")
+		t.Fail()
+	}
+}
+
+// 等待目标实例期间执行swapdb，删除的仍然是迁移的key所在的数据库
+func TestMigrateSwapDB(t *testing.T) {
+	engine := NewDBEngine()
+	execIn(engine, &Session{DB: 0}, "set a 1")
+	execIn(engine, &Session{DB: 1}, "set a 1")
+	db := engine.dbs[0]
+	_, migrated := engine.dumpKeys(&Session{DB: 0}, []string{"a"})
+	engine.ExecCmd(LineToArgs("swapdb 0 1"))
+	engine.deleteMigrated(db, migrated)
+	if execIn(engine, &Session{DB: 1}, "exists a").(*parser.Integer).Arg != 0 ||
+		execIn(engine, &Session{DB: 0}, "exists a").(*parser.Integer).Arg != 1 {
+		t.Log("key should be deleted from the migrated database")
+		t.Fail()
+	}
+}
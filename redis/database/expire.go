@@ -0,0 +1,169 @@
+package database
+
+import (
+	"time"
+)
+
+// 过期的key和redis一样通过两种方式删除：
+// 1. 惰性删除：访问key时检查过期时间，已经过期的key当作不存在，持有写锁时直接删除
+// 2. 定期删除：后台每隔一段时间从每个shard中抽样设置了过期时间的key，删除其中已经过期的key
+
+const (
+	activeExpireInterval  = 100 * time.Millisecond // 定期删除的周期
+	activeExpireTimeLimit = 25 * time.Millisecond  // 每个周期最多使用的时间，即最多占用25%的CPU
+	activeExpireSamples   = 20                     // 每次从一个shard中抽样的key的数量
+	activeExpireStalePerc = 10                     // 抽样中过期的比例超过这个百分比时继续抽样这个shard
+)
+
+// dataMap 是数据库实际存储数据的map，读取时会检查过期时间
+type dataMap struct {
+	*ConcurrentMap
+	db *DB
+}
+
+// 已经过期的key当作不存在
+func (m *dataMap) GetWithLock(key string) (any, bool) {
+	if m.db.isExpired(key, m.db.mstime()) {
+		return nil, false
+	}
+	return m.ConcurrentMap.GetWithLock(key)
+}
+
+// 需要持有key的写锁。已经过期的旧值先删除，新值不会继承旧值的过期时间
+func (m *dataMap) SetWithLock(key string, value any) {
+	m.db.expireIfNeeded(key)
+	m.ConcurrentMap.SetWithLock(key, value)
+}
+
+// 需要持有key的写锁。已经过期的key会被删除，但是不计入删除成功
+func (m *dataMap) DelWithLock(key string) bool {
+	if m.db.expireIfNeeded(key) {
+		return false
+	}
+	return m.ConcurrentMap.DelWithLock(key)
+}
+
+// 跳过已经过期的key
+func (m *dataMap) ForEach(f func(key string, value any) bool) {
+	now := m.db.mstime()
+	m.ConcurrentMap.ForEach(func(key string, value any) bool {
+		if m.db.isExpired(key, now) {
+			return true
+		}
+		return f(key, value)
+	})
+}
+
+// 遍历第i个shard，跳过已经过期的key
+func (m *dataMap) ForEachInShard(i int, f func(key string, value any) bool) bool {
+	now := m.db.mstime()
+	return m.ConcurrentMap.ForEachInShard(i, func(key string, value any) bool {
+		if m.db.isExpired(key, now) {
+			return true
+		}
+		return f(key, value)
+	})
+}
+
+// 过滤掉已经过期的key，不影响cursor
+func (m *dataMap) Scan(cursor uint64, count int) ([]scanEntry, uint64) {
+	entries, next := m.ConcurrentMap.Scan(cursor, count, m.db.lock)
+	now := m.db.mstime()
+	alive := entries[:0]
+	for _, entry := range entries {
+		if !m.db.isExpired(entry.key, now) {
+			alive = append(alive, entry)
+		}
+	}
+	return alive, next
+}
+
+// 当前的unix毫秒时间。过期相关的判断都使用engine的时钟，测试时可以手动推进
+func (db *DB) mstime() int64 {
+	return db.engine.clock.Now().UnixMilli()
+}
+
+func (db *DB) isExpired(key string, now int64) bool {
+	if db.ttldb.Len() == 0 {
+		return false
+	}
+	t, ok := db.ttldb.Get(key)
+	return ok && t.(int64) <= now
+}
+
+// 需要持有key的写锁。key已经过期时删除并返回true
+func (db *DB) expireIfNeeded(key string) bool {
+	if !db.isExpired(key, db.mstime()) {
+		return false
+	}
+	db.data.ConcurrentMap.DelWithLock(key)
+	db.CancelTTL(key)
+	db.engine.expiredKeys.Add(1)
+	// 和redis一样把过期删除记录为del，aof重放时不依赖时间
+	db.propagate([][]byte{[]byte("del"), []byte(key)})
+	return true
+}
+
+// 定期删除作为时间轮上的任务，每次执行完之后重新添加
+func (engine *DBEngine) scheduleExpireCycle(cursor *expireCursor) {
+	engine.tw.AddTask("active-expire-cycle", activeExpireInterval, func() {
+		engine.activeExpireCycle(cursor)
+		engine.scheduleExpireCycle(cursor)
+	})
+}
+
+// 定期删除上一个周期停下的位置
+type expireCursor struct {
+	db    int
+	shard int
+}
+
+// 依次抽样每个数据库的每个shard，超过时间限制时停下，下一个周期从cursor继续
+func (engine *DBEngine) activeExpireCycle(cursor *expireCursor) {
+	start := time.Now()
+	defer func() {
+		engine.expireCycleTime.Add(int64(time.Since(start)))
+	}()
+
+	engine.dbsMu.RLock()
+	defer engine.dbsMu.RUnlock()
+	total := 0
+	for _, db := range engine.dbs {
+		total += db.ttldb.ShardCount()
+	}
+	for i := 0; i < total; i++ {
+		db := engine.dbs[cursor.db]
+		for {
+			sampled, expired := db.expireSample(cursor.shard)
+			if time.Since(start) > activeExpireTimeLimit {
+				engine.expireTimeCapReached.Add(1)
+				return
+			}
+			if sampled == 0 || expired*100 <= sampled*activeExpireStalePerc {
+				break
+			}
+		}
+		cursor.shard++
+		if cursor.shard >= db.ttldb.ShardCount() {
+			cursor.shard = 0
+			cursor.db = (cursor.db + 1) % len(engine.dbs)
+		}
+	}
+}
+
+// 从ttldb的第shard个shard中抽样，删除已经过期的key，返回抽样和删除的数量
+func (db *DB) expireSample(shard int) (sampled int, expired int) {
+	sample := db.ttldb.SampleShard(shard, activeExpireSamples)
+	now := db.mstime()
+	for key, t := range sample {
+		if t.(int64) > now {
+			continue
+		}
+		db.lock.Lock(key)
+		if db.expireIfNeeded(key) {
+			expired++
+		}
+		db.lock.UnLock(key)
+	}
+	return len(sample), expired
+}
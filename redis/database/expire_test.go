@@ -0,0 +1,109 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/timewheel"
+)
+
+// 使用手动推进的时钟，测试不需要sleep
+func newTestEngine() (*DBEngine, *timewheel.FakeClock) {
+	clock := timewheel.NewFakeClock(time.Now())
+	return newDBEngine(clock), clock
+}
+
+// 过期之后、被删除之前访问key，应该当作不存在
+func TestLazyExpire(t *testing.T) {
+	engine, clock := newTestEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	engine.ExecCmd(LineToArgs("mset a 1 b 2 c 3"))
+	engine.ExecCmd(LineToArgs("pexpire a 20"))
+	engine.ExecCmd(LineToArgs("pexpire b 20"))
+	engine.ExecCmd(LineToArgs("pexpire c 20"))
+	// 停止时间轮，保证key不会被定期删除
+	engine.tw.Stop()
+	clock.Advance(30 * time.Millisecond)
+
+	if reply := engine.ExecCmd(LineToArgs("get a")).(*parser.BulkString); reply.Arg != nil {
+		t.Log("expired key should not be read")
+		t.Fail()
+	}
+	if integer("exists a") != 0 || integer("ttl a") != -2 {
+		t.Fail()
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("keys *")))) != 0 {
+		t.Fail()
+	}
+	if keys := scanAll(t, engine, "scan", ""); len(keys) != 0 {
+		t.Logf("scan should skip expired keys, got %v", keys)
+		t.Fail()
+	}
+	if integer("del b") != 0 {
+		t.Log("deleting an expired key should return 0")
+		t.Fail()
+	}
+	// 写入过期的key会创建新的值，不继承过期时间
+	if integer("rpush c x") != 1 || integer("ttl c") != -1 {
+		t.Fail()
+	}
+}
+
+func TestActiveExpire(t *testing.T) {
+	engine, clock := newTestEngine()
+	engine.tw.Stop()
+	for i := 0; i < 1000; i++ {
+		engine.ExecCmd(LineToArgs("set tmp" + strconv.Itoa(i) + " v px 10"))
+	}
+	for i := 0; i < 100; i++ {
+		engine.ExecCmd(LineToArgs("set stable" + strconv.Itoa(i) + " v"))
+	}
+	clock.Advance(20 * time.Millisecond)
+
+	cursor := &expireCursor{}
+	for i := 0; i < 100 && engine.ExecCmd(LineToArgs("dbsize")).(*parser.Integer).Arg > 100; i++ {
+		engine.activeExpireCycle(cursor)
+	}
+	if size := engine.ExecCmd(LineToArgs("dbsize")).(*parser.Integer).Arg; size != 100 {
+		t.Logf("expired keys should be removed, dbsize %d", size)
+		t.Fail()
+	}
+	if engine.expiredKeys.Load() != 1000 {
+		t.Logf("want 1000 expired keys, got %d", engine.expiredKeys.Load())
+		t.Fail()
+	}
+
+	info := string(engine.ExecCmd(LineToArgs("info")).(*parser.BulkString).Arg)
+	if !strings.Contains(info, "expired_keys:1000\r\n") || !strings.Contains(info, "db0:keys=100,expires=0\r\n") {
+		t.Logf("wrong info %q", info)
+		t.Fail()
+	}
+	stats := string(engine.ExecCmd(LineToArgs("info stats")).(*parser.BulkString).Arg)
+	if !strings.HasPrefix(stats, "# Stats\r\n") || strings.Contains(stats, "Keyspace") {
+		t.Fail()
+	}
+}
+
+// 定期删除由engine的时间轮驱动
+func TestExpireCycleOnTimeWheel(t *testing.T) {
+	engine, clock := newTestEngine()
+	defer engine.Close()
+	engine.ExecCmd(LineToArgs("set k v px 50"))
+	engine.ExecCmd(LineToArgs("set k2 v"))
+
+	clock.Advance(40 * time.Millisecond)
+	if engine.ExecCmd(LineToArgs("pttl k")).(*parser.Integer).Arg != 10 {
+		t.Fail()
+	}
+	clock.Advance(activeExpireInterval)
+	if size := engine.ExecCmd(LineToArgs("dbsize")).(*parser.Integer).Arg; size != 1 || engine.expiredKeys.Load() != 1 {
+		t.Logf("k should be removed by the expire cycle, dbsize %d", size)
+		t.Fail()
+	}
+}
@@ -6,7 +6,7 @@ import (
 	parser "github.com/HK40404/simpredis/redis/resp"
 )
 
-func ExecHset(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHset(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -14,14 +14,14 @@ func ExecHset(engine *DBEngine, args [][]byte) parser.RespData {
 	field := string(args[2])
 	value := string(args[3])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var hset *HashTable
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		hset = NewHashTable()
-		defer engine.db.SetWithLock(key, hset)
+		defer db.data.SetWithLock(key, hset)
 	} else {
 		hset, ok = item.(*HashTable)
 		if !ok {
@@ -30,23 +30,24 @@ func ExecHset(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 
 	isNew := hset.Set(field, value)
+	db.propagate(args)
 	if isNew {
 		return parser.NewInteger(1)
 	}
 	return parser.NewInteger(0)
 }
 
-func ExecHget(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHget(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 	field := string(args[2])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -62,16 +63,16 @@ func ExecHget(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewBulkString([]byte(v))
 }
 
-func ExecHlen(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHlen(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -82,16 +83,16 @@ func ExecHlen(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(hset.Len()))
 }
 
-func ExecHkeys(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHkeys(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewArray(nil)
 	}
@@ -107,16 +108,16 @@ func ExecHkeys(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewArray(keys)
 }
 
-func ExecHvals(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHvals(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewArray(nil)
 	}
@@ -132,45 +133,48 @@ func ExecHvals(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewArray(values)
 }
 
-func ExecHgetall(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHgetall(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
-		return parser.NewArray(nil)
+		return parser.NewMap()
 	}
 	hset, ok := item.(*HashTable)
 	if !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	items := make([][]byte, 0, hset.Len()*2)
-	for _, item := range hset.ALL() {
-		items = append(items, []byte(item))
-	}
-	return parser.NewArray(items)
+	reply := parser.NewBulkMap()
+	reply.Grow(hset.Len() * 2)
+	hset.ForEach(func(field, value string) bool {
+		reply.AddString(field)
+		reply.AddString(value)
+		return true
+	})
+	return reply
 }
 
-func ExecHmset(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHmset(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 4 || len(args)%2 != 0 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var hset *HashTable
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		hset = NewHashTable()
-		defer engine.db.SetWithLock(key, hset)
+		defer db.data.SetWithLock(key, hset)
 	} else {
 		hset, ok = item.(*HashTable)
 		if !ok {
@@ -183,19 +187,20 @@ func ExecHmset(engine *DBEngine, args [][]byte) parser.RespData {
 		value := string(args[i+1])
 		hset.Set(field, value)
 	}
+	db.propagate(args)
 	return parser.MakeOKReply()
 }
 
-func ExecHmget(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHmget(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewArray(nil)
 	}
@@ -216,17 +221,17 @@ func ExecHmget(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewArray(values)
 }
 
-func ExecHexists(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHexists(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 	filed := string(args[2])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -241,16 +246,16 @@ func ExecHexists(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(0)
 }
 
-func ExecHdel(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHdel(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -265,10 +270,17 @@ func ExecHdel(engine *DBEngine, args [][]byte) parser.RespData {
 			delCount++
 		}
 	}
+	if hset.Len() == 0 {
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	if delCount > 0 {
+		db.propagate(args)
+	}
 	return parser.NewInteger(int64(delCount))
 }
 
-func ExecHsetnx(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHsetnx(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -276,14 +288,14 @@ func ExecHsetnx(engine *DBEngine, args [][]byte) parser.RespData {
 	field := string(args[2])
 	value := string(args[3])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var hset *HashTable
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		hset = NewHashTable()
-		defer engine.db.SetWithLock(key, hset)
+		defer db.data.SetWithLock(key, hset)
 	} else {
 		hset, ok = item.(*HashTable)
 		if !ok {
@@ -296,10 +308,11 @@ func ExecHsetnx(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 
 	hset.Set(field, value)
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecHincrby(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHincrby(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -310,14 +323,14 @@ func ExecHincrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer or out of range")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var hset *HashTable
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		hset = NewHashTable()
-		defer engine.db.SetWithLock(key, hset)
+		defer db.data.SetWithLock(key, hset)
 	} else {
 		hset, ok = item.(*HashTable)
 		if !ok {
@@ -329,6 +342,7 @@ func ExecHincrby(engine *DBEngine, args [][]byte) parser.RespData {
 	if v == "" {
 		// filed不存在，直接设置为inc
 		hset.Set(field, strconv.Itoa(inc))
+		db.propagate(args)
 		return parser.NewInteger(int64(inc))
 	}
 
@@ -337,10 +351,11 @@ func ExecHincrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Hash value is not an integer")
 	}
 	hset.Set(field, strconv.Itoa(inc+n))
+	db.propagate(args)
 	return parser.NewInteger(int64(inc + n))
 }
 
-func ExecHincrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecHincrbyfloat(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -351,14 +366,14 @@ func ExecHincrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not a valid float")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var hset *HashTable
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		hset = NewHashTable()
-		defer engine.db.SetWithLock(key, hset)
+		defer db.data.SetWithLock(key, hset)
 	} else {
 		hset, ok = item.(*HashTable)
 		if !ok {
@@ -371,7 +386,8 @@ func ExecHincrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
 		// filed不存在，直接设置为inc
 		v = strconv.FormatFloat(inc, 'f', -1, 64)
 		hset.Set(field, v)
-		return parser.NewBulkString([]byte(v))
+		db.propagate(args)
+		return parser.NewDouble(inc)
 	}
 
 	n, err := strconv.ParseFloat(v, 64)
@@ -380,7 +396,48 @@ func ExecHincrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	v = strconv.FormatFloat(inc+n, 'f', -1, 64)
 	hset.Set(field, v)
-	return parser.NewBulkString([]byte(v))
+	db.propagate(args)
+	return parser.NewDouble(inc + n)
+}
+
+// hscan key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func ExecHscan(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	cursor, errReply := parseCursor(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[3:], "novalues")
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return makeScanReply(0, parser.NewBulkArray())
+	}
+	hset, ok := item.(*HashTable)
+	if !ok {
+		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	entries, next := hset.Scan(cursor, opts.count)
+	reply := parser.NewBulkArray()
+	for _, entry := range entries {
+		if !opts.match(entry.key) {
+			continue
+		}
+		reply.AddString(entry.key)
+		if !opts.noValues {
+			reply.AddString(entry.value.(string))
+		}
+	}
+	return makeScanReply(next, reply)
 }
 
 func init() {
@@ -397,4 +454,5 @@ func init() {
 	RegisterCmd("hsetnx", ExecHsetnx)
 	RegisterCmd("hincrby", ExecHincrby)
 	RegisterCmd("hincrbyfloat", ExecHincrbyfloat)
+	RegisterCmd("hscan", ExecHscan)
 }
@@ -1,12 +1,14 @@
 package database
 
 type HashTable struct {
-	m map[string]string
+	m     map[string]string
+	index *scanIndex // 用于hscan
 }
 
 func NewHashTable() *HashTable {
 	return &HashTable{
-		m: make(map[string]string),
+		m:     make(map[string]string),
+		index: newScanIndex(),
 	}
 }
 
@@ -17,6 +19,9 @@ func (ht *HashTable) Len() int {
 func (ht *HashTable) Set(key, value string) bool {
 	_, ok := ht.m[key]
 	ht.m[key] = value
+	if !ok {
+		ht.index.add(key)
+	}
 	return !ok
 }
 
@@ -62,6 +67,23 @@ func (ht *HashTable) ALL() []string {
 	return items
 }
 
+func (ht *HashTable) ForEach(f func(key, value string) bool) {
+	for k, v := range ht.m {
+		if !f(k, v) {
+			break
+		}
+	}
+}
+
+// Scan 和Set.Scan相同，entry的value是field对应的值
+func (ht *HashTable) Scan(cursor uint64, count int) ([]scanEntry, uint64) {
+	entries := make([]scanEntry, 0, count)
+	next, _ := ht.index.scan(cursor, count, func(key string) {
+		entries = append(entries, scanEntry{key: key, value: ht.m[key]})
+	})
+	return entries, next
+}
+
 func (ht *HashTable) Exist(key string) bool {
 	_, ok := ht.m[key]
 	return ok
@@ -69,6 +91,9 @@ func (ht *HashTable) Exist(key string) bool {
 
 func (ht *HashTable) Remove(key string) bool {
 	_, ok := ht.m[key]
-	delete(ht.m, key)
+	if ok {
+		delete(ht.m, key)
+		ht.index.remove(key)
+	}
 	return ok
 }
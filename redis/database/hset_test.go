@@ -1,6 +1,7 @@
 package database
 
 import (
+	"strconv"
 	"testing"
 
 	parser "github.com/HK40404/simpredis/redis/resp"
@@ -147,22 +148,21 @@ func TestHkeyvalsAndHgetall(t *testing.T) {
 
 	args = LineToArgs("hgetall no_exists")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
-	if data != nil {
+	if len(bulkArgs(reply)) != 0 {
 		t.Fail()
 	}
 
 	args = LineToArgs("hgetall h")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	all := bulkArgs(reply)
 	items := make(map[string]string)
-	for i := 0; i < len(data); i += 2 {
-		items[string(data[i])] = string(data[i+1])
+	for i := 0; i < len(all); i += 2 {
+		items[string(all[i])] = string(all[i+1])
 	}
 
 	args = LineToArgs("hkeys no_exists")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data := reply.(*parser.Array).Args
 	if data != nil {
 		t.Fail()
 	}
@@ -230,15 +230,13 @@ func TestHincrbyAndHincrbyfloat(t *testing.T) {
 
 	args = LineToArgs("hincrbyfloat h num1 1e3")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.BulkString).Arg
-	if string(data) != "100" {
+	if reply.(*parser.Double).Arg != 100 {
 		t.Fail()
 	}
 
 	args = LineToArgs("hincrbyfloat h num2 3.14")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.BulkString).Arg
-	if string(data) != "3.14" {
+	if reply.(*parser.Double).Arg != 3.14 {
 		t.Fail()
 	}
 
@@ -256,8 +254,7 @@ func TestHincrbyAndHincrbyfloat(t *testing.T) {
 
 	args = LineToArgs("hincrbyfloat h num3 0.314")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.BulkString).Arg
-	if string(data) != "314.314" {
+	if reply.(*parser.Double).Arg != 314.314 {
 		t.Fail()
 	}
 }
@@ -294,3 +291,51 @@ func TestHmsetAndHmget(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestHscan(t *testing.T) {
+	engine := NewDBEngine()
+	for i := 0; i < 300; i++ {
+		engine.ExecCmd(LineToArgs("hset h f" + strconv.Itoa(i) + " v" + strconv.Itoa(i)))
+	}
+	items := scanAll(t, engine, "hscan h", "")
+	if len(items) != 600 {
+		t.Logf("want 600 fields and values, got %d", len(items))
+		t.Fail()
+	}
+	for i := 0; i+1 < len(items); i += 2 {
+		if "v"+items[i][1:] != items[i+1] {
+			t.Logf("field %s has wrong value %s", items[i], items[i+1])
+			t.Fail()
+		}
+	}
+	if fields := scanAll(t, engine, "hscan h", "match f1?? novalues"); len(fields) != 100 || fields[0][0] != 'f' {
+		t.Logf("want 100 fields matching f1??, got %d", len(fields))
+		t.Fail()
+	}
+
+	// 两次调用之间增删其他field，一直存在的field仍然会被返回
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		var fields []string
+		cursor, fields = scanReply(t, engine.ExecCmd(LineToArgs("hscan h "+cursor+" count 5 novalues")))
+		for _, f := range fields {
+			seen[f] = true
+		}
+		if cursor == "0" {
+			break
+		}
+		engine.ExecCmd(LineToArgs("hset h tmp" + strconv.Itoa(i) + " v"))
+		engine.ExecCmd(LineToArgs("hdel h f" + strconv.Itoa(150+i%150)))
+	}
+	for i := 0; i < 150; i++ {
+		if !seen["f"+strconv.Itoa(i)] {
+			t.Logf("f%d is missing", i)
+			t.Fail()
+		}
+	}
+
+	if _, ok := engine.ExecCmd(LineToArgs("hscan h abc")).(*parser.Error); !ok {
+		t.Fail()
+	}
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"bytes"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// HyperLogLog保存为字符串，get、set、dump等字符串命令仍然可以使用
+func getHLL(db *DB, key string) ([]byte, parser.RespData) {
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return nil, nil
+	}
+	p, ok := item.([]byte)
+	if !ok {
+		return nil, parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if !IsHLL(p) {
+		return nil, parser.NewError("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	}
+	return p, nil
+}
+
+// pfadd key [element ...]
+func ExecPfadd(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	p, errReply := getHLL(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	created := p == nil
+	if created {
+		p = NewHLL()
+	}
+	p, updated, err := HLLAdd(p, args[2:])
+	if err != nil {
+		return parser.NewError(err.Error())
+	}
+	if !created && !updated {
+		return parser.NewInteger(0)
+	}
+	db.data.SetWithLock(key, p)
+	db.propagate(args)
+	return parser.NewInteger(1)
+}
+
+// pfcount key [key ...]，多个key时返回并集的基数
+func ExecPfcount(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	if len(args) == 2 {
+		return pfcountKey(db, string(args[1]))
+	}
+
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		keys = append(keys, string(arg))
+	}
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
+	regs := make([]uint8, hllRegisters)
+	for _, key := range keys {
+		p, errReply := getHLL(db, key)
+		if errReply != nil {
+			return errReply
+		}
+		if p == nil {
+			continue
+		}
+		if err := HLLMerge(regs, p); err != nil {
+			return parser.NewError(err.Error())
+		}
+	}
+	return parser.NewInteger(int64(HLLCountRegisters(regs)))
+}
+
+// 单个key时使用并更新头部的基数缓存，缓存只是加速计算，不需要传播
+func pfcountKey(db *DB, key string) parser.RespData {
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	p, errReply := getHLL(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if p == nil {
+		return parser.NewInteger(0)
+	}
+	if count, ok := hllCachedCount(p); ok {
+		return parser.NewInteger(int64(count))
+	}
+	count, err := HLLCount(p)
+	if err != nil {
+		return parser.NewError(err.Error())
+	}
+	p = bytes.Clone(p)
+	hllSetCachedCount(p, count)
+	db.data.SetWithLock(key, p)
+	return parser.NewInteger(int64(count))
+}
+
+// pfmerge destkey [sourcekey ...]，destkey已经存在时也参与合并，保留destkey的过期时间
+func ExecPfmerge(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	dest := string(args[1])
+	srcs := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		srcs = append(srcs, string(arg))
+	}
+
+	db.lock.RWLocks(srcs, []string{dest})
+	defer db.lock.RWUnLocks(srcs, []string{dest})
+	regs := make([]uint8, hllRegisters)
+	// 有一个是dense编码时结果使用dense编码
+	dense := false
+	for _, key := range append([]string{dest}, srcs...) {
+		p, errReply := getHLL(db, key)
+		if errReply != nil {
+			return errReply
+		}
+		if p == nil {
+			continue
+		}
+		if p[4] == hllDense {
+			dense = true
+		}
+		if err := HLLMerge(regs, p); err != nil {
+			return parser.NewError(err.Error())
+		}
+	}
+	db.data.SetWithLock(dest, HLLFromRegisters(regs, dense))
+	db.propagate(args)
+	return parser.MakeOKReply()
+}
+
+func init() {
+	RegisterCmd("pfadd", ExecPfadd)
+	RegisterCmd("pfcount", ExecPfcount)
+	RegisterCmd("pfmerge", ExecPfmerge)
+}
@@ -0,0 +1,400 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"github.com/HK40404/simpredis/utils/hash"
+)
+
+// HyperLogLog保存为和redis相同格式的字符串：16字节的头部（"HYLL"、编码、3字节保留、8字节小端的基数缓存）之后是寄存器。
+// dense编码每个寄存器6位，共16384个；sparse编码用ZERO、XZERO、VAL三种操作码记录连续相同值的寄存器
+const (
+	hllP           = 14
+	hllQ           = 64 - hllP
+	hllRegisters   = 1 << hllP
+	hllPMask       = hllRegisters - 1
+	hllBits        = 6
+	hllRegisterMax = 1<<hllBits - 1
+	hllHdrSize     = 16
+	hllDenseSize   = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllAlphaInf    = 0.721347520444481703680
+	hllHashSeed    = 0xadc83b19
+
+	hllDense  = 0
+	hllSparse = 1
+
+	// sparse操作码：ZERO 00xxxxxx，XZERO 01xxxxxx yyyyyyyy，VAL 1vvvvvxx
+	hllSparseXZeroBit    = 0x40
+	hllSparseValBit      = 0x80
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	// sparse编码超过这个长度时转换为dense编码，和redis的hll-sparse-max-bytes默认值相同
+	hllSparseMaxBytes = 3000
+)
+
+var errInvalidHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+
+// 空的HyperLogLog，sparse编码，所有寄存器为0，基数缓存为0
+func NewHLL() []byte {
+	p := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(p, "HYLL")
+	p[4] = hllSparse
+	return appendSparseRun(p, hllRegisters, 0)
+}
+
+// 检查字符串是否为合法的HyperLogLog头部，sparse的内容在使用时检查
+func IsHLL(p []byte) bool {
+	if len(p) < hllHdrSize || string(p[:4]) != "HYLL" {
+		return false
+	}
+	switch p[4] {
+	case hllDense:
+		return len(p) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+// 元素哈希之后低14位选择寄存器，剩余位中第一个1的位置作为寄存器的候选值
+func hllPatLen(ele []byte) (int, uint8) {
+	h := hash.MurmurHash64A(ele, hllHashSeed)
+	index := int(h & hllPMask)
+	h >>= hllP
+	h |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); h&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func hllDenseGet(regs []byte, index int) uint8 {
+	pos := index * hllBits
+	b, fb := pos/8, uint(pos&7)
+	v := regs[b] >> fb
+	// 最后一个寄存器不会跨字节
+	if b+1 < len(regs) {
+		v |= regs[b+1] << (8 - fb)
+	}
+	return v & hllRegisterMax
+}
+
+func hllDenseSet(regs []byte, index int, val uint8) {
+	pos := index * hllBits
+	b, fb := pos/8, uint(pos&7)
+	regs[b] &^= hllRegisterMax << fb
+	regs[b] |= val << fb
+	if b+1 < len(regs) {
+		regs[b+1] &^= hllRegisterMax >> (8 - fb)
+		regs[b+1] |= val >> (8 - fb)
+	}
+}
+
+// 按顺序遍历sparse编码中连续相同值的寄存器，操作码覆盖的寄存器数量不对时返回错误
+func hllSparseRuns(sparse []byte, f func(start, n int, val uint8)) error {
+	index := 0
+	for i := 0; i < len(sparse); {
+		op := sparse[i]
+		var n int
+		var val uint8
+		switch {
+		case op&hllSparseValBit != 0:
+			val = (op>>2)&0x1f + 1
+			n = int(op&0x3) + 1
+			i++
+		case op&hllSparseXZeroBit != 0:
+			if i+1 >= len(sparse) {
+				return errInvalidHLL
+			}
+			n = (int(op&0x3f)<<8 | int(sparse[i+1])) + 1
+			i += 2
+		default:
+			n = int(op&0x3f) + 1
+			i++
+		}
+		if index+n > hllRegisters {
+			return errInvalidHLL
+		}
+		f(index, n, val)
+		index += n
+	}
+	if index != hllRegisters {
+		return errInvalidHLL
+	}
+	return nil
+}
+
+// 把n个值为val的寄存器编码为sparse操作码
+func appendSparseRun(buf []byte, n int, val uint8) []byte {
+	for n > 0 {
+		var c int
+		switch {
+		case val != 0:
+			c = n
+			if c > hllSparseValMaxLen {
+				c = hllSparseValMaxLen
+			}
+			buf = append(buf, hllSparseValBit|(val-1)<<2|byte(c-1))
+		case n > hllSparseZeroMaxLen:
+			c = n
+			if c > hllSparseXZeroMaxLen {
+				c = hllSparseXZeroMaxLen
+			}
+			buf = append(buf, hllSparseXZeroBit|byte((c-1)>>8), byte(c-1))
+		default:
+			c = n
+			buf = append(buf, byte(c-1))
+		}
+		n -= c
+	}
+	return buf
+}
+
+func hllSparseToDense(p []byte) ([]byte, error) {
+	dense := make([]byte, hllDenseSize)
+	copy(dense, p[:hllHdrSize])
+	dense[4] = hllDense
+	regs := dense[hllHdrSize:]
+	err := hllSparseRuns(p[hllHdrSize:], func(start, n int, val uint8) {
+		if val == 0 {
+			return
+		}
+		for i := start; i < start+n; i++ {
+			hllDenseSet(regs, i, val)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dense, nil
+}
+
+// 重新编码sparse内容并设置寄存器，相邻的相同值会合并。值超过sparse的上限或者编码过长时转换为dense编码
+func hllSparseSet(p []byte, index int, val uint8) ([]byte, error) {
+	if val > hllSparseValMaxValue {
+		dense, err := hllSparseToDense(p)
+		if err != nil {
+			return nil, err
+		}
+		hllDenseSet(dense[hllHdrSize:], index, val)
+		return dense, nil
+	}
+
+	res := make([]byte, hllHdrSize, len(p)+4)
+	copy(res, p[:hllHdrSize])
+	runLen, runVal := 0, uint8(0)
+	add := func(n int, val uint8) {
+		if n == 0 {
+			return
+		}
+		if runLen > 0 && val != runVal {
+			res = appendSparseRun(res, runLen, runVal)
+			runLen = 0
+		}
+		runLen += n
+		runVal = val
+	}
+	err := hllSparseRuns(p[hllHdrSize:], func(start, n int, old uint8) {
+		if index < start || index >= start+n || old >= val {
+			add(n, old)
+			return
+		}
+		add(index-start, old)
+		add(1, val)
+		add(start+n-index-1, old)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res = appendSparseRun(res, runLen, runVal)
+
+	if len(res) > hllSparseMaxBytes {
+		return hllSparseToDense(res)
+	}
+	return res, nil
+}
+
+func hllGet(p []byte, index int) (uint8, error) {
+	if p[4] == hllDense {
+		return hllDenseGet(p[hllHdrSize:], index), nil
+	}
+	var res uint8
+	err := hllSparseRuns(p[hllHdrSize:], func(start, n int, val uint8) {
+		if index >= start && index < start+n {
+			res = val
+		}
+	})
+	return res, err
+}
+
+// 添加元素，返回新的HyperLogLog和是否有寄存器被修改。修改前会拷贝，不影响还在使用原字符串的回复
+func HLLAdd(p []byte, elements [][]byte) ([]byte, bool, error) {
+	updated := false
+	for _, ele := range elements {
+		index, count := hllPatLen(ele)
+		old, err := hllGet(p, index)
+		if err != nil {
+			return nil, false, err
+		}
+		if old >= count {
+			continue
+		}
+		if p[4] == hllDense {
+			if !updated {
+				p = bytes.Clone(p)
+			}
+			hllDenseSet(p[hllHdrSize:], index, count)
+		} else if p, err = hllSparseSet(p, index, count); err != nil {
+			return nil, false, err
+		}
+		updated = true
+	}
+	if updated {
+		// 基数缓存失效
+		p[15] |= 0x80
+	}
+	return p, updated, nil
+}
+
+// 把p的寄存器合并到regs中，每个寄存器取最大值
+func HLLMerge(regs []uint8, p []byte) error {
+	if p[4] == hllDense {
+		dense := p[hllHdrSize:]
+		for i := range regs {
+			if val := hllDenseGet(dense, i); val > regs[i] {
+				regs[i] = val
+			}
+		}
+		return nil
+	}
+	return hllSparseRuns(p[hllHdrSize:], func(start, n int, val uint8) {
+		for i := start; i < start+n; i++ {
+			if val > regs[i] {
+				regs[i] = val
+			}
+		}
+	})
+}
+
+// 用合并之后的寄存器创建HyperLogLog，dense为false时尽量使用sparse编码
+func HLLFromRegisters(regs []uint8, dense bool) []byte {
+	if !dense {
+		p := make([]byte, hllHdrSize)
+		copy(p, "HYLL")
+		p[4] = hllSparse
+		for start := 0; start < len(regs) && !dense; {
+			n := 1
+			for start+n < len(regs) && regs[start+n] == regs[start] {
+				n++
+			}
+			if regs[start] > hllSparseValMaxValue {
+				dense = true
+				break
+			}
+			p = appendSparseRun(p, n, regs[start])
+			start += n
+		}
+		if !dense && len(p) <= hllSparseMaxBytes {
+			p[15] |= 0x80
+			return p
+		}
+	}
+	p := make([]byte, hllDenseSize)
+	copy(p, "HYLL")
+	p[4] = hllDense
+	for i, val := range regs {
+		hllDenseSet(p[hllHdrSize:], i, val)
+	}
+	p[15] |= 0x80
+	return p
+}
+
+// 基数缓存，最高位为1表示缓存失效
+func hllCachedCount(p []byte) (uint64, bool) {
+	if p[15]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(p[8:hllHdrSize]), true
+}
+
+func hllSetCachedCount(p []byte, count uint64) {
+	binary.LittleEndian.PutUint64(p[8:hllHdrSize], count)
+}
+
+// 估算一个HyperLogLog的基数
+func HLLCount(p []byte) (uint64, error) {
+	var histo [64]int
+	if p[4] == hllDense {
+		dense := p[hllHdrSize:]
+		for i := 0; i < hllRegisters; i++ {
+			histo[hllDenseGet(dense, i)]++
+		}
+	} else {
+		err := hllSparseRuns(p[hllHdrSize:], func(start, n int, val uint8) {
+			histo[val] += n
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return hllEstimate(&histo), nil
+}
+
+// 估算合并之后的寄存器的基数
+func HLLCountRegisters(regs []uint8) uint64 {
+	var histo [64]int
+	for _, val := range regs {
+		histo[val]++
+	}
+	return hllEstimate(&histo)
+}
+
+// Ertl的改进估算方法，和redis相同，histo[i]是值为i的寄存器数量
+func hllEstimate(histo *[64]int) uint64 {
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
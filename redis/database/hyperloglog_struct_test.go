@@ -0,0 +1,122 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestHLLDenseRegisters(t *testing.T) {
+	regs := make([]byte, hllDenseSize-hllHdrSize)
+	expected := make([]uint8, hllRegisters)
+	for i := 0; i < 100000; i++ {
+		index := rand.Intn(hllRegisters)
+		val := uint8(rand.Intn(hllRegisterMax + 1))
+		hllDenseSet(regs, index, val)
+		expected[index] = val
+	}
+	hllDenseSet(regs, hllRegisters-1, hllRegisterMax)
+	expected[hllRegisters-1] = hllRegisterMax
+	for i, val := range expected {
+		if got := hllDenseGet(regs, i); got != val {
+			t.Logf("register %d: want %d, got %d", i, val, got)
+			t.FailNow()
+		}
+	}
+}
+
+// sparse编码在添加元素之后应该和直接记录的寄存器一致，变长之后转换为dense编码
+func TestHLLSparse(t *testing.T) {
+	p := NewHLL()
+	if len(p) != hllHdrSize+2 || p[16] != 0x7f || p[17] != 0xff {
+		t.Logf("wrong empty hll %v", p)
+		t.FailNow()
+	}
+	expected := make([]uint8, hllRegisters)
+	for i := 0; p[4] == hllSparse; i++ {
+		ele := []byte(strconv.Itoa(i))
+		index, count := hllPatLen(ele)
+		if count > expected[index] {
+			expected[index] = count
+		}
+		var err error
+		if p, _, err = HLLAdd(p, [][]byte{ele}); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if p[4] == hllSparse && len(p) > hllSparseMaxBytes {
+			t.Log("sparse hll is too long")
+			t.FailNow()
+		}
+		if i%100 != 0 && p[4] == hllSparse {
+			continue
+		}
+		regs := make([]uint8, hllRegisters)
+		if err := HLLMerge(regs, p); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		for j := range regs {
+			if regs[j] != expected[j] {
+				t.Logf("register %d after %d elements: want %d, got %d", j, i+1, expected[j], regs[j])
+				t.FailNow()
+			}
+		}
+		if n, _ := HLLCount(p); n != HLLCountRegisters(regs) {
+			t.Fail()
+		}
+	}
+
+	// 合并之后尽量保持sparse编码
+	small := NewHLL()
+	small, _, _ = HLLAdd(small, [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	regs := make([]uint8, hllRegisters)
+	HLLMerge(regs, small)
+	merged := HLLFromRegisters(regs, false)
+	if merged[4] != hllSparse || string(merged[hllHdrSize:]) != string(small[hllHdrSize:]) {
+		t.Log("merged hll should be kept sparse")
+		t.Fail()
+	}
+	if merged = HLLFromRegisters(regs, true); merged[4] != hllDense {
+		t.Fail()
+	}
+
+	// 操作码覆盖的寄存器数量不对
+	broken := append(NewHLL(), 0x00)
+	if _, err := HLLCount(broken); err != errInvalidHLL {
+		t.Fail()
+	}
+}
+
+// 标准误差约为0.81%，这里允许3倍的误差
+func TestHLLCount(t *testing.T) {
+	p := NewHLL()
+	if count, _ := HLLCount(p); count != 0 {
+		t.Fail()
+	}
+	elements := make([][]byte, 0, 1000)
+	added := 0
+	for _, target := range []int{1, 10, 100, 1000, 10000, 100000, 500000} {
+		for ; added < target; added++ {
+			elements = append(elements, []byte("ele:"+strconv.Itoa(added)))
+			if len(elements) == cap(elements) || added == target-1 {
+				p, _, _ = HLLAdd(p, elements)
+				elements = elements[:0]
+			}
+		}
+		count, err := HLLCount(p)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if rate := math.Abs(float64(count)-float64(target)) / float64(target); rate > 0.0081*3 {
+			t.Logf("count %d, want %d", count, target)
+			t.Fail()
+		}
+	}
+	if p[4] != hllDense {
+		t.Log("hll should be dense")
+		t.Fail()
+	}
+}
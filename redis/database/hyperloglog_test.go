@@ -0,0 +1,112 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+func TestPfadd(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	if integer("pfadd h") != 1 || integer("pfadd h") != 0 || integer("pfcount h") != 0 {
+		t.Log("pfadd without elements should create the key")
+		t.Fail()
+	}
+	if integer("pfadd h a b c") != 1 || integer("pfadd h a b") != 0 || integer("pfcount h") != 3 {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("expire h 100"))
+	if integer("pfadd h d") != 1 || integer("pfcount h") != 4 || integer("ttl h") <= 0 {
+		t.Log("pfadd should keep the ttl")
+		t.Fail()
+	}
+
+	// 基数缓存保存在头部，修改之后失效
+	value := engine.ExecCmd(LineToArgs("get h")).(*parser.BulkString).Arg
+	if string(value[:4]) != "HYLL" || value[4] != hllSparse || value[8] != 4 || value[15] != 0 {
+		t.Logf("wrong hll string %v", value[:16])
+		t.Fail()
+	}
+	integer("pfadd h e")
+	if value[15] != 0 || engine.ExecCmd(LineToArgs("get h")).(*parser.BulkString).Arg[15]&0x80 == 0 {
+		t.Log("pfadd should invalidate the cache of a copy")
+		t.Fail()
+	}
+
+	engine.ExecCmd(LineToArgs("set str v"))
+	engine.ExecCmd(LineToArgs("rpush l v"))
+	engine.ExecCmd(LineToArgs("set broken HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x00"))
+	cases := []struct{ cmd, want string }{
+		{"pfadd", "Invalid command format"},
+		{"pfadd l a", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"pfadd str a", "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		{"pfcount h str", "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		{"pfcount broken", "INVALIDOBJ Corrupted HLL object detected"},
+		{"pfadd broken a", "INVALIDOBJ Corrupted HLL object detected"},
+	}
+	for _, c := range cases {
+		reply, ok := engine.ExecCmd(LineToArgs(c.cmd)).(*parser.Error)
+		if !ok || reply.Arg != c.want {
+			t.Logf("%s: want %q, got %v", c.cmd, c.want, reply)
+			t.Fail()
+		}
+	}
+}
+
+func TestPfcountAndPfmerge(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	for i := 0; i < 3000; i++ {
+		engine.ExecCmd(LineToArgs("pfadd h1 e" + strconv.Itoa(i)))
+		engine.ExecCmd(LineToArgs("pfadd h2 e" + strconv.Itoa(i+2000)))
+	}
+	engine.ExecCmd(LineToArgs("pfadd small a b c"))
+	union := integer("pfcount h1 h2 none")
+	if union < 4900 || union > 5100 {
+		t.Logf("wrong union count %d", union)
+		t.Fail()
+	}
+	if integer("pfcount none") != 0 || integer("pfcount none1 none2") != 0 {
+		t.Fail()
+	}
+
+	if _, ok := engine.ExecCmd(LineToArgs("pfmerge dst h1 h2 none")).(*parser.String); !ok {
+		t.FailNow()
+	}
+	if integer("pfcount dst") != union {
+		t.Log("pfmerge should be the same as pfcount with many keys")
+		t.Fail()
+	}
+	// destkey已经存在时也参与合并
+	engine.ExecCmd(LineToArgs("pfmerge dst small"))
+	if got := integer("pfcount dst"); got != integer("pfcount h1 h2 small") {
+		t.Logf("wrong merged count %d", got)
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("pfmerge empty"))
+	engine.ExecCmd(LineToArgs("pfmerge sparse small"))
+	if integer("pfcount empty") != 0 || integer("pfcount sparse") != 3 ||
+		engine.ExecCmd(LineToArgs("get sparse")).(*parser.BulkString).Arg[4] != hllSparse {
+		t.Fail()
+	}
+
+	// 通过get和set拷贝的HyperLogLog仍然可用
+	value := engine.ExecCmd(LineToArgs("get dst")).(*parser.BulkString).Arg
+	engine.ExecCmd([][]byte{[]byte("set"), []byte("copy"), value})
+	if integer("pfadd copy e0") != 0 || integer("pfcount copy") != integer("pfcount dst") {
+		t.Fail()
+	}
+	target := NewDBEngine()
+	target.ExecCmd(restoreArgs("dst", "0", dumpKey(t, engine, "dst")))
+	if target.ExecCmd(LineToArgs("pfcount dst")).(*parser.Integer).Arg != integer("pfcount dst") {
+		t.Log("wrong restored hll")
+		t.Fail()
+	}
+}
@@ -1,94 +1,162 @@
 package database
 
 import (
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	parser "github.com/HK40404/simpredis/redis/resp"
 )
 
-func ExecTTL(engine *DBEngine, args [][]byte) parser.RespData {
+// ttl系列命令：key不存在返回-2，没有过期时间返回-1。
+// outputMs为true时以毫秒为单位，否则四舍五入到秒；outputAbs为true时返回unix时间戳，否则返回剩余时间
+func ttlGeneric(db *DB, args [][]byte, outputMs, outputAbs bool) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	if _, ok := engine.db.GetWithLock(key); !ok {
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	if _, ok := db.data.GetWithLock(key); !ok {
 		return parser.NewInteger(-2)
 	}
 
-	t, ok := engine.ttldb.Get(key)
+	t, ok := db.ttldb.Get(key)
 	if !ok {
 		return parser.NewInteger(-1)
 	}
-	interval := time.Until(time.Unix(t.(int64), 0))
-	return parser.NewInteger(int64(interval.Seconds()))
+	ttl := t.(int64)
+	if !outputAbs {
+		ttl -= db.mstime()
+		if ttl < 0 {
+			ttl = 0
+		}
+	}
+	if outputMs {
+		return parser.NewInteger(ttl)
+	}
+	return parser.NewInteger((ttl + 500) / 1000)
+}
+
+func ExecTTL(db *DB, args [][]byte) parser.RespData {
+	return ttlGeneric(db, args, false, false)
+}
+
+func ExecPTTL(db *DB, args [][]byte) parser.RespData {
+	return ttlGeneric(db, args, true, false)
+}
+
+func ExecExpireTime(db *DB, args [][]byte) parser.RespData {
+	return ttlGeneric(db, args, false, true)
 }
 
+func ExecPExpireTime(db *DB, args [][]byte) parser.RespData {
+	return ttlGeneric(db, args, true, true)
+}
+
+// 把expire系列命令的参数转换为unix毫秒时间戳：basetime（unix毫秒）加上unit为单位的when，
+// 相对时间的basetime为当前时间，绝对时间为0。溢出时返回false
+func toExpireAt(when, basetime int64, unit time.Duration) (int64, bool) {
+	if unit == time.Second {
+		if when > math.MaxInt64/1000 || when < math.MinInt64/1000 {
+			return 0, false
+		}
+		when *= 1000
+	}
+	if when > math.MaxInt64-basetime {
+		return 0, false
+	}
+	return when + basetime, true
+}
+
+// expire系列命令：key ttl [NX|XX|GT|LT]，没有过期时间的key当作永不过期比较。
 // 设置不成功返回0，成功返回1
-func ExecExpire(engine *DBEngine, args [][]byte) parser.RespData {
-	if len(args) != 3 {
+func expireGeneric(db *DB, args [][]byte, basetime int64, unit time.Duration) parser.RespData {
+	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
-	seconds, err := strconv.Atoi(string(args[2]))
+	when, err := strconv.ParseInt(string(args[2]), 10, 64)
 	if err != nil {
 		return parser.NewError("Value is not an integer or out of range")
 	}
-
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	_, ok := engine.db.GetWithLock(key)
+	when, ok := toExpireAt(when, basetime, unit)
 	if !ok {
-		// 不存在key，执行失败
-		return parser.NewInteger(0)
+		return parser.NewError("ERR invalid expire time in '" + strings.ToLower(string(args[0])) + "' command")
 	}
 
-	// 直接过期
-	if seconds <= 0 {
-		engine.db.DelWithLock(key)
-		return parser.NewInteger(1)
+	var nx, xx, gt, lt bool
+	for _, arg := range args[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		default:
+			return parser.NewError("ERR Unsupported option " + string(arg))
+		}
 	}
-
-	engine.SetTTL(key, time.Duration(seconds)*time.Second)
-	return parser.NewInteger(1)
-}
-
-func ExecExpireat(engine *DBEngine, args [][]byte) parser.RespData {
-	if len(args) != 3 {
-		return parser.NewError("Invalid command format")
+	if nx && (xx || gt || lt) {
+		return parser.NewError("ERR NX and XX, GT or LT options at the same time are not compatible")
 	}
-	key := string(args[1])
-	timestamp, err := strconv.ParseInt(string(args[2]), 10, 64)
-	if err != nil {
-		return parser.NewError("Value is not an integer or out of range")
+	if gt && lt {
+		return parser.NewError("ERR GT and LT options at the same time are not compatible")
 	}
 
-	if timestamp < 0 {
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	_, ok = db.data.GetWithLock(key)
+	if !ok {
+		// 不存在key，执行失败
 		return parser.NewInteger(0)
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	_, ok := engine.db.GetWithLock(key)
-	if !ok {
-		// 不存在key，执行失败
+	t, hasTTL := db.ttldb.Get(key)
+	switch {
+	case nx && hasTTL, xx && !hasTTL:
+		return parser.NewInteger(0)
+	case gt && (!hasTTL || when <= t.(int64)):
+		return parser.NewInteger(0)
+	case lt && hasTTL && when >= t.(int64):
 		return parser.NewInteger(0)
 	}
 
 	// 直接过期
-	if timestamp <= time.Now().Unix() {
-		engine.db.DelWithLock(key)
+	if when <= db.mstime() {
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+		db.propagate([][]byte{[]byte("del"), args[1]})
 		return parser.NewInteger(1)
 	}
 
-	engine.SetTTL(key, time.Until(time.Unix(timestamp, 0)))
+	db.SetExpireAt(key, when)
+	db.propagateExpire(key)
 	return parser.NewInteger(1)
 }
 
-func ExecDel(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecExpire(db *DB, args [][]byte) parser.RespData {
+	return expireGeneric(db, args, db.mstime(), time.Second)
+}
+
+func ExecPExpire(db *DB, args [][]byte) parser.RespData {
+	return expireGeneric(db, args, db.mstime(), time.Millisecond)
+}
+
+func ExecExpireat(db *DB, args [][]byte) parser.RespData {
+	return expireGeneric(db, args, 0, time.Second)
+}
+
+func ExecPExpireat(db *DB, args [][]byte) parser.RespData {
+	return expireGeneric(db, args, 0, time.Millisecond)
+}
+
+func ExecDel(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 2 {
 		return parser.NewError("Invalid command format")
 	}
@@ -100,51 +168,53 @@ func ExecDel(engine *DBEngine, args [][]byte) parser.RespData {
 
 	delCount := 0
 	for _, k := range keys {
-		engine.lock.Lock(k)
-		if engine.db.DelWithLock(k) {
+		db.lock.Lock(k)
+		if db.data.DelWithLock(k) {
 			delCount++
+			db.propagate([][]byte{[]byte("del"), []byte(k)})
 		}
-		engine.CancelTTL(k)
-		engine.lock.UnLock(k)
+		db.CancelTTL(k)
+		db.lock.UnLock(k)
 	}
 	return parser.NewInteger(int64(delCount))
 }
 
-func ExecExists(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecExists(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	_, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	_, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
 	return parser.NewInteger(1)
 }
 
-func ExecPersist(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecPersist(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	_, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	_, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
-	if !engine.CancelTTL(key) {
+	if !db.CancelTTL(key) {
 		return parser.NewInteger(0)
 	}
 
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecRename(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRename(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -152,31 +222,29 @@ func ExecRename(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	newkey := string(args[2])
 
-	engine.lock.Locks([]string{key, newkey})
-	defer engine.lock.UnLocks([]string{key, newkey})
+	db.lock.Locks([]string{key, newkey})
+	defer db.lock.UnLocks([]string{key, newkey})
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewError("No such key")
 	}
 
-	engine.db.DelWithLock(newkey)
-	engine.CancelTTL(newkey)
+	db.data.DelWithLock(newkey)
+	db.CancelTTL(newkey)
 
-	var interval time.Duration
-	t, ok := engine.ttldb.Get(key)
-	if ok {
-		interval = time.Until(time.Unix(t.(int64), 0))
+	// 没有过期时间的key改名后也不应该有过期时间
+	if t, ok := db.ttldb.Get(key); ok {
+		db.SetExpireAt(newkey, t.(int64))
 	}
-
-	engine.SetTTL(newkey, interval)
-	engine.db.SetWithLock(newkey, item)
-	engine.db.DelWithLock(key)
-	engine.CancelTTL(key)
+	db.data.SetWithLock(newkey, item)
+	db.data.DelWithLock(key)
+	db.CancelTTL(key)
+	db.propagate(args)
 	return parser.MakeOKReply()
 }
 
-func ExecRenamenx(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRenamenx(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -184,65 +252,184 @@ func ExecRenamenx(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	newkey := string(args[2])
 
-	engine.lock.Locks([]string{key, newkey})
-	defer engine.lock.UnLocks([]string{key, newkey})
+	db.lock.Locks([]string{key, newkey})
+	defer db.lock.UnLocks([]string{key, newkey})
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewError("No such key")
 	}
 
-	_, ok = engine.db.GetWithLock(newkey)
+	_, ok = db.data.GetWithLock(newkey)
 	if ok {
 		return parser.NewInteger(0)
 	}
 
-	var interval time.Duration
-	t, ok := engine.ttldb.Get(key)
-	if ok {
-		interval = time.Until(time.Unix(t.(int64), 0))
+	// 没有过期时间的key改名后也不应该有过期时间
+	if t, ok := db.ttldb.Get(key); ok {
+		db.SetExpireAt(newkey, t.(int64))
 	}
-
-	engine.SetTTL(newkey, interval)
-	engine.db.SetWithLock(newkey, item)
-	engine.db.DelWithLock(key)
-	engine.CancelTTL(key)
+	db.data.SetWithLock(newkey, item)
+	db.data.DelWithLock(key)
+	db.CancelTTL(key)
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecType(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecType(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewString("none")
 	}
+	return parser.NewString(typeName(item))
+}
 
+func typeName(item any) string {
 	switch item.(type) {
 	case []byte:
-		return parser.NewString("string")
+		return "string"
 	case *QuickList:
-		return parser.NewString("list")
+		return "list"
 	case *Set:
-		return parser.NewString("set")
+		return "set"
 	case *HashTable:
-		return parser.NewString("hash")
+		return "hash"
+	case *ZSet:
+		return "zset"
+	case *Stream:
+		return "stream"
 	default:
-		return parser.NewString("unknow type")
+		return "unknow type"
+	}
+}
+
+// 锁住两个数据库中的key，不同数据库按下标顺序加锁，防止死锁
+func lockInDBs(src *DB, srcKey string, dest *DB, destKey string) (unlock func()) {
+	if src == dest {
+		keys := []string{srcKey, destKey}
+		src.lock.Locks(keys)
+		return func() { src.lock.UnLocks(keys) }
+	}
+	first, firstKey, second, secondKey := src, srcKey, dest, destKey
+	if first.index > second.index {
+		first, firstKey, second, secondKey = dest, destKey, src, srcKey
+	}
+	first.lock.Lock(firstKey)
+	second.lock.Lock(secondKey)
+	return func() {
+		second.lock.UnLock(secondKey)
+		first.lock.UnLock(firstKey)
+	}
+}
+
+// move key db：目标数据库已经存在key时不移动
+func ExecMove(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	index, errReply := db.engine.parseDBIndex(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	if index == db.index {
+		return parser.NewError("ERR source and destination objects are the same")
+	}
+	dest := db.engine.dbs[index]
+
+	unlock := lockInDBs(db, key, dest, key)
+	defer unlock()
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return parser.NewInteger(0)
 	}
+	if _, ok := dest.data.GetWithLock(key); ok {
+		return parser.NewInteger(0)
+	}
+
+	dest.data.SetWithLock(key, item)
+	if t, ok := db.ttldb.Get(key); ok {
+		dest.SetExpireAt(key, t.(int64))
+	}
+	db.data.DelWithLock(key)
+	db.CancelTTL(key)
+	db.propagate(args)
+	return parser.NewInteger(1)
+}
+
+// copy source destination [DB destination-db] [REPLACE]：拷贝值和过期时间
+func ExecCopy(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	src, destKey := string(args[1]), string(args[2])
+	dest := db
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "db":
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			index, errReply := db.engine.parseDBIndex(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			dest = db.engine.dbs[index]
+			i++
+		case "replace":
+			replace = true
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+	if dest == db && src == destKey {
+		return parser.NewError("ERR source and destination objects are the same")
+	}
+
+	unlock := lockInDBs(db, src, dest, destKey)
+	defer unlock()
+	item, ok := db.data.GetWithLock(src)
+	if !ok {
+		return parser.NewInteger(0)
+	}
+	if _, ok := dest.data.GetWithLock(destKey); ok && !replace {
+		return parser.NewInteger(0)
+	}
+	value := newItem(copyValue(item))
+	if value == nil {
+		return parser.NewError("ERR unsupported value type")
+	}
+
+	dest.data.SetWithLock(destKey, value)
+	dest.CancelTTL(destKey)
+	if t, ok := db.ttldb.Get(src); ok {
+		dest.SetExpireAt(destKey, t.(int64))
+	}
+	db.propagate(args)
+	return parser.NewInteger(1)
 }
 
 func init() {
+	RegisterCmd("move", ExecMove)
+	RegisterCmd("copy", ExecCopy)
 	RegisterCmd("ttl", ExecTTL)
 	RegisterCmd("expire", ExecExpire)
 	RegisterCmd("expireat", ExecExpireat)
+	RegisterCmd("pttl", ExecPTTL)
+	RegisterCmd("pexpire", ExecPExpire)
+	RegisterCmd("pexpireat", ExecPExpireat)
+	RegisterCmd("expiretime", ExecExpireTime)
+	RegisterCmd("pexpiretime", ExecPExpireTime)
 	RegisterCmd("persist", ExecPersist)
 	RegisterCmd("del", ExecDel)
 	RegisterCmd("exists", ExecExists)
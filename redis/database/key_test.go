@@ -137,6 +137,111 @@ func TestExpire(t *testing.T) {
 	}
 }
 
+func TestPExpire(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+
+	engine.ExecCmd(LineToArgs("set k v px 150"))
+	if pttl := integer("pttl k"); pttl <= 0 || pttl > 150 {
+		t.Logf("wrong pttl %d", pttl)
+		t.Fail()
+	}
+	time.Sleep(200 * time.Millisecond)
+	if integer("exists k") != 0 {
+		t.Log("k should expire within milliseconds")
+		t.Fail()
+	}
+
+	// ttl四舍五入到秒
+	engine.ExecCmd(LineToArgs("set k v"))
+	if integer("pexpire k 2600") != 1 || integer("ttl k") != 3 {
+		t.Fail()
+	}
+	integer("pexpire k 2400")
+	if integer("ttl k") != 2 {
+		t.Fail()
+	}
+
+	at := time.Now().Add(time.Hour).UnixMilli()
+	if integer("pexpireat k "+strconv.FormatInt(at, 10)) != 1 {
+		t.Fail()
+	}
+	if integer("pexpiretime k") != at || integer("expiretime k") != (at+500)/1000 {
+		t.Fail()
+	}
+	if integer("expiretime noexist") != -2 || integer("pttl noexist") != -2 {
+		t.Fail()
+	}
+	integer("persist k")
+	if integer("pexpiretime k") != -1 || integer("pttl k") != -1 {
+		t.Fail()
+	}
+	if integer("pexpireat k 1") != 1 || integer("exists k") != 0 {
+		t.Log("past deadline should delete the key")
+		t.Fail()
+	}
+
+	engine.ExecCmd(LineToArgs("psetex k 100 v"))
+	if pttl := integer("pttl k"); pttl <= 0 || pttl > 100 {
+		t.Fail()
+	}
+	time.Sleep(150 * time.Millisecond)
+	if integer("exists k") != 0 {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("set k v"))
+	for _, line := range []string{"psetex k 0 v", "expire k 9223372036854775807", "pexpire k 9223372036854775807"} {
+		if _, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok {
+			t.Logf("%s should fail", line)
+			t.Fail()
+		}
+	}
+}
+
+func TestExpireOptions(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	engine.ExecCmd(LineToArgs("set k v"))
+
+	// 没有过期时间的key当作永不过期
+	if integer("expire k 100 xx") != 0 || integer("expire k 100 gt") != 0 || integer("ttl k") != -1 {
+		t.Fail()
+	}
+	if integer("expire k 100 nx") != 1 || integer("expire k 200 nx") != 0 || integer("ttl k") != 100 {
+		t.Fail()
+	}
+	if integer("expire k 50 gt") != 0 || integer("expire k 200 gt") != 1 || integer("ttl k") != 200 {
+		t.Fail()
+	}
+	if integer("pexpire k 300000 lt") != 0 || integer("pexpire k 150000 lt") != 1 || integer("ttl k") != 150 {
+		t.Fail()
+	}
+	if integer("expire k 120 xx") != 1 || integer("ttl k") != 120 {
+		t.Fail()
+	}
+	at := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	if integer("expireat k "+at+" lt") != 0 || integer("expireat k "+at+" gt") != 1 {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("persist k"))
+	if integer("pexpireat k 1 lt") != 1 || integer("exists k") != 0 {
+		t.Log("lt on a key without ttl should succeed")
+		t.Fail()
+	}
+
+	engine.ExecCmd(LineToArgs("set k v"))
+	for _, line := range []string{"expire k 10 nx xx", "expire k 10 nx gt", "expire k 10 gt lt", "expire k 10 abc"} {
+		if _, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok {
+			t.Logf("%s should fail", line)
+			t.Fail()
+		}
+	}
+}
+
 func TestRename(t *testing.T) {
 	engine := NewDBEngine()
 	engine.ExecCmd(LineToArgs("set k v"))
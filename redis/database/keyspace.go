@@ -0,0 +1,116 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 解析数据库下标，出错时返回错误回复
+func (engine *DBEngine) parseDBIndex(arg []byte) (int, parser.RespData) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, parser.NewError("Value is not an integer or out of range")
+	}
+	if index < 0 || index >= len(engine.dbs) {
+		return 0, parser.NewError("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+func ExecSelect(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	index, errReply := engine.parseDBIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	session.DB = index
+	return parser.MakeOKReply()
+}
+
+// swapdb index1 index2：交换两个数据库的数据，连接选择的下标不变
+func ExecSwapDB(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 3 {
+		return parser.NewError("Invalid command format")
+	}
+	i, errReply := engine.parseDBIndex(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	j, errReply := engine.parseDBIndex(args[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	// 等待正在执行的命令结束，之后的命令会看到交换后的数据库
+	engine.dbsMu.Lock()
+	defer engine.dbsMu.Unlock()
+	engine.dbs[i], engine.dbs[j] = engine.dbs[j], engine.dbs[i]
+	engine.dbs[i].index, engine.dbs[j].index = i, j
+	// 交换之后的数据库中可能已经有阻塞的连接需要的数据
+	engine.blocked.signalAll(i)
+	engine.blocked.signalAll(j)
+	engine.propagate(-1, args)
+	return parser.MakeOKReply()
+}
+
+func ExecDBSize(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 1 {
+		return parser.NewError("Invalid command format")
+	}
+	return parser.NewInteger(int64(db.data.Len()))
+}
+
+// 只接受ASYNC或SYNC。数据由GC回收，两者都只需要常数时间清空
+func checkFlushArgs(args [][]byte) bool {
+	switch len(args) {
+	case 1:
+		return true
+	case 2:
+		mode := strings.ToLower(string(args[1]))
+		return mode == "async" || mode == "sync"
+	}
+	return false
+}
+
+// 清空数据库。定时任务不删除，过期时检查到过期时间已不存在后直接忽略
+func (db *DB) flush() {
+	db.lock.LockAll()
+	defer db.lock.UnLockAll()
+	db.data.Clear()
+	db.ttldb.Clear()
+}
+
+// flushdb [ASYNC|SYNC]
+func ExecFlushDB(db *DB, args [][]byte) parser.RespData {
+	if !checkFlushArgs(args) {
+		return parser.NewError("Invalid command format")
+	}
+	db.flush()
+	db.propagate(args)
+	return parser.MakeOKReply()
+}
+
+// flushall [ASYNC|SYNC]
+func ExecFlushAll(db *DB, args [][]byte) parser.RespData {
+	if !checkFlushArgs(args) {
+		return parser.NewError("Invalid command format")
+	}
+	engine := db.engine
+	for _, d := range engine.dbs {
+		d.flush()
+	}
+	engine.propagate(-1, args)
+	return parser.MakeOKReply()
+}
+
+func init() {
+	RegisterEngineCmd("select", ExecSelect)
+	RegisterEngineCmd("swapdb", ExecSwapDB)
+	RegisterCmd("dbsize", ExecDBSize)
+	RegisterCmd("flushdb", ExecFlushDB)
+	RegisterCmd("flushall", ExecFlushAll)
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"sync"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+// 在session选择的数据库上执行一行命令
+func execIn(engine *DBEngine, session *Session, line string) parser.RespData {
+	return engine.Exec(session, LineToArgs(line))
+}
+
+func getIn(engine *DBEngine, db int, key string) string {
+	reply := engine.Exec(&Session{DB: db}, LineToArgs("get "+key))
+	bs, ok := reply.(*parser.BulkString)
+	if !ok || bs.Arg == nil {
+		return "(nil)"
+	}
+	return string(bs.Arg)
+}
+
+func TestSelect(t *testing.T) {
+	engine := NewDBEngine()
+	session := &Session{}
+	execIn(engine, session, "set k v0")
+	if reply, ok := execIn(engine, session, "select 1").(*parser.String); !ok || reply.Arg != "OK" {
+		t.Fail()
+	}
+	if session.DB != 1 {
+		t.Logf("want db 1, got %d", session.DB)
+		t.Fail()
+	}
+	if got := getIn(engine, 1, "k"); got != "(nil)" {
+		t.Logf("db 1 should be empty, got %s", got)
+		t.Fail()
+	}
+	execIn(engine, session, "set k v1")
+	if getIn(engine, 0, "k") != "v0" || getIn(engine, 1, "k") != "v1" {
+		t.Fail()
+	}
+
+	for _, line := range []string{"select 16", "select -1", "select a", "select"} {
+		if _, ok := execIn(engine, session, line).(*parser.Error); !ok {
+			t.Logf("%s should fail", line)
+			t.Fail()
+		}
+	}
+	if session.DB != 1 {
+		t.Fail()
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	engine := NewDBEngine()
+	execIn(engine, &Session{DB: 0}, "set k v0")
+	execIn(engine, &Session{DB: 2}, "set k v2")
+	execIn(engine, &Session{DB: 2}, "expire k 100")
+
+	if reply, ok := execIn(engine, &Session{}, "swapdb 0 2").(*parser.String); !ok || reply.Arg != "OK" {
+		t.Fail()
+	}
+	if getIn(engine, 0, "k") != "v2" || getIn(engine, 2, "k") != "v0" {
+		t.Fail()
+	}
+	if ttl := execIn(engine, &Session{DB: 0}, "ttl k").(*parser.Integer).Arg; ttl <= 0 {
+		t.Logf("ttl should move with the data, got %d", ttl)
+		t.Fail()
+	}
+	if _, ok := execIn(engine, &Session{}, "swapdb 0 16").(*parser.Error); !ok {
+		t.Fail()
+	}
+}
+
+func TestMoveAndCopy(t *testing.T) {
+	engine := NewDBEngine()
+	s0, s1 := &Session{DB: 0}, &Session{DB: 1}
+	execIn(engine, s0, "rpush l a b")
+	execIn(engine, s0, "expire l 100")
+	execIn(engine, s0, "set exist v0")
+	execIn(engine, s1, "set exist v1")
+
+	if execIn(engine, s0, "move l 1").(*parser.Integer).Arg != 1 {
+		t.Fail()
+	}
+	if execIn(engine, s0, "exists l").(*parser.Integer).Arg != 0 ||
+		execIn(engine, s1, "llen l").(*parser.Integer).Arg != 2 ||
+		execIn(engine, s1, "ttl l").(*parser.Integer).Arg <= 0 {
+		t.Log("list should be moved to db 1 with its ttl")
+		t.Fail()
+	}
+	// 目标数据库已经有这个key时不移动
+	if execIn(engine, s0, "move exist 1").(*parser.Integer).Arg != 0 || getIn(engine, 0, "exist") != "v0" {
+		t.Fail()
+	}
+	if _, ok := execIn(engine, s0, "move exist 0").(*parser.Error); !ok {
+		t.Fail()
+	}
+
+	// 拷贝是深拷贝，修改新key不影响原来的key
+	if execIn(engine, s1, "copy l l2").(*parser.Integer).Arg != 1 {
+		t.Fail()
+	}
+	execIn(engine, s1, "rpush l2 c")
+	if execIn(engine, s1, "llen l").(*parser.Integer).Arg != 2 || execIn(engine, s1, "llen l2").(*parser.Integer).Arg != 3 {
+		t.Fail()
+	}
+	if execIn(engine, s1, "ttl l2").(*parser.Integer).Arg <= 0 {
+		t.Log("copy should keep the ttl")
+		t.Fail()
+	}
+	if execIn(engine, s0, "copy exist exist db 1").(*parser.Integer).Arg != 0 || getIn(engine, 1, "exist") != "v1" {
+		t.Fail()
+	}
+	if execIn(engine, s0, "copy exist exist db 1 replace").(*parser.Integer).Arg != 1 || getIn(engine, 1, "exist") != "v0" {
+		t.Fail()
+	}
+	if execIn(engine, s0, "copy noexist k").(*parser.Integer).Arg != 0 {
+		t.Fail()
+	}
+	if _, ok := execIn(engine, s0, "copy exist exist").(*parser.Error); !ok {
+		t.Fail()
+	}
+}
+
+func TestFlushAndDBSize(t *testing.T) {
+	engine := NewDBEngine()
+	s0, s1 := &Session{DB: 0}, &Session{DB: 1}
+	execIn(engine, s0, "mset a 1 b 2 c 3")
+	execIn(engine, s0, "expire a 100")
+	execIn(engine, s1, "set d 4")
+
+	if execIn(engine, s0, "dbsize").(*parser.Integer).Arg != 3 || execIn(engine, s1, "dbsize").(*parser.Integer).Arg != 1 {
+		t.Fail()
+	}
+	if reply, ok := execIn(engine, s0, "flushdb async").(*parser.String); !ok || reply.Arg != "OK" {
+		t.Fail()
+	}
+	if execIn(engine, s0, "dbsize").(*parser.Integer).Arg != 0 || execIn(engine, s1, "dbsize").(*parser.Integer).Arg != 1 {
+		t.Fail()
+	}
+	if execIn(engine, s0, "ttl a").(*parser.Integer).Arg != -2 {
+		t.Fail()
+	}
+	if _, ok := execIn(engine, s0, "flushdb now").(*parser.Error); !ok {
+		t.Fail()
+	}
+
+	execIn(engine, s0, "set a 1")
+	execIn(engine, s0, "flushall")
+	if execIn(engine, s0, "dbsize").(*parser.Integer).Arg != 0 || execIn(engine, s1, "dbsize").(*parser.Integer).Arg != 0 {
+		t.Fail()
+	}
+}
+
+// 并发写入不同的key后dbsize应该准确
+func TestDBSizeConcurrent(t *testing.T) {
+	engine := NewDBEngine()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				engine.ExecCmd([][]byte{[]byte("set"), []byte{byte('a' + i), byte(j), byte(j >> 8)}, []byte("v")})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if size := engine.ExecCmd(LineToArgs("dbsize")).(*parser.Integer).Arg; size != 8000 {
+		t.Logf("want 8000 keys, got %d", size)
+		t.Fail()
+	}
+}
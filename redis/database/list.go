@@ -8,7 +8,7 @@ import (
 	parser "github.com/HK40404/simpredis/redis/resp"
 )
 
-func ExecLpush(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLpush(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -16,35 +16,36 @@ func ExecLpush(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	values := args[2:]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var l *QuickList
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		l = NewQuickList()
-		defer engine.db.SetWithLock(key, l)
+		defer db.data.SetWithLock(key, l)
 	} else if l, ok = item.(*QuickList); !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 	for _, v := range values {
 		l.Insert(0, v)
 	}
+	db.propagate(args)
 	length := l.Len()
 	return parser.NewInteger(int64(length))
 }
 
-func ExecLpop(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLpop(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -54,13 +55,14 @@ func ExecLpop(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	val := l.RemoveByIndex(0)
 	if l.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
 	}
+	db.propagate(args)
 	return parser.NewBulkString(val)
 }
 
-func ExecRpush(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRpush(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -68,35 +70,36 @@ func ExecRpush(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	value := args[2:]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var l *QuickList
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		l = NewQuickList()
-		defer engine.db.SetWithLock(key, l)
+		defer db.data.SetWithLock(key, l)
 	} else if l, ok = item.(*QuickList); !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 	for _, v := range value {
 		l.PushBack(v)
 	}
+	db.propagate(args)
 	length := l.Len()
 	return parser.NewInteger(int64(length))
 }
 
-func ExecRpop(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRpop(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -106,13 +109,14 @@ func ExecRpop(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	val := l.RemoveByIndex(-1)
 	if l.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
 	}
+	db.propagate(args)
 	return parser.NewBulkString(val)
 }
 
-func ExecLindex(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLindex(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -123,9 +127,9 @@ func ExecLindex(engine *DBEngine, args [][]byte) parser.RespData {
 	if err != nil {
 		return parser.NewError("Value is not an integer")
 	}
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -140,16 +144,16 @@ func ExecLindex(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewBulkString(val)
 }
 
-func ExecLlen(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLlen(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -160,7 +164,7 @@ func ExecLlen(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(l.Len()))
 }
 
-func ExecLrange(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLrange(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -176,9 +180,9 @@ func ExecLrange(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("End index is not an integer")
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewArray(nil)
 	}
@@ -186,14 +190,14 @@ func ExecLrange(engine *DBEngine, args [][]byte) parser.RespData {
 	if !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	vals := l.Range(start, end)
-	if vals == nil {
-		return parser.NewArray(nil)
-	}
-	return parser.NewArray(vals)
+	// 持有锁时直接把元素编码到回复中
+	reply := parser.NewBulkArray()
+	reply.Grow(l.RangeLen(start, end))
+	l.ForRange(start, end, reply.Add)
+	return reply
 }
 
-func ExecLset(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLset(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -204,9 +208,9 @@ func ExecLset(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Index value is not an integer")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewError("No such key")
 	}
@@ -218,20 +222,21 @@ func ExecLset(engine *DBEngine, args [][]byte) parser.RespData {
 	if !l.Set(index, args[3]) {
 		return parser.NewError("Index out of range")
 	}
+	db.propagate(args)
 	return parser.MakeOKReply()
 }
 
-func ExecLpushX(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLpushX(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -240,21 +245,22 @@ func ExecLpushX(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 	l.Insert(0, args[2])
+	db.propagate(args)
 	length := l.Len()
 	return parser.NewInteger(int64(length))
 }
 
-func ExecRpushX(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRpushX(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -263,11 +269,12 @@ func ExecRpushX(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 	l.PushBack(args[2])
+	db.propagate(args)
 	length := l.Len()
 	return parser.NewInteger(int64(length))
 }
 
-func ExecRpopLpush(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecRpopLpush(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -276,10 +283,10 @@ func ExecRpopLpush(engine *DBEngine, args [][]byte) parser.RespData {
 	keys[0] = string(args[1])
 	keys[1] = string(args[2])
 
-	engine.lock.Locks(keys)
-	defer engine.lock.UnLocks(keys)
+	db.lock.Locks(keys)
+	defer db.lock.UnLocks(keys)
 
-	srcitem, ok := engine.db.GetWithLock(keys[0])
+	srcitem, ok := db.data.GetWithLock(keys[0])
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -288,26 +295,27 @@ func ExecRpopLpush(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	dstitem, ok := engine.db.GetWithLock(keys[1])
+	dstitem, ok := db.data.GetWithLock(keys[1])
 	var dstl *QuickList
 	if !ok {
 		dstl = NewQuickList()
-		defer engine.db.SetWithLock(keys[1], dstl)
+		defer db.data.SetWithLock(keys[1], dstl)
 	} else if dstl, ok = dstitem.(*QuickList); !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
 	val := srcl.RemoveByIndex(-1)
 	if srcl.Len() == 0 {
-		engine.db.DelWithLock(keys[0])
-		engine.CancelTTL(keys[0])
+		db.data.DelWithLock(keys[0])
+		db.CancelTTL(keys[0])
 	}
 
 	dstl.Insert(0, val)
+	db.propagate(args)
 	return parser.NewBulkString(val)
 }
 
-func ExecLinsert(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLinsert(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 5 {
 		return parser.NewError("Invalid command format")
 	}
@@ -321,10 +329,10 @@ func ExecLinsert(engine *DBEngine, args [][]byte) parser.RespData {
 	pivot := args[3]
 	value := args[4]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -352,11 +360,12 @@ func ExecLinsert(engine *DBEngine, args [][]byte) parser.RespData {
 	case "after":
 		l.Insert(pivotIndex+1, value)
 	}
+	db.propagate(args)
 
 	return parser.NewInteger(int64(l.Len()))
 }
 
-func ExecLrem(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLrem(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -368,10 +377,10 @@ func ExecLrem(engine *DBEngine, args [][]byte) parser.RespData {
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -382,13 +391,16 @@ func ExecLrem(engine *DBEngine, args [][]byte) parser.RespData {
 
 	n := l.RemoveByCount(args[3], count)
 	if l.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	if n > 0 {
+		db.propagate(args)
 	}
 	return parser.NewInteger(int64(n))
 }
 
-func ExecLtrim(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecLtrim(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -403,10 +415,10 @@ func ExecLtrim(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Stop index is not an integer")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeOKReply()
 	}
@@ -444,6 +456,9 @@ func ExecLtrim(engine *DBEngine, args [][]byte) parser.RespData {
 		for !iter.atEnd() {
 			iter.remove()
 		}
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+		db.propagate(args)
 		return parser.MakeOKReply()
 	}
 
@@ -459,9 +474,10 @@ func ExecLtrim(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 
 	if l.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
 	}
+	db.propagate(args)
 	return parser.MakeOKReply()
 }
 
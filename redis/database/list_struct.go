@@ -344,9 +344,22 @@ func (ql *QuickList) removeCountReverse(val []byte, count int) int {
 // 若下标值超出范围，则设为边界值
 // 若start >= 列表长度，返回空列表
 func (ql *QuickList) Range(start, stop int) [][]byte {
-	if ql == nil || ql.Len() == 0 {
+	n := ql.RangeLen(start, stop)
+	if n == 0 {
 		return nil
 	}
+	vals := make([][]byte, 0, n)
+	ql.ForRange(start, stop, func(v []byte) {
+		vals = append(vals, v)
+	})
+	return vals
+}
+
+// 按照Range的规则把下标转换为[start, stop]，范围为空时ok为false
+func (ql *QuickList) rangeIndex(start, stop int) (int, int, bool) {
+	if ql == nil || ql.Len() == 0 {
+		return 0, 0, false
+	}
 
 	if start < 0 {
 		start += ql.len
@@ -354,28 +367,47 @@ func (ql *QuickList) Range(start, stop int) [][]byte {
 			start = 0
 		}
 	} else if start >= ql.len {
-		return nil
+		return 0, 0, false
 	}
 	if stop < 0 {
 		stop += ql.len
 		if stop < 0 {
-			return nil
+			return 0, 0, false
 		}
 	} else if stop >= ql.len {
 		stop = ql.len - 1
 	}
+	return start, stop, start <= stop
+}
+
+// Range返回的元素数量
+func (ql *QuickList) RangeLen(start, stop int) int {
+	start, stop, ok := ql.rangeIndex(start, stop)
+	if !ok {
+		return 0
+	}
+	return stop - start + 1
+}
 
-	if start > stop {
-		return nil
+// 和Range的下标规则相同，直接按页遍历元素，不需要构造结果数组
+func (ql *QuickList) ForRange(start, stop int, f func([]byte)) {
+	start, stop, ok := ql.rangeIndex(start, stop)
+	if !ok {
+		return
 	}
 
-	vals := make([][]byte, 0, stop-start+1)
 	iter := ql.Find(start)
-	for i := start; i <= stop; i++ {
-		vals = append(vals, iter.get().([]byte))
-		iter.next()
+	remain := stop - start + 1
+	for ele, offset := iter.ele, iter.offset; remain > 0; ele, offset = ele.Next(), 0 {
+		page := ele.Value.([]any)[offset:]
+		if len(page) > remain {
+			page = page[:remain]
+		}
+		for _, v := range page {
+			f(v.([]byte))
+		}
+		remain -= len(page)
 	}
-	return vals
 }
 
 // 支持负数下标
@@ -111,4 +111,10 @@ func TestList(t *testing.T) {
 	if !bytes.Equal(vals[1], []byte("888")) {
 		t.Fail()
 	}
+	for _, r := range [][3]int{{1, 23, 2}, {0, -1, 3}, {-2, -1, 2}, {2, 1, 0}, {5, 10, 0}, {-10, -4, 0}} {
+		if n := ql.RangeLen(r[0], r[1]); n != r[2] || n != len(ql.Range(r[0], r[1])) {
+			t.Logf("range %d %d: want %d, got %d", r[0], r[1], r[2], n)
+			t.Fail()
+		}
+	}
 }
@@ -26,7 +26,7 @@ func TestLpsuh(t *testing.T) {
 
 	args = LineToArgs("lrange l 0 -1")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := bulkArgs(reply)
 	for i := 0; i < len(data); i++ {
 		if string(data[i]) != strconv.Itoa(i+1) {
 			t.Fail()
@@ -79,7 +79,7 @@ func TestRpush(t *testing.T) {
 
 	args = LineToArgs("lrange l 0 -1")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := bulkArgs(reply)
 	for i := 0; i < len(data); i++ {
 		if string(data[i]) != strconv.Itoa(i+1) {
 			t.Fail()
@@ -185,7 +185,7 @@ func TestLrange(t *testing.T) {
 
 	args = LineToArgs("lrange l 0 -3")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := bulkArgs(reply)
 	for i := 0; i < 4; i++ {
 		if string(data[i]) != strconv.Itoa(i+1) {
 			t.Fail()
@@ -194,7 +194,7 @@ func TestLrange(t *testing.T) {
 
 	args = LineToArgs("lrange l 4 100")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = bulkArgs(reply)
 	for i := 0; i < 2; i++ {
 		if string(data[i]) != strconv.Itoa(i+5) {
 			t.Fail()
@@ -203,8 +203,8 @@ func TestLrange(t *testing.T) {
 
 	args = LineToArgs("lrange l 4 -6")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
-	if data != nil {
+	data = bulkArgs(reply)
+	if len(data) != 0 {
 		t.Fail()
 	}
 }
@@ -472,3 +472,21 @@ func TestTrim(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// 600个元素的lrange，回复编码到复用的连接缓冲区中
+// 编码时复用同一个缓冲区，和服务端写回复的方式相同
+func BenchmarkLrange(b *testing.B) {
+	engine := NewDBEngine()
+	args := [][]byte{[]byte("rpush"), []byte("l")}
+	for i := 0; i < 600; i++ {
+		args = append(args, []byte("element:"+strconv.Itoa(i)))
+	}
+	engine.ExecCmd(args)
+	cmd := LineToArgs("lrange l 0 -1")
+	var buf []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = engine.ExecCmd(cmd).AppendTo(buf[:0])
+	}
+}
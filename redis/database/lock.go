@@ -3,12 +3,21 @@ package database
 import (
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/HK40404/simpredis/utils/hash"
 )
 
 type ItemsLock struct {
 	l []sync.RWMutex
+	// 拍快照期间不为nil，拿到写锁之后、修改item之前调用，参数是锁的索引，见snapshot.go
+	beforeWrite atomic.Pointer[func(index int)]
+}
+
+func (lock *ItemsLock) notifyWrite(index int) {
+	if f := lock.beforeWrite.Load(); f != nil {
+		(*f)(index)
+	}
 }
 
 func NewItemsLock(lockCount int) *ItemsLock {
@@ -26,6 +35,7 @@ func (lock *ItemsLock) spread(key string) int {
 func (lock *ItemsLock) Lock(key string) {
 	index := lock.spread(key)
 	lock.l[index].Lock()
+	lock.notifyWrite(index)
 }
 
 func (lock *ItemsLock) UnLock(key string) {
@@ -64,6 +74,9 @@ func (lock *ItemsLock) Locks(keys []string) {
 	for _, index := range indices {
 		lock.l[index].Lock()
 	}
+	for _, index := range indices {
+		lock.notifyWrite(index)
+	}
 }
 
 func (lock *ItemsLock) UnLocks(keys []string) {
@@ -102,6 +115,9 @@ func (lock *ItemsLock) RWLocks(rkeys, wkeys []string) {
 			lock.l[index].RLock()
 		}
 	}
+	for index := range wIndicesSet {
+		lock.notifyWrite(index)
+	}
 }
 
 func (lock *ItemsLock) RWUnLocks(rkeys, wkeys []string) {
@@ -120,3 +136,41 @@ func (lock *ItemsLock) RWUnLocks(rkeys, wkeys []string) {
 		}
 	}
 }
+
+// 锁的数量和ConcurrentMap的shard数量相同时，第i个锁正好保护第i个shard中的所有key，
+// 遍历shard时只需要锁住这一个
+func (lock *ItemsLock) RLockShard(i int) {
+	lock.l[i].RLock()
+}
+
+func (lock *ItemsLock) RUnLockShard(i int) {
+	lock.l[i].RUnlock()
+}
+
+// 锁住所有item，用于获取某一时刻数据库的一致视图
+func (lock *ItemsLock) RLockAll() {
+	for i := range lock.l {
+		lock.l[i].RLock()
+	}
+}
+
+func (lock *ItemsLock) RUnLockAll() {
+	for i := range lock.l {
+		lock.l[i].RUnlock()
+	}
+}
+
+func (lock *ItemsLock) LockAll() {
+	for i := range lock.l {
+		lock.l[i].Lock()
+	}
+	for i := range lock.l {
+		lock.notifyWrite(i)
+	}
+}
+
+func (lock *ItemsLock) UnLockAll() {
+	for i := range lock.l {
+		lock.l[i].Unlock()
+	}
+}
@@ -0,0 +1,222 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HK40404/simpredis/redis/rdb"
+	"github.com/HK40404/simpredis/utils/config"
+	"github.com/HK40404/simpredis/utils/logger"
+)
+
+var ErrSaveInProgress = errors.New("background save already in progress")
+
+// save <seconds> <changes>：距上次保存超过seconds秒且至少有changes次修改时自动保存
+type saveParam struct {
+	seconds int64
+	changes int64
+}
+
+func parseSaveParams(s string) ([]saveParam, error) {
+	s = strings.Trim(strings.TrimSpace(s), "\"")
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save params")
+	}
+	params := make([]saveParam, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, errors.New("invalid save params")
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, errors.New("invalid save params")
+		}
+		params = append(params, saveParam{seconds: seconds, changes: changes})
+	}
+	return params, nil
+}
+
+// 将快照写入临时文件后再替换，保证rdb文件总是完整的
+func (engine *DBEngine) SaveRdb(filename string) error {
+	var dirty int64
+	entries := engine.snapshot(func() {
+		dirty = engine.dirty.Load()
+	})
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	if err = writeRdb(tmpFile, entries); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	engine.dirty.Add(-dirty)
+	engine.lastSave.Store(time.Now().Unix())
+	return nil
+}
+
+// entries按数据库排列，每个数据库写入一个select段
+func writeRdb(file *os.File, entries []*snapshotEntry) error {
+	enc := rdb.NewEncoder(file)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	if err := enc.WriteAux("redis-bits", "64"); err != nil {
+		return err
+	}
+	if err := enc.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
+		return err
+	}
+	for start := 0; start < len(entries); {
+		end, expireSize := start, 0
+		for ; end < len(entries) && entries[end].db == entries[start].db; end++ {
+			if entries[end].expireAt > 0 {
+				expireSize++
+			}
+		}
+		if err := enc.WriteDBHeader(entries[start].db, end-start, expireSize); err != nil {
+			return err
+		}
+		for _, entry := range entries[start:end] {
+			var err error
+			switch v := entry.value.(type) {
+			case []byte:
+				err = enc.WriteString(entry.key, v, entry.expireAt)
+			case [][]byte:
+				err = enc.WriteList(entry.key, v, entry.expireAt)
+			case []string:
+				err = enc.WriteSet(entry.key, v, entry.expireAt)
+			case map[string]string:
+				err = enc.WriteHash(entry.key, v, entry.expireAt)
+			case map[string]float64:
+				err = enc.WriteZSet(entry.key, v, entry.expireAt)
+			case *rdb.Stream:
+				err = enc.WriteStream(entry.key, v, entry.expireAt)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		start = end
+	}
+	return enc.WriteEnd()
+}
+
+func (engine *DBEngine) BgSave(filename string) error {
+	if !engine.saving.CompareAndSwap(false, true) {
+		return ErrSaveInProgress
+	}
+	engine.bgWg.Add(1)
+	go func() {
+		defer engine.bgWg.Done()
+		defer engine.saving.Store(false)
+		start := time.Now()
+		if err := engine.SaveRdb(filename); err != nil {
+			logger.Error("Background saving error: %v", err)
+			return
+		}
+		logger.Info("Background saving finished in %v", time.Since(start))
+	}()
+	return nil
+}
+
+// 从rdb文件恢复数据，文件不存在时直接返回
+func (engine *DBEngine) LoadRdb(filename string) error {
+	count, err := engine.loadRdbFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// 启动时恢复的数据不算修改
+	engine.dirty.Store(0)
+	logger.Info("Load %d keys from rdb file %s", count, filename)
+	return nil
+}
+
+// 把rdb文件中的key导入数据库，已存在的key会被覆盖，返回导入的key的数量
+func (engine *DBEngine) loadRdbFile(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	engine.dbsMu.RLock()
+	defer engine.dbsMu.RUnlock()
+	count := 0
+	now := engine.clock.Now().UnixMilli()
+	dec := rdb.NewDecoder(file)
+	err = dec.Parse(func(obj *rdb.Object) error {
+		if obj.DB >= len(engine.dbs) {
+			logger.Warn("Ignore key %s in db %d, only %d databases are configured", obj.Key, obj.DB, len(engine.dbs))
+			return nil
+		}
+		if obj.ExpireAt > 0 && obj.ExpireAt <= now {
+			return nil
+		}
+		engine.dbs[obj.DB].loadObject(obj)
+		count++
+		return nil
+	})
+	return count, err
+}
+
+func (db *DB) loadObject(obj *rdb.Object) {
+	item := newItem(obj.Value)
+	if item == nil {
+		return
+	}
+
+	db.lock.Lock(obj.Key)
+	defer db.lock.UnLock(obj.Key)
+	db.data.SetWithLock(obj.Key, item)
+	db.CancelTTL(obj.Key)
+	if obj.ExpireAt > 0 {
+		db.SetExpireAt(obj.Key, obj.ExpireAt)
+	}
+	db.propagateValue(obj.Key, obj.Value)
+}
+
+// 每秒检查一次是否满足save的条件
+func (engine *DBEngine) saveCron() {
+	defer engine.bgWg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			dirty := engine.dirty.Load()
+			elapsed := time.Now().Unix() - engine.lastSave.Load()
+			for _, param := range engine.saveParams {
+				if dirty >= param.changes && elapsed >= param.seconds && dirty > 0 {
+					logger.Info("%d changes in %d seconds. Saving...", param.changes, param.seconds)
+					if err := engine.BgSave(config.Cfg.DBFilename); err != nil && err != ErrSaveInProgress {
+						logger.Error("Fail to start background saving: %v", err)
+					}
+					break
+				}
+			}
+		case <-engine.closeCh:
+			return
+		}
+	}
+}
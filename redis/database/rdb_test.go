@@ -0,0 +1,249 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+func setRdbConfig(t *testing.T, save string) string {
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	old := *config.Cfg
+	config.Cfg.AppendOnly = "no"
+	config.Cfg.DBFilename = filename
+	config.Cfg.Save = save
+	t.Cleanup(func() { *config.Cfg = old })
+	return filename
+}
+
+func TestSaveAndLoadRdb(t *testing.T) {
+	filename := setRdbConfig(t, "")
+
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("set str v"))
+	engine.ExecCmd(LineToArgs("set num 12345"))
+	engine.ExecCmd(LineToArgs("set tmp v ex 100"))
+	engine.ExecCmd(LineToArgs("rpush l 1 2 3"))
+	engine.ExecCmd(LineToArgs("sadd s a b c"))
+	engine.ExecCmd(LineToArgs("hmset h f1 v1 f2 v2"))
+	engine.ExecCmd(LineToArgs("expire h 200"))
+	engine.ExecCmd(LineToArgs("zadd z 1.5 a -inf b 3 c"))
+	engine.ExecCmd(LineToArgs("xadd x 1-0 f v"))
+	engine.ExecCmd(LineToArgs("xadd x 2-0 f v"))
+	engine.ExecCmd(LineToArgs("xgroup create x g 0"))
+	engine.ExecCmd(LineToArgs("xreadgroup group g c count 1 streams x >"))
+	engine.ExecCmd(LineToArgs("xgroup createconsumer x g idle"))
+	engine.ExecCmd(LineToArgs("xdel x 2-0"))
+	engine.ExecCmd(LineToArgs("xadd ex maxlen 0 5-0 f v"))
+
+	before := engine.ExecCmd(LineToArgs("lastsave")).(*parser.Integer).Arg
+	time.Sleep(time.Second)
+	if reply, ok := engine.ExecCmd(LineToArgs("save")).(*parser.String); !ok || reply.Arg != "OK" {
+		t.Log("fail to save rdb")
+		t.FailNow()
+	}
+	if after := engine.ExecCmd(LineToArgs("lastsave")).(*parser.Integer).Arg; after <= before {
+		t.Logf("lastsave should be updated, before %d, after %d", before, after)
+		t.Fail()
+	}
+	if engine.dirty.Load() != 0 {
+		t.Log("dirty should be reset after saving")
+		t.Fail()
+	}
+
+	loaded := NewDBEngine()
+	if err := loaded.InitPersistence(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	if string(loaded.ExecCmd(LineToArgs("get str")).(*parser.BulkString).Arg) != "v" {
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("get num")).(*parser.BulkString).Arg) != "12345" {
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("lindex l 2")).(*parser.BulkString).Arg) != "3" {
+		t.Fail()
+	}
+	if loaded.ExecCmd(LineToArgs("scard s")).(*parser.Integer).Arg != 3 {
+		t.Fail()
+	}
+	if string(loaded.ExecCmd(LineToArgs("hget h f2")).(*parser.BulkString).Arg) != "v2" {
+		t.Fail()
+	}
+	if zrange := bulkArgs(loaded.ExecCmd(LineToArgs("zrange z 0 -1 withscores"))); string(bytes.Join(zrange, []byte(" "))) != "b -inf a 1.5 c 3" {
+		t.Logf("wrong zset %s", zrange)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xrange x - +"))); got != "1-0 f v" {
+		t.Logf("wrong stream %s", got)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xpending x g"))); got != "1 1-0 1-0 c 1" {
+		t.Logf("wrong pending entries %s", got)
+		t.Fail()
+	}
+	if got := streamReply(loaded.ExecCmd(LineToArgs("xinfo groups x"))); !strings.HasPrefix(got, "name g consumers 2 pending 1 last-delivered-id 1-0 entries-read 1") {
+		t.Logf("wrong consumer group %s", got)
+		t.Fail()
+	}
+	// 最大ID在删除和裁剪之后也要保留
+	if _, ok := loaded.ExecCmd(LineToArgs("xadd x 2-0 f v")).(*parser.Error); !ok {
+		t.Fail()
+	}
+	if _, ok := loaded.ExecCmd(LineToArgs("xadd ex 5-0 f v")).(*parser.Error); !ok || loaded.ExecCmd(LineToArgs("type ex")).(*parser.String).Arg != "stream" {
+		t.Log("empty stream should be kept")
+		t.Fail()
+	}
+	if ttl := loaded.ExecCmd(LineToArgs("ttl tmp")).(*parser.Integer).Arg; ttl < 97 || ttl > 100 {
+		t.Log(ttl)
+		t.Fail()
+	}
+	if ttl := loaded.ExecCmd(LineToArgs("ttl h")).(*parser.Integer).Arg; ttl < 197 || ttl > 200 {
+		t.Log(ttl)
+		t.Fail()
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestBgSave(t *testing.T) {
+	filename := setRdbConfig(t, "")
+
+	engine := NewDBEngine()
+	for i := 0; i < 100; i++ {
+		engine.ExecCmd(LineToArgs("incr counter"))
+	}
+	if _, ok := engine.ExecCmd(LineToArgs("bgsave")).(*parser.String); !ok {
+		t.Log("fail to start bgsave")
+		t.FailNow()
+	}
+	engine.Close()
+
+	loaded := NewDBEngine()
+	if err := loaded.LoadRdb(filename); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if string(loaded.ExecCmd(LineToArgs("get counter")).(*parser.BulkString).Arg) != "100" {
+		t.Fail()
+	}
+}
+
+func TestSaveParams(t *testing.T) {
+	filename := setRdbConfig(t, "1 1")
+
+	engine := NewDBEngine()
+	if err := engine.InitPersistence(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer engine.Close()
+	engine.ExecCmd(LineToArgs("set k v"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(filename); err == nil && !engine.saving.Load() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Log("rdb should be saved automatically")
+			t.FailNow()
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := parseSaveParams("900"); err == nil {
+		t.Fail()
+	}
+	if params, err := parseSaveParams(`""`); err != nil || len(params) != 0 {
+		t.Fail()
+	}
+}
+
+func TestLoadRdbCommand(t *testing.T) {
+	rdbFile := setRdbConfig(t, "")
+	src := NewDBEngine()
+	src.ExecCmd(LineToArgs("set str v"))
+	src.ExecCmd(LineToArgs("rpush l 1 2 3"))
+	src.ExecCmd(LineToArgs("hmset h f v"))
+	src.ExecCmd(LineToArgs("expire h 100"))
+	if err := src.SaveRdb(rdbFile); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// 导入的key要写入aof，重启后才不会丢失
+	setAofConfig(t, FsyncAlways)
+	engine := NewDBEngine()
+	if err := engine.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	engine.ExecCmd(LineToArgs("rpush l old"))
+	if reply, ok := engine.ExecCmd(LineToArgs("loadrdb " + rdbFile)).(*parser.Integer); !ok || reply.Arg != 3 {
+		t.Log("should load 3 keys")
+		t.FailNow()
+	}
+	if _, ok := engine.ExecCmd(LineToArgs("loadrdb " + rdbFile + ".missing")).(*parser.Error); !ok {
+		t.Log("loading missing file should fail")
+		t.Fail()
+	}
+	engine.Close()
+
+	loaded := NewDBEngine()
+	if err := loaded.InitAof(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	if string(loaded.ExecCmd(LineToArgs("get str")).(*parser.BulkString).Arg) != "v" {
+		t.Fail()
+	}
+	if loaded.ExecCmd(LineToArgs("llen l")).(*parser.Integer).Arg != 3 {
+		t.Log("old list should be replaced")
+		t.Fail()
+	}
+	if ttl := loaded.ExecCmd(LineToArgs("ttl h")).(*parser.Integer).Arg; ttl < 97 || ttl > 100 {
+		t.Log(ttl)
+		t.Fail()
+	}
+}
+
+func TestRdbMultiDB(t *testing.T) {
+	setRdbConfig(t, "")
+	engine := NewDBEngine()
+	execIn(engine, &Session{DB: 0}, "set k v0")
+	execIn(engine, &Session{DB: 5}, "set k v5")
+	execIn(engine, &Session{DB: 5}, "expire k 100")
+	execIn(engine, &Session{DB: 15}, "sadd s a b")
+	if _, ok := engine.ExecCmd(LineToArgs("save")).(*parser.String); !ok {
+		t.FailNow()
+	}
+
+	loaded := NewDBEngine()
+	if err := loaded.InitPersistence(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer loaded.Close()
+	if getIn(loaded, 0, "k") != "v0" || getIn(loaded, 5, "k") != "v5" {
+		t.Fail()
+	}
+	if ttl := loaded.Exec(&Session{DB: 5}, LineToArgs("ttl k")).(*parser.Integer).Arg; ttl <= 0 {
+		t.Fail()
+	}
+	if loaded.Exec(&Session{DB: 15}, LineToArgs("scard s")).(*parser.Integer).Arg != 2 {
+		t.Fail()
+	}
+}
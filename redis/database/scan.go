@@ -0,0 +1,137 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/glob"
+)
+
+// map的遍历顺序不固定，scan系列命令通过scanIndex按照key的哈希值从小到大遍历，cursor记录下一次开始的哈希值
+type scanEntry struct {
+	key   string
+	value any
+}
+
+type scanOptions struct {
+	pattern  string // 为空表示不过滤
+	count    int
+	typ      string // 只用于scan
+	noValues bool   // 只用于hscan
+}
+
+// 解析cursor之后的选项，extra是MATCH和COUNT之外允许的选项
+func parseScanOptions(args [][]byte, extra ...string) (*scanOptions, parser.RespData) {
+	opts := &scanOptions{count: 10}
+	allowed := func(name string) bool {
+		for _, e := range extra {
+			if e == name {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(string(args[i]))
+		switch {
+		case name == "match" && i+1 < len(args):
+			opts.pattern = string(args[i+1])
+			if opts.pattern == "*" {
+				opts.pattern = ""
+			}
+			i++
+		case name == "count" && i+1 < len(args):
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, parser.NewError("Value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, parser.NewError("Invalid command format")
+			}
+			opts.count = count
+			i++
+		case name == "type" && i+1 < len(args) && allowed(name):
+			opts.typ = strings.ToLower(string(args[i+1]))
+			i++
+		case name == "novalues" && allowed(name):
+			opts.noValues = true
+		default:
+			return nil, parser.NewError("Invalid command format")
+		}
+	}
+	return opts, nil
+}
+
+func (opts *scanOptions) match(key string) bool {
+	return opts.pattern == "" || glob.Match(opts.pattern, key)
+}
+
+func parseCursor(arg []byte) (uint64, parser.RespData) {
+	cursor, err := strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		return 0, parser.NewError("ERR invalid cursor")
+	}
+	return cursor, nil
+}
+
+// scan的回复：下一次的cursor和这一次的元素
+func makeScanReply(cursor uint64, elems *parser.BulkArray) parser.RespData {
+	return parser.NewMultiBulk([]parser.RespData{
+		parser.NewBulkString([]byte(strconv.FormatUint(cursor, 10))),
+		elems,
+	})
+}
+
+// keys pattern：会遍历整个数据库，key很多时应该使用scan
+func ExecKeys(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	pattern := string(args[1])
+	reply := parser.NewBulkArray()
+	// SetWithLock等写操作只持有item锁，每次只锁住正在遍历的shard对应的锁
+	for i := 0; i < db.data.ShardCount(); i++ {
+		db.lock.RLockShard(i)
+		db.data.ForEachInShard(i, func(key string, value any) bool {
+			if glob.Match(pattern, key) {
+				reply.AddString(key)
+			}
+			return true
+		})
+		db.lock.RUnLockShard(i)
+	}
+	return reply
+}
+
+// scan cursor [MATCH pattern] [COUNT count] [TYPE type]
+func ExecScan(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	cursor, errReply := parseCursor(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[2:], "type")
+	if errReply != nil {
+		return errReply
+	}
+
+	entries, next := db.data.Scan(cursor, opts.count)
+	keys := parser.NewBulkArray()
+	for _, entry := range entries {
+		if opts.typ != "" && typeName(entry.value) != opts.typ {
+			continue
+		}
+		if opts.match(entry.key) {
+			keys.AddString(entry.key)
+		}
+	}
+	return makeScanReply(next, keys)
+}
+
+func init() {
+	RegisterCmd("keys", ExecKeys)
+	RegisterCmd("scan", ExecScan)
+}
@@ -0,0 +1,106 @@
+package database
+
+import "github.com/HK40404/simpredis/utils/hash"
+
+// scanIndex 和map一起保存元素，按哈希值的高bits位把元素分到1<<bits个桶中，桶的顺序就是哈希值的顺序。
+// cursor是哈希值空间中的位置，和桶的数量无关，两次scan之间扩容或者缩容不影响遍历，
+// 每次scan只访问count个左右的桶，不需要遍历整个集合。扩容和缩容时一次性重新分桶，均摊到每次增删是O(1)
+type scanIndex struct {
+	buckets [][]indexEntry
+	bits    uint
+	count   int
+}
+
+type indexEntry struct {
+	hash uint64
+	key  string
+}
+
+// FNV的高位分布不均匀，只有结尾不同的key高位几乎相同，用murmur3的fmix64打散之后再分桶
+func scanHash(key string) uint64 {
+	h := hash.Fnv64(key)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+func newScanIndex() *scanIndex {
+	return &scanIndex{buckets: make([][]indexEntry, 1)}
+}
+
+func (idx *scanIndex) bucket(hv uint64) uint64 {
+	if idx.bits == 0 {
+		return 0
+	}
+	return hv >> (64 - idx.bits)
+}
+
+// 调用者保证key不在索引中
+func (idx *scanIndex) add(key string) {
+	hv := scanHash(key)
+	b := idx.bucket(hv)
+	idx.buckets[b] = append(idx.buckets[b], indexEntry{hash: hv, key: key})
+	idx.count++
+	// 负载因子超过1时扩容
+	if idx.count > len(idx.buckets) {
+		idx.resize(idx.bits + 1)
+	}
+}
+
+func (idx *scanIndex) remove(key string) {
+	hv := scanHash(key)
+	b := idx.bucket(hv)
+	entries := idx.buckets[b]
+	for i := range entries {
+		if entries[i].hash == hv && entries[i].key == key {
+			last := len(entries) - 1
+			entries[i] = entries[last]
+			entries[last] = indexEntry{}
+			if last == 0 {
+				entries = nil
+			}
+			idx.buckets[b] = entries[:last]
+			idx.count--
+			break
+		}
+	}
+	// 元素少于桶数量的1/4时缩容，避免遍历大量空桶
+	if idx.bits > 0 && idx.count < len(idx.buckets)/4 {
+		idx.resize(idx.bits - 1)
+	}
+}
+
+// 哈希值已经保存在indexEntry中，重新分桶不需要计算哈希
+func (idx *scanIndex) resize(bits uint) {
+	old := idx.buckets
+	idx.bits = bits
+	idx.buckets = make([][]indexEntry, 1<<bits)
+	for _, entries := range old {
+		for _, entry := range entries {
+			b := idx.bucket(entry.hash)
+			idx.buckets[b] = append(idx.buckets[b], entry)
+		}
+	}
+}
+
+// 从哈希值pos开始返回整个桶，直到返回了至少count个元素，f收到的key的哈希值都在[pos, next)之间。
+// 只要元素在整个遍历期间一直存在，就一定会被返回。遍历完时done为true
+func (idx *scanIndex) scan(pos uint64, count int, f func(key string)) (next uint64, done bool) {
+	n := 0
+	for b := idx.bucket(pos); b < uint64(len(idx.buckets)); b++ {
+		if n >= count {
+			return b << (64 - idx.bits), false
+		}
+		for _, entry := range idx.buckets[b] {
+			// 缩容之后pos可能在桶的中间
+			if entry.hash >= pos {
+				f(entry.key)
+				n++
+			}
+		}
+	}
+	return 0, true
+}
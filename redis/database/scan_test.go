@@ -0,0 +1,260 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+// 解析scan的回复，返回下一次的cursor和元素
+func scanReply(t *testing.T, reply parser.RespData) (string, []string) {
+	mb, ok := reply.(*parser.MultiBulk)
+	if !ok || len(mb.Args) != 2 {
+		t.Logf("invalid scan reply: %q", reply.Serialize())
+		t.FailNow()
+	}
+	cursor := string(mb.Args[0].(*parser.BulkString).Arg)
+	var elems []string
+	for _, arg := range bulkArgs(mb.Args[1]) {
+		elems = append(elems, string(arg))
+	}
+	return cursor, elems
+}
+
+// 从0开始scan直到cursor回到0，返回所有元素。opts是cursor之后的选项
+func scanAll(t *testing.T, engine *DBEngine, cmd string, opts string) []string {
+	var all []string
+	cursor := "0"
+	for i := 0; ; i++ {
+		if i > 100000 {
+			t.Log("scan does not terminate")
+			t.FailNow()
+		}
+		var elems []string
+		cursor, elems = scanReply(t, engine.ExecCmd(LineToArgs(cmd+" "+cursor+" count 10 "+opts)))
+		all = append(all, elems...)
+		if cursor == "0" {
+			return all
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("mset user:1 a user:2 b order:1 c"))
+	var keys []string
+	for _, key := range bulkArgs(engine.ExecCmd(LineToArgs("keys user:*"))) {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "user:1" || keys[1] != "user:2" {
+		t.Logf("wrong keys %v", keys)
+		t.Fail()
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("keys *")))) != 3 {
+		t.Fail()
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("keys nothing*")))) != 0 {
+		t.Fail()
+	}
+}
+
+func TestScan(t *testing.T) {
+	engine := NewDBEngine()
+	for i := 0; i < 1000; i++ {
+		engine.ExecCmd(LineToArgs("set key" + strconv.Itoa(i) + " v"))
+	}
+	engine.ExecCmd(LineToArgs("rpush list a"))
+
+	seen := make(map[string]bool)
+	for _, key := range scanAll(t, engine, "scan", "") {
+		seen[key] = true
+	}
+	if len(seen) != 1001 {
+		t.Logf("want 1001 keys, got %d", len(seen))
+		t.Fail()
+	}
+
+	matched := scanAll(t, engine, "scan", "match key1?")
+	if len(matched) != 10 {
+		t.Logf("want 10 keys matching key1?, got %v", matched)
+		t.Fail()
+	}
+	typed := scanAll(t, engine, "scan", "type list")
+	if len(typed) != 1 || typed[0] != "list" {
+		t.Logf("want only list, got %v", typed)
+		t.Fail()
+	}
+
+	for _, line := range []string{"scan abc", "scan 0 count 0", "scan 0 count", "scan 0 novalues"} {
+		if _, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok {
+			t.Logf("%s should fail", line)
+			t.Fail()
+		}
+	}
+}
+
+// 遍历期间一直存在的key一定会被返回，即使同时有其他key被写入和删除
+func TestScanConcurrentWrites(t *testing.T) {
+	engine := NewDBEngine()
+	for i := 0; i < 2000; i++ {
+		engine.ExecCmd(LineToArgs("set stable" + strconv.Itoa(i) + " v"))
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100000; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := "tmp" + strconv.Itoa(i%5000)
+			engine.ExecCmd(LineToArgs("set " + key + " v"))
+			if i%3 == 0 {
+				engine.ExecCmd(LineToArgs("del tmp" + strconv.Itoa((i/3)%5000)))
+			}
+		}
+	}()
+
+	seen := make(map[string]bool)
+	for _, key := range scanAll(t, engine, "scan", "") {
+		seen[key] = true
+	}
+	close(stop)
+	wg.Wait()
+	for i := 0; i < 2000; i++ {
+		if !seen["stable"+strconv.Itoa(i)] {
+			t.Logf("stable%d is missing", i)
+			t.Fail()
+		}
+	}
+}
+
+// 两次scan之间增删元素导致扩容和缩容时，一直存在的元素仍然都会被返回，每次返回的数量接近count
+func TestScanIndex(t *testing.T) {
+	idx := newScanIndex()
+	for i := 0; i < 1000; i++ {
+		idx.add("k" + strconv.Itoa(i))
+	}
+	seen := make(map[string]bool)
+	pos, calls := uint64(0), 0
+	for done := false; !done; calls++ {
+		n := 0
+		pos, done = idx.scan(pos, 10, func(key string) {
+			seen[key] = true
+			n++
+		})
+		if n > 30 {
+			t.Logf("%d keys in one call", n)
+			t.Fail()
+		}
+		switch calls {
+		case 10:
+			// 缩容
+			for i := 100; i < 1000; i++ {
+				idx.remove("k" + strconv.Itoa(i))
+			}
+		case 12:
+			// 扩容
+			for i := 0; i < 5000; i++ {
+				idx.add("new" + strconv.Itoa(i))
+			}
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Logf("k%d is missing", i)
+			t.Fail()
+		}
+	}
+	if idx.count != 5100 || len(idx.buckets) < idx.count {
+		t.Logf("%d keys in %d buckets", idx.count, len(idx.buckets))
+		t.Fail()
+	}
+}
+
+// 每次sscan的开销和count相关，和集合大小无关
+func TestSscanCount(t *testing.T) {
+	engine := NewDBEngine()
+	args := [][]byte{[]byte("sadd"), []byte("s")}
+	for i := 0; i < 100000; i++ {
+		args = append(args, []byte("member:"+strconv.Itoa(i)))
+	}
+	engine.ExecCmd(args)
+	_, members := scanReply(t, engine.ExecCmd(LineToArgs("sscan s 0 count 10")))
+	if len(members) < 10 || len(members) > 30 {
+		t.Logf("count 10 returns %d members", len(members))
+		t.Fail()
+	}
+}
+
+func BenchmarkSscan(b *testing.B) {
+	engine := NewDBEngine()
+	args := [][]byte{[]byte("sadd"), []byte("s")}
+	for i := 0; i < 200000; i++ {
+		args = append(args, []byte("member:"+strconv.Itoa(i)))
+	}
+	engine.ExecCmd(args)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.ExecCmd(LineToArgs("sscan s 0 count 10"))
+	}
+}
+
+// keys和scan只锁住正在遍历的shard，其他shard的写锁不影响已经遍历过的部分
+func TestScanLocksOneShard(t *testing.T) {
+	engine := NewDBEngine()
+	db := engine.dbs[0]
+	last := db.data.ShardCount() - 1
+	var first, locked string
+	for i := 0; first == "" || locked == ""; i++ {
+		key := "k" + strconv.Itoa(i)
+		switch db.data.spread(key) {
+		case 0:
+			first = key
+		case last:
+			locked = key
+		}
+	}
+	engine.ExecCmd(LineToArgs("set " + first + " v"))
+
+	db.lock.Lock(locked)
+	replies := make(chan parser.RespData)
+	go func() {
+		replies <- engine.ExecCmd(LineToArgs("scan 0 count 1"))
+	}()
+	select {
+	case reply := <-replies:
+		if _, keys := scanReply(t, reply); len(keys) != 1 || keys[0] != first {
+			t.Logf("wrong keys %v", keys)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Log("scan should not wait for the lock of another shard")
+		t.Fail()
+	}
+
+	go func() {
+		replies <- engine.ExecCmd(LineToArgs("keys *"))
+	}()
+	select {
+	case <-replies:
+		t.Log("keys should wait for the locked shard")
+		t.Fail()
+	case <-time.After(50 * time.Millisecond):
+	}
+	db.lock.UnLock(locked)
+	if keys := bulkArgs(<-replies); len(keys) != 1 || string(keys[0]) != first {
+		t.Logf("wrong keys %q", keys)
+		t.Fail()
+	}
+}
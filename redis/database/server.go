@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+func ExecBgRewriteAof(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 1 {
+		return parser.NewError("Invalid command format")
+	}
+	if err := engine.BgRewriteAof(); err != nil {
+		return parser.NewError("ERR " + err.Error())
+	}
+	return parser.NewString("Background append only file rewriting started")
+}
+
+func ExecSave(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 1 {
+		return parser.NewError("Invalid command format")
+	}
+	if !engine.saving.CompareAndSwap(false, true) {
+		return parser.NewError("ERR " + ErrSaveInProgress.Error())
+	}
+	defer engine.saving.Store(false)
+	if err := engine.SaveRdb(config.Cfg.DBFilename); err != nil {
+		return parser.NewError("ERR " + err.Error())
+	}
+	return parser.MakeOKReply()
+}
+
+func ExecBgSave(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 1 {
+		return parser.NewError("Invalid command format")
+	}
+	if err := engine.BgSave(config.Cfg.DBFilename); err != nil {
+		return parser.NewError("ERR " + err.Error())
+	}
+	return parser.NewString("Background saving started")
+}
+
+func ExecLastSave(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 1 {
+		return parser.NewError("Invalid command format")
+	}
+	return parser.NewInteger(engine.lastSave.Load())
+}
+
+// loadrdb path：导入已有的rdb文件，返回导入的key的数量
+func ExecLoadRdb(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	count, err := engine.loadRdbFile(string(args[1]))
+	if err != nil {
+		return parser.NewError("ERR " + err.Error())
+	}
+	return parser.NewInteger(int64(count))
+}
+
+// info [section]：支持stats和keyspace两部分，不指定时返回全部
+func ExecInfo(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) > 2 {
+		return parser.NewError("Invalid command format")
+	}
+	section := "all"
+	if len(args) == 2 {
+		section = strings.ToLower(string(args[1]))
+	}
+	all := section == "all" || section == "default" || section == "everything"
+
+	var b strings.Builder
+	if all || section == "stats" {
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "expired_keys:%d\r\n", engine.expiredKeys.Load())
+		fmt.Fprintf(&b, "expire_cycle_cpu_milliseconds:%d\r\n", time.Duration(engine.expireCycleTime.Load()).Milliseconds())
+		fmt.Fprintf(&b, "expired_time_cap_reached_count:%d\r\n", engine.expireTimeCapReached.Load())
+	}
+	if all || section == "keyspace" {
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# Keyspace\r\n")
+		engine.dbsMu.RLock()
+		for i, db := range engine.dbs {
+			if keys := db.data.Len(); keys > 0 {
+				fmt.Fprintf(&b, "db%d:keys=%d,expires=%d\r\n", i, keys, db.ttldb.Len())
+			}
+		}
+		engine.dbsMu.RUnlock()
+	}
+	return parser.NewBulkString([]byte(b.String()))
+}
+
+func init() {
+	RegisterEngineCmd("bgrewriteaof", ExecBgRewriteAof)
+	RegisterEngineCmd("save", ExecSave)
+	RegisterEngineCmd("bgsave", ExecBgSave)
+	RegisterEngineCmd("lastsave", ExecLastSave)
+	RegisterEngineCmd("loadrdb", ExecLoadRdb)
+	RegisterEngineCmd("info", ExecInfo)
+}
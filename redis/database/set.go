@@ -6,21 +6,21 @@ import (
 	parser "github.com/HK40404/simpredis/redis/resp"
 )
 
-func ExecSadd(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSadd(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 	members := args[2:]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
 	var set *Set
-	item, ok := engine.db.GetWithLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		set = NewSet()
-		defer engine.db.SetWithLock(key, set)
+		defer db.data.SetWithLock(key, set)
 	} else {
 		set, ok = item.(*Set)
 		if !ok {
@@ -36,18 +36,21 @@ func ExecSadd(engine *DBEngine, args [][]byte) parser.RespData {
 		count++
 		set.Add(string(m))
 	}
+	if count > 0 {
+		db.propagate(args)
+	}
 	return parser.NewInteger(int64(count))
 }
 
-func ExecScard(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecScard(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -58,41 +61,42 @@ func ExecScard(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(len(set.s)))
 }
 
-func ExecSmembers(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSmembers(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
-		return parser.NewArray(nil)
+		return parser.NewSet(nil)
 	}
 	set, ok := item.(*Set)
 	if !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	members := make([][]byte, 0, set.Len())
-	for _, m := range set.Members() {
-		members = append(members, []byte(m))
-	}
-
-	return parser.NewArray(members)
+	reply := parser.NewBulkSet()
+	reply.Grow(set.Len())
+	set.ForEach(func(m string) bool {
+		reply.AddString(m)
+		return true
+	})
+	return reply
 }
 
-func ExecSrem(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSrem(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 	members := args[2:]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -108,23 +112,26 @@ func ExecSrem(engine *DBEngine, args [][]byte) parser.RespData {
 		}
 	}
 	if set.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	if delCount > 0 {
+		db.propagate(args)
 	}
 
 	return parser.NewInteger(int64(delCount))
 }
 
-func ExecSismember(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSismember(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 	member := string(args[2])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -139,7 +146,7 @@ func ExecSismember(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(0)
 }
 
-func ExecSinter(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSinter(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 2 {
 		return parser.NewError("Invalid command format")
 	}
@@ -148,12 +155,12 @@ func ExecSinter(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(k))
 	}
 	sets := make([]*Set, 0, len(keys))
-	engine.lock.RLocks(keys)
-	defer engine.lock.RUnLocks(keys)
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
-			return parser.NewArray(nil)
+			return parser.NewSet(nil)
 		}
 		set, ok := item.(*Set)
 		if !ok {
@@ -166,10 +173,10 @@ func ExecSinter(engine *DBEngine, args [][]byte) parser.RespData {
 	for _, m := range Inter(sets) {
 		members = append(members, []byte(m))
 	}
-	return parser.NewArray(members)
+	return parser.NewSet(parser.NewBulkStrings(members))
 }
 
-func ExecSinterstore(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSinterstore(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -180,12 +187,12 @@ func ExecSinterstore(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(k))
 	}
 
-	engine.lock.RWLocks(keys, []string{storekey})
-	defer engine.lock.RWUnLocks(keys, []string{storekey})
+	db.lock.RWLocks(keys, []string{storekey})
+	defer db.lock.RWUnLocks(keys, []string{storekey})
 
 	sets := make([]*Set, 0, len(keys))
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			return parser.NewArray(nil)
 		}
@@ -200,21 +207,22 @@ func ExecSinterstore(engine *DBEngine, args [][]byte) parser.RespData {
 	for _, m := range Inter(sets) {
 		storeset.Add(m)
 	}
-	engine.CancelTTL(storekey)
-	engine.db.SetWithLock(storekey, storeset)
+	db.CancelTTL(storekey)
+	db.data.SetWithLock(storekey, storeset)
+	db.propagate(args)
 
 	return parser.NewInteger(int64(storeset.Len()))
 }
 
-func ExecSpop(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSpop(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -225,14 +233,16 @@ func ExecSpop(engine *DBEngine, args [][]byte) parser.RespData {
 
 	str := set.Pop()
 	if set.Len() == 0 {
-		engine.db.DelWithLock(key)
-		engine.CancelTTL(key)
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
 	}
+	// spop是随机的，记录为srem才能保证重放结果一致
+	db.propagate([][]byte{[]byte("srem"), args[1], []byte(str)})
 
 	return parser.NewBulkString([]byte(str))
 }
 
-func ExecSrandmember(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSrandmember(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 && len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -246,9 +256,9 @@ func ExecSrandmember(engine *DBEngine, args [][]byte) parser.RespData {
 		}
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -268,7 +278,7 @@ func ExecSrandmember(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewArray(members)
 }
 
-func ExecSdiff(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSdiff(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 2 {
 		return parser.NewError("Invalid command format")
 	}
@@ -277,13 +287,13 @@ func ExecSdiff(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(k))
 	}
 
-	engine.lock.RLocks(keys)
-	defer engine.lock.RUnLocks(keys)
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
 
 	sets := make([]*Set, 0, len(keys))
 
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			sets = append(sets, nil)
 			continue
@@ -296,7 +306,7 @@ func ExecSdiff(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 
 	if sets[0] == nil {
-		return parser.NewArray(nil)
+		return parser.NewSet(nil)
 	}
 	diff := make([][]byte, 0, sets[0].Len()/2)
 	sets[0].ForEach(func(s string) bool {
@@ -312,10 +322,10 @@ func ExecSdiff(engine *DBEngine, args [][]byte) parser.RespData {
 		}
 		return true
 	})
-	return parser.NewArray(diff)
+	return parser.NewSet(parser.NewBulkStrings(diff))
 }
 
-func ExecSdiffstore(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSdiffstore(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -325,12 +335,12 @@ func ExecSdiffstore(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(v))
 	}
 
-	engine.lock.RWLocks(keys, []string{storekey})
-	defer engine.lock.RWUnLocks(keys, []string{storekey})
+	db.lock.RWLocks(keys, []string{storekey})
+	defer db.lock.RWUnLocks(keys, []string{storekey})
 
 	sets := make([]*Set, 0, len(keys))
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			sets = append(sets, nil)
 			continue
@@ -344,8 +354,9 @@ func ExecSdiffstore(engine *DBEngine, args [][]byte) parser.RespData {
 
 	if sets[0] == nil {
 		// empty set
-		engine.CancelTTL(storekey)
-		engine.db.DelWithLock(storekey)
+		db.CancelTTL(storekey)
+		db.data.DelWithLock(storekey)
+		db.propagate(args)
 		return parser.NewInteger(0)
 	}
 	diff := make([]string, 0, sets[0].Len()/2)
@@ -367,13 +378,14 @@ func ExecSdiffstore(engine *DBEngine, args [][]byte) parser.RespData {
 	for _, m := range diff {
 		storeset.Add(m)
 	}
-	engine.CancelTTL(storekey)
-	engine.db.SetWithLock(storekey, storeset)
+	db.CancelTTL(storekey)
+	db.data.SetWithLock(storekey, storeset)
+	db.propagate(args)
 
 	return parser.NewInteger(int64(storeset.Len()))
 }
 
-func ExecSmove(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSmove(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -382,10 +394,10 @@ func ExecSmove(engine *DBEngine, args [][]byte) parser.RespData {
 	dstkey := string(args[2])
 	member := string(args[3])
 
-	engine.lock.Locks([]string{srckey, dstkey})
-	defer engine.lock.UnLocks([]string{srckey, dstkey})
+	db.lock.Locks([]string{srckey, dstkey})
+	defer db.lock.UnLocks([]string{srckey, dstkey})
 
-	srcitem, ok := engine.db.GetWithLock(srckey)
+	srcitem, ok := db.data.GetWithLock(srckey)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -394,34 +406,44 @@ func ExecSmove(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if !srcset.Remove(member) {
+	// 源集合和目标集合相同时不需要移动
+	if srckey == dstkey {
+		if srcset.IsMember(member) {
+			return parser.NewInteger(1)
+		}
 		return parser.NewInteger(0)
 	}
-	if srcset.Len() == 0 {
-		engine.db.DelWithLock(srckey)
-		engine.CancelTTL(srckey)
-	}
 
+	// 先检查目标类型，避免移除成员后才发现无法写入
 	var dstset *Set
-	item, ok := engine.db.GetWithLock(dstkey)
-	if !ok {
-		dstset = NewSet()
-		defer engine.db.SetWithLock(dstkey, dstset)
-	} else {
+	item, ok := db.data.GetWithLock(dstkey)
+	if ok {
 		dstset, ok = item.(*Set)
 		if !ok {
 			return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
 	}
 
-	if dstset.IsMember(member) {
-		return parser.NewInteger(1)
+	if !srcset.Remove(member) {
+		return parser.NewInteger(0)
+	}
+	if srcset.Len() == 0 {
+		db.data.DelWithLock(srckey)
+		db.CancelTTL(srckey)
 	}
-	dstset.Add(member)
+
+	if dstset == nil {
+		dstset = NewSet()
+		dstset.Add(member)
+		db.data.SetWithLock(dstkey, dstset)
+	} else {
+		dstset.Add(member)
+	}
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecSunion(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSunion(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 2 {
 		return parser.NewError("Invalid command format")
 	}
@@ -431,12 +453,12 @@ func ExecSunion(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(k))
 	}
 
-	engine.lock.RLocks(keys)
-	defer engine.lock.RUnLocks(keys)
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
 
 	var sets []*Set
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			sets = append(sets, nil)
 			continue
@@ -453,10 +475,10 @@ func ExecSunion(engine *DBEngine, args [][]byte) parser.RespData {
 	for _, m := range union {
 		members = append(members, []byte(m))
 	}
-	return parser.NewArray(members)
+	return parser.NewSet(parser.NewBulkStrings(members))
 }
 
-func ExecSunionStore(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSunionStore(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -467,12 +489,12 @@ func ExecSunionStore(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(k))
 	}
 
-	engine.lock.RWLocks(keys, []string{dstkey})
-	defer engine.lock.RWUnLocks(keys, []string{dstkey})
+	db.lock.RWLocks(keys, []string{dstkey})
+	defer db.lock.RWUnLocks(keys, []string{dstkey})
 
 	var sets []*Set
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			sets = append(sets, nil)
 			continue
@@ -489,11 +511,48 @@ func ExecSunionStore(engine *DBEngine, args [][]byte) parser.RespData {
 	for _, m := range union {
 		dstset.Add(m)
 	}
-	engine.CancelTTL(dstkey)
-	engine.db.SetWithLock(dstkey, dstset)
+	db.CancelTTL(dstkey)
+	db.data.SetWithLock(dstkey, dstset)
+	db.propagate(args)
 	return parser.NewInteger(int64(dstset.Len()))
 }
 
+// sscan key cursor [MATCH pattern] [COUNT count]
+func ExecSscan(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	cursor, errReply := parseCursor(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseScanOptions(args[3:])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return makeScanReply(0, parser.NewBulkArray())
+	}
+	set, ok := item.(*Set)
+	if !ok {
+		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	members, next := set.Scan(cursor, opts.count)
+	reply := parser.NewBulkArray()
+	for _, m := range members {
+		if opts.match(m) {
+			reply.AddString(m)
+		}
+	}
+	return makeScanReply(next, reply)
+}
+
 func init() {
 	RegisterCmd("sadd", ExecSadd)
 	RegisterCmd("scard", ExecScard)
@@ -509,4 +568,5 @@ func init() {
 	RegisterCmd("smove", ExecSmove)
 	RegisterCmd("sunion", ExecSunion)
 	RegisterCmd("sunionstore", ExecSunionStore)
+	RegisterCmd("sscan", ExecSscan)
 }
@@ -1,17 +1,23 @@
 package database
 
 type Set struct {
-	s map[string]struct{}
+	s     map[string]struct{}
+	index *scanIndex // 用于sscan
 }
 
 func NewSet() *Set {
 	return &Set{
-		s: make(map[string]struct{}),
+		s:     make(map[string]struct{}),
+		index: newScanIndex(),
 	}
 }
 
 func (s *Set) Add(member string) {
+	if _, ok := s.s[member]; ok {
+		return
+	}
 	s.s[member] = struct{}{}
+	s.index.add(member)
 }
 
 func (s *Set) Len() int {
@@ -30,6 +36,7 @@ func (s *Set) Remove(member string) bool {
 	_, ok := s.s[member]
 	if ok {
 		delete(s.s, member)
+		s.index.remove(member)
 	}
 	return ok
 }
@@ -70,6 +77,7 @@ func Inter(sets []*Set) []string {
 func (s *Set) Pop() string {
 	for m := range s.s {
 		delete(s.s, m)
+		s.index.remove(m)
 		return m
 	}
 	return ""
@@ -109,6 +117,16 @@ func (s *Set) ForEach(f func(string) bool) {
 	}
 }
 
+// Scan 从cursor开始返回大约count个成员和下一次的cursor，返回的cursor为0表示遍历结束。
+// cursor是成员的哈希值，两次调用之间增删成员不影响其他成员被返回
+func (s *Set) Scan(cursor uint64, count int) ([]string, uint64) {
+	members := make([]string, 0, count)
+	next, _ := s.index.scan(cursor, count, func(m string) {
+		members = append(members, m)
+	})
+	return members, next
+}
+
 func Union(sets []*Set) []string {
 	if len(sets) <= 0 {
 		return nil
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"bytes"
 	"strconv"
 	"testing"
 
@@ -8,6 +9,29 @@ import (
 	. "github.com/HK40404/simpredis/utils/client"
 )
 
+// 解析只包含BulkString的数组回复，如BulkArray
+func bulkArgs(reply parser.RespData) [][]byte {
+	var args [][]byte
+	for payload := range parser.ParseStream(bytes.NewReader(reply.Serialize())) {
+		if array, ok := payload.Data.(*parser.Array); ok {
+			args = array.Args
+		}
+	}
+	return args
+}
+
+// 把Set类型的回复转换为成员列表
+func setMembers(reply parser.RespData) [][]byte {
+	if set, ok := reply.(*parser.Set); ok {
+		var members [][]byte
+		for _, m := range set.Args {
+			members = append(members, m.(*parser.BulkString).Arg)
+		}
+		return members
+	}
+	return bulkArgs(reply)
+}
+
 func TestSadd(t *testing.T) {
 	engine := NewDBEngine()
 	args := LineToArgs("sadd s 1 2 3")
@@ -23,7 +47,7 @@ func TestSadd(t *testing.T) {
 
 	args = LineToArgs("sdiff s")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -85,7 +109,7 @@ func TestSdiffAndSdiffstore(t *testing.T) {
 
 	args = LineToArgs("sdiff s s1 s2")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -108,7 +132,7 @@ func TestSdiffAndSdiffstore(t *testing.T) {
 
 	args = LineToArgs("sdiff s3 ")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = setMembers(reply)
 	m = make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -147,7 +171,7 @@ func TestSinterAndSinterstore(t *testing.T) {
 
 	args = LineToArgs("sinter s s1 s2")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -170,7 +194,7 @@ func TestSinterAndSinterstore(t *testing.T) {
 
 	args = LineToArgs("sinter s3 ")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = setMembers(reply)
 	m = make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -212,7 +236,7 @@ func TestSunionAndSunionstore(t *testing.T) {
 
 	args = LineToArgs("sunion s s1 s2")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -233,7 +257,7 @@ func TestSunionAndSunionstore(t *testing.T) {
 
 	args = LineToArgs("sunion s")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = setMembers(reply)
 	m = make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -269,14 +293,14 @@ func TestSismemberAndSmembers(t *testing.T) {
 
 	args = LineToArgs("smembers no_exist")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	if data != nil {
 		t.Fail()
 	}
 
 	args = LineToArgs("smembers s")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -332,7 +356,7 @@ func TestSmove(t *testing.T) {
 
 	args = LineToArgs("smembers s3")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := setMembers(reply)
 	m := make(map[string]struct{})
 	for i := 0; i < len(data); i++ {
 		m[string(data[i])] = struct{}{}
@@ -471,3 +495,51 @@ func TestSrandmember(t *testing.T) {
 		}
 	}
 }
+
+func TestSscan(t *testing.T) {
+	engine := NewDBEngine()
+	for i := 0; i < 500; i++ {
+		engine.ExecCmd(LineToArgs("sadd s m" + strconv.Itoa(i)))
+	}
+	if members := scanAll(t, engine, "sscan s", ""); len(members) != 500 {
+		t.Logf("want 500 members, got %d", len(members))
+		t.Fail()
+	}
+	if members := scanAll(t, engine, "sscan s", "match m4?"); len(members) != 10 {
+		t.Logf("want 10 members matching m4?, got %v", members)
+		t.Fail()
+	}
+	if cursor, members := scanReply(t, engine.ExecCmd(LineToArgs("sscan nokey 0"))); cursor != "0" || len(members) != 0 {
+		t.Fail()
+	}
+
+	// 两次调用之间增删其他成员，一直存在的成员仍然会被返回
+	seen := make(map[string]bool)
+	cursor := "0"
+	for i := 0; ; i++ {
+		var members []string
+		cursor, members = scanReply(t, engine.ExecCmd(LineToArgs("sscan s "+cursor+" count 5")))
+		for _, m := range members {
+			seen[m] = true
+		}
+		if cursor == "0" {
+			break
+		}
+		engine.ExecCmd(LineToArgs("sadd s tmp" + strconv.Itoa(i)))
+		engine.ExecCmd(LineToArgs("srem s m" + strconv.Itoa(250+i%250)))
+	}
+	for i := 0; i < 250; i++ {
+		if !seen["m"+strconv.Itoa(i)] {
+			t.Logf("m%d is missing", i)
+			t.Fail()
+		}
+	}
+
+	engine.ExecCmd(LineToArgs("set str v"))
+	if _, ok := engine.ExecCmd(LineToArgs("sscan str 0")).(*parser.Error); !ok {
+		t.Fail()
+	}
+	if _, ok := engine.ExecCmd(LineToArgs("sscan s 0 novalues")).(*parser.Error); !ok {
+		t.Fail()
+	}
+}
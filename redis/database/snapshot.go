@@ -0,0 +1,234 @@
+package database
+
+import "github.com/HK40404/simpredis/redis/rdb"
+
+// 某一时刻的key快照，value为深拷贝，不受之后写命令的影响
+type snapshotEntry struct {
+	db       int // 所在的数据库
+	key      string
+	value    any   // []byte, [][]byte(list), []string(set), map[string]string(hash), map[string]float64(zset), *rdb.Stream
+	expireAt int64 // unix毫秒，0表示没有过期时间
+}
+
+// 快照开始时短暂锁住所有数据库的所有item，之后每次只锁住一个shard拷贝，结果按数据库排列。
+// onLocked在持有所有锁时执行，用于和快照保持同一时刻的状态（如开始缓存aof重写期间的命令）
+func (engine *DBEngine) snapshot(onLocked func()) []*snapshotEntry {
+	return engine.startSnapshot(onLocked).finish()
+}
+
+// 拍快照期间的状态，在finish之前一直持有snapshotMu和dbsMu的读锁，swapdb会等待快照结束
+type engineSnapshot struct {
+	engine *DBEngine
+	dbs    []*dbSnapshot
+}
+
+// 一个数据库的拷贝进度。写命令拿到还没有拷贝的shard的写锁之后，先替快照拷贝这个shard（写时复制），
+// 所以不论写命令和快照谁先拿到锁，结果都是快照开始时的状态
+type dbSnapshot struct {
+	db     *DB
+	index  int
+	copied []bool // 第i个元素由第i个锁保护
+	shards [][]*snapshotEntry
+	hook   func(index int)
+}
+
+func (engine *DBEngine) startSnapshot(onLocked func()) *engineSnapshot {
+	engine.snapshotMu.Lock()
+	engine.dbsMu.RLock()
+	s := &engineSnapshot{engine: engine, dbs: make([]*dbSnapshot, len(engine.dbs))}
+	for i, db := range engine.dbs {
+		ds := &dbSnapshot{
+			db:     db,
+			index:  i,
+			copied: make([]bool, db.data.ShardCount()),
+			shards: make([][]*snapshotEntry, db.data.ShardCount()),
+		}
+		ds.hook = ds.copyShard
+		s.dbs[i] = ds
+	}
+
+	// 持有所有锁时没有正在执行的写命令，之后的写命令都会看到hook
+	for _, ds := range s.dbs {
+		ds.db.lock.RLockAll()
+		ds.db.lock.beforeWrite.Store(&ds.hook)
+	}
+	if onLocked != nil {
+		onLocked()
+	}
+	for _, ds := range s.dbs {
+		ds.db.lock.RUnLockAll()
+	}
+	return s
+}
+
+// 调用者持有第i个锁。每个shard只拷贝一次
+func (ds *dbSnapshot) copyShard(i int) {
+	if ds.copied[i] {
+		return
+	}
+	ds.copied[i] = true
+	db := ds.db
+	db.data.ForEachInShard(i, func(key string, item any) bool {
+		value := copyValue(item)
+		if value == nil {
+			return true
+		}
+		entry := &snapshotEntry{db: ds.index, key: key, value: value}
+		if t, ok := db.ttldb.Get(key); ok {
+			entry.expireAt = t.(int64)
+		}
+		ds.shards[i] = append(ds.shards[i], entry)
+		return true
+	})
+}
+
+// 依次拷贝还没有被写命令拷贝的shard，全部拷贝完之后移除hook并返回结果
+func (s *engineSnapshot) finish() []*snapshotEntry {
+	defer s.engine.snapshotMu.Unlock()
+	defer s.engine.dbsMu.RUnlock()
+	entries := make([]*snapshotEntry, 0)
+	for _, ds := range s.dbs {
+		for i := range ds.shards {
+			ds.db.lock.RLockShard(i)
+			ds.copyShard(i)
+			ds.db.lock.RUnLockShard(i)
+		}
+		ds.db.lock.beforeWrite.Store(nil)
+		for _, shard := range ds.shards {
+			entries = append(entries, shard...)
+		}
+	}
+	return entries
+}
+
+// 把数据库中的item深拷贝为snapshotEntry.value的类型，不支持的类型返回nil
+func copyValue(item any) any {
+	switch v := item.(type) {
+	case []byte:
+		value := make([]byte, len(v))
+		copy(value, v)
+		return value
+	case *QuickList:
+		return v.Range(0, -1)
+	case *Set:
+		return v.Members()
+	case *HashTable:
+		m := make(map[string]string, v.Len())
+		for field, value := range v.m {
+			m[field] = value
+		}
+		return m
+	case *ZSet:
+		m := make(map[string]float64, v.Len())
+		for member, score := range v.dict {
+			m[member] = score
+		}
+		return m
+	case *Stream:
+		return copyStream(v)
+	}
+	return nil
+}
+
+// entry添加之后不会被修改，可以和数据库共享field
+func copyStream(s *Stream) *rdb.Stream {
+	v := &rdb.Stream{
+		Entries:      make([]rdb.StreamEntry, 0, s.Len()),
+		LastID:       rdb.StreamID(s.lastID),
+		MaxDeletedID: rdb.StreamID(s.maxDeletedID),
+		EntriesAdded: s.entriesAdded,
+	}
+	for _, node := range s.nodes {
+		for _, e := range node.entries {
+			v.Entries = append(v.Entries, rdb.StreamEntry{ID: rdb.StreamID(e.ID), Fields: e.Fields})
+		}
+	}
+	for _, g := range s.Groups() {
+		group := rdb.StreamGroup{Name: g.name, LastID: rdb.StreamID(g.lastID), EntriesRead: g.entriesRead}
+		for _, id := range g.pel.ids {
+			n, _ := g.pel.get(id)
+			group.Pending = append(group.Pending, rdb.StreamPending{
+				ID:            rdb.StreamID(id),
+				DeliveryTime:  n.deliveryTime,
+				DeliveryCount: n.deliveryCount,
+			})
+		}
+		for _, c := range g.Consumers() {
+			consumer := rdb.StreamConsumer{Name: c.name, SeenTime: c.seenTime, ActiveTime: c.activeTime}
+			for _, id := range c.pel.ids {
+				consumer.Pending = append(consumer.Pending, rdb.StreamID(id))
+			}
+			group.Consumers = append(group.Consumers, consumer)
+		}
+		v.Groups = append(v.Groups, group)
+	}
+	return v
+}
+
+func newStreamItem(v *rdb.Stream) *Stream {
+	s := NewStream()
+	for _, e := range v.Entries {
+		s.Append(StreamID(e.ID), e.Fields)
+	}
+	s.lastID = StreamID(v.LastID)
+	s.maxDeletedID = StreamID(v.MaxDeletedID)
+	s.entriesAdded = v.EntriesAdded
+	for _, group := range v.Groups {
+		g, ok := s.CreateGroup(group.Name, StreamID(group.LastID), group.EntriesRead)
+		if !ok {
+			continue
+		}
+		nacks := make(map[StreamID]*streamNack, len(group.Pending))
+		for _, p := range group.Pending {
+			nacks[StreamID(p.ID)] = &streamNack{id: StreamID(p.ID), deliveryTime: p.DeliveryTime, deliveryCount: p.DeliveryCount}
+		}
+		// 待确认的entry通过消费者加入消费组，不属于任何消费者的会被丢弃
+		for _, consumer := range group.Consumers {
+			c, _ := g.CreateConsumer(consumer.Name, consumer.SeenTime)
+			c.activeTime = consumer.ActiveTime
+			for _, id := range consumer.Pending {
+				if n, ok := nacks[StreamID(id)]; ok && n.consumer == nil {
+					n.consumer = c
+					g.pel.add(n)
+					c.pel.add(n)
+				}
+			}
+		}
+	}
+	return s
+}
+
+// copyValue的逆过程，把拷贝出的value转换为数据库中的item
+func newItem(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case [][]byte:
+		l := NewQuickList()
+		for _, value := range v {
+			l.PushBack(value)
+		}
+		return l
+	case []string:
+		set := NewSet()
+		for _, m := range v {
+			set.Add(m)
+		}
+		return set
+	case map[string]string:
+		ht := NewHashTable()
+		for field, value := range v {
+			ht.Set(field, value)
+		}
+		return ht
+	case map[string]float64:
+		zset := NewZSet()
+		for member, score := range v {
+			zset.Add(member, score)
+		}
+		return zset
+	case *rdb.Stream:
+		return newStreamItem(v)
+	}
+	return nil
+}
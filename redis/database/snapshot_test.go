@@ -0,0 +1,71 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// 快照开始之后的写命令不需要等待快照结束，快照仍然是开始时的状态
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	engine := NewDBEngine()
+	defer engine.Close()
+	session := &Session{}
+	for i := 0; i < 100; i++ {
+		execIn(engine, session, fmt.Sprintf("set k%d v%d", i, i))
+	}
+	execIn(engine, session, "rpush l a b")
+	execIn(engine, session, "sadd s a b")
+	execIn(engine, &Session{DB: 3}, "set k v3")
+	want := snapshotState(engine.snapshot(nil))
+
+	s := engine.startSnapshot(nil)
+	// 先拷贝一部分shard，之后的写命令有的在已经拷贝的shard上，有的需要写时复制
+	ds := s.dbs[0]
+	for i := 0; i < len(ds.shards)/2; i++ {
+		ds.db.lock.RLockShard(i)
+		ds.copyShard(i)
+		ds.db.lock.RUnLockShard(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i += 3 {
+			execIn(engine, session, fmt.Sprintf("set k%d new", i))
+			execIn(engine, session, fmt.Sprintf("del k%d", i+1))
+			execIn(engine, session, fmt.Sprintf("expire k%d 100", i+2))
+		}
+		execIn(engine, session, "rpush l c")
+		execIn(engine, session, "srem s a")
+		execIn(engine, session, "set newkey v")
+		execIn(engine, &Session{DB: 3}, "flushdb")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writes should not wait for the snapshot")
+	}
+	if got := snapshotState(s.finish()); !reflect.DeepEqual(got, want) {
+		t.Logf("snapshot changed by later writes:\nwant %v\ngot  %v", want, got)
+		t.Fail()
+	}
+	if got := getIn(engine, 0, "k0"); got != "new" {
+		t.Logf("want new, got %s", got)
+		t.Fail()
+	}
+}
+
+// 把快照转换为和顺序无关的形式
+func snapshotState(entries []*snapshotEntry) map[string]any {
+	state := make(map[string]any, len(entries))
+	for _, entry := range entries {
+		value := entry.value
+		if members, ok := value.([]string); ok {
+			sort.Strings(members)
+		}
+		state[fmt.Sprintf("%d:%s:%d", entry.db, entry.key, entry.expireAt)] = value
+	}
+	return state
+}
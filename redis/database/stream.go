@@ -0,0 +1,639 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+var maxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// 近似裁剪时默认最多删除的entry数量
+const streamTrimDefaultLimit = 100 * streamNodeMaxEntries
+
+// 返回key对应的stream，key不存在时返回nil
+func getStream(db *DB, key string) (*Stream, parser.RespData) {
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := item.(*Stream)
+	if !ok {
+		return nil, parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s, nil
+}
+
+func invalidStreamIDError() parser.RespData {
+	return parser.NewError("ERR Invalid stream ID specified as stream command argument")
+}
+
+// ms-seq格式的ID，省略seq时使用missingSeq
+func parseStreamID(arg []byte, missingSeq uint64) (StreamID, bool) {
+	s := string(arg)
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	if !hasSeq {
+		return StreamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, false
+	}
+	return StreamID{ms, seq}, true
+}
+
+// 范围查询的端点："-"和"+"表示最小和最大的ID，以"("开头时不包含端点。
+// 起点省略seq时为0，终点省略seq时为最大值。不包含端点导致区间为空时返回false
+func parseStreamRangeBound(arg []byte, isStart bool) (StreamID, bool, parser.RespData) {
+	switch string(arg) {
+	case "-":
+		return StreamID{}, true, nil
+	case "+":
+		return maxStreamID, true, nil
+	}
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	var missingSeq uint64
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	id, ok := parseStreamID(arg, missingSeq)
+	if !ok {
+		return id, false, invalidStreamIDError()
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	if isStart {
+		id, ok = id.incr()
+	} else {
+		id, ok = id.decr()
+	}
+	return id, ok, nil
+}
+
+// entry回复为ID和field、value交替排列的数组
+func makeStreamEntryReply(e *StreamEntry) parser.RespData {
+	return parser.NewMultiBulk([]parser.RespData{
+		parser.NewBulkString([]byte(e.ID.String())),
+		parser.NewArray(e.Fields),
+	})
+}
+
+func makeStreamEntriesReply(entries []*StreamEntry) parser.RespData {
+	args := make([]parser.RespData, 0, len(entries))
+	for _, e := range entries {
+		args = append(args, makeStreamEntryReply(e))
+	}
+	return parser.NewMultiBulk(args)
+}
+
+// xadd和xtrim的裁剪参数：MAXLEN|MINID [=|~] threshold [LIMIT count]
+type streamTrimArgs struct {
+	byMinID bool
+	maxLen  int
+	minID   StreamID
+	approx  bool
+	limit   int
+}
+
+// 从args[i]开始解析裁剪参数，返回下一个参数的下标
+func parseStreamTrimArgs(args [][]byte, i int) (*streamTrimArgs, int, parser.RespData) {
+	trim := &streamTrimArgs{byMinID: strings.ToLower(string(args[i])) == "minid"}
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		trim.approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, parser.NewError("Invalid command format")
+	}
+	if trim.byMinID {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			return nil, 0, invalidStreamIDError()
+		}
+		trim.minID = id
+	} else {
+		maxLen, err := strconv.Atoi(string(args[i]))
+		if err != nil {
+			return nil, 0, parser.NewError("Value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, parser.NewError("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = maxLen
+	}
+	i++
+
+	if trim.approx {
+		trim.limit = streamTrimDefaultLimit
+	}
+	if i+1 < len(args) && strings.ToLower(string(args[i])) == "limit" {
+		limit, err := strconv.Atoi(string(args[i+1]))
+		if err != nil {
+			return nil, 0, parser.NewError("Value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return nil, 0, parser.NewError("ERR The LIMIT argument must be >= 0.")
+		}
+		if !trim.approx {
+			return nil, 0, parser.NewError("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.limit = limit
+		i += 2
+	}
+	return trim, i, nil
+}
+
+func (trim *streamTrimArgs) apply(s *Stream) int {
+	if trim.byMinID {
+		return s.TrimByMinID(trim.minID, trim.approx, trim.limit)
+	}
+	return s.TrimByLen(trim.maxLen, trim.approx, trim.limit)
+}
+
+// 近似裁剪的结果和节点的划分有关，所以按照裁剪之后的第一个ID精确裁剪写入aof
+func (db *DB) propagateTrim(key string, s *Stream) {
+	if first, ok := s.First(); ok {
+		db.propagate([][]byte{[]byte("xtrim"), []byte(key), []byte("minid"), []byte(first.ID.String())})
+	} else {
+		db.propagate([][]byte{[]byte("xtrim"), []byte(key), []byte("maxlen"), []byte("0")})
+	}
+}
+
+// xadd的ID："*"自动生成，"ms-*"指定时间自动生成序号，其他为完整的ID
+type streamAddID struct {
+	id      StreamID
+	autoMs  bool
+	autoSeq bool
+}
+
+func parseStreamAddID(arg []byte) (*streamAddID, parser.RespData) {
+	if string(arg) == "*" {
+		return &streamAddID{autoMs: true, autoSeq: true}, nil
+	}
+	if msPart, ok := strings.CutSuffix(string(arg), "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return nil, invalidStreamIDError()
+		}
+		return &streamAddID{id: StreamID{Ms: ms}, autoSeq: true}, nil
+	}
+	id, ok := parseStreamID(arg, 0)
+	if !ok {
+		return nil, invalidStreamIDError()
+	}
+	if id == (StreamID{}) {
+		return nil, parser.NewError("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	return &streamAddID{id: id}, nil
+}
+
+// 根据stream当前最大的ID得到新entry的ID
+func (addID *streamAddID) resolve(s *Stream, now uint64) (StreamID, parser.RespData) {
+	if addID.autoMs {
+		id, ok := s.NextID(now)
+		if !ok {
+			return id, parser.NewError("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	id := addID.id
+	if addID.autoSeq && id.Ms == s.lastID.Ms {
+		if id.Seq = s.lastID.Seq + 1; id.Seq == 0 {
+			// 序号溢出
+			return id, parser.NewError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+	if !s.lastID.Less(id) {
+		return id, parser.NewError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+// xadd key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func ExecXadd(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 5 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	noMkStream := false
+	var trim *streamTrimArgs
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nomkstream":
+			noMkStream = true
+		case "maxlen", "minid":
+			if trim != nil {
+				return parser.NewError("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+			}
+			var errReply parser.RespData
+			if trim, i, errReply = parseStreamTrimArgs(args, i); errReply != nil {
+				return errReply
+			}
+			// parseStreamTrimArgs返回的是下一个参数
+			i--
+		default:
+			break options
+		}
+	}
+	if i+1 >= len(args) || (len(args)-i-1)%2 != 0 {
+		return parser.NewError("Invalid command format")
+	}
+	// entry会保存fields，参数数组在读取下一条命令时会被复用，需要拷贝
+	fields := append([][]byte(nil), args[i+1:]...)
+	addID, errReply := parseStreamAddID(args[i])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	created := s == nil
+	if created {
+		if noMkStream {
+			return parser.MakeNullBulkReply()
+		}
+		s = NewStream()
+	}
+	id, errReply := addID.resolve(s, uint64(db.mstime()))
+	if errReply != nil {
+		return errReply
+	}
+
+	s.Append(id, fields)
+	if created {
+		db.data.SetWithLock(key, s)
+	}
+	cmd := [][]byte{[]byte("xadd"), []byte(key), []byte(id.String())}
+	db.propagate(append(cmd, fields...))
+	if trim != nil && trim.apply(s) > 0 {
+		db.propagateTrim(key, s)
+	}
+	db.signalKey(key)
+	return parser.NewBulkString([]byte(id.String()))
+}
+
+// xtrim key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func ExecXtrim(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	switch strings.ToLower(string(args[2])) {
+	case "maxlen", "minid":
+	default:
+		return parser.NewError("Invalid command format")
+	}
+	trim, i, errReply := parseStreamTrimArgs(args, 2)
+	if errReply != nil {
+		return errReply
+	}
+	if i != len(args) {
+		return parser.NewError("Invalid command format")
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewInteger(0)
+	}
+	removed := trim.apply(s)
+	if removed > 0 {
+		db.propagateTrim(key, s)
+	}
+	return parser.NewInteger(int64(removed))
+}
+
+// xdel key id [id ...]，stream变为空时也不会删除key
+func ExecXdel(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	ids := make([]StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDError()
+		}
+		ids = append(ids, id)
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewInteger(0)
+	}
+	count := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.propagate(args)
+	}
+	return parser.NewInteger(int64(count))
+}
+
+func ExecXlen(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewInteger(0)
+	}
+	return parser.NewInteger(int64(s.Len()))
+}
+
+// xrange key start end [COUNT count]，xrevrange的start和end位置相反
+func xrangeGeneric(db *DB, args [][]byte, reverse bool) parser.RespData {
+	if len(args) != 4 && len(args) != 6 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	startArg, endArg := args[2], args[3]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+	start, ok1, errReply := parseStreamRangeBound(startArg, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, ok2, errReply := parseStreamRangeBound(endArg, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := -1
+	if len(args) == 6 {
+		if strings.ToLower(string(args[4])) != "count" {
+			return parser.NewError("Invalid command format")
+		}
+		var err error
+		if count, err = strconv.Atoi(string(args[5])); err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+	}
+	if !ok1 || !ok2 || count == 0 {
+		return parser.NewMultiBulk(nil)
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewMultiBulk(nil)
+	}
+	return makeStreamEntriesReply(s.Range(start, end, count, reverse))
+}
+
+func ExecXrange(db *DB, args [][]byte) parser.RespData {
+	return xrangeGeneric(db, args, false)
+}
+
+func ExecXrevrange(db *DB, args [][]byte) parser.RespData {
+	return xrangeGeneric(db, args, true)
+}
+
+// xsetid key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+// 用于aof重写时恢复stream的元信息
+func ExecXsetid(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	lastID, ok := parseStreamID(args[2], 0)
+	if !ok {
+		return invalidStreamIDError()
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *StreamID
+	for i := 3; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			if n < 0 {
+				return parser.NewError("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "maxdeletedid":
+			id, ok := parseStreamID(args[i+1], 0)
+			if !ok {
+				return invalidStreamIDError()
+			}
+			if lastID.Less(id) {
+				return parser.NewError("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			maxDeletedID = &id
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewError("ERR no such key")
+	}
+	if last, ok := s.Last(); ok && lastID.Less(last.ID) {
+		return parser.NewError("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	if entriesAdded >= 0 && entriesAdded < int64(s.Len()) {
+		return parser.NewError("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	s.lastID = lastID
+	if entriesAdded >= 0 {
+		s.entriesAdded = uint64(entriesAdded)
+	}
+	if maxDeletedID != nil {
+		s.maxDeletedID = *maxDeletedID
+	}
+	db.propagate(args)
+	return parser.MakeOKReply()
+}
+
+// xread和xreadgroup共同的参数：[COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+type streamReadArgs struct {
+	count   int // 0表示不限制
+	block   bool
+	timeout time.Duration
+	noAck   bool
+	keys    []string
+	ids     [][]byte
+}
+
+// 从args[i]开始解析，只有xreadgroup可以使用NOACK
+func parseStreamReadArgs(args [][]byte, i int, group bool) (*streamReadArgs, parser.RespData) {
+	readArgs := &streamReadArgs{}
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "streams" {
+			i++
+			break
+		}
+		if opt == "noack" && group {
+			readArgs.noAck = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, parser.NewError("Invalid command format")
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return nil, parser.NewError("Value is not an integer or out of range")
+		}
+		switch opt {
+		case "count":
+			if n > 0 && n <= math.MaxInt32 {
+				readArgs.count = int(n)
+			}
+		case "block":
+			if n < 0 {
+				return nil, parser.NewError("ERR timeout is negative")
+			}
+			readArgs.block = true
+			readArgs.timeout = time.Duration(n) * time.Millisecond
+		default:
+			return nil, parser.NewError("Invalid command format")
+		}
+		i++
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%2 != 0 {
+		cmd := strings.ToLower(string(args[0]))
+		return nil, parser.NewError("ERR Unbalanced '" + cmd + "' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	n := len(rest) / 2
+	readArgs.keys = make([]string, n)
+	for j, key := range rest[:n] {
+		readArgs.keys[j] = string(key)
+	}
+	readArgs.ids = rest[n:]
+	return readArgs, nil
+}
+
+// xread [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// id为"$"时只读取之后添加的entry。BLOCK时没有数据则等待，直到有新的entry或者超时
+func ExecXread(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	readArgs, errReply := parseStreamReadArgs(args, 1, false)
+	if errReply != nil {
+		return errReply
+	}
+	keys, count, block := readArgs.keys, readArgs.count, readArgs.block
+	ids := make([]StreamID, len(keys))
+	lastIDs := make([]bool, len(keys)) // 为"$"的ID在第一次检查时替换为当时的最大ID
+	for j, arg := range readArgs.ids {
+		if string(arg) == "$" {
+			lastIDs[j] = true
+			continue
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDError()
+		}
+		ids[j] = id
+	}
+
+	check := func(db *DB) parser.RespData {
+		db.lock.RLocks(keys)
+		defer db.lock.RUnLocks(keys)
+		var result []parser.RespData
+		for j, key := range keys {
+			s, errReply := getStream(db, key)
+			if errReply != nil {
+				return errReply
+			}
+			if lastIDs[j] {
+				if s != nil {
+					ids[j] = s.LastID()
+				}
+				lastIDs[j] = false
+				continue
+			}
+			if s == nil {
+				continue
+			}
+			start, ok := ids[j].incr()
+			if !ok {
+				continue
+			}
+			if entries := s.Range(start, maxStreamID, count, false); len(entries) > 0 {
+				result = append(result, parser.NewMultiBulk([]parser.RespData{
+					parser.NewBulkString([]byte(key)),
+					makeStreamEntriesReply(entries),
+				}))
+			}
+		}
+		if len(result) == 0 {
+			if block {
+				return nil
+			}
+			return parser.MakeNullArrayReply()
+		}
+		return parser.NewMultiBulk(result)
+	}
+
+	if !block {
+		engine.dbsMu.RLock()
+		defer engine.dbsMu.RUnlock()
+		return check(engine.dbs[session.DB])
+	}
+	if reply := engine.blockingExec(session, keys, readArgs.timeout, check); reply != nil {
+		return reply
+	}
+	return parser.MakeNullArrayReply()
+}
+
+func init() {
+	RegisterCmd("xadd", ExecXadd)
+	RegisterCmd("xtrim", ExecXtrim)
+	RegisterCmd("xdel", ExecXdel)
+	RegisterCmd("xlen", ExecXlen)
+	RegisterCmd("xrange", ExecXrange)
+	RegisterCmd("xrevrange", ExecXrevrange)
+	RegisterCmd("xsetid", ExecXsetid)
+	RegisterEngineCmd("xread", ExecXread)
+}
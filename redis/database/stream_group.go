@@ -0,0 +1,1019 @@
+package database
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// xautoclaim每认领一个entry最多检查的待确认entry数量
+const streamAutoClaimAttemptsFactor = 10
+
+func noGroupError(key, group string) parser.RespData {
+	return parser.NewError("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+// xgroup和xinfo的消费组不存在
+func noConsumerGroupError(key, group string) parser.RespData {
+	return parser.NewError("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+}
+
+func noKeyError() parser.RespData {
+	return parser.NewError("ERR The XGROUP subcommand requires the key to exist. " +
+		"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+}
+
+// 返回key对应的stream中的消费组，key或者消费组不存在时返回NOGROUP错误
+func getStreamGroup(db *DB, key, group string) (*Stream, *StreamGroup, parser.RespData) {
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if s == nil {
+		return nil, nil, noGroupError(key, group)
+	}
+	g, ok := s.Group(group)
+	if !ok {
+		return nil, nil, noGroupError(key, group)
+	}
+	return s, g, nil
+}
+
+// "$"表示stream当前的最大ID
+func parseGroupID(arg []byte, s *Stream) (StreamID, parser.RespData) {
+	if string(arg) == "$" {
+		if s == nil {
+			return StreamID{}, nil
+		}
+		return s.lastID, nil
+	}
+	id, ok := parseStreamID(arg, 0)
+	if !ok {
+		return id, invalidStreamIDError()
+	}
+	return id, nil
+}
+
+// 解析[ENTRIESREAD entries-read]，没有指定时为-1
+func parseEntriesRead(args [][]byte) (int64, parser.RespData) {
+	if len(args) == 0 {
+		return -1, nil
+	}
+	if len(args) != 2 || strings.ToLower(string(args[0])) != "entriesread" {
+		return 0, parser.NewError("Invalid command format")
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return 0, parser.NewError("Value is not an integer or out of range")
+	}
+	if n < -1 {
+		return 0, parser.NewError("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// 把消费组的lastID和entriesRead写入aof
+func (db *DB) propagateGroupID(key string, g *StreamGroup) {
+	db.propagate([][]byte{
+		[]byte("xgroup"), []byte("setid"), []byte(key), []byte(g.name), []byte(g.lastID.String()),
+		[]byte("entriesread"), []byte(strconv.FormatInt(g.entriesRead, 10)),
+	})
+}
+
+// 认领或发送之后的状态按记录的时间和次数强制认领写入aof，重放时不依赖当时的时间
+func (db *DB) propagateClaim(key string, g *StreamGroup, n *streamNack) {
+	db.propagate(claimCommand([]byte(key), g.name, n.consumer.name, n.id, n.deliveryTime, n.deliveryCount, g.lastID))
+}
+
+func claimCommand(key []byte, group, consumer string, id StreamID, deliveryTime int64, deliveryCount uint64, lastID StreamID) [][]byte {
+	return [][]byte{
+		[]byte("xclaim"), key, []byte(group), []byte(consumer), []byte("0"), []byte(id.String()),
+		[]byte("time"), []byte(strconv.FormatInt(deliveryTime, 10)),
+		[]byte("retrycount"), []byte(strconv.FormatUint(deliveryCount, 10)),
+		[]byte("force"), []byte("justid"), []byte("lastid"), []byte(lastID.String()),
+	}
+}
+
+// xgroup CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER key group ...
+func ExecXgroup(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	switch strings.ToLower(string(args[1])) {
+	case "create":
+		return xgroupCreate(db, args)
+	case "setid":
+		return xgroupSetID(db, args)
+	case "destroy":
+		if len(args) != 4 {
+			return parser.NewError("Invalid command format")
+		}
+		return xgroupDestroy(db, args)
+	case "createconsumer", "delconsumer":
+		if len(args) != 5 {
+			return parser.NewError("Invalid command format")
+		}
+		return xgroupConsumer(db, args)
+	}
+	return parser.NewError("Invalid command format")
+}
+
+// 和redis一样，id为$且没有指定ENTRIESREAD时，消费组已经读取了所有添加过的entry
+func groupEntriesRead(s *Stream, id []byte, entriesRead int64) int64 {
+	if entriesRead == -1 && string(id) == "$" {
+		return int64(s.entriesAdded)
+	}
+	return entriesRead
+}
+
+// xgroup create key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+func xgroupCreate(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 5 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name := string(args[2]), string(args[3])
+	opts := args[5:]
+	mkStream := len(opts) > 0 && strings.ToLower(string(opts[0])) == "mkstream"
+	if mkStream {
+		opts = opts[1:]
+	}
+	entriesRead, errReply := parseEntriesRead(opts)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && !mkStream {
+		return noKeyError()
+	}
+	id, errReply := parseGroupID(args[4], s)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		s = NewStream()
+		db.data.SetWithLock(key, s)
+	}
+	entriesRead = groupEntriesRead(s, args[4], entriesRead)
+	if _, ok := s.CreateGroup(name, id, entriesRead); !ok {
+		return parser.NewError("BUSYGROUP Consumer Group name already exists")
+	}
+	cmd := [][]byte{[]byte("xgroup"), []byte("create"), []byte(key), []byte(name), []byte(id.String()), []byte("mkstream")}
+	if entriesRead != -1 {
+		cmd = append(cmd, []byte("entriesread"), []byte(strconv.FormatInt(entriesRead, 10)))
+	}
+	db.propagate(cmd)
+	return parser.MakeOKReply()
+}
+
+// xgroup setid key group id|$ [ENTRIESREAD entries-read]
+func xgroupSetID(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 5 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name := string(args[2]), string(args[3])
+	entriesRead, errReply := parseEntriesRead(args[5:])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return noKeyError()
+	}
+	g, ok := s.Group(name)
+	if !ok {
+		return noConsumerGroupError(key, name)
+	}
+	id, errReply := parseGroupID(args[4], s)
+	if errReply != nil {
+		return errReply
+	}
+	g.lastID = id
+	g.entriesRead = groupEntriesRead(s, args[4], entriesRead)
+	db.propagateGroupID(key, g)
+	return parser.MakeOKReply()
+}
+
+// xgroup destroy key group：返回删除的消费组数量
+func xgroupDestroy(db *DB, args [][]byte) parser.RespData {
+	key, name := string(args[2]), string(args[3])
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return noKeyError()
+	}
+	if !s.DestroyGroup(name) {
+		return parser.NewInteger(0)
+	}
+	db.propagate(args)
+	// 阻塞在这个消费组上的连接需要返回错误
+	db.signalKey(key)
+	return parser.NewInteger(1)
+}
+
+// xgroup createconsumer key group consumer：返回创建的消费者数量
+// xgroup delconsumer key group consumer：返回消费者删除前待确认的entry数量
+func xgroupConsumer(db *DB, args [][]byte) parser.RespData {
+	key, name, consumer := string(args[2]), string(args[3]), string(args[4])
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return noKeyError()
+	}
+	g, ok := s.Group(name)
+	if !ok {
+		return noConsumerGroupError(key, name)
+	}
+
+	if strings.ToLower(string(args[1])) == "createconsumer" {
+		if _, created := g.CreateConsumer(consumer, db.mstime()); !created {
+			return parser.NewInteger(0)
+		}
+		db.propagate(args)
+		return parser.NewInteger(1)
+	}
+	pending, ok := g.DeleteConsumer(consumer)
+	if ok {
+		db.propagate(args)
+	}
+	return parser.NewInteger(int64(pending))
+}
+
+// xreadgroup GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// id为">"时读取从没有发送给消费组的entry，否则读取消费者待确认的entry中ID更大的部分。
+// 只有所有的id都为">"时才会阻塞
+func ExecXreadgroup(engine *DBEngine, session *Session, args [][]byte) parser.RespData {
+	if len(args) < 7 || strings.ToLower(string(args[1])) != "group" {
+		return parser.NewError("Invalid command format")
+	}
+	groupName, consumerName := string(args[2]), string(args[3])
+	readArgs, errReply := parseStreamReadArgs(args, 4, true)
+	if errReply != nil {
+		return errReply
+	}
+	keys := readArgs.keys
+	ids := make([]StreamID, len(keys))
+	newOnly := make([]bool, len(keys))
+	block := readArgs.block
+	for j, arg := range readArgs.ids {
+		switch string(arg) {
+		case ">":
+			newOnly[j] = true
+			continue
+		case "$":
+			return parser.NewError("ERR The $ ID is meaningless in the context of XREADGROUP: " +
+				"you want to read the history of this consumer by specifying a proper ID, " +
+				"or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDError()
+		}
+		ids[j] = id
+		block = false
+	}
+
+	check := func(db *DB) parser.RespData {
+		db.lock.Locks(keys)
+		defer db.lock.UnLocks(keys)
+		streams := make([]*Stream, len(keys))
+		groups := make([]*StreamGroup, len(keys))
+		for j, key := range keys {
+			s, errReply := getStream(db, key)
+			if errReply != nil {
+				return errReply
+			}
+			var g *StreamGroup
+			ok := false
+			if s != nil {
+				g, ok = s.Group(groupName)
+			}
+			if !ok {
+				return parser.NewError("NOGROUP No such key '" + key + "' or consumer group '" + groupName + "' in XREADGROUP with GROUP option")
+			}
+			streams[j], groups[j] = s, g
+		}
+
+		now := db.mstime()
+		var result []parser.RespData
+		for j, key := range keys {
+			s, g := streams[j], groups[j]
+			c, created := g.CreateConsumer(consumerName, now)
+			if created {
+				db.propagate([][]byte{[]byte("xgroup"), []byte("createconsumer"), []byte(key), []byte(groupName), []byte(consumerName)})
+			}
+			c.seenTime = now
+
+			var reply parser.RespData
+			if newOnly[j] {
+				reply = readGroupNew(db, key, s, g, c, readArgs.count, readArgs.noAck, now)
+			} else {
+				reply = readGroupHistory(db, key, s, g, c, ids[j], readArgs.count, now)
+			}
+			if reply != nil {
+				result = append(result, parser.NewMultiBulk([]parser.RespData{parser.NewBulkString([]byte(key)), reply}))
+			}
+		}
+		if len(result) == 0 {
+			if block {
+				return nil
+			}
+			return parser.MakeNullArrayReply()
+		}
+		return parser.NewMultiBulk(result)
+	}
+
+	if !block {
+		engine.dbsMu.RLock()
+		defer engine.dbsMu.RUnlock()
+		return check(engine.dbs[session.DB])
+	}
+	if reply := engine.blockingExec(session, keys, readArgs.timeout, check); reply != nil {
+		return reply
+	}
+	return parser.MakeNullArrayReply()
+}
+
+// 发送消费组还没有读取过的entry，除了NOACK之外都加入待确认列表。没有新的entry时返回nil
+func readGroupNew(db *DB, key string, s *Stream, g *StreamGroup, c *StreamConsumer, count int, noAck bool, now int64) parser.RespData {
+	start, ok := g.lastID.incr()
+	if !ok {
+		return nil
+	}
+	entries := s.Range(start, maxStreamID, count, false)
+	if len(entries) == 0 {
+		return nil
+	}
+	for _, e := range entries {
+		s.advanceGroup(g, e.ID)
+		if noAck {
+			continue
+		}
+		n := g.assign(e.ID, c)
+		n.deliveryTime = now
+		n.deliveryCount = 1
+		db.propagateClaim(key, g, n)
+	}
+	db.propagateGroupID(key, g)
+	c.activeTime = now
+	return makeStreamEntriesReply(entries)
+}
+
+// 重新发送消费者待确认的entry，已经从stream中删除的entry只返回ID
+func readGroupHistory(db *DB, key string, s *Stream, g *StreamGroup, c *StreamConsumer, after StreamID, count int, now int64) parser.RespData {
+	args := make([]parser.RespData, 0)
+	start, ok := after.incr()
+	if !ok {
+		return parser.NewMultiBulk(args)
+	}
+	pending := c.pel.ids[c.pel.seek(start):]
+	if count > 0 && len(pending) > count {
+		pending = pending[:count]
+	}
+	for _, id := range pending {
+		n, _ := c.pel.get(id)
+		n.deliveryTime = now
+		n.deliveryCount++
+		db.propagateClaim(key, g, n)
+		if e, ok := s.Get(id); ok {
+			args = append(args, makeStreamEntryReply(e))
+		} else {
+			args = append(args, parser.NewMultiBulk([]parser.RespData{
+				parser.NewBulkString([]byte(id.String())),
+				parser.MakeNullArrayReply(),
+			}))
+		}
+	}
+	return parser.NewMultiBulk(args)
+}
+
+// xack key group id [id ...]：返回确认的entry数量
+func ExecXack(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name := string(args[1]), string(args[2])
+	ids := make([]StreamID, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return invalidStreamIDError()
+		}
+		ids = append(ids, id)
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewInteger(0)
+	}
+	g, ok := s.Group(name)
+	if !ok {
+		return parser.NewInteger(0)
+	}
+	count := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.propagate(args)
+	}
+	return parser.NewInteger(int64(count))
+}
+
+// xpending key group [[IDLE min-idle-time] start end count [consumer]]
+// 没有范围时返回待确认的数量、最小和最大ID以及每个消费者待确认的数量
+func ExecXpending(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name := string(args[1]), string(args[2])
+	summary := len(args) == 3
+	minIdle := int64(0)
+	var start, end StreamID
+	count := 0
+	consumerName := ""
+	nonEmpty := true
+	if !summary {
+		i := 3
+		if strings.ToLower(string(args[i])) == "idle" {
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			minIdle = n
+			i += 2
+		}
+		rest := args[i:]
+		if len(rest) != 3 && len(rest) != 4 {
+			return parser.NewError("Invalid command format")
+		}
+		var ok1, ok2 bool
+		var errReply parser.RespData
+		if start, ok1, errReply = parseStreamRangeBound(rest[0], true); errReply != nil {
+			return errReply
+		}
+		if end, ok2, errReply = parseStreamRangeBound(rest[1], false); errReply != nil {
+			return errReply
+		}
+		n, err := strconv.ParseInt(string(rest[2]), 10, 64)
+		if err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+		if n > math.MaxInt32 {
+			n = math.MaxInt32
+		}
+		count = int(n)
+		if len(rest) == 4 {
+			consumerName = string(rest[3])
+		}
+		nonEmpty = ok1 && ok2 && count > 0
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	_, g, errReply := getStreamGroup(db, key, name)
+	if errReply != nil {
+		return errReply
+	}
+
+	if summary {
+		if g.pel.Len() == 0 {
+			return parser.NewMultiBulk([]parser.RespData{
+				parser.NewInteger(0), parser.MakeNullBulkReply(), parser.MakeNullBulkReply(), parser.MakeNullArrayReply(),
+			})
+		}
+		var consumers []parser.RespData
+		for _, c := range g.Consumers() {
+			if c.pel.Len() > 0 {
+				consumers = append(consumers, parser.NewArray([][]byte{
+					[]byte(c.name), []byte(strconv.Itoa(c.pel.Len())),
+				}))
+			}
+		}
+		return parser.NewMultiBulk([]parser.RespData{
+			parser.NewInteger(int64(g.pel.Len())),
+			parser.NewBulkString([]byte(g.pel.ids[0].String())),
+			parser.NewBulkString([]byte(g.pel.ids[g.pel.Len()-1].String())),
+			parser.NewMultiBulk(consumers),
+		})
+	}
+
+	entries := make([]parser.RespData, 0)
+	pel := g.pel
+	if consumerName != "" {
+		c, ok := g.Consumer(consumerName)
+		if !ok {
+			return parser.NewMultiBulk(entries)
+		}
+		pel = c.pel
+	}
+	if !nonEmpty {
+		return parser.NewMultiBulk(entries)
+	}
+	now := db.mstime()
+	for _, id := range pel.ids[pel.seek(start):] {
+		if end.Less(id) || len(entries) >= count {
+			break
+		}
+		n, _ := pel.get(id)
+		idle := now - n.deliveryTime
+		if idle < minIdle {
+			continue
+		}
+		entries = append(entries, parser.NewMultiBulk([]parser.RespData{
+			parser.NewBulkString([]byte(id.String())),
+			parser.NewBulkString([]byte(n.consumer.name)),
+			parser.NewInteger(idle),
+			parser.NewInteger(int64(n.deliveryCount)),
+		}))
+	}
+	return parser.NewMultiBulk(entries)
+}
+
+// 把待确认的entry交给消费者，更新发送时间和次数。retryCount小于0时除了JUSTID之外发送次数加1
+func claimEntry(g *StreamGroup, c *StreamConsumer, id StreamID, deliveryTime int64, retryCount int64, justID bool) *streamNack {
+	n, ok := g.pel.get(id)
+	if !ok {
+		n = g.assign(id, c)
+		n.deliveryCount = 1
+	} else {
+		g.assign(id, c)
+	}
+	n.deliveryTime = deliveryTime
+	if retryCount >= 0 {
+		n.deliveryCount = uint64(retryCount)
+	} else if !justID {
+		n.deliveryCount++
+	}
+	return n
+}
+
+// xclaim key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+// 把空闲时间不少于min-idle-time的待确认entry交给consumer，FORCE时不在待确认列表中的entry也会被认领
+func ExecXclaim(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 6 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name, consumerName := string(args[1]), string(args[2]), string(args[3])
+	minIdle, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return parser.NewError("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	i := 5
+	var ids []StreamID
+	for ; i < len(args); i++ {
+		id, ok := parseStreamID(args[i], 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return invalidStreamIDError()
+	}
+
+	now := db.mstime()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *StreamID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch opt {
+		case "force":
+			force = true
+			continue
+		case "justid":
+			justID = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return parser.NewError("Invalid command format")
+		}
+		i++
+		if opt == "lastid" {
+			id, ok := parseStreamID(args[i], 0)
+			if !ok {
+				return invalidStreamIDError()
+			}
+			lastID = &id
+			continue
+		}
+		n, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+		switch opt {
+		case "idle":
+			deliveryTime = now - n
+		case "time":
+			deliveryTime = n
+		case "retrycount":
+			if n < 0 {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			retryCount = n
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+	// 发送时间不能在未来
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, g, errReply := getStreamGroup(db, key, name)
+	if errReply != nil {
+		return errReply
+	}
+	lastIDChanged := lastID != nil && g.lastID.Less(*lastID)
+	if lastIDChanged {
+		g.lastID = *lastID
+	}
+
+	c, _ := g.Consumer(consumerName)
+	if c != nil {
+		c.seenTime = now
+	}
+	result := make([]parser.RespData, 0, len(ids))
+	claimed := 0
+	for _, id := range ids {
+		n, pending := g.pel.get(id)
+		e, exist := s.Get(id)
+		if !exist {
+			// 已经删除的entry不再需要确认
+			if pending {
+				g.Ack(id)
+				db.propagate([][]byte{[]byte("xack"), []byte(key), []byte(name), []byte(id.String())})
+			}
+			continue
+		}
+		if !pending && !force {
+			continue
+		}
+		if pending && minIdle > 0 && now-n.deliveryTime < minIdle {
+			continue
+		}
+		if c == nil {
+			c, _ = g.CreateConsumer(consumerName, now)
+		}
+		n = claimEntry(g, c, id, deliveryTime, retryCount, justID)
+		c.activeTime = now
+		db.propagateClaim(key, g, n)
+		claimed++
+		if justID {
+			result = append(result, parser.NewBulkString([]byte(id.String())))
+		} else {
+			result = append(result, makeStreamEntryReply(e))
+		}
+	}
+	if lastIDChanged && claimed == 0 {
+		db.propagateGroupID(key, g)
+	}
+	return parser.NewMultiBulk(result)
+}
+
+// xautoclaim key group consumer min-idle-time start [COUNT count] [JUSTID]
+// 从start开始扫描消费组的待确认列表，认领最多count个空闲时间足够的entry。
+// 返回下一次扫描的起点（0-0表示扫描完毕）、认领的entry和已经从stream中删除的ID
+func ExecXautoclaim(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 6 {
+		return parser.NewError("Invalid command format")
+	}
+	key, name, consumerName := string(args[1]), string(args[2]), string(args[3])
+	minIdle, err := strconv.ParseInt(string(args[4]), 10, 64)
+	if err != nil {
+		return parser.NewError("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	start, ok, errReply := parseStreamRangeBound(args[5], true)
+	if errReply != nil {
+		return errReply
+	}
+	count := 100
+	justID := false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "justid":
+			justID = true
+		case "count":
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			if n < 1 || n > math.MaxInt32/streamAutoClaimAttemptsFactor {
+				return parser.NewError("ERR COUNT must be > 0")
+			}
+			count = int(n)
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	s, g, errReply := getStreamGroup(db, key, name)
+	if errReply != nil {
+		return errReply
+	}
+
+	now := db.mstime()
+	c, _ := g.Consumer(consumerName)
+	if c != nil {
+		c.seenTime = now
+	}
+	claimed := make([]parser.RespData, 0)
+	deleted := make([][]byte, 0)
+	next := StreamID{}
+	if ok {
+		// 认领和删除会修改待确认列表，先拷贝需要检查的ID
+		pending := g.pel.ids[g.pel.seek(start):]
+		if attempts := count * streamAutoClaimAttemptsFactor; len(pending) > attempts {
+			pending = pending[:attempts]
+		}
+		pending = append([]StreamID(nil), pending...)
+		var last StreamID
+		for _, id := range pending {
+			if len(claimed) >= count {
+				break
+			}
+			last = id
+			e, exist := s.Get(id)
+			if !exist {
+				g.Ack(id)
+				db.propagate([][]byte{[]byte("xack"), []byte(key), []byte(name), []byte(id.String())})
+				deleted = append(deleted, []byte(id.String()))
+				continue
+			}
+			n, _ := g.pel.get(id)
+			if minIdle > 0 && now-n.deliveryTime < minIdle {
+				continue
+			}
+			if c == nil {
+				c, _ = g.CreateConsumer(consumerName, now)
+			}
+			n = claimEntry(g, c, id, now, -1, justID)
+			c.activeTime = now
+			db.propagateClaim(key, g, n)
+			if justID {
+				claimed = append(claimed, parser.NewBulkString([]byte(id.String())))
+			} else {
+				claimed = append(claimed, makeStreamEntryReply(e))
+			}
+		}
+		if len(pending) > 0 {
+			if after, ok := last.incr(); ok {
+				if i := g.pel.seek(after); i < g.pel.Len() {
+					next = g.pel.ids[i]
+				}
+			}
+		}
+	}
+	return parser.NewMultiBulk([]parser.RespData{
+		parser.NewBulkString([]byte(next.String())),
+		parser.NewMultiBulk(claimed),
+		parser.NewArray(deleted),
+	})
+}
+
+// 消费组的entriesRead和lag未知时为nil
+func optionalInteger(n int64, ok bool) parser.RespData {
+	if !ok {
+		return parser.MakeNullBulkReply()
+	}
+	return parser.NewInteger(n)
+}
+
+func bulkID(id StreamID) parser.RespData {
+	return parser.NewBulkString([]byte(id.String()))
+}
+
+// xinfo STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group
+func ExecXinfo(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[2])
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "stream":
+		if len(args) != 3 && len(args) != 4 && len(args) != 6 || len(args) > 3 && strings.ToLower(string(args[3])) != "full" {
+			return parser.NewError("Invalid command format")
+		}
+	case "groups":
+		if len(args) != 3 {
+			return parser.NewError("Invalid command format")
+		}
+	case "consumers":
+		if len(args) != 4 {
+			return parser.NewError("Invalid command format")
+		}
+	default:
+		return parser.NewError("Invalid command format")
+	}
+	count := 10
+	if len(args) == 6 {
+		if strings.ToLower(string(args[4])) != "count" {
+			return parser.NewError("Invalid command format")
+		}
+		n, err := strconv.ParseInt(string(args[5]), 10, 64)
+		if err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+		if n <= 0 || n > math.MaxInt32 {
+			n = 0
+		}
+		count = int(n)
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	s, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return parser.NewError("ERR no such key")
+	}
+	now := db.mstime()
+	switch sub {
+	case "stream":
+		if len(args) == 3 {
+			return xinfoStream(s)
+		}
+		return xinfoStreamFull(s, count)
+	case "groups":
+		groups := make([]parser.RespData, 0, len(s.groups))
+		for _, g := range s.Groups() {
+			info := parser.NewMap()
+			info.Add(parser.NewBulkString([]byte("name")), parser.NewBulkString([]byte(g.name)))
+			info.Add(parser.NewBulkString([]byte("consumers")), parser.NewInteger(int64(len(g.consumers))))
+			info.Add(parser.NewBulkString([]byte("pending")), parser.NewInteger(int64(g.pel.Len())))
+			info.Add(parser.NewBulkString([]byte("last-delivered-id")), bulkID(g.lastID))
+			info.Add(parser.NewBulkString([]byte("entries-read")), optionalInteger(g.entriesRead, g.entriesRead != -1))
+			info.Add(parser.NewBulkString([]byte("lag")), optionalInteger(s.Lag(g)))
+			groups = append(groups, info)
+		}
+		return parser.NewMultiBulk(groups)
+	}
+
+	g, ok := s.Group(string(args[3]))
+	if !ok {
+		return noConsumerGroupError(key, string(args[3]))
+	}
+	consumers := make([]parser.RespData, 0, len(g.consumers))
+	for _, c := range g.Consumers() {
+		inactive := int64(-1)
+		if c.activeTime != -1 {
+			inactive = now - c.activeTime
+		}
+		info := parser.NewMap()
+		info.Add(parser.NewBulkString([]byte("name")), parser.NewBulkString([]byte(c.name)))
+		info.Add(parser.NewBulkString([]byte("pending")), parser.NewInteger(int64(c.pel.Len())))
+		info.Add(parser.NewBulkString([]byte("idle")), parser.NewInteger(now-c.seenTime))
+		info.Add(parser.NewBulkString([]byte("inactive")), parser.NewInteger(inactive))
+		consumers = append(consumers, info)
+	}
+	return parser.NewMultiBulk(consumers)
+}
+
+// stream的基本信息，第一个和最后一个entry
+func xinfoStreamHeader(s *Stream) *parser.Map {
+	var firstID StreamID
+	if first, ok := s.First(); ok {
+		firstID = first.ID
+	}
+	info := parser.NewMap()
+	info.Add(parser.NewBulkString([]byte("length")), parser.NewInteger(int64(s.Len())))
+	info.Add(parser.NewBulkString([]byte("radix-tree-keys")), parser.NewInteger(int64(len(s.nodes))))
+	info.Add(parser.NewBulkString([]byte("radix-tree-nodes")), parser.NewInteger(int64(len(s.nodes))))
+	info.Add(parser.NewBulkString([]byte("last-generated-id")), bulkID(s.lastID))
+	info.Add(parser.NewBulkString([]byte("max-deleted-entry-id")), bulkID(s.maxDeletedID))
+	info.Add(parser.NewBulkString([]byte("entries-added")), parser.NewInteger(int64(s.entriesAdded)))
+	info.Add(parser.NewBulkString([]byte("recorded-first-entry-id")), bulkID(firstID))
+	return info
+}
+
+func xinfoStream(s *Stream) parser.RespData {
+	info := xinfoStreamHeader(s)
+	info.Add(parser.NewBulkString([]byte("groups")), parser.NewInteger(int64(len(s.groups))))
+	for _, field := range []string{"first-entry", "last-entry"} {
+		var e *StreamEntry
+		var ok bool
+		if field == "first-entry" {
+			e, ok = s.First()
+		} else {
+			e, ok = s.Last()
+		}
+		if ok {
+			info.Add(parser.NewBulkString([]byte(field)), makeStreamEntryReply(e))
+		} else {
+			info.Add(parser.NewBulkString([]byte(field)), parser.MakeNullBulkReply())
+		}
+	}
+	return info
+}
+
+// FULL返回entry和消费组的所有信息，count限制entry和待确认列表的数量，0表示不限制
+func xinfoStreamFull(s *Stream, count int) parser.RespData {
+	limit := func(ids []StreamID) []StreamID {
+		if count > 0 && len(ids) > count {
+			return ids[:count]
+		}
+		return ids
+	}
+	info := xinfoStreamHeader(s)
+	info.Add(parser.NewBulkString([]byte("entries")), makeStreamEntriesReply(s.Range(StreamID{}, maxStreamID, count, false)))
+	groups := make([]parser.RespData, 0, len(s.groups))
+	for _, g := range s.Groups() {
+		pending := make([]parser.RespData, 0)
+		for _, id := range limit(g.pel.ids) {
+			n, _ := g.pel.get(id)
+			pending = append(pending, parser.NewMultiBulk([]parser.RespData{
+				bulkID(id),
+				parser.NewBulkString([]byte(n.consumer.name)),
+				parser.NewInteger(n.deliveryTime),
+				parser.NewInteger(int64(n.deliveryCount)),
+			}))
+		}
+		consumers := make([]parser.RespData, 0, len(g.consumers))
+		for _, c := range g.Consumers() {
+			cpending := make([]parser.RespData, 0)
+			for _, id := range limit(c.pel.ids) {
+				n, _ := c.pel.get(id)
+				cpending = append(cpending, parser.NewMultiBulk([]parser.RespData{
+					bulkID(id),
+					parser.NewInteger(n.deliveryTime),
+					parser.NewInteger(int64(n.deliveryCount)),
+				}))
+			}
+			cinfo := parser.NewMap()
+			cinfo.Add(parser.NewBulkString([]byte("name")), parser.NewBulkString([]byte(c.name)))
+			cinfo.Add(parser.NewBulkString([]byte("seen-time")), parser.NewInteger(c.seenTime))
+			cinfo.Add(parser.NewBulkString([]byte("active-time")), parser.NewInteger(c.activeTime))
+			cinfo.Add(parser.NewBulkString([]byte("pel-count")), parser.NewInteger(int64(c.pel.Len())))
+			cinfo.Add(parser.NewBulkString([]byte("pending")), parser.NewMultiBulk(cpending))
+			consumers = append(consumers, cinfo)
+		}
+		ginfo := parser.NewMap()
+		ginfo.Add(parser.NewBulkString([]byte("name")), parser.NewBulkString([]byte(g.name)))
+		ginfo.Add(parser.NewBulkString([]byte("last-delivered-id")), bulkID(g.lastID))
+		ginfo.Add(parser.NewBulkString([]byte("entries-read")), optionalInteger(g.entriesRead, g.entriesRead != -1))
+		ginfo.Add(parser.NewBulkString([]byte("lag")), optionalInteger(s.Lag(g)))
+		ginfo.Add(parser.NewBulkString([]byte("pel-count")), parser.NewInteger(int64(g.pel.Len())))
+		ginfo.Add(parser.NewBulkString([]byte("pending")), parser.NewMultiBulk(pending))
+		ginfo.Add(parser.NewBulkString([]byte("consumers")), parser.NewMultiBulk(consumers))
+		groups = append(groups, ginfo)
+	}
+	info.Add(parser.NewBulkString([]byte("groups")), parser.NewMultiBulk(groups))
+	return info
+}
+
+func init() {
+	RegisterCmd("xgroup", ExecXgroup)
+	RegisterCmd("xack", ExecXack)
+	RegisterCmd("xpending", ExecXpending)
+	RegisterCmd("xclaim", ExecXclaim)
+	RegisterCmd("xautoclaim", ExecXautoclaim)
+	RegisterCmd("xinfo", ExecXinfo)
+	RegisterEngineCmd("xreadgroup", ExecXreadgroup)
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+func TestXgroup(t *testing.T) {
+	engine := NewDBEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	engine.ExecCmd(LineToArgs("xadd s 1-0 f v"))
+	cases := []struct{ cmd, want string }{
+		{"xgroup create none g $", "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."},
+		{"xgroup create s g $", "OK"},
+		{"xgroup create s g 0", "BUSYGROUP Consumer Group name already exists"},
+		{"xgroup create s g2 x", "ERR Invalid stream ID specified as stream command argument"},
+		{"xgroup create s g2 0 entriesread -2", "ERR value for ENTRIESREAD must be positive or -1"},
+		{"xgroup create new g $ mkstream", "OK"},
+		{"xgroup setid s none 0", "NOGROUP No such consumer group 'none' for key name 's'"},
+		{"xgroup setid s g 0", "OK"},
+		{"xgroup unknown s g", "Invalid command format"},
+	}
+	for _, c := range cases {
+		if got := exec(c.cmd); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	if integer("xlen new") != 0 || exec("type new") != "stream" {
+		t.Fail()
+	}
+	if integer("xgroup createconsumer s g c1") != 1 || integer("xgroup createconsumer s g c1") != 0 {
+		t.Fail()
+	}
+	exec("xreadgroup group g c1 streams s >")
+	if integer("xgroup delconsumer s g c1") != 1 || integer("xgroup delconsumer s g c1") != 0 {
+		t.Log("delconsumer should return the pending count")
+		t.Fail()
+	}
+	if got := exec("xpending s g"); got != "0   nil" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if integer("xgroup destroy s g") != 1 || integer("xgroup destroy s g") != 0 {
+		t.Fail()
+	}
+}
+
+func TestXreadgroup(t *testing.T) {
+	engine, clock := newTestEngine()
+	defer engine.Close()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		exec("xadd s " + id + " f " + id)
+	}
+	exec("xgroup create s g 0")
+
+	cases := []struct{ cmd, want string }{
+		{"xreadgroup group g c1 count 2 streams s >", "s 1-0 f 1-0 2-0 f 2-0"},
+		{"xreadgroup group g c2 streams s >", "s 3-0 f 3-0"},
+		{"xreadgroup group g c2 streams s >", "nil"},
+		{"xreadgroup group g c1 streams s 0", "s 1-0 f 1-0 2-0 f 2-0"},
+		{"xreadgroup group g c1 streams s 1-0", "s 2-0 f 2-0"},
+		{"xreadgroup group g c3 streams s 0", "s "},
+		{"xreadgroup group none c streams s >", "NOGROUP No such key 's' or consumer group 'none' in XREADGROUP with GROUP option"},
+		{"xreadgroup group g c streams s $", "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set."},
+		{"xpending s g", "3 1-0 3-0 c1 2 c2 1"},
+	}
+	for _, c := range cases {
+		if got := exec(c.cmd); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+
+	// 读取历史会增加发送次数
+	clock.Advance(100 * time.Millisecond)
+	if got := exec("xpending s g - + 10"); got != "1-0 c1 100 2 2-0 c1 100 3 3-0 c2 100 1" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := exec("xpending s g idle 50 (1-0 + 1 c1"); got != "2-0 c1 100 3" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if integer("xack s g 1-0 3-0 9-0") != 2 || integer("xack s none 1-0") != 0 {
+		t.Fail()
+	}
+	if got := exec("xpending s g"); got != "1 2-0 2-0 c1 1" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	// NOACK不加入待确认列表
+	exec("xadd s 4-0 f 4-0")
+	if got := exec("xreadgroup group g c1 noack streams s >"); got != "s 4-0 f 4-0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := exec("xpending s g"); got != "1 2-0 2-0 c1 1" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	// 阻塞等待新的entry
+	done := make(chan string)
+	go func() {
+		done <- exec("xreadgroup group g c2 block 0 streams s >")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	exec("xadd s 5-0 f 5-0")
+	if got := <-done; got != "s 5-0 f 5-0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	go func() {
+		done <- exec("xreadgroup group g c2 block 0 streams s >")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	exec("xgroup destroy s g")
+	if got := <-done; !strings.HasPrefix(got, "NOGROUP") {
+		t.Log("destroying the group should wake up blocked consumers")
+		t.Fail()
+	}
+}
+
+func TestXclaim(t *testing.T) {
+	engine, clock := newTestEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0", "4-0", "5-0"} {
+		exec("xadd s " + id + " f " + id)
+	}
+	exec("xgroup create s g 0")
+	exec("xreadgroup group g c1 count 4 streams s >")
+	clock.Advance(time.Second)
+	exec("xdel s 2-0")
+
+	cases := []struct{ cmd, want string }{
+		{"xclaim s g c2 2000 1-0", ""},
+		{"xclaim s g c2 500 1-0 2-0", "1-0 f 1-0"},
+		{"xclaim s g c2 0 5-0", ""},
+		{"xclaim s g c2 0 5-0 force justid", "5-0"},
+		{"xclaim s g c2 0 3-0 retrycount 7 idle 300 justid", "3-0"},
+		{"xclaim s g c2 x 1-0", "ERR Invalid min-idle-time argument for XCLAIM"},
+		{"xclaim s none c2 0 1-0", "NOGROUP No such key 's' or consumer group 'none'"},
+		// 已经删除的2-0从待确认列表中删除
+		{"xpending s g - + 10", "1-0 c2 0 2 3-0 c2 300 7 4-0 c1 1000 1 5-0 c2 0 1"},
+	}
+	for _, c := range cases {
+		if got := exec(c.cmd); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+
+	exec("xdel s 4-0")
+	clock.Advance(time.Second)
+	cases = []struct{ cmd, want string }{
+		{"xautoclaim s g c3 500 - count 1", "3-0 1-0 f 1-0 "},
+		{"xautoclaim s g c3 500 3-0 count 5 justid", "0-0 3-0 5-0 4-0"},
+		{"xautoclaim s g c3 500 0 count 0", "ERR COUNT must be > 0"},
+		{"xpending s g", "3 1-0 5-0 c3 3"},
+	}
+	for _, c := range cases {
+		if got := exec(c.cmd); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestXinfo(t *testing.T) {
+	engine, clock := newTestEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		exec("xadd s " + id + " f " + id)
+	}
+	exec("xgroup create s g1 0")
+	exec("xgroup create s g2 $")
+	exec("xreadgroup group g1 c count 1 streams s >")
+	clock.Advance(50 * time.Millisecond)
+	exec("xgroup createconsumer s g1 idle")
+
+	want := "length 3 radix-tree-keys 1 radix-tree-nodes 1 last-generated-id 3-0 max-deleted-entry-id 0-0 " +
+		"entries-added 3 recorded-first-entry-id 1-0 groups 2 first-entry 1-0 f 1-0 last-entry 3-0 f 3-0"
+	if got := exec("xinfo stream s"); got != want {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	want = "name g1 consumers 2 pending 1 last-delivered-id 1-0 entries-read 1 lag 2 " +
+		"name g2 consumers 0 pending 0 last-delivered-id 3-0 entries-read 3 lag 0"
+	if got := exec("xinfo groups s"); got != want {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	want = "name c pending 1 idle 50 inactive 50 name idle pending 0 idle 0 inactive -1"
+	if got := exec("xinfo consumers s g1"); got != want {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	// 删除还没有读取的entry之后无法计算lag
+	exec("xdel s 2-0")
+	if got := exec("xinfo groups s"); !strings.Contains(got, "entries-read 1 lag  name g2") {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := exec("xinfo stream s full count 1"); !strings.Contains(got, "entries 1-0 f 1-0 groups name g1") ||
+		!strings.Contains(got, "pel-count 1 pending 1-0 c") {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := exec("xinfo stream none"); got != "ERR no such key" {
+		t.Fail()
+	}
+	if got := exec("xinfo consumers s none"); got != "NOGROUP No such consumer group 'none' for key name 's'" {
+		t.Fail()
+	}
+}
+
+// 消费组读取新的entry时更新entries-read，xack和读取历史消息不影响
+func TestXgroupEntriesRead(t *testing.T) {
+	engine := NewDBEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	groups := func() string {
+		reply := exec("xinfo groups s")
+		_, after, _ := strings.Cut(reply, "entries-read ")
+		return after
+	}
+	exec("xadd s 1-0 f v")
+	exec("xadd s 2-0 f v")
+	exec("xgroup create s g $")
+	if got := groups(); got != "2 lag 0" {
+		t.Logf("group created with $ should have read all entries, got %q", got)
+		t.Fail()
+	}
+	exec("xadd s 3-0 f v")
+	exec("xadd s 4-0 f v")
+	if got := groups(); got != "2 lag 2" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	exec("xreadgroup group g c count 1 streams s >")
+	exec("xack s g 3-0")
+	exec("xreadgroup group g c streams s 0")
+	if got := groups(); got != "3 lag 1" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	exec("xreadgroup group g c streams s >")
+	if got := groups(); got != "4 lag 0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	exec("xgroup setid s g 0")
+	if got := groups(); got != " lag 4" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	exec("xgroup setid s g $")
+	if got := groups(); got != "4 lag 0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+}
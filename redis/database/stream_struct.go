@@ -0,0 +1,488 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// 和redis一样，每个节点最多保存100个entry
+const streamNodeMaxEntries = 100
+
+// StreamID 由毫秒时间和同一毫秒内的序号组成，stream中的ID严格递增
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || id.Ms == other.Ms && id.Seq < other.Seq
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// 下一个ID，已经是最大的ID时返回false
+func (id StreamID) incr() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{id.Ms, id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// 上一个ID，已经是0-0时返回false
+func (id StreamID) decr() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{id.Ms, id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte // field和value交替排列
+}
+
+// Stream 把entry按ID顺序分段保存在节点中，通过二分查找定位，
+// 追加和从头部裁剪都只涉及少数节点，范围查询的代价和返回的entry数量相关
+type Stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       StreamID // 添加过的最大ID，entry被删除后也不会变小
+	maxDeletedID StreamID // xdel删除过的最大ID
+	entriesAdded uint64   // 添加过的entry总数
+	groups       map[string]*StreamGroup
+}
+
+type streamNode struct {
+	entries []*StreamEntry
+}
+
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+func (s *Stream) First() (*StreamEntry, bool) {
+	if s.length == 0 {
+		return nil, false
+	}
+	return s.nodes[0].entries[0], true
+}
+
+func (s *Stream) Last() (*StreamEntry, bool) {
+	if s.length == 0 {
+		return nil, false
+	}
+	node := s.nodes[len(s.nodes)-1]
+	return node.entries[len(node.entries)-1], true
+}
+
+// 自动生成ID：时间比上一个ID大时序号从0开始，否则在上一个ID的基础上加1
+func (s *Stream) NextID(ms uint64) (StreamID, bool) {
+	if ms > s.lastID.Ms {
+		return StreamID{ms, 0}, true
+	}
+	return s.lastID.incr()
+}
+
+// Append 添加entry，调用者需要保证id比lastID大
+func (s *Stream) Append(id StreamID, fields [][]byte) {
+	if len(s.nodes) == 0 || len(s.nodes[len(s.nodes)-1].entries) >= streamNodeMaxEntries {
+		s.nodes = append(s.nodes, &streamNode{entries: make([]*StreamEntry, 0, streamNodeMaxEntries)})
+	}
+	node := s.nodes[len(s.nodes)-1]
+	node.entries = append(node.entries, &StreamEntry{ID: id, Fields: fields})
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// 返回第一个满足f的entry的位置，f对于ID需要是单调的。没有时返回(len(s.nodes), 0)
+func (s *Stream) search(f func(id StreamID) bool) (int, int) {
+	ni := sort.Search(len(s.nodes), func(i int) bool {
+		entries := s.nodes[i].entries
+		return f(entries[len(entries)-1].ID)
+	})
+	if ni == len(s.nodes) {
+		return ni, 0
+	}
+	entries := s.nodes[ni].entries
+	return ni, sort.Search(len(entries), func(i int) bool { return f(entries[i].ID) })
+}
+
+// Range 返回ID在[start, end]中的entry，reverse为true时从end开始倒序返回，count<=0表示不限制数量
+func (s *Stream) Range(start, end StreamID, count int, reverse bool) []*StreamEntry {
+	var result []*StreamEntry
+	if end.Less(start) {
+		return result
+	}
+	if !reverse {
+		ni, ei := s.search(func(id StreamID) bool { return !id.Less(start) })
+		for ; ni < len(s.nodes); ni, ei = ni+1, 0 {
+			for _, e := range s.nodes[ni].entries[ei:] {
+				if end.Less(e.ID) || count > 0 && len(result) >= count {
+					return result
+				}
+				result = append(result, e)
+			}
+		}
+		return result
+	}
+
+	// 从第一个大于end的entry的前一个开始
+	ni, ei := s.search(func(id StreamID) bool { return end.Less(id) })
+	for {
+		if ei == 0 {
+			if ni == 0 {
+				return result
+			}
+			ni--
+			ei = len(s.nodes[ni].entries)
+		}
+		ei--
+		e := s.nodes[ni].entries[ei]
+		if e.ID.Less(start) || count > 0 && len(result) >= count {
+			return result
+		}
+		result = append(result, e)
+	}
+}
+
+// Get 查找指定ID的entry
+func (s *Stream) Get(id StreamID) (*StreamEntry, bool) {
+	ni, ei := s.search(func(eid StreamID) bool { return !eid.Less(id) })
+	if ni == len(s.nodes) || s.nodes[ni].entries[ei].ID != id {
+		return nil, false
+	}
+	return s.nodes[ni].entries[ei], true
+}
+
+// Delete 删除指定ID的entry，不存在时返回false
+func (s *Stream) Delete(id StreamID) bool {
+	ni, ei := s.search(func(eid StreamID) bool { return !eid.Less(id) })
+	if ni == len(s.nodes) || s.nodes[ni].entries[ei].ID != id {
+		return false
+	}
+	node := s.nodes[ni]
+	copy(node.entries[ei:], node.entries[ei+1:])
+	node.entries[len(node.entries)-1] = nil
+	node.entries = node.entries[:len(node.entries)-1]
+	if len(node.entries) == 0 {
+		copy(s.nodes[ni:], s.nodes[ni+1:])
+		s.nodes[len(s.nodes)-1] = nil
+		s.nodes = s.nodes[:len(s.nodes)-1]
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// 从头部删除entry，count返回节点开头需要删除的entry数量。
+// approx为true时只删除整个节点，limit限制删除的entry数量，0表示不限制。返回删除的数量
+func (s *Stream) trim(approx bool, limit int, count func(entries []*StreamEntry) int) int {
+	removed := 0
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		n := count(node.entries)
+		if n == 0 {
+			break
+		}
+		if n == len(node.entries) {
+			if limit > 0 && removed+n > limit {
+				break
+			}
+			s.nodes[0] = nil
+			s.nodes = s.nodes[1:]
+		} else {
+			if approx {
+				break
+			}
+			for i := 0; i < n; i++ {
+				node.entries[i] = nil
+			}
+			node.entries = node.entries[n:]
+		}
+		s.length -= n
+		removed += n
+	}
+	return removed
+}
+
+// TrimByLen 删除最旧的entry，直到长度不超过maxlen
+func (s *Stream) TrimByLen(maxlen int, approx bool, limit int) int {
+	return s.trim(approx, limit, func(entries []*StreamEntry) int {
+		n := s.length - maxlen
+		if n < 0 {
+			return 0
+		}
+		if n > len(entries) {
+			return len(entries)
+		}
+		return n
+	})
+}
+
+// TrimByMinID 删除ID比minID小的entry
+func (s *Stream) TrimByMinID(minID StreamID, approx bool, limit int) int {
+	return s.trim(approx, limit, func(entries []*StreamEntry) int {
+		return sort.Search(len(entries), func(i int) bool { return !entries[i].ID.Less(minID) })
+	})
+}
+
+// streamNack 是已经发送给消费者但还没有确认的entry
+type streamNack struct {
+	id            StreamID
+	deliveryTime  int64 // unix毫秒
+	deliveryCount uint64
+	consumer      *StreamConsumer
+}
+
+// streamPEL 是按ID排序的待确认列表，新发送的entry的ID通常最大，添加时只需要追加
+type streamPEL struct {
+	ids   []StreamID
+	nacks map[StreamID]*streamNack
+}
+
+func newStreamPEL() *streamPEL {
+	return &streamPEL{nacks: make(map[StreamID]*streamNack)}
+}
+
+func (p *streamPEL) Len() int {
+	return len(p.ids)
+}
+
+func (p *streamPEL) get(id StreamID) (*streamNack, bool) {
+	n, ok := p.nacks[id]
+	return n, ok
+}
+
+// 第一个不小于id的位置
+func (p *streamPEL) seek(id StreamID) int {
+	return sort.Search(len(p.ids), func(i int) bool { return !p.ids[i].Less(id) })
+}
+
+func (p *streamPEL) add(n *streamNack) {
+	p.nacks[n.id] = n
+	if len(p.ids) == 0 || p.ids[len(p.ids)-1].Less(n.id) {
+		p.ids = append(p.ids, n.id)
+		return
+	}
+	i := p.seek(n.id)
+	p.ids = append(p.ids, StreamID{})
+	copy(p.ids[i+1:], p.ids[i:])
+	p.ids[i] = n.id
+}
+
+func (p *streamPEL) remove(id StreamID) bool {
+	if _, ok := p.nacks[id]; !ok {
+		return false
+	}
+	delete(p.nacks, id)
+	i := p.seek(id)
+	p.ids = append(p.ids[:i], p.ids[i+1:]...)
+	return true
+}
+
+// StreamConsumer 的pel和消费组的pel共享streamNack
+type StreamConsumer struct {
+	name       string
+	seenTime   int64 // 最后一次尝试读取或认领的时间
+	activeTime int64 // 最后一次成功读取或认领的时间，-1表示还没有
+	pel        *streamPEL
+}
+
+// StreamGroup 记录发送给消费组的最大ID，以及所有已经发送但没有确认的entry
+type StreamGroup struct {
+	name        string
+	lastID      StreamID
+	entriesRead int64 // 读取过的entry数量，用于计算lag，-1表示未知
+	pel         *streamPEL
+	consumers   map[string]*StreamConsumer
+}
+
+func (s *Stream) Group(name string) (*StreamGroup, bool) {
+	g, ok := s.groups[name]
+	return g, ok
+}
+
+// CreateGroup 创建消费组，已经存在时返回false
+func (s *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) (*StreamGroup, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	if s.groups == nil {
+		s.groups = make(map[string]*StreamGroup)
+	}
+	g := &StreamGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pel:         newStreamPEL(),
+		consumers:   make(map[string]*StreamConsumer),
+	}
+	s.groups[name] = g
+	return g, true
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups 返回按名字排序的消费组
+func (s *Stream) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].name < groups[j].name })
+	return groups
+}
+
+func (g *StreamGroup) Consumer(name string) (*StreamConsumer, bool) {
+	c, ok := g.consumers[name]
+	return c, ok
+}
+
+// CreateConsumer 创建消费者，已经存在时返回原来的消费者和false
+func (g *StreamGroup) CreateConsumer(name string, now int64) (*StreamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &StreamConsumer{name: name, seenTime: now, activeTime: -1, pel: newStreamPEL()}
+	g.consumers[name] = c
+	return c, true
+}
+
+// DeleteConsumer 删除消费者和它所有待确认的entry，返回待确认的entry数量
+func (g *StreamGroup) DeleteConsumer(name string) (int, bool) {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+	for _, id := range c.pel.ids {
+		g.pel.remove(id)
+	}
+	delete(g.consumers, name)
+	return c.pel.Len(), true
+}
+
+// Consumers 返回按名字排序的消费者
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].name < consumers[j].name })
+	return consumers
+}
+
+// Ack 确认entry，把它从消费组和消费者的待确认列表中删除
+func (g *StreamGroup) Ack(id StreamID) bool {
+	n, ok := g.pel.get(id)
+	if !ok {
+		return false
+	}
+	g.pel.remove(id)
+	n.consumer.pel.remove(id)
+	return true
+}
+
+// 把entry交给消费者c，不在待确认列表中时创建新的记录。返回对应的记录
+func (g *StreamGroup) assign(id StreamID, c *StreamConsumer) *streamNack {
+	n, ok := g.pel.get(id)
+	if !ok {
+		n = &streamNack{id: id}
+		g.pel.add(n)
+	} else if n.consumer != c {
+		n.consumer.pel.remove(id)
+	}
+	if n.consumer != c {
+		n.consumer = c
+		c.pel.add(n)
+	}
+	return n
+}
+
+// ID在[start, 最大ID]之间是否有被xdel删除的entry，用于判断entriesRead是否还准确
+func (s *Stream) rangeHasTombstones(start StreamID) bool {
+	if s.length == 0 || s.maxDeletedID == (StreamID{}) {
+		return false
+	}
+	return !s.maxDeletedID.Less(start)
+}
+
+// 估计id是stream中添加的第几个entry，无法确定时返回-1
+func (s *Stream) estimateEntriesRead(id StreamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && !s.lastID.Less(id) {
+		return int64(s.entriesAdded)
+	}
+	if id == s.lastID {
+		return int64(s.entriesAdded)
+	}
+	if s.lastID.Less(id) {
+		return -1
+	}
+	first, _ := s.First()
+	if s.maxDeletedID == (StreamID{}) || s.maxDeletedID.Less(first.ID) {
+		// 第一个entry之后没有被删除的entry
+		if id.Less(first.ID) {
+			return int64(s.entriesAdded) - int64(s.length)
+		}
+		if id == first.ID {
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+	return -1
+}
+
+// 消费组向后读取到id时更新entriesRead和lastID
+func (s *Stream) advanceGroup(g *StreamGroup, id StreamID) {
+	if !g.lastID.Less(id) {
+		return
+	}
+	if g.entriesRead != -1 && !s.rangeHasTombstones(id) {
+		g.entriesRead++
+	} else if s.entriesAdded != 0 {
+		g.entriesRead = s.estimateEntriesRead(id)
+	}
+	g.lastID = id
+}
+
+// Lag 返回消费组还没有读取的entry数量，无法确定时返回false
+func (s *Stream) Lag(g *StreamGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.entriesRead != -1 && !s.rangeHasTombstones(g.lastID) {
+		return int64(s.entriesAdded) - g.entriesRead, true
+	}
+	entriesRead := s.estimateEntriesRead(g.lastID)
+	if entriesRead == -1 {
+		return 0, false
+	}
+	return int64(s.entriesAdded) - entriesRead, true
+}
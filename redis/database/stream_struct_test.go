@@ -0,0 +1,112 @@
+package database
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func streamIDs(entries []*StreamEntry) []StreamID {
+	ids := make([]StreamID, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []StreamID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 随机添加和删除之后，范围查询的结果应该和按顺序保存的ID一致
+func TestStreamRange(t *testing.T) {
+	s := NewStream()
+	var expected []StreamID
+	for i := 0; i < 3000; i++ {
+		id, _ := s.NextID(uint64(i / 3))
+		s.Append(id, [][]byte{[]byte("f"), []byte("v")})
+		expected = append(expected, id)
+		if rand.Intn(3) == 0 {
+			j := rand.Intn(len(expected))
+			if !s.Delete(expected[j]) {
+				t.FailNow()
+			}
+			expected = append(expected[:j], expected[j+1:]...)
+		}
+	}
+	if s.Len() != len(expected) || !equalIDs(streamIDs(s.Range(StreamID{}, maxStreamID, 0, false)), expected) {
+		t.Log("wrong entries")
+		t.FailNow()
+	}
+	if s.Delete(StreamID{5000, 0}) {
+		t.Fail()
+	}
+
+	for i := 0; i < 100; i++ {
+		a, b := expected[rand.Intn(len(expected))], expected[rand.Intn(len(expected))]
+		if b.Less(a) {
+			a, b = b, a
+		}
+		var want []StreamID
+		for _, id := range expected {
+			if !id.Less(a) && !b.Less(id) {
+				want = append(want, id)
+			}
+		}
+		if !equalIDs(streamIDs(s.Range(a, b, 0, false)), want) {
+			t.Logf("wrong range [%s, %s]", a, b)
+			t.FailNow()
+		}
+		reversed := streamIDs(s.Range(a, b, 5, true))
+		for j, id := range reversed {
+			if id != want[len(want)-1-j] {
+				t.Logf("wrong reverse range [%s, %s]", a, b)
+				t.FailNow()
+			}
+		}
+		if len(want) >= 5 && len(reversed) != 5 {
+			t.Fail()
+		}
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	s := NewStream()
+	for i := 1; i <= 1000; i++ {
+		s.Append(StreamID{uint64(i), 0}, nil)
+	}
+	// 近似裁剪只删除整个节点
+	if s.TrimByLen(850, true, 0) != 100 || s.Len() != 900 {
+		t.Fail()
+	}
+	if s.TrimByLen(450, true, 200) != 200 || s.Len() != 700 {
+		t.Log("limit should stop trimming")
+		t.Fail()
+	}
+	if s.TrimByLen(650, false, 0) != 50 || s.Len() != 650 {
+		t.Fail()
+	}
+	if first, _ := s.First(); first.ID != (StreamID{351, 0}) {
+		t.Fail()
+	}
+	if s.TrimByMinID(StreamID{500, 0}, true, 0) != 50 || s.TrimByMinID(StreamID{500, 0}, false, 0) != 99 {
+		t.Fail()
+	}
+	if first, _ := s.First(); first.ID != (StreamID{500, 0}) || s.Len() != 501 {
+		t.Fail()
+	}
+	if s.TrimByLen(0, false, 0) != 501 || s.Len() != 0 || s.LastID() != (StreamID{1000, 0}) {
+		t.Log("trimming should not change last id")
+		t.Fail()
+	}
+	if id, _ := s.NextID(3); id != (StreamID{1000, 1}) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,271 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+// 把嵌套的回复展开成用空格分隔的字符串，空数组回复为nil
+func streamReply(reply parser.RespData) string {
+	switch r := reply.(type) {
+	case *parser.MultiBulk:
+		parts := make([]string, 0, len(r.Args))
+		for _, arg := range r.Args {
+			parts = append(parts, streamReply(arg))
+		}
+		return strings.Join(parts, " ")
+	case *parser.Array:
+		parts := make([]string, 0, len(r.Args))
+		for _, arg := range r.Args {
+			parts = append(parts, string(arg))
+		}
+		return strings.Join(parts, " ")
+	case *parser.Map:
+		parts := make([]string, 0, len(r.Keys)*2)
+		for i := range r.Keys {
+			parts = append(parts, streamReply(r.Keys[i]), streamReply(r.Values[i]))
+		}
+		return strings.Join(parts, " ")
+	case *parser.BulkString:
+		return string(r.Arg)
+	case *parser.String:
+		return r.Arg
+	case *parser.Integer:
+		return strconv.FormatInt(r.Arg, 10)
+	case *parser.NullArray:
+		return "nil"
+	case *parser.Error:
+		return r.Arg
+	}
+	return ""
+}
+
+func TestXadd(t *testing.T) {
+	engine, clock := newTestEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	now := clock.Now().UnixMilli()
+	if exec("xadd s * name a") != (StreamID{uint64(now), 0}).String() || exec("xadd s * name b") != (StreamID{uint64(now), 1}).String() {
+		t.Log("auto id should use the clock and increase the sequence")
+		t.Fail()
+	}
+	cases := []struct{ cmd, want string }{
+		{"xadd s 1-1 f v", "ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{"xadd t 0-0 f v", "ERR The ID specified in XADD must be greater than 0-0"},
+		{"xadd t 0-* f v", "0-1"},
+		{"xadd t 5 f v", "5-0"},
+		{"xadd t 5-* f v", "5-1"},
+		{"xadd t 4-* f v", "ERR The ID specified in XADD is equal or smaller than the target stream top item"},
+		{"xadd t 18446744073709551615-18446744073709551615 f v", "18446744073709551615-18446744073709551615"},
+		{"xadd t * f v", "ERR The stream has exhausted the last possible ID, unable to add more items"},
+		{"xadd t abc f v", "ERR Invalid stream ID specified as stream command argument"},
+		{"xadd t * f", "Invalid command format"},
+		{"xadd none nomkstream * f v", ""},
+		{"xadd t maxlen -1 * f v", "ERR The MAXLEN argument must be >= 0."},
+		{"xadd t maxlen 1 limit 10 * f v", "ERR syntax error, LIMIT cannot be used without the special ~ option"},
+	}
+	for _, c := range cases {
+		if got := exec(c.cmd); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+	if engine.ExecCmd(LineToArgs("exists none")).(*parser.Integer).Arg != 0 {
+		t.Log("NOMKSTREAM should not create the key")
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("type s")).(*parser.String); reply.Arg != "stream" {
+		t.Fail()
+	}
+
+	for i := 0; i < 10; i++ {
+		exec("xadd m maxlen 5 * f v")
+	}
+	if got := exec("xadd m maxlen = 3 minid 0 * f v"); got != "ERR syntax error, MAXLEN and MINID options at the same time are not compatible" {
+		t.Fail()
+	}
+	if n := engine.ExecCmd(LineToArgs("xlen m")).(*parser.Integer).Arg; n != 5 {
+		t.Logf("xlen %d", n)
+		t.Fail()
+	}
+	exec("xadd m maxlen 0 * f v")
+	if n := engine.ExecCmd(LineToArgs("xlen m")).(*parser.Integer).Arg; n != 0 || exec("type m") != "stream" {
+		t.Log("empty stream should be kept")
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("set str v"))
+	if got := exec("xadd str * f v"); !strings.HasPrefix(got, "WRONGTYPE") {
+		t.Fail()
+	}
+}
+
+func TestXrange(t *testing.T) {
+	engine := NewDBEngine()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-5", "4-0"} {
+		exec("xadd s " + id + " k " + id)
+	}
+	cases := map[string]string{
+		"xrange s - +":            "1-0 k 1-0 1-1 k 1-1 2-0 k 2-0 3-5 k 3-5 4-0 k 4-0",
+		"xrange s 1 1":            "1-0 k 1-0 1-1 k 1-1",
+		"xrange s (1-0 3 count 2": "1-1 k 1-1 2-0 k 2-0",
+		"xrange s 2 (3-5":         "2-0 k 2-0",
+		"xrevrange s + - count 2": "4-0 k 4-0 3-5 k 3-5",
+		"xrevrange s (4-0 (1-1":   "3-5 k 3-5 2-0 k 2-0",
+		"xrange s 3 2":            "",
+		"xrange s - + count 0":    "",
+		"xrange none - +":         "",
+		"xrange s (18446744073709551615-18446744073709551615 +": "",
+		"xrange s x +":         "ERR Invalid stream ID specified as stream command argument",
+		"xrange s - + limit 1": "Invalid command format",
+	}
+	for line, want := range cases {
+		if got := exec(line); got != want {
+			t.Logf("%s: want %q, got %q", line, want, got)
+			t.Fail()
+		}
+	}
+
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	if integer("xdel s 1-1 2-0 9-9") != 2 || integer("xlen s") != 3 || integer("xlen none") != 0 {
+		t.Fail()
+	}
+	if got := exec("xrange s - +"); got != "1-0 k 1-0 3-5 k 3-5 4-0 k 4-0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if integer("xtrim s minid 4") != 2 || integer("xtrim s maxlen ~ 0") != 1 || integer("xtrim none maxlen 0") != 0 {
+		t.Fail()
+	}
+	if got := exec("xadd s 3-0 f v"); !strings.HasPrefix(got, "ERR") {
+		t.Log("deleted entries should not lower the last id")
+		t.Fail()
+	}
+	if got := exec("xtrim s maxlen"); got != "Invalid command format" {
+		t.Fail()
+	}
+}
+
+func TestXread(t *testing.T) {
+	engine, clock := newTestEngine()
+	defer engine.Close()
+	exec := func(line string) string {
+		return streamReply(engine.ExecCmd(LineToArgs(line)))
+	}
+	exec("xadd a 1-0 f 1")
+	exec("xadd a 2-0 f 2")
+	exec("xadd b 1-0 f 3")
+	cases := map[string]string{
+		"xread streams a b 0 0":         "a 1-0 f 1 2-0 f 2 b 1-0 f 3",
+		"xread count 1 streams a b 1 0": "a 2-0 f 2 b 1-0 f 3",
+		"xread streams a b $ 1":         "nil",
+		"xread streams none 0":          "nil",
+		"xread streams a b 0":           "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.",
+		"xread block -1 streams a 0":    "ERR timeout is negative",
+		"xread streams a x":             "ERR Invalid stream ID specified as stream command argument",
+	}
+	for line, want := range cases {
+		if got := exec(line); got != want {
+			t.Logf("%s: want %q, got %q", line, want, got)
+			t.Fail()
+		}
+	}
+
+	// "$"只读取阻塞之后添加的entry
+	done := make(chan string)
+	go func() {
+		done <- exec("xread block 0 streams none a $ $")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	exec("xadd b 2-0 f 4")
+	exec("xadd a 3-0 f 5")
+	if got := <-done; got != "a 3-0 f 5" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	// 超时返回nil，在时间轮上计时
+	go func() {
+		done <- exec("xread block 100 streams a $")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-done:
+		t.Log("should block until timeout")
+		t.FailNow()
+	default:
+	}
+	clock.Advance(200 * time.Millisecond)
+	if got := <-done; got != "nil" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	// 关闭时唤醒阻塞的连接
+	go func() {
+		done <- exec("xread block 0 streams a $")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	engine.Close()
+	if got := <-done; got != "nil" {
+		t.Fail()
+	}
+}
+
+// 阻塞在交换之后的数据库上的连接也能读到数据
+func TestXreadSwapDB(t *testing.T) {
+	engine := NewDBEngine()
+	defer engine.Close()
+	execIn(engine, &Session{DB: 1}, "xadd s 1-0 f v")
+	done := make(chan string)
+	go func() {
+		done <- streamReply(execIn(engine, &Session{DB: 0}, "xread block 0 streams s 0"))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	engine.ExecCmd(LineToArgs("swapdb 0 1"))
+	select {
+	case got := <-done:
+		if got != "s 1-0 f v" {
+			t.Logf("got %q", got)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Log("swapdb should wake up blocked clients")
+		t.Fail()
+	}
+}
+
+// 连接断开之后阻塞的命令返回，不再等待key
+func TestXreadClosed(t *testing.T) {
+	engine := NewDBEngine()
+	defer engine.Close()
+	closed := make(chan struct{})
+	done := make(chan parser.RespData)
+	go func() {
+		done <- execIn(engine, &Session{Closed: closed}, "xread block 0 streams s $")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(closed)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Log("closed connection should stop blocking")
+		t.FailNow()
+	}
+	engine.blocked.mu.Lock()
+	defer engine.blocked.mu.Unlock()
+	if len(engine.blocked.waiters) != 0 {
+		t.Logf("waiters should be removed, got %v", engine.blocked.waiters)
+		t.Fail()
+	}
+}
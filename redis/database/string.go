@@ -16,120 +16,156 @@ const (
 	SETXX
 )
 
-func ExecSet(engine *DBEngine, args [][]byte) parser.RespData {
+// set key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
+func ExecSet(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 	value := args[2]
-	delayTime := time.Duration(0)
 	setFlag := SETNON
+	get := false
+	expireOpt := ""    // 指定的过期时间选项，多个不同的选项不能同时使用
+	var expireAt int64 // unix毫秒，0表示不设置过期时间
 
 	for i := 3; i < len(args); i++ {
 		arg := strings.ToLower(string(args[i]))
 		switch arg {
-		case "nx":
-			setFlag = SETNX
-		case "xx":
-			setFlag = SETXX
-		case "ex":
-			if i+1 >= len(args) {
-				return parser.NewError("Invalid command format")
+		case "nx", "xx":
+			flag := SETNX
+			if arg == "xx" {
+				flag = SETXX
 			}
-			i++
-			seconds, err := strconv.Atoi(string(args[i]))
-			if err != nil {
-				return parser.NewError("Invalid command format")
+			if setFlag != SETNON && setFlag != flag {
+				return parser.NewError("ERR NX and XX options at the same time are not compatible")
 			}
-			if seconds <= 0 {
-				// 时间不能为零或负数
-				return parser.NewError("Invalid command format")
+			setFlag = flag
+		case "get":
+			get = true
+		case "keepttl", "ex", "px", "exat", "pxat":
+			if expireOpt != "" && expireOpt != arg {
+				return parser.NewError("ERR EX, PX, EXAT, PXAT and KEEPTTL options at the same time are not compatible")
+			}
+			expireOpt = arg
+			if arg == "keepttl" {
+				continue
 			}
-			delayTime = time.Duration(seconds) * time.Second
-		case "px":
 			if i+1 >= len(args) {
 				return parser.NewError("Invalid command format")
 			}
 			i++
-			milliseconds, err := strconv.Atoi(string(args[i]))
+			when, err := strconv.ParseInt(string(args[i]), 10, 64)
 			if err != nil {
-				return parser.NewError("Invalid command format")
+				return parser.NewError("Value is not an integer or out of range")
 			}
-			if milliseconds <= 0 {
-				// 时间不能为零或负数
-				return parser.NewError("Invalid command format")
+			var ok bool
+			if when > 0 {
+				switch arg {
+				case "ex":
+					expireAt, ok = toExpireAt(when, db.mstime(), time.Second)
+				case "px":
+					expireAt, ok = toExpireAt(when, db.mstime(), time.Millisecond)
+				case "exat":
+					expireAt, ok = toExpireAt(when, 0, time.Second)
+				case "pxat":
+					expireAt, ok = toExpireAt(when, 0, time.Millisecond)
+				}
+			}
+			// 时间不能为零或负数
+			if !ok {
+				return parser.NewError("ERR invalid expire time in 'set' command")
 			}
-			delayTime = time.Duration(milliseconds) * time.Millisecond
 		default:
 			// 不支持的参数
 			return parser.NewError("Invalid command format")
 		}
 	}
 
-	switch setFlag {
-	case SETNON:
-		engine.lock.Lock(key)
-		defer engine.lock.UnLock(key)
-		engine.db.SetWithLock(key, value)
-		if delayTime == time.Duration(0) {
-			engine.CancelTTL(key)
-		} else {
-			engine.SetTTL(key, delayTime)
-		}
-		return parser.MakeOKReply()
-	case SETNX:
-		engine.lock.Lock(key)
-		defer engine.lock.UnLock(key)
-		if _, ok := engine.db.GetWithLock(key); ok {
-			return parser.MakeNullBulkReply()
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	item, exist := db.data.GetWithLock(key)
+	// GET选项返回旧值，旧值不是字符串时不做修改
+	var old parser.RespData = parser.MakeNullBulkReply()
+	if get && exist {
+		s, ok := item.([]byte)
+		if !ok {
+			return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
-		engine.db.SetWithLock(key, value)
-		if delayTime == time.Duration(0) {
-			engine.CancelTTL(key)
-		} else {
-			engine.SetTTL(key, delayTime)
+		old = parser.NewBulkString(s)
+	}
+	if (setFlag == SETNX && exist) || (setFlag == SETXX && !exist) {
+		if get {
+			return old
 		}
-		return parser.MakeOKReply()
-	case SETXX:
-		engine.lock.Lock(key)
-		defer engine.lock.UnLock(key)
-		if _, ok := engine.db.GetWithLock(key); !ok {
-			return parser.MakeNullBulkReply()
+		return parser.MakeNullBulkReply()
+	}
+
+	switch {
+	case expireAt > 0 && expireAt <= db.mstime():
+		// EXAT和PXAT指定的时间已经过去，相当于设置后立即过期
+		if exist {
+			db.data.DelWithLock(key)
+			db.CancelTTL(key)
+			db.propagate([][]byte{[]byte("del"), args[1]})
 		}
-		engine.db.SetWithLock(key, value)
-		if delayTime == time.Duration(0) {
-			engine.CancelTTL(key)
-		} else {
-			engine.SetTTL(key, delayTime)
+	case expireAt > 0:
+		db.data.SetWithLock(key, value)
+		db.SetExpireAt(key, expireAt)
+		db.propagateSet(key, value)
+	default:
+		db.data.SetWithLock(key, value)
+		if expireOpt != "keepttl" {
+			db.CancelTTL(key)
 		}
-		return parser.MakeOKReply()
+		db.propagateSet(key, value)
 	}
+	if get {
+		return old
+	}
+	return parser.MakeOKReply()
+}
 
-	return parser.NewError("Unknow error")
+// 带过期时间的set记录为set和pexpireat两条命令
+func (db *DB) propagateSet(key string, value []byte) {
+	db.propagate([][]byte{[]byte("set"), []byte(key), value})
+	db.propagateExpire(key)
 }
 
-func ExecSetex(engine *DBEngine, args [][]byte) parser.RespData {
+// setex和psetex，unit是过期时间的单位
+func setexGeneric(db *DB, args [][]byte, unit time.Duration) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 	value := args[3]
-	seconds, err := strconv.Atoi(string(args[2]))
+	ttl, err := strconv.ParseInt(string(args[2]), 10, 64)
 	if err != nil {
 		return parser.NewError("Value is not an integer")
 	}
-	delayTime := time.Duration(seconds) * time.Second
+	if ttl <= 0 || ttl > int64(math.MaxInt64/unit) {
+		return parser.NewError("Invalid expire time in " + strings.ToLower(string(args[0])))
+	}
+	delayTime := time.Duration(ttl) * unit
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	engine.db.SetWithLock(key, value)
-	engine.SetTTL(key, delayTime)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	db.data.SetWithLock(key, value)
+	db.SetTTL(key, delayTime)
+	db.propagateSet(key, value)
 	return parser.MakeOKReply()
 }
 
-func ExecSetnx(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSetex(db *DB, args [][]byte) parser.RespData {
+	return setexGeneric(db, args, time.Second)
+}
+
+func ExecPsetex(db *DB, args [][]byte) parser.RespData {
+	return setexGeneric(db, args, time.Millisecond)
+}
+
+func ExecSetnx(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -137,17 +173,18 @@ func ExecSetnx(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	value := args[2]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	if _, ok := engine.db.GetWithLock(key); ok {
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	if _, ok := db.data.GetWithLock(key); ok {
 		return parser.NewInteger(0)
 	}
 
-	engine.db.SetWithLock(key, value)
+	db.data.SetWithLock(key, value)
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecMsetnx(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecMsetnx(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 || len(args)%2 != 1 {
 		return parser.NewError("Invalid command format")
 	}
@@ -159,30 +196,31 @@ func ExecMsetnx(engine *DBEngine, args [][]byte) parser.RespData {
 		vals = append(vals, args[i+1])
 	}
 
-	engine.lock.Locks(keys)
-	defer engine.lock.UnLocks(keys)
+	db.lock.Locks(keys)
+	defer db.lock.UnLocks(keys)
 
 	for _, k := range keys {
-		if _, ok := engine.db.GetWithLock(k); ok {
+		if _, ok := db.data.GetWithLock(k); ok {
 			return parser.NewInteger(0)
 		}
 	}
 
 	for i := 0; i < len(keys); i++ {
-		engine.db.SetWithLock(keys[i], vals[i])
+		db.data.SetWithLock(keys[i], vals[i])
 	}
+	db.propagate(args)
 	return parser.NewInteger(1)
 }
 
-func ExecGet(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecGet(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 	key := string(args[1])
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.MakeNullBulkReply()
 	}
@@ -193,19 +231,20 @@ func ExecGet(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewBulkString(str)
 }
 
-func ExecIncr(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecIncr(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	// item不存在的情况，初始化为0然后自增
 	if !ok {
-		engine.db.SetWithLock(key, []byte("1"))
+		db.data.SetWithLock(key, []byte("1"))
+		db.propagate(args)
 		return parser.NewInteger(1)
 	}
 	item, ok := v.([]byte)
@@ -220,11 +259,12 @@ func ExecIncr(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is out of range")
 	}
 	n++
-	engine.db.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.data.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.propagate(args)
 	return parser.NewInteger(int64(n))
 }
 
-func ExecIncrby(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecIncrby(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -235,11 +275,12 @@ func ExecIncrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
-		engine.db.SetWithLock(key, []byte(strconv.Itoa(incr)))
+		db.data.SetWithLock(key, []byte(strconv.Itoa(incr)))
+		db.propagate(args)
 		return parser.NewInteger(int64(incr))
 	}
 	item, ok := v.([]byte)
@@ -251,11 +292,12 @@ func ExecIncrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer")
 	}
 	n += incr
-	engine.db.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.data.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.propagate(args)
 	return parser.NewInteger(int64(n))
 }
 
-func ExecIncrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecIncrbyfloat(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -266,13 +308,14 @@ func ExecIncrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not a valid float")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
 		f := []byte(strconv.FormatFloat(incr, 'f', -1, 64))
-		engine.db.SetWithLock(key, f)
-		return parser.NewBulkString(f)
+		db.data.SetWithLock(key, f)
+		db.propagate(args)
+		return parser.NewDouble(incr)
 	}
 	item, ok := v.([]byte)
 	if !ok {
@@ -284,23 +327,25 @@ func ExecIncrbyfloat(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	n += incr
 	f := []byte(strconv.FormatFloat(n, 'f', -1, 64))
-	engine.db.SetWithLock(key, f)
-	return parser.NewBulkString(f)
+	db.data.SetWithLock(key, f)
+	db.propagate(args)
+	return parser.NewDouble(n)
 }
 
-func ExecDecr(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecDecr(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	// item不存在的情况，初始化为0然后自增
 	if !ok {
-		engine.db.SetWithLock(key, []byte("-1"))
+		db.data.SetWithLock(key, []byte("-1"))
+		db.propagate(args)
 		return parser.NewInteger(-1)
 	}
 	item, ok := v.([]byte)
@@ -315,11 +360,12 @@ func ExecDecr(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is out of range")
 	}
 	n--
-	engine.db.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.data.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.propagate(args)
 	return parser.NewInteger(int64(n))
 }
 
-func ExecDecrby(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecDecrby(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -330,11 +376,12 @@ func ExecDecrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
-		engine.db.SetWithLock(key, []byte(strconv.Itoa(-decr)))
+		db.data.SetWithLock(key, []byte(strconv.Itoa(-decr)))
+		db.propagate(args)
 		return parser.NewInteger(int64(-decr))
 	}
 	item, ok := v.([]byte)
@@ -346,11 +393,12 @@ func ExecDecrby(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer")
 	}
 	n -= decr
-	engine.db.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.data.SetWithLock(key, []byte(strconv.Itoa(n)))
+	db.propagate(args)
 	return parser.NewInteger(int64(n))
 }
 
-func ExecMset(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecMset(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 3 || len(args)%2 != 1 {
 		return parser.NewError("Invalid command format")
 	}
@@ -360,16 +408,17 @@ func ExecMset(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(args[i]))
 		values = append(values, args[i+1])
 	}
-	engine.lock.Locks(keys)
-	defer engine.lock.UnLocks(keys)
+	db.lock.Locks(keys)
+	defer db.lock.UnLocks(keys)
 
 	for i := 0; i < len(keys); i++ {
-		engine.db.SetWithLock(keys[i], values[i])
+		db.data.SetWithLock(keys[i], values[i])
 	}
+	db.propagate(args)
 	return parser.MakeOKReply()
 }
 
-func ExecMget(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecMget(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 2 {
 		return parser.NewError("Invalid command format")
 	}
@@ -377,35 +426,29 @@ func ExecMget(engine *DBEngine, args [][]byte) parser.RespData {
 	for i := 1; i < len(args); i++ {
 		keys = append(keys, string(args[i]))
 	}
-	engine.lock.RLocks(keys)
-	defer engine.lock.RUnLocks(keys)
+	db.lock.RLocks(keys)
+	defer db.lock.RUnLocks(keys)
 
-	values := make([][]byte, 0, len(keys))
+	reply := parser.NewBulkArray()
+	reply.Grow(len(keys))
 	for i := 0; i < len(keys); i++ {
-		item, ok := engine.db.GetWithLock(keys[i])
-		if !ok {
-			values = append(values, nil)
-			continue
-		}
-		v, ok := item.([]byte)
-		if !ok {
-			values = append(values, nil)
-			continue
-		}
-		values = append(values, v)
+		item, _ := db.data.GetWithLock(keys[i])
+		// 不存在或者不是字符串时返回空值
+		v, _ := item.([]byte)
+		reply.Add(v)
 	}
-	return parser.NewArray(values)
+	return reply
 }
 
-func ExecStrlen(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecStrlen(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 {
 		return parser.NewError("Invalid command format")
 	}
 
 	key := string(args[1])
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -417,7 +460,7 @@ func ExecStrlen(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(len(str)))
 }
 
-func ExecAppend(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecAppend(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -425,11 +468,12 @@ func ExecAppend(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	value := args[2]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
-		engine.db.SetWithLock(key, value)
+		db.data.SetWithLock(key, value)
+		db.propagate(args)
 		return parser.NewInteger(int64(len(value)))
 	}
 	s, ok := v.([]byte)
@@ -437,11 +481,12 @@ func ExecAppend(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 	s = append(s, value...)
-	engine.db.SetWithLock(key, s)
+	db.data.SetWithLock(key, s)
+	db.propagate(args)
 	return parser.NewInteger(int64(len(s)))
 }
 
-func ExecGetset(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecGetset(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -449,22 +494,24 @@ func ExecGetset(engine *DBEngine, args [][]byte) parser.RespData {
 	key := string(args[1])
 	value := args[2]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	v, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
-		engine.db.SetWithLock(key, value)
+		db.data.SetWithLock(key, value)
+		db.propagate(args)
 		return parser.MakeNullBulkReply()
 	}
 	s, ok := v.([]byte)
 	if !ok {
 		return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	engine.db.SetWithLock(key, value)
+	db.data.SetWithLock(key, value)
+	db.propagate(args)
 	return parser.NewBulkString(s)
 }
 
-func ExecSetbit(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSetbit(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -483,9 +530,9 @@ func ExecSetbit(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Bit is not an integer or out of range")
 	}
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		bmLen := offset / 8
 		if offset%8 != 0 || bmLen == 0 {
@@ -493,7 +540,8 @@ func ExecSetbit(engine *DBEngine, args [][]byte) parser.RespData {
 		}
 		bm := make([]byte, bmLen)
 		SetBit(&bm, offset, bitvalue)
-		engine.db.SetWithLock(key, bm)
+		db.data.SetWithLock(key, bm)
+		db.propagate(args)
 		return parser.NewInteger(0)
 	}
 	bm, ok := item.([]byte)
@@ -502,11 +550,12 @@ func ExecSetbit(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	bit := GetBit(&bm, offset)
 	SetBit(&bm, offset, bitvalue)
-	engine.db.SetWithLock(key, bm)
+	db.data.SetWithLock(key, bm)
+	db.propagate(args)
 	return parser.NewInteger(int64(bit))
 }
 
-func ExecGetbit(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecGetbit(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 3 {
 		return parser.NewError("Invalid command format")
 	}
@@ -517,9 +566,9 @@ func ExecGetbit(engine *DBEngine, args [][]byte) parser.RespData {
 		parser.NewError("Bit offset is not an integer or out of range")
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -531,7 +580,7 @@ func ExecGetbit(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(bit))
 }
 
-func ExecBitcount(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecBitcount(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 2 && len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -550,9 +599,9 @@ func ExecBitcount(engine *DBEngine, args [][]byte) parser.RespData {
 		}
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
-	item, ok := engine.db.GetWithLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	item, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewInteger(0)
 	}
@@ -591,7 +640,7 @@ func ExecBitcount(engine *DBEngine, args [][]byte) parser.RespData {
 	return parser.NewInteger(int64(count))
 }
 
-func ExecBitop(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecBitop(db *DB, args [][]byte) parser.RespData {
 	if len(args) < 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -612,12 +661,12 @@ func ExecBitop(engine *DBEngine, args [][]byte) parser.RespData {
 		keys = append(keys, string(args[i]))
 	}
 
-	engine.lock.RWLocks(keys, []string{dstkey})
-	defer engine.lock.RWUnLocks(keys, []string{dstkey})
+	db.lock.RWLocks(keys, []string{dstkey})
+	defer db.lock.RWUnLocks(keys, []string{dstkey})
 
 	vals := make([][]byte, 0, len(keys))
 	for _, k := range keys {
-		item, ok := engine.db.GetWithLock(k)
+		item, ok := db.data.GetWithLock(k)
 		if !ok {
 			vals = append(vals, nil)
 			continue
@@ -629,12 +678,13 @@ func ExecBitop(engine *DBEngine, args [][]byte) parser.RespData {
 		vals = append(vals, bm)
 	}
 	res := BitOp(op, vals)
-	engine.db.SetWithLock(dstkey, res)
-	engine.CancelTTL(dstkey)
+	db.data.SetWithLock(dstkey, res)
+	db.CancelTTL(dstkey)
+	db.propagate(args)
 	return parser.NewInteger(int64(len(res)))
 }
 
-func ExecSetrange(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecSetrange(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -646,14 +696,15 @@ func ExecSetrange(engine *DBEngine, args [][]byte) parser.RespData {
 	}
 	value := args[3]
 
-	engine.lock.Lock(key)
-	defer engine.lock.UnLock(key)
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
 
-	v, ok := engine.db.GetWithLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
 		res := make([]byte, offset)
 		res = append(res, value...)
-		engine.db.SetWithLock(key, res)
+		db.data.SetWithLock(key, res)
+		db.propagate(args)
 		return parser.NewInteger(int64(len(res)))
 	}
 	s, ok := v.([]byte)
@@ -665,11 +716,12 @@ func ExecSetrange(engine *DBEngine, args [][]byte) parser.RespData {
 	buf.Write(value)
 	buf.Write(s[offset+len(value):])
 
-	engine.db.SetWithLock(key, buf.Bytes())
+	db.data.SetWithLock(key, buf.Bytes())
+	db.propagate(args)
 	return parser.NewInteger(int64(buf.Len()))
 }
 
-func ExecGetrange(engine *DBEngine, args [][]byte) parser.RespData {
+func ExecGetrange(db *DB, args [][]byte) parser.RespData {
 	if len(args) != 4 {
 		return parser.NewError("Invalid command format")
 	}
@@ -684,10 +736,10 @@ func ExecGetrange(engine *DBEngine, args [][]byte) parser.RespData {
 		return parser.NewError("Value is not an integer or out of range")
 	}
 
-	engine.lock.RLock(key)
-	defer engine.lock.RUnLock(key)
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
 
-	v, ok := engine.db.GetWithLock(key)
+	v, ok := db.data.GetWithLock(key)
 	if !ok {
 		return parser.NewBulkString(make([]byte, 0))
 	}
@@ -722,6 +774,7 @@ func ExecGetrange(engine *DBEngine, args [][]byte) parser.RespData {
 func init() {
 	RegisterCmd("set", ExecSet)
 	RegisterCmd("setex", ExecSetex)
+	RegisterCmd("psetex", ExecPsetex)
 	RegisterCmd("setnx", ExecSetnx)
 	RegisterCmd("getset", ExecGetset)
 	RegisterCmd("get", ExecGet)
@@ -2,6 +2,7 @@ package database
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 	"time"
 
@@ -64,7 +65,8 @@ func TestSetAndGet(t *testing.T) {
 		t.Fail()
 	}
 
-	time.Sleep(time.Second)
+	// 过期时间精确到毫秒，需要等到过期时间之后
+	time.Sleep(time.Second + 50*time.Millisecond)
 	args = LineToArgs("get cat")
 	reply = engine.ExecCmd(args)
 	data = reply.(*parser.BulkString).Arg
@@ -85,7 +87,7 @@ func TestSetAndGet(t *testing.T) {
 		t.Fail()
 	}
 
-	time.Sleep(time.Second)
+	time.Sleep(time.Second + 50*time.Millisecond)
 	args = LineToArgs("get cat")
 	reply = engine.ExecCmd(args)
 	data = reply.(*parser.BulkString).Arg
@@ -130,7 +132,7 @@ func TestMsetAndget(t *testing.T) {
 
 	args = LineToArgs("mget dog cat duck")
 	reply = engine.ExecCmd(args)
-	data := reply.(*parser.Array).Args
+	data := bulkArgs(reply)
 	if string(data[0]) != "bark" {
 		t.Fail()
 	}
@@ -149,7 +151,7 @@ func TestMsetAndget(t *testing.T) {
 
 	args = LineToArgs("mget duck")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = bulkArgs(reply)
 	if data[0] != nil {
 		t.Fail()
 	}
@@ -162,7 +164,7 @@ func TestMsetAndget(t *testing.T) {
 
 	args = LineToArgs("mget dog duck")
 	reply = engine.ExecCmd(args)
-	data = reply.(*parser.Array).Args
+	data = bulkArgs(reply)
 	if string(data[0]) != "wouw" {
 		t.Fail()
 	}
@@ -221,7 +223,7 @@ func TestIncrAndDecr(t *testing.T) {
 
 	args = LineToArgs("incrbyfloat f 1.11")
 	reply = engine.ExecCmd(args)
-	if string(reply.(*parser.BulkString).Arg) != "1.11" {
+	if reply.(*parser.Double).Arg != 1.11 {
 		t.Fail()
 	}
 
@@ -229,7 +231,7 @@ func TestIncrAndDecr(t *testing.T) {
 	engine.ExecCmd(args)
 	args = LineToArgs("incrbyfloat f2 -1e10")
 	reply = engine.ExecCmd(args)
-	if string(reply.(*parser.BulkString).Arg) != "0" {
+	if reply.(*parser.Double).Arg != 0 {
 		t.Fail()
 	}
 
@@ -534,3 +536,74 @@ func TestBitOps(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSetOptions(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	bulk := func(line string) string {
+		reply, ok := engine.ExecCmd(LineToArgs(line)).(*parser.BulkString)
+		if !ok {
+			t.Logf("%s should reply a bulk string", line)
+			t.FailNow()
+		}
+		if reply.Arg == nil {
+			return "(nil)"
+		}
+		return string(reply.Arg)
+	}
+
+	// GET返回旧值
+	if bulk("set k v1 get") != "(nil)" || bulk("set k v2 get") != "v1" || bulk("get k") != "v2" {
+		t.Fail()
+	}
+	if bulk("set k v3 nx get") != "v2" || bulk("get k") != "v2" {
+		t.Log("nx should not overwrite but still return the old value")
+		t.Fail()
+	}
+	if bulk("set nokey v xx get") != "(nil)" || integer("exists nokey") != 0 {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("rpush list a"))
+	if _, ok := engine.ExecCmd(LineToArgs("set list v get")).(*parser.Error); !ok || integer("llen list") != 1 {
+		t.Log("get on a non-string key should fail without overwriting")
+		t.Fail()
+	}
+
+	// KEEPTTL保留原来的过期时间，普通的set会清除过期时间
+	engine.ExecCmd(LineToArgs("set k v ex 100"))
+	engine.ExecCmd(LineToArgs("set k v2 keepttl"))
+	if integer("ttl k") != 100 || bulk("get k") != "v2" {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("set k v3"))
+	if integer("ttl k") != -1 {
+		t.Fail()
+	}
+
+	at := time.Now().Add(time.Hour).UnixMilli()
+	engine.ExecCmd(LineToArgs("set k v pxat " + strconv.FormatInt(at, 10)))
+	if integer("pexpiretime k") != at {
+		t.Fail()
+	}
+	engine.ExecCmd(LineToArgs("set k v exat " + strconv.FormatInt(at/1000, 10)))
+	if integer("pexpiretime k") != at/1000*1000 {
+		t.Fail()
+	}
+	// 已经过去的时间相当于立即过期
+	engine.ExecCmd(LineToArgs("set k v pxat 1"))
+	if integer("exists k") != 0 {
+		t.Fail()
+	}
+
+	for _, line := range []string{
+		"set k v nx xx", "set k v ex 10 px 100", "set k v keepttl ex 10", "set k v exat 10 pxat 10",
+		"set k v ex 0", "set k v px -1", "set k v ex abc", "set k v ex 9223372036854775807", "set k v ex",
+	} {
+		if _, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok {
+			t.Logf("%s should fail", line)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,901 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 返回key对应的有序集合，key不存在时返回nil
+func getZSet(db *DB, key string) (*ZSet, parser.RespData) {
+	item, ok := db.data.GetWithLock(key)
+	if !ok {
+		return nil, nil
+	}
+	zset, ok := item.(*ZSet)
+	if !ok {
+		return nil, parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return zset, nil
+}
+
+// 分数可以是inf，但不能是nan
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+func formatScore(score float64) []byte {
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+// min和max以"("开头时不包含端点
+func parseScoreRange(min, max []byte) (*scoreRange, parser.RespData) {
+	r := &scoreRange{}
+	var ok1, ok2 bool
+	r.min, r.minex, ok1 = parseScoreBound(min)
+	r.max, r.maxex, ok2 = parseScoreBound(max)
+	if !ok1 || !ok2 {
+		return nil, parser.NewError("ERR min or max is not a float")
+	}
+	return r, nil
+}
+
+func parseScoreBound(arg []byte) (float64, bool, bool) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, ok := parseScore(arg)
+	return score, exclusive, ok
+}
+
+// "-"和"+"表示无穷小和无穷大，其他端点必须以"["或"("开头
+func parseLexRange(min, max []byte) (*lexRange, parser.RespData) {
+	r := &lexRange{}
+	var ok1, ok2 bool
+	r.min, ok1 = parseLexBound(min)
+	r.max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return nil, parser.NewError("ERR min or max not valid string range item")
+	}
+	return r, nil
+}
+
+func parseLexBound(arg []byte) (lexBound, bool) {
+	if len(arg) == 0 {
+		return lexBound{}, false
+	}
+	switch arg[0] {
+	case '-', '+':
+		if len(arg) != 1 {
+			return lexBound{}, false
+		}
+		if arg[0] == '-' {
+			return lexBound{inf: -1}, true
+		}
+		return lexBound{inf: 1}, true
+	case '(':
+		return lexBound{value: string(arg[1:]), exclusive: true}, true
+	case '[':
+		return lexBound{value: string(arg[1:])}, true
+	}
+	return lexBound{}, false
+}
+
+// 把可以为负数的下标转换到[0, length)之间，区间为空时返回false
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
+
+// 成员和分数交替排列
+func zsetEntryArgs(entries []ZSetEntry, withScores bool) []parser.RespData {
+	args := make([]parser.RespData, 0, len(entries)*2)
+	for _, e := range entries {
+		args = append(args, parser.NewBulkString([]byte(e.Member)))
+		if withScores {
+			args = append(args, parser.NewDouble(e.Score))
+		}
+	}
+	return args
+}
+
+// 带分数时RESP3下每个成员和分数组成一个二元组
+func makeZSetReply(entries []ZSetEntry, withScores bool) parser.RespData {
+	args := zsetEntryArgs(entries, withScores)
+	if withScores {
+		return parser.NewPairs(args)
+	}
+	return parser.NewMultiBulk(args)
+}
+
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ExecZadd(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	var flags zaddFlags
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		case "ch":
+			flags.ch = true
+		case "incr":
+			flags.incr = true
+		default:
+			break options
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return parser.NewError("Invalid command format")
+	}
+	if flags.nx && flags.xx {
+		return parser.NewError("ERR XX and NX options at the same time are not compatible")
+	}
+	if flags.gt && flags.lt || flags.nx && (flags.gt || flags.lt) {
+		return parser.NewError("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags.incr && len(pairs) > 2 {
+		return parser.NewError("ERR INCR option supports a single increment-element pair")
+	}
+
+	// 先检查所有分数，出错时不做任何修改
+	entries := make([]ZSetEntry, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			return parser.NewError("Value is not a valid float")
+		}
+		entries = append(entries, ZSetEntry{Member: string(pairs[j+1]), Score: score})
+	}
+	return zaddGeneric(db, args, string(args[1]), flags, entries)
+}
+
+func zaddGeneric(db *DB, args [][]byte, key string, flags zaddFlags, entries []ZSetEntry) parser.RespData {
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	created := zset == nil
+	if created {
+		zset = NewZSet()
+	}
+
+	added, updated := 0, 0
+	var result float64
+	aborted := false
+	for _, e := range entries {
+		cur, exist := zset.Score(e.Member)
+		if !exist {
+			if flags.xx {
+				aborted = true
+				continue
+			}
+			zset.Add(e.Member, e.Score)
+			added++
+			result = e.Score
+			continue
+		}
+		if flags.nx {
+			aborted = true
+			continue
+		}
+		score := e.Score
+		if flags.incr {
+			score += cur
+			if math.IsNaN(score) {
+				return parser.NewError("ERR resulting score is not a number (NaN)")
+			}
+		}
+		// GT和LT只限制更新已有的成员
+		if flags.gt && score <= cur || flags.lt && score >= cur {
+			aborted = true
+			continue
+		}
+		if score != cur {
+			zset.Add(e.Member, score)
+			updated++
+		}
+		result = score
+	}
+
+	if created && zset.Len() > 0 {
+		db.data.SetWithLock(key, zset)
+	}
+	if added+updated > 0 {
+		db.propagate(args)
+	}
+	if flags.incr {
+		if aborted {
+			return parser.MakeNullBulkReply()
+		}
+		return parser.NewDouble(result)
+	}
+	if flags.ch {
+		return parser.NewInteger(int64(added + updated))
+	}
+	return parser.NewInteger(int64(added))
+}
+
+// zincrby key increment member
+func ExecZincrby(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 4 {
+		return parser.NewError("Invalid command format")
+	}
+	incr, ok := parseScore(args[2])
+	if !ok {
+		return parser.NewError("Value is not a valid float")
+	}
+	entries := []ZSetEntry{{Member: string(args[3]), Score: incr}}
+	return zaddGeneric(db, args, string(args[1]), zaddFlags{incr: true}, entries)
+}
+
+func ExecZrem(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return parser.NewInteger(0)
+	}
+
+	count := 0
+	for _, m := range args[2:] {
+		if zset.Remove(string(m)) {
+			count++
+		}
+	}
+	if zset.Len() == 0 {
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	if count > 0 {
+		db.propagate(args)
+	}
+	return parser.NewInteger(int64(count))
+}
+
+func ExecZcard(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 2 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return parser.NewInteger(0)
+	}
+	return parser.NewInteger(int64(zset.Len()))
+}
+
+func ExecZscore(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return parser.MakeNullBulkReply()
+	}
+	score, ok := zset.Score(string(args[2]))
+	if !ok {
+		return parser.MakeNullBulkReply()
+	}
+	return parser.NewDouble(score)
+}
+
+func ExecZmscore(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	scores := make([]parser.RespData, 0, len(args)-2)
+	for _, m := range args[2:] {
+		if zset == nil {
+			scores = append(scores, parser.MakeNullBulkReply())
+			continue
+		}
+		if score, ok := zset.Score(string(m)); ok {
+			scores = append(scores, parser.NewDouble(score))
+		} else {
+			scores = append(scores, parser.MakeNullBulkReply())
+		}
+	}
+	return parser.NewMultiBulk(scores)
+}
+
+// zrank key member [WITHSCORE]
+func zrankGeneric(db *DB, args [][]byte, reverse bool) parser.RespData {
+	if len(args) != 3 && len(args) != 4 {
+		return parser.NewError("Invalid command format")
+	}
+	withScore := len(args) == 4
+	if withScore && strings.ToLower(string(args[3])) != "withscore" {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	member := string(args[2])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	var rank int
+	ok := false
+	if zset != nil {
+		rank, ok = zset.Rank(member, reverse)
+	}
+	if !ok {
+		if withScore {
+			return parser.MakeNullArrayReply()
+		}
+		return parser.MakeNullBulkReply()
+	}
+	if withScore {
+		score, _ := zset.Score(member)
+		return parser.NewMultiBulk([]parser.RespData{parser.NewInteger(int64(rank)), parser.NewDouble(score)})
+	}
+	return parser.NewInteger(int64(rank))
+}
+
+func ExecZrank(db *DB, args [][]byte) parser.RespData {
+	return zrankGeneric(db, args, false)
+}
+
+func ExecZrevrank(db *DB, args [][]byte) parser.RespData {
+	return zrankGeneric(db, args, true)
+}
+
+type zrangeOptions struct {
+	by         string // 为空时按排名，否则为"score"或"lex"
+	reverse    bool
+	withScores bool
+	limit      bool
+	offset     int
+	count      int // 小于0表示不限制数量
+}
+
+// 解析zrange的选项，byOption为false时不接受BYSCORE、BYLEX和REV
+func parseZrangeOptions(args [][]byte, opts *zrangeOptions, byOption bool) parser.RespData {
+	opts.count = -1
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "withscores":
+			opts.withScores = true
+		case arg == "limit" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(string(args[i+1]))
+			count, err2 := strconv.Atoi(string(args[i+2]))
+			if err1 != nil || err2 != nil {
+				return parser.NewError("Value is not an integer or out of range")
+			}
+			opts.limit, opts.offset, opts.count = true, offset, count
+			i += 2
+		case byOption && (arg == "byscore" || arg == "bylex"):
+			opts.by = arg[2:]
+		case byOption && arg == "rev":
+			opts.reverse = true
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+	if opts.limit && opts.by == "" {
+		return parser.NewError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if opts.withScores && opts.by == "lex" {
+		return parser.NewError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// 按opts取出区间内的成员，REV时start和stop分别是区间的上界和下界
+func zrangeEntries(zset *ZSet, start, stop []byte, opts *zrangeOptions) ([]ZSetEntry, parser.RespData) {
+	if opts.by != "" && opts.reverse {
+		start, stop = stop, start
+	}
+	switch opts.by {
+	case "score":
+		r, errReply := parseScoreRange(start, stop)
+		if errReply != nil || zset == nil || opts.offset < 0 {
+			return nil, errReply
+		}
+		return zset.RangeByScore(r, opts.offset, opts.count, opts.reverse), nil
+	case "lex":
+		r, errReply := parseLexRange(start, stop)
+		if errReply != nil || zset == nil || opts.offset < 0 {
+			return nil, errReply
+		}
+		return zset.RangeByLex(r, opts.offset, opts.count, opts.reverse), nil
+	}
+
+	from, err1 := strconv.Atoi(string(start))
+	to, err2 := strconv.Atoi(string(stop))
+	if err1 != nil || err2 != nil {
+		return nil, parser.NewError("Value is not an integer or out of range")
+	}
+	if zset == nil {
+		return nil, nil
+	}
+	from, to, ok := normalizeRange(from, to, zset.Len())
+	if !ok {
+		return nil, nil
+	}
+	return zset.RangeByRank(from, to, opts.reverse), nil
+}
+
+func zrangeGeneric(db *DB, args [][]byte, opts *zrangeOptions) parser.RespData {
+	key := string(args[1])
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	entries, errReply := zrangeEntries(zset, args[2], args[3], opts)
+	if errReply != nil {
+		return errReply
+	}
+	return makeZSetReply(entries, opts.withScores)
+}
+
+// zrange key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func ExecZrange(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	opts := &zrangeOptions{}
+	if errReply := parseZrangeOptions(args[4:], opts, true); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, args, opts)
+}
+
+// zrangebyscore key min max [WITHSCORES] [LIMIT offset count]
+func ExecZrangebyscore(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	opts := &zrangeOptions{by: "score"}
+	if errReply := parseZrangeOptions(args[4:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zrangeGeneric(db, args, opts)
+}
+
+func ExecZcount(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	r, errReply := parseScoreRange(args[2], args[3])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return parser.NewInteger(0)
+	}
+	return parser.NewInteger(int64(zset.CountByScore(r)))
+}
+
+func ExecZlexcount(db *DB, args [][]byte) parser.RespData {
+	if len(args) != 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	r, errReply := parseLexRange(args[2], args[3])
+	if errReply != nil {
+		return errReply
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return parser.NewInteger(0)
+	}
+	return parser.NewInteger(int64(zset.CountByLex(r)))
+}
+
+// zremrangebyrank、zremrangebyscore和zremrangebylex，by和zrange的选项相同
+func zremrangeGeneric(db *DB, args [][]byte, by string) parser.RespData {
+	if len(args) != 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	entries, errReply := zrangeEntries(zset, args[2], args[3], &zrangeOptions{by: by, count: -1})
+	if errReply != nil {
+		return errReply
+	}
+	if len(entries) == 0 {
+		return parser.NewInteger(0)
+	}
+
+	for _, e := range entries {
+		zset.Remove(e.Member)
+	}
+	if zset.Len() == 0 {
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	db.propagate(args)
+	return parser.NewInteger(int64(len(entries)))
+}
+
+func ExecZremrangebyrank(db *DB, args [][]byte) parser.RespData {
+	return zremrangeGeneric(db, args, "")
+}
+
+func ExecZremrangebyscore(db *DB, args [][]byte) parser.RespData {
+	return zremrangeGeneric(db, args, "score")
+}
+
+func ExecZremrangebylex(db *DB, args [][]byte) parser.RespData {
+	return zremrangeGeneric(db, args, "lex")
+}
+
+// zpopmin key [count]，reverse为true时从分数最大的一端弹出
+func zpopGeneric(db *DB, args [][]byte, reverse bool) parser.RespData {
+	if len(args) != 2 && len(args) != 3 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	count := 1
+	if len(args) == 3 {
+		var err error
+		count, err = strconv.Atoi(string(args[2]))
+		if err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+		if count < 0 {
+			return parser.NewError("ERR value is out of range, must be positive")
+		}
+	}
+
+	db.lock.Lock(key)
+	defer db.lock.UnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil || count == 0 {
+		return parser.NewMultiBulk(nil)
+	}
+
+	entries := zset.RangeByRank(0, count-1, reverse)
+	// 和spop一样记录为zrem，保证重放结果一致
+	cmd := [][]byte{[]byte("zrem"), args[1]}
+	for _, e := range entries {
+		zset.Remove(e.Member)
+		cmd = append(cmd, []byte(e.Member))
+	}
+	if zset.Len() == 0 {
+		db.data.DelWithLock(key)
+		db.CancelTTL(key)
+	}
+	db.propagate(cmd)
+	// 和redis一样，没有指定count时不使用二元组
+	if len(args) == 2 {
+		return parser.NewMultiBulk(zsetEntryArgs(entries, true))
+	}
+	return makeZSetReply(entries, true)
+}
+
+func ExecZpopmin(db *DB, args [][]byte) parser.RespData {
+	return zpopGeneric(db, args, false)
+}
+
+func ExecZpopmax(db *DB, args [][]byte) parser.RespData {
+	return zpopGeneric(db, args, true)
+}
+
+// inf乘以0以及inf和-inf相加的结果按0处理，和redis一致
+func zsetWeighted(score, weight float64) float64 {
+	v := score * weight
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+func zsetAggregate(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	}
+	v := a + b
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// zunionstore、zinterstore和zdiffstore：
+// destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]，zdiffstore不支持选项
+func zsetOpStore(db *DB, args [][]byte, op string) parser.RespData {
+	if len(args) < 4 {
+		return parser.NewError("Invalid command format")
+	}
+	dstkey := string(args[1])
+	numkeys, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return parser.NewError("Value is not an integer or out of range")
+	}
+	if numkeys < 1 {
+		return parser.NewError(fmt.Sprintf("ERR at least 1 input key is needed for '%sstore' command", op))
+	}
+	if numkeys > len(args)-3 {
+		return parser.NewError("Invalid command format")
+	}
+	keys := make([]string, 0, numkeys)
+	for _, k := range args[3 : 3+numkeys] {
+		keys = append(keys, string(k))
+	}
+
+	weights := make([]float64, numkeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "sum"
+	for i := 3 + numkeys; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case op != "zdiff" && arg == "weights" && i+numkeys < len(args):
+			for j := range weights {
+				i++
+				w, ok := parseScore(args[i])
+				if !ok {
+					return parser.NewError("ERR weight value is not a float")
+				}
+				weights[j] = w
+			}
+		case op != "zdiff" && arg == "aggregate" && i+1 < len(args):
+			i++
+			aggregate = strings.ToLower(string(args[i]))
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				return parser.NewError("Invalid command format")
+			}
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	db.lock.RWLocks(keys, []string{dstkey})
+	defer db.lock.RWUnLocks(keys, []string{dstkey})
+
+	// 和redis一样，集合也可以作为输入，成员的分数为1
+	srcs := make([]map[string]float64, 0, numkeys)
+	for _, k := range keys {
+		item, ok := db.data.GetWithLock(k)
+		if !ok {
+			srcs = append(srcs, nil)
+			continue
+		}
+		switch v := item.(type) {
+		case *ZSet:
+			srcs = append(srcs, v.dict)
+		case *Set:
+			src := make(map[string]float64, v.Len())
+			v.ForEach(func(m string) bool {
+				src[m] = 1
+				return true
+			})
+			srcs = append(srcs, src)
+		default:
+			return parser.NewError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+
+	result := make(map[string]float64)
+	switch op {
+	case "zunion":
+		for i, src := range srcs {
+			for m, score := range src {
+				score = zsetWeighted(score, weights[i])
+				if cur, ok := result[m]; ok {
+					score = zsetAggregate(aggregate, cur, score)
+				}
+				result[m] = score
+			}
+		}
+	case "zinter":
+		for m, score := range srcs[0] {
+			score = zsetWeighted(score, weights[0])
+			inter := true
+			for i := 1; i < len(srcs) && inter; i++ {
+				other, ok := srcs[i][m]
+				if ok {
+					score = zsetAggregate(aggregate, score, zsetWeighted(other, weights[i]))
+				}
+				inter = ok
+			}
+			if inter {
+				result[m] = score
+			}
+		}
+	case "zdiff":
+		for m, score := range srcs[0] {
+			diff := true
+			for i := 1; i < len(srcs) && diff; i++ {
+				_, ok := srcs[i][m]
+				diff = !ok
+			}
+			if diff {
+				result[m] = score
+			}
+		}
+	}
+
+	db.CancelTTL(dstkey)
+	if len(result) == 0 {
+		db.data.DelWithLock(dstkey)
+	} else {
+		zset := NewZSet()
+		for m, score := range result {
+			zset.Add(m, score)
+		}
+		db.data.SetWithLock(dstkey, zset)
+	}
+	db.propagate(args)
+	return parser.NewInteger(int64(len(result)))
+}
+
+func ExecZunionstore(db *DB, args [][]byte) parser.RespData {
+	return zsetOpStore(db, args, "zunion")
+}
+
+func ExecZinterstore(db *DB, args [][]byte) parser.RespData {
+	return zsetOpStore(db, args, "zinter")
+}
+
+func ExecZdiffstore(db *DB, args [][]byte) parser.RespData {
+	return zsetOpStore(db, args, "zdiff")
+}
+
+// zrandmember key [count [WITHSCORES]]
+func ExecZrandmember(db *DB, args [][]byte) parser.RespData {
+	if len(args) < 2 || len(args) > 4 {
+		return parser.NewError("Invalid command format")
+	}
+	key := string(args[1])
+	count := 1
+	if len(args) >= 3 {
+		n, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil {
+			return parser.NewError("Value is not an integer or out of range")
+		}
+		// 和redis一样限制count的范围，取反和乘2都不会溢出
+		if n < -math.MaxInt64/2 || n > math.MaxInt64/2 {
+			return parser.NewError("ERR value is out of range")
+		}
+		count = int(n)
+	}
+	withScores := len(args) == 4
+	if withScores && strings.ToLower(string(args[3])) != "withscores" {
+		return parser.NewError("Invalid command format")
+	}
+
+	db.lock.RLock(key)
+	defer db.lock.RUnLock(key)
+	zset, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 2 {
+		if zset == nil {
+			return parser.MakeNullBulkReply()
+		}
+		return parser.NewBulkString([]byte(zset.RandMem(1)[0].Member))
+	}
+	if zset == nil {
+		return parser.NewMultiBulk(nil)
+	}
+	return makeZSetReply(zset.RandMem(count), withScores)
+}
+
+func init() {
+	RegisterCmd("zadd", ExecZadd)
+	RegisterCmd("zincrby", ExecZincrby)
+	RegisterCmd("zrem", ExecZrem)
+	RegisterCmd("zcard", ExecZcard)
+	RegisterCmd("zscore", ExecZscore)
+	RegisterCmd("zmscore", ExecZmscore)
+	RegisterCmd("zrank", ExecZrank)
+	RegisterCmd("zrevrank", ExecZrevrank)
+	RegisterCmd("zrange", ExecZrange)
+	RegisterCmd("zrangebyscore", ExecZrangebyscore)
+	RegisterCmd("zcount", ExecZcount)
+	RegisterCmd("zlexcount", ExecZlexcount)
+	RegisterCmd("zremrangebyrank", ExecZremrangebyrank)
+	RegisterCmd("zremrangebyscore", ExecZremrangebyscore)
+	RegisterCmd("zremrangebylex", ExecZremrangebylex)
+	RegisterCmd("zpopmin", ExecZpopmin)
+	RegisterCmd("zpopmax", ExecZpopmax)
+	RegisterCmd("zunionstore", ExecZunionstore)
+	RegisterCmd("zinterstore", ExecZinterstore)
+	RegisterCmd("zdiffstore", ExecZdiffstore)
+	RegisterCmd("zrandmember", ExecZrandmember)
+}
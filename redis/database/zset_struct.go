@@ -0,0 +1,478 @@
+package database
+
+import (
+	"math/rand"
+)
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// ZSet 是有序集合，跳表按(score, member)排序，map用于按成员查找分数
+type ZSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+}
+
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int // 到forward之间跨过的节点数，用于计算排名
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// 节点n是否排在(score, member)之前
+func nodeLess(n *skiplistNode, score float64, member string) bool {
+	return n.score < score || n.score == score && n.member < member
+}
+
+// 节点n是否排在(score, member)之后
+func nodeGreater(n *skiplistNode, score float64, member string) bool {
+	return n.score > score || n.score == score && n.member > member
+}
+
+// 插入一个新节点，调用者需要保证成员不存在
+func (zsl *skiplist) insert(score float64, member string) *skiplistNode {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && nodeLess(x.level[i].forward, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	// 更高的层跨过了新节点
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *skiplist) deleteNode(x *skiplistNode, update []*skiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *skiplist) delete(score float64, member string) bool {
+	update := make([]*skiplistNode, skiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && nodeLess(x.level[i].forward, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, update)
+	return true
+}
+
+// 返回从1开始的排名，成员不存在时返回0
+func (zsl *skiplist) getRank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !nodeGreater(x.level[i].forward, score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// rank从1开始
+func (zsl *skiplist) getByRank(rank int) *skiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// 第一个满足inRange的节点，节点按顺序排列时inRange的结果是先false后true
+func (zsl *skiplist) first(inRange func(n *skiplistNode) bool) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !inRange(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// 最后一个满足inRange的节点，inRange的结果是先true后false
+func (zsl *skiplist) last(inRange func(n *skiplistNode) bool) *skiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && inRange(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header {
+		return nil
+	}
+	return x
+}
+
+func NewZSet() *ZSet {
+	return &ZSet{
+		dict: make(map[string]float64),
+		zsl:  newSkiplist(),
+	}
+}
+
+func (z *ZSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Add 添加成员或者更新分数，成员是新添加的时返回true
+func (z *ZSet) Add(member string, score float64) bool {
+	old, ok := z.dict[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		z.zsl.delete(old, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return !ok
+}
+
+func (z *ZSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+// Rank 返回从0开始的排名，reverse为true时按分数从大到小排名
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.getRank(score, member)
+	if reverse {
+		return z.zsl.length - rank, true
+	}
+	return rank - 1, true
+}
+
+// RangeByRank 返回排名在[start, stop]之间的成员，下标从0开始，stop可以超出范围
+func (z *ZSet) RangeByRank(start, stop int, reverse bool) []ZSetEntry {
+	if stop >= z.zsl.length {
+		stop = z.zsl.length - 1
+	}
+	if start > stop || start >= z.zsl.length {
+		return nil
+	}
+	entries := make([]ZSetEntry, 0, stop-start+1)
+	var x *skiplistNode
+	if reverse {
+		x = z.zsl.getByRank(z.zsl.length - start)
+	} else {
+		x = z.zsl.getByRank(start + 1)
+	}
+	for i := start; i <= stop && x != nil; i++ {
+		entries = append(entries, ZSetEntry{Member: x.member, Score: x.score})
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// 分数区间，min和max可以不包含在内
+type scoreRange struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *scoreRange) gteMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r *scoreRange) lteMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+func (r *scoreRange) empty() bool {
+	return r.min > r.max || r.min == r.max && (r.minex || r.maxex)
+}
+
+// 字典序区间的端点，inf为-1和1分别表示"-"和"+"
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int
+}
+
+type lexRange struct {
+	min, max lexBound
+}
+
+func (r *lexRange) gteMin(member string) bool {
+	switch {
+	case r.min.inf < 0:
+		return true
+	case r.min.inf > 0:
+		return false
+	case r.min.exclusive:
+		return member > r.min.value
+	}
+	return member >= r.min.value
+}
+
+func (r *lexRange) lteMax(member string) bool {
+	switch {
+	case r.max.inf > 0:
+		return true
+	case r.max.inf < 0:
+		return false
+	case r.max.exclusive:
+		return member < r.max.value
+	}
+	return member <= r.max.value
+}
+
+func (r *lexRange) empty() bool {
+	if r.min.inf > 0 || r.max.inf < 0 {
+		return true
+	}
+	if r.min.inf < 0 || r.max.inf > 0 {
+		return false
+	}
+	return r.min.value > r.max.value || r.min.value == r.max.value && (r.min.exclusive || r.max.exclusive)
+}
+
+// 区间内的成员是跳表中连续的一段，gteMin和lteMax分别描述区间的两端
+func (z *ZSet) rangeGeneric(gteMin, lteMax func(n *skiplistNode) bool, offset, count int, reverse bool) []ZSetEntry {
+	var x *skiplistNode
+	if reverse {
+		x = z.zsl.last(lteMax)
+	} else {
+		x = z.zsl.first(gteMin)
+	}
+	var entries []ZSetEntry
+	for ; x != nil && count != 0; offset-- {
+		if !gteMin(x) || !lteMax(x) {
+			break
+		}
+		if offset <= 0 {
+			entries = append(entries, ZSetEntry{Member: x.member, Score: x.score})
+			count--
+		}
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return entries
+}
+
+// RangeByScore 返回分数在区间内的成员，跳过前offset个，count小于0表示不限制数量
+func (z *ZSet) RangeByScore(r *scoreRange, offset, count int, reverse bool) []ZSetEntry {
+	if r.empty() {
+		return nil
+	}
+	return z.rangeGeneric(
+		func(n *skiplistNode) bool { return r.gteMin(n.score) },
+		func(n *skiplistNode) bool { return r.lteMax(n.score) },
+		offset, count, reverse)
+}
+
+// RangeByLex 只在所有成员分数相同时有意义，和redis一致
+func (z *ZSet) RangeByLex(r *lexRange, offset, count int, reverse bool) []ZSetEntry {
+	if r.empty() {
+		return nil
+	}
+	return z.rangeGeneric(
+		func(n *skiplistNode) bool { return r.gteMin(n.member) },
+		func(n *skiplistNode) bool { return r.lteMax(n.member) },
+		offset, count, reverse)
+}
+
+// 区间内成员的数量，通过首尾节点的排名计算
+func (z *ZSet) countGeneric(gteMin, lteMax func(n *skiplistNode) bool) int {
+	first := z.zsl.first(gteMin)
+	if first == nil || !lteMax(first) {
+		return 0
+	}
+	last := z.zsl.last(lteMax)
+	return z.zsl.getRank(last.score, last.member) - z.zsl.getRank(first.score, first.member) + 1
+}
+
+func (z *ZSet) CountByScore(r *scoreRange) int {
+	if r.empty() {
+		return 0
+	}
+	return z.countGeneric(
+		func(n *skiplistNode) bool { return r.gteMin(n.score) },
+		func(n *skiplistNode) bool { return r.lteMax(n.score) })
+}
+
+func (z *ZSet) CountByLex(r *lexRange) int {
+	if r.empty() {
+		return 0
+	}
+	return z.countGeneric(
+		func(n *skiplistNode) bool { return r.gteMin(n.member) },
+		func(n *skiplistNode) bool { return r.lteMax(n.member) })
+}
+
+// ForEach 按分数从小到大遍历，f返回false时停止
+func (z *ZSet) ForEach(f func(member string, score float64) bool) {
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		if !f(x.member, x.score) {
+			break
+		}
+	}
+}
+
+// count为负数时结果的预分配上限
+const maxRandPrealloc = 1024
+
+func randPrealloc(count int) int {
+	if count > maxRandPrealloc {
+		return maxRandPrealloc
+	}
+	return count
+}
+
+// RandMem 和Set.RandMem相同，count为负数时成员可以重复
+func (z *ZSet) RandMem(count int) []ZSetEntry {
+	if count == 0 || z.Len() == 0 {
+		return nil
+	}
+	if count < 0 {
+		// 允许重复时count不受集合大小限制，预分配的空间需要有上限
+		res := make([]ZSetEntry, 0, randPrealloc(-count))
+		for i := 0; i < -count; i++ {
+			x := z.zsl.getByRank(rand.Intn(z.zsl.length) + 1)
+			res = append(res, ZSetEntry{Member: x.member, Score: x.score})
+		}
+		return res
+	}
+	if count >= z.Len() {
+		return z.RangeByRank(0, z.Len()-1, false)
+	}
+	// 随机打乱排名，取前count个
+	ranks := rand.Perm(z.Len())[:count]
+	res := make([]ZSetEntry, 0, count)
+	for _, rank := range ranks {
+		x := z.zsl.getByRank(rank + 1)
+		res = append(res, ZSetEntry{Member: x.member, Score: x.score})
+	}
+	return res
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// 随机增删改之后，跳表的顺序和排名应该和排序的结果一致
+func TestZSetSkiplist(t *testing.T) {
+	zset := NewZSet()
+	scores := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rand.Intn(1000))
+		if rand.Intn(4) == 0 {
+			zset.Remove(member)
+			delete(scores, member)
+			continue
+		}
+		score := float64(rand.Intn(100))
+		zset.Add(member, score)
+		scores[member] = score
+	}
+
+	expected := make([]ZSetEntry, 0, len(scores))
+	for m, s := range scores {
+		expected = append(expected, ZSetEntry{Member: m, Score: s})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		a, b := expected[i], expected[j]
+		return a.Score < b.Score || a.Score == b.Score && a.Member < b.Member
+	})
+
+	if zset.Len() != len(expected) || zset.zsl.length != len(expected) {
+		t.Logf("wrong length %d, want %d", zset.Len(), len(expected))
+		t.FailNow()
+	}
+	entries := zset.RangeByRank(0, zset.Len()-1, false)
+	for i, e := range expected {
+		if entries[i] != e {
+			t.Logf("wrong entry at %d: %v, want %v", i, entries[i], e)
+			t.FailNow()
+		}
+		if rank, _ := zset.Rank(e.Member, false); rank != i {
+			t.Logf("wrong rank of %s: %d, want %d", e.Member, rank, i)
+			t.Fail()
+		}
+		if rank, _ := zset.Rank(e.Member, true); rank != len(expected)-1-i {
+			t.Fail()
+		}
+	}
+	reversed := zset.RangeByRank(0, 9, true)
+	for i, e := range reversed {
+		if e != expected[len(expected)-1-i] {
+			t.Fail()
+		}
+	}
+
+	// 分数区间[10, 20)
+	r := &scoreRange{min: 10, max: 20, maxex: true}
+	count := 0
+	for _, e := range expected {
+		if e.Score >= 10 && e.Score < 20 {
+			count++
+		}
+	}
+	if zset.CountByScore(r) != count || len(zset.RangeByScore(r, 0, -1, false)) != count {
+		t.Fail()
+	}
+	if len(zset.RangeByScore(r, 2, 3, true)) != 3 {
+		t.Fail()
+	}
+}
+
+func TestZSetLexRange(t *testing.T) {
+	zset := NewZSet()
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		zset.Add(m, 0)
+	}
+	members := func(entries []ZSetEntry) string {
+		s := ""
+		for _, e := range entries {
+			s += e.Member
+		}
+		return s
+	}
+	cases := []struct {
+		r       lexRange
+		reverse bool
+		want    string
+	}{
+		{lexRange{lexBound{inf: -1}, lexBound{inf: 1}}, false, "abcde"},
+		{lexRange{lexBound{value: "b"}, lexBound{value: "d", exclusive: true}}, false, "bc"},
+		{lexRange{lexBound{value: "b", exclusive: true}, lexBound{inf: 1}}, true, "edc"},
+		{lexRange{lexBound{value: "c"}, lexBound{value: "b"}}, false, ""},
+	}
+	for _, c := range cases {
+		if got := members(zset.RangeByLex(&c.r, 0, -1, c.reverse)); got != c.want {
+			t.Logf("want %s, got %s", c.want, got)
+			t.Fail()
+		}
+		if !c.reverse && zset.CountByLex(&c.r) != len(c.want) {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	. "github.com/HK40404/simpredis/utils/client"
+)
+
+// 把数组回复拼成用空格分隔的字符串，分数在RESP2下也是BulkString
+func zsetReply(reply parser.RespData) string {
+	return string(bytes.Join(bulkArgs(reply), []byte(" ")))
+}
+
+func TestZadd(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	if integer("zadd z 1 a 2 b 3 c") != 3 || integer("zadd z 5 a 4 d") != 1 || integer("zcard z") != 4 {
+		t.Fail()
+	}
+	if integer("zadd z ch 6 a 4 d 7 e") != 2 {
+		t.Log("CH should count changed members")
+		t.Fail()
+	}
+	if integer("zadd z nx 0 a 8 f") != 1 || integer("zadd z xx 0 a 9 g") != 0 ||
+		engine.ExecCmd(LineToArgs("zscore z a")).(*parser.Double).Arg != 0 {
+		t.Fail()
+	}
+	// GT和LT只更新分数变大或变小的成员，新成员仍然会添加
+	if integer("zadd z gt ch 10 b -1 c 1 h") != 2 {
+		t.Fail()
+	}
+	// 不存在的成员为nil
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zmscore z b c h none"))); got != "10 3 1 " {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	if reply := engine.ExecCmd(LineToArgs("zadd z incr 2.5 a")).(*parser.Double); reply.Arg != 2.5 {
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("zadd z nx incr 1 a")).(*parser.BulkString); reply.Arg != nil {
+		t.Log("aborted INCR should return nil")
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("zincrby z -1.5 new")).(*parser.Double); reply.Arg != -1.5 {
+		t.Fail()
+	}
+
+	errors := map[string]string{
+		"zadd z nx xx 1 a":    "ERR XX and NX options at the same time are not compatible",
+		"zadd z gt lt 1 a":    "ERR GT, LT, and/or NX options at the same time are not compatible",
+		"zadd z incr 1 a 2 b": "ERR INCR option supports a single increment-element pair",
+		"zadd z 1 a nan b":    "Value is not a valid float",
+		"zadd z 1 a 2":        "Invalid command format",
+	}
+	for line, msg := range errors {
+		if reply, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok || reply.Arg != msg {
+			t.Logf("%s: want %s", line, msg)
+			t.Fail()
+		}
+	}
+	engine.ExecCmd(LineToArgs("zadd inf inf a"))
+	if reply, ok := engine.ExecCmd(LineToArgs("zincrby inf -inf a")).(*parser.Error); !ok || reply.Arg != "ERR resulting score is not a number (NaN)" {
+		t.Fail()
+	}
+
+	engine.ExecCmd(LineToArgs("set str v"))
+	if reply, ok := engine.ExecCmd(LineToArgs("zadd str 1 a")).(*parser.Error); !ok || reply.Arg[:9] != "WRONGTYPE" {
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("type z")).(*parser.String); reply.Arg != "zset" {
+		t.Fail()
+	}
+	if integer("zrem z a b none") != 2 || integer("zrem z c d e f h new") != 6 || integer("exists z") != 0 {
+		t.Log("empty zset should be removed")
+		t.Fail()
+	}
+}
+
+func TestZrange(t *testing.T) {
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("zadd z 1 a 2 b 3 c 4 d 5 e"))
+	cases := map[string]string{
+		"zrange z 0 -1":                              "a b c d e",
+		"zrange z -2 10 withscores":                  "d 4 e 5",
+		"zrange z 0 1 rev":                           "e d",
+		"zrange z 3 1":                               "",
+		"zrange z (1 3 byscore":                      "b c",
+		"zrange z +inf -inf byscore rev limit 1 2":   "d c",
+		"zrange z -inf +inf byscore limit 1 -1":      "b c d e",
+		"zrangebyscore z 2 (4 withscores":            "b 2 c 3",
+		"zrangebyscore z -inf +inf limit 3 10":       "d e",
+		"zrange z 5 1 byscore":                       "",
+		"zrange nokey 0 -1":                          "",
+		"zrange z [b (d bylex":                       "b c",
+		"zrange z + - bylex rev limit 0 2":           "e d",
+		"zrange z - [a bylex":                        "a",
+		"zrangebyscore z (1 (2":                      "",
+		"zrange z 0 -1 byscore withscores limit 0 1": "",
+	}
+	for line, want := range cases {
+		if got := zsetReply(engine.ExecCmd(LineToArgs(line))); got != want {
+			t.Logf("%s: want %q, got %q", line, want, got)
+			t.Fail()
+		}
+	}
+
+	errors := map[string]string{
+		"zrange z 0 -1 limit 0 1":       "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX",
+		"zrange z - + bylex withscores": "ERR syntax error, WITHSCORES not supported in combination with BYLEX",
+		"zrange z a 1 byscore":          "ERR min or max is not a float",
+		"zrange z a + bylex":            "ERR min or max not valid string range item",
+		"zrange z a 1":                  "Value is not an integer or out of range",
+		"zrangebyscore z 0 1 rev":       "Invalid command format",
+	}
+	for line, msg := range errors {
+		if reply, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok || reply.Arg != msg {
+			t.Logf("%s: want %s", line, msg)
+			t.Fail()
+		}
+	}
+
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	if integer("zcount z (1 3") != 2 || integer("zcount z -inf +inf") != 5 || integer("zcount z 6 7") != 0 {
+		t.Fail()
+	}
+	if integer("zlexcount z - +") != 5 || integer("zlexcount z (a [c") != 2 {
+		t.Fail()
+	}
+	if integer("zrank z c") != 2 || integer("zrevrank z c") != 2 || integer("zrevrank z a") != 4 {
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("zrank z none")).(*parser.BulkString); reply.Arg != nil {
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("zrank z d withscore")).(*parser.MultiBulk); reply.Args[0].(*parser.Integer).Arg != 3 ||
+		reply.Args[1].(*parser.Double).Arg != 4 {
+		t.Fail()
+	}
+}
+
+func TestZremrangeAndPop(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	engine.ExecCmd(LineToArgs("zadd z 1 a 2 b 3 c 4 d 5 e 6 f 7 g"))
+	if integer("zremrangebyrank z 0 1") != 2 || integer("zremrangebyscore z (3 4") != 1 ||
+		integer("zremrangebylex z [f +") != 2 || integer("zremrangebyrank z 5 10") != 0 {
+		t.Fail()
+	}
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zrange z 0 -1"))); got != "c e" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+
+	engine.ExecCmd(LineToArgs("zadd z 10 x 0 y"))
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zpopmin z"))); got != "y 0" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zpopmax z 2"))); got != "x 10 e 5" {
+		t.Logf("got %q", got)
+		t.Fail()
+	}
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zpopmax z 10"))); got != "c 3" || integer("exists z") != 0 {
+		t.Fail()
+	}
+	if got := zsetReply(engine.ExecCmd(LineToArgs("zpopmin z"))); got != "" {
+		t.Fail()
+	}
+	if reply, ok := engine.ExecCmd(LineToArgs("zpopmin z -1")).(*parser.Error); !ok || reply.Arg != "ERR value is out of range, must be positive" {
+		t.Fail()
+	}
+}
+
+func TestZsetStore(t *testing.T) {
+	engine := NewDBEngine()
+	integer := func(line string) int64 {
+		return engine.ExecCmd(LineToArgs(line)).(*parser.Integer).Arg
+	}
+	engine.ExecCmd(LineToArgs("zadd z1 1 a 2 b 3 c"))
+	engine.ExecCmd(LineToArgs("zadd z2 10 b 20 c 30 d"))
+	engine.ExecCmd(LineToArgs("sadd s c d e"))
+
+	cases := []struct {
+		cmd  string
+		n    int64
+		want string
+	}{
+		{"zunionstore out 2 z1 z2", 4, "a 1 b 12 c 23 d 30"},
+		{"zunionstore out 2 z1 z2 weights 2 1 aggregate min", 4, "a 2 b 4 c 6 d 30"},
+		{"zinterstore out 2 z1 z2 aggregate max", 2, "b 10 c 20"},
+		{"zinterstore out 3 z1 z2 s weights 1 0 5", 1, "c 8"},
+		{"zunionstore out 2 s nokey", 3, "c 1 d 1 e 1"},
+		{"zdiffstore out 2 z1 z2", 1, "a 1"},
+		{"zdiffstore out 2 z2 s", 1, "b 10"},
+	}
+	for _, c := range cases {
+		if n := integer(c.cmd); n != c.n {
+			t.Logf("%s: want %d, got %d", c.cmd, c.n, n)
+			t.Fail()
+		}
+		if got := zsetReply(engine.ExecCmd(LineToArgs("zrange out 0 -1 withscores"))); got != c.want {
+			t.Logf("%s: want %q, got %q", c.cmd, c.want, got)
+			t.Fail()
+		}
+	}
+
+	// 结果为空时删除目标key，目标key也可以是输入
+	if integer("zinterstore out 2 z1 nokey") != 0 || integer("exists out") != 0 {
+		t.Fail()
+	}
+	if integer("zunionstore z1 2 z1 z2") != 4 || integer("zcard z1") != 4 {
+		t.Fail()
+	}
+
+	errors := map[string]string{
+		"zunionstore out 0 z1":               "ERR at least 1 input key is needed for 'zunionstore' command",
+		"zunionstore out 3 z1 z2":            "Invalid command format",
+		"zinterstore out 2 z1 z2 weights 1":  "Invalid command format",
+		"zinterstore out 1 z1 weights x":     "ERR weight value is not a float",
+		"zdiffstore out 1 z1 weights 1":      "Invalid command format",
+		"zunionstore out 1 z1 aggregate avg": "Invalid command format",
+	}
+	for line, msg := range errors {
+		if reply, ok := engine.ExecCmd(LineToArgs(line)).(*parser.Error); !ok || reply.Arg != msg {
+			t.Logf("%s: want %s", line, msg)
+			t.Fail()
+		}
+	}
+}
+
+func TestZrandmember(t *testing.T) {
+	engine := NewDBEngine()
+	engine.ExecCmd(LineToArgs("zadd z 1 a 2 b 3 c"))
+	if reply := engine.ExecCmd(LineToArgs("zrandmember nokey")).(*parser.BulkString); reply.Arg != nil {
+		t.Fail()
+	}
+	if reply := engine.ExecCmd(LineToArgs("zrandmember z")).(*parser.BulkString); len(reply.Arg) != 1 {
+		t.Fail()
+	}
+
+	members := bulkArgs(engine.ExecCmd(LineToArgs("zrandmember z 2")))
+	if len(members) != 2 || bytes.Equal(members[0], members[1]) {
+		t.Logf("positive count should return distinct members: %q", members)
+		t.Fail()
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("zrandmember z 10 withscores")))) != 6 {
+		t.Fail()
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("zrandmember z -10")))) != 10 {
+		t.Log("negative count allows repeated members")
+		t.Fail()
+	}
+	// 超出范围的count直接拒绝，不会分配内存
+	for _, count := range []string{"-9223372036854775808", "-4611686018427387904", "4611686018427387904"} {
+		reply, ok := engine.ExecCmd(LineToArgs("zrandmember z " + count)).(*parser.Error)
+		if !ok || reply.Arg != "ERR value is out of range" {
+			t.Logf("count %s should be out of range", count)
+			t.Fail()
+		}
+	}
+	if len(bulkArgs(engine.ExecCmd(LineToArgs("zrandmember z 4611686018427387903")))) != 3 {
+		t.Fail()
+	}
+}
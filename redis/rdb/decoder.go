@@ -0,0 +1,530 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/HK40404/simpredis/utils/crc64"
+)
+
+var ErrInvalidFormat = errors.New("invalid rdb format")
+
+const (
+	// 字符串长度的上限，和redis的proto-max-bulk-len默认值相同
+	maxStringLen = 512 << 20
+	// 按长度字段预先分配的上限，更长的数据在读到之后再增长，损坏的长度字段不会导致一次分配大量内存
+	maxPreallocBytes = 64 << 10
+	maxPreallocItems = 1024
+)
+
+func preallocItems(n int) int {
+	if n > maxPreallocItems {
+		return maxPreallocItems
+	}
+	return n
+}
+
+// Object 是从RDB中读出的一个key
+// Value的类型：string为[]byte，list为[][]byte，set为[]string，hash为map[string]string，zset为map[string]float64，stream为*Stream
+type Object struct {
+	DB       int
+	Key      string
+	Value    any
+	ExpireAt int64 // 毫秒时间戳，0表示没有过期时间
+}
+
+// Decoder 从RDB格式的数据中逐个读出key
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+func (dec *Decoder) readFull(n int) ([]byte, error) {
+	if n < 0 || n > maxStringLen {
+		return nil, ErrInvalidFormat
+	}
+	size := n
+	if size > maxPreallocBytes {
+		size = maxPreallocBytes
+	}
+	buf := make([]byte, size)
+	for {
+		if _, err := io.ReadFull(dec.r, buf[len(buf)-size:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if len(buf) == n {
+			break
+		}
+		// 每次最多增长一倍
+		size = n - len(buf)
+		if size > len(buf) {
+			size = len(buf)
+		}
+		grown := make([]byte, len(buf)+size)
+		copy(grown, buf)
+		buf = grown
+	}
+	dec.crc = crc64.Update(dec.crc, buf)
+	return buf, nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	buf, err := dec.readFull(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// 返回长度，或者特殊编码字符串的编码类型（isEncoded为true）
+func (dec *Decoder) readLength() (length uint64, isEncoded bool, err error) {
+	b, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case len6Bit:
+		return uint64(b & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case lenEnc:
+		return uint64(b & 0x3f), true, nil
+	}
+	switch b {
+	case len32Bit:
+		buf, err := dec.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case len64Bit:
+		buf, err := dec.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, ErrInvalidFormat
+}
+
+func (dec *Decoder) readLen() (int, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if isEncoded || length > math.MaxInt32 {
+		return 0, ErrInvalidFormat
+	}
+	return int(length), nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !isEncoded {
+		return dec.readFull(int(length))
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encInt16:
+		buf, err := dec.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case encInt32:
+		buf, err := dec.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case encLZF:
+		clen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := dec.readFull(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, ulen)
+	}
+	return nil, fmt.Errorf("unknown string encoding %d", length)
+}
+
+func (dec *Decoder) readHeader() error {
+	header, err := dec.readFull(9)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != Magic {
+		return ErrInvalidFormat
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return ErrInvalidFormat
+	}
+	dec.version = version
+	return nil
+}
+
+// Parse 依次读出每个key交给f处理，f返回错误时停止解析
+func (dec *Decoder) Parse(f func(obj *Object) error) error {
+	if err := dec.readHeader(); err != nil {
+		return err
+	}
+
+	db := 0
+	var expireAt int64
+	for {
+		opcode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case opEOF:
+			return dec.verifyChecksum()
+		case opSelectDB:
+			if db, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opResizeDB:
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opExpireTimeMs:
+			buf, err := dec.readFull(8)
+			if err != nil {
+				return err
+			}
+			expireAt = int64(binary.LittleEndian.Uint64(buf))
+		case opExpireTime:
+			buf, err := dec.readFull(4)
+			if err != nil {
+				return err
+			}
+			expireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+		case opIdle:
+			// 淘汰策略相关的信息，直接跳过
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opFreq:
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+		case opFunction2:
+			// 函数库的代码，不支持函数，直接跳过
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opFunction, opModuleAux:
+			return fmt.Errorf("unsupported rdb opcode %d", opcode)
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			value, err := dec.readValue(opcode)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key, err)
+			}
+			obj := &Object{DB: db, Key: string(key), Value: value, ExpireAt: expireAt}
+			expireAt = 0
+			if err := f(obj); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (dec *Decoder) readValue(valueType byte) (any, error) {
+	switch valueType {
+	case TypeString:
+		return dec.readString()
+	case TypeList:
+		length, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		values := make([][]byte, 0, preallocItems(length))
+		for i := 0; i < length; i++ {
+			v, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case TypeSet:
+		length, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		members := make([]string, 0, preallocItems(length))
+		for i := 0; i < length; i++ {
+			m, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, string(m))
+		}
+		return members, nil
+	case TypeHash:
+		length, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		hash := make(map[string]string, preallocItems(length))
+		for i := 0; i < length; i++ {
+			field, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			hash[string(field)] = string(value)
+		}
+		return hash, nil
+	case TypeZSet, TypeZSet2:
+		length, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		zset := make(map[string]float64, preallocItems(length))
+		for i := 0; i < length; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == TypeZSet2 {
+				score, err = dec.readBinaryDouble()
+			} else {
+				score, err = dec.readDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zset[string(member)] = score
+		}
+		return zset, nil
+	case TypeListZiplist:
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseZiplist(buf)
+	case TypeListQuicklist, TypeListQuicklist2:
+		return dec.readQuicklist(valueType == TypeListQuicklist2)
+	case TypeSetIntset:
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		return parseIntset(buf)
+	case TypeSetListpack:
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := parseListpack(buf)
+		if err != nil {
+			return nil, err
+		}
+		members := make([]string, 0, len(entries))
+		for _, m := range entries {
+			members = append(members, string(m))
+		}
+		return members, nil
+	case TypeHashZiplist, TypeHashListpack:
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries [][]byte
+		if valueType == TypeHashZiplist {
+			entries, err = parseZiplist(buf)
+		} else {
+			entries, err = parseListpack(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, ErrInvalidFormat
+		}
+		hash := make(map[string]string, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			hash[string(entries[i])] = string(entries[i+1])
+		}
+		return hash, nil
+	case TypeZSetZiplist, TypeZSetListpack:
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries [][]byte
+		if valueType == TypeZSetZiplist {
+			entries, err = parseZiplist(buf)
+		} else {
+			entries, err = parseListpack(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, ErrInvalidFormat
+		}
+		// 成员和分数交替存放，分数为字符串
+		zset := make(map[string]float64, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(string(entries[i+1]), 64)
+			if err != nil {
+				return nil, ErrInvalidFormat
+			}
+			zset[string(entries[i])] = score
+		}
+		return zset, nil
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return dec.readStream(valueType)
+	}
+	return nil, fmt.Errorf("unsupported rdb value type %s", typeName(valueType))
+}
+
+// 旧的ZSET格式中分数为字符串，长度253、254、255分别表示nan、+inf、-inf
+func (dec *Decoder) readDouble() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := dec.readFull(int(length))
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, ErrInvalidFormat
+	}
+	return score, nil
+}
+
+func (dec *Decoder) readBinaryDouble() (float64, error) {
+	buf, err := dec.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// quicklist的每个节点是一个ziplist，quicklist2的节点是listpack或者单个大元素
+func (dec *Decoder) readQuicklist(v2 bool) ([][]byte, error) {
+	nodes, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]byte
+	for i := 0; i < nodes; i++ {
+		container := quicklistNodePacked
+		if v2 {
+			if container, err = dec.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries [][]byte
+		switch {
+		case container == quicklistNodePlain:
+			entries = [][]byte{buf}
+		case container != quicklistNodePacked:
+			return nil, ErrInvalidFormat
+		case v2:
+			entries, err = parseListpack(buf)
+		default:
+			entries, err = parseZiplist(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, entries...)
+	}
+	return values, nil
+}
+
+func typeName(valueType byte) string {
+	switch valueType {
+	case TypeModule, TypeModule2:
+		return "module"
+	case TypeHashZipmap:
+		return "zipmap hash"
+	}
+	return strconv.Itoa(int(valueType))
+}
+
+// 校验和为0表示生成文件时关闭了校验
+func (dec *Decoder) verifyChecksum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(dec.r, buf); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	checksum := binary.LittleEndian.Uint64(buf)
+	if checksum != 0 && checksum != expected {
+		return errors.New("rdb checksum mismatch")
+	}
+	return nil
+}
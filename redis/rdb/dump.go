@@ -0,0 +1,79 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/HK40404/simpredis/utils/crc64"
+)
+
+// 能够识别的最高的rdb版本
+const maxVersion = 12
+
+var ErrInvalidPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// Dump 序列化单个value，格式和redis的DUMP一致：
+// <类型><value的rdb编码><2字节rdb版本><8字节crc64校验和>
+// value的类型和Object.Value相同
+func Dump(value any) []byte {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	switch v := value.(type) {
+	case []byte:
+		enc.writeByte(TypeString)
+		enc.writeString(v)
+	case [][]byte:
+		enc.writeByte(TypeList)
+		enc.writeListValue(v)
+	case []string:
+		enc.writeByte(TypeSet)
+		enc.writeSetValue(v)
+	case map[string]string:
+		enc.writeByte(TypeHash)
+		enc.writeHashValue(v)
+	case map[string]float64:
+		enc.writeByte(TypeZSet2)
+		enc.writeZSetValue(v)
+	case *Stream:
+		enc.writeByte(TypeStreamListpacks2)
+		enc.writeStreamValue(v)
+	}
+	// 写入bytes.Buffer不会出错
+	enc.w.Flush()
+
+	payload := buf.Bytes()
+	payload = binary.LittleEndian.AppendUint16(payload, Version)
+	return binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload))
+}
+
+// Restore 校验并解析Dump生成的数据，也可以解析redis生成的数据
+func Restore(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, ErrInvalidPayload
+	}
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer[:2])
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+	if version > maxVersion || checksum != crc64.Checksum(payload[:len(payload)-8]) {
+		return nil, ErrInvalidPayload
+	}
+
+	dec := NewDecoder(bytes.NewReader(payload[:len(payload)-10]))
+	dec.version = int(version)
+	valueType, err := dec.readByte()
+	if err != nil {
+		return nil, ErrInvalidPayload
+	}
+	value, err := dec.readValue(valueType)
+	if err == io.ErrUnexpectedEOF || errors.Is(err, ErrInvalidFormat) {
+		return nil, ErrInvalidPayload
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := dec.r.ReadByte(); err != io.EOF {
+		return nil, ErrInvalidPayload
+	}
+	return value, nil
+}
@@ -0,0 +1,261 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/HK40404/simpredis/utils/crc64"
+)
+
+const (
+	Magic   = "REDIS"
+	Version = 9
+)
+
+// 值的类型
+const (
+	TypeString           = 0
+	TypeList             = 1
+	TypeSet              = 2
+	TypeZSet             = 3
+	TypeHash             = 4
+	TypeZSet2            = 5
+	TypeModule           = 6
+	TypeModule2          = 7
+	TypeHashZipmap       = 9
+	TypeListZiplist      = 10
+	TypeSetIntset        = 11
+	TypeZSetZiplist      = 12
+	TypeHashZiplist      = 13
+	TypeListQuicklist    = 14
+	TypeStreamListpacks  = 15
+	TypeHashListpack     = 16
+	TypeZSetListpack     = 17
+	TypeListQuicklist2   = 18
+	TypeStreamListpacks2 = 19
+	TypeSetListpack      = 20
+	TypeStreamListpacks3 = 21
+)
+
+// quicklist2中每个节点的类型
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// 特殊的操作码
+const (
+	opFunction2    = 245
+	opFunction     = 246
+	opModuleAux    = 247
+	opIdle         = 248
+	opFreq         = 249
+	opAux          = 250
+	opResizeDB     = 251
+	opExpireTimeMs = 252
+	opExpireTime   = 253
+	opSelectDB     = 254
+	opEOF          = 255
+)
+
+// 长度编码的前两位
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+)
+
+// 特殊编码的字符串
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Encoder 将数据按RDB格式写入，同时计算校验和
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+func (enc *Encoder) write(p []byte) {
+	if enc.err != nil {
+		return
+	}
+	enc.crc = crc64.Update(enc.crc, p)
+	_, enc.err = enc.w.Write(p)
+}
+
+func (enc *Encoder) writeByte(b byte) {
+	enc.write([]byte{b})
+}
+
+func (enc *Encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		enc.writeByte(byte(n))
+	case n < 1<<14:
+		enc.write([]byte{byte(n>>8) | len14Bit<<6, byte(n)})
+	case n <= math.MaxUint32:
+		buf := make([]byte, 5)
+		buf[0] = len32Bit
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		enc.write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = len64Bit
+		binary.BigEndian.PutUint64(buf[1:], n)
+		enc.write(buf)
+	}
+}
+
+// 能表示为32位整数的短字符串使用整数编码，其余原样写入
+func (enc *Encoder) writeString(s []byte) {
+	if len(s) > 0 && len(s) <= 11 {
+		if n, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(n, 10) == string(s) {
+			enc.writeInt(n)
+			return
+		}
+	}
+	enc.writeLength(uint64(len(s)))
+	enc.write(s)
+}
+
+func (enc *Encoder) writeInt(n int64) {
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		enc.write([]byte{lenEnc<<6 | encInt8, byte(int8(n))})
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		buf := []byte{lenEnc<<6 | encInt16, 0, 0}
+		binary.LittleEndian.PutUint16(buf[1:], uint16(int16(n)))
+		enc.write(buf)
+	default:
+		buf := []byte{lenEnc<<6 | encInt32, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(buf[1:], uint32(int32(n)))
+		enc.write(buf)
+	}
+}
+
+// 写入"REDIS0009"
+func (enc *Encoder) WriteHeader() error {
+	enc.write([]byte(fmt.Sprintf("%s%04d", Magic, Version)))
+	return enc.err
+}
+
+func (enc *Encoder) WriteAux(key, value string) error {
+	enc.writeByte(opAux)
+	enc.writeString([]byte(key))
+	enc.writeString([]byte(value))
+	return enc.err
+}
+
+// 写入SELECTDB和RESIZEDB，后面的key都属于该db
+func (enc *Encoder) WriteDBHeader(index, size, expireSize int) error {
+	enc.writeByte(opSelectDB)
+	enc.writeLength(uint64(index))
+	enc.writeByte(opResizeDB)
+	enc.writeLength(uint64(size))
+	enc.writeLength(uint64(expireSize))
+	return enc.err
+}
+
+// expireAt为毫秒时间戳，0表示没有过期时间
+func (enc *Encoder) writeKeyHeader(valueType byte, key string, expireAt int64) {
+	if expireAt > 0 {
+		buf := make([]byte, 9)
+		buf[0] = opExpireTimeMs
+		binary.LittleEndian.PutUint64(buf[1:], uint64(expireAt))
+		enc.write(buf)
+	}
+	enc.writeByte(valueType)
+	enc.writeString([]byte(key))
+}
+
+func (enc *Encoder) WriteString(key string, value []byte, expireAt int64) error {
+	enc.writeKeyHeader(TypeString, key, expireAt)
+	enc.writeString(value)
+	return enc.err
+}
+
+func (enc *Encoder) WriteList(key string, values [][]byte, expireAt int64) error {
+	enc.writeKeyHeader(TypeList, key, expireAt)
+	enc.writeListValue(values)
+	return enc.err
+}
+
+func (enc *Encoder) WriteSet(key string, members []string, expireAt int64) error {
+	enc.writeKeyHeader(TypeSet, key, expireAt)
+	enc.writeSetValue(members)
+	return enc.err
+}
+
+func (enc *Encoder) WriteHash(key string, hash map[string]string, expireAt int64) error {
+	enc.writeKeyHeader(TypeHash, key, expireAt)
+	enc.writeHashValue(hash)
+	return enc.err
+}
+
+func (enc *Encoder) WriteZSet(key string, zset map[string]float64, expireAt int64) error {
+	enc.writeKeyHeader(TypeZSet2, key, expireAt)
+	enc.writeZSetValue(zset)
+	return enc.err
+}
+
+func (enc *Encoder) writeListValue(values [][]byte) {
+	enc.writeLength(uint64(len(values)))
+	for _, v := range values {
+		enc.writeString(v)
+	}
+}
+
+func (enc *Encoder) writeSetValue(members []string) {
+	enc.writeLength(uint64(len(members)))
+	for _, m := range members {
+		enc.writeString([]byte(m))
+	}
+}
+
+func (enc *Encoder) writeHashValue(hash map[string]string) {
+	enc.writeLength(uint64(len(hash)))
+	for field, value := range hash {
+		enc.writeString([]byte(field))
+		enc.writeString([]byte(value))
+	}
+}
+
+// ZSET_2格式，分数为8字节小端的double
+func (enc *Encoder) writeZSetValue(zset map[string]float64) {
+	enc.writeLength(uint64(len(zset)))
+	buf := make([]byte, 8)
+	for member, score := range zset {
+		enc.writeString([]byte(member))
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(score))
+		enc.write(buf)
+	}
+}
+
+// 写入EOF和校验和，并把缓冲区的数据刷到底层writer
+func (enc *Encoder) WriteEnd() error {
+	enc.writeByte(opEOF)
+	if enc.err != nil {
+		return enc.err
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, enc.crc)
+	if _, err := enc.w.Write(buf); err != nil {
+		return err
+	}
+	return enc.w.Flush()
+}
@@ -0,0 +1,354 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// 一个3字节的引用最多展开为264字节，解压之后的长度不会超过压缩数据的88倍
+const lzfMaxExpansion = 88
+
+// redis用来压缩大字符串的lzf算法，这里只需要解压
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	if outLen < 0 || outLen > len(in)*lzfMaxExpansion {
+		return nil, ErrInvalidFormat
+	}
+	out := make([]byte, 0, outLen)
+	ip := 0
+	for ip < len(in) {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < 1<<5 {
+			// 字面量
+			length := ctrl + 1
+			if ip+length > len(in) {
+				return nil, errors.New("invalid lzf data")
+			}
+			out = append(out, in[ip:ip+length]...)
+			ip += length
+			continue
+		}
+
+		// 引用之前出现过的数据
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errors.New("invalid lzf data")
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errors.New("invalid lzf data")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+		if ref < 0 {
+			return nil, errors.New("invalid lzf data")
+		}
+		// 引用的区域可能和要写入的区域重叠，需要逐字节复制
+		for i := 0; i < length+2; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errors.New("invalid lzf data")
+	}
+	return out, nil
+}
+
+var errInvalidZiplist = errors.New("invalid ziplist")
+
+// ziplist: <zlbytes><zltail><zllen><entry>...<0xff>
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errInvalidZiplist
+	}
+	count := int(binary.LittleEndian.Uint16(buf[8:10]))
+	entries := make([][]byte, 0, count)
+	pos := 10
+	for {
+		if pos >= len(buf) {
+			return nil, errInvalidZiplist
+		}
+		if buf[pos] == 0xff {
+			break
+		}
+		// prevlen
+		if buf[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(buf) {
+			return nil, errInvalidZiplist
+		}
+		entry, n, err := parseZiplistEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		pos += n
+	}
+	return entries, nil
+}
+
+// 返回entry的值和编码占用的字节数
+func parseZiplistEntry(buf []byte) ([]byte, int, error) {
+	header := buf[0]
+	switch header >> 6 {
+	case 0:
+		return sliceString(buf, 1, int(header&0x3f))
+	case 1:
+		if len(buf) < 2 {
+			return nil, 0, errInvalidZiplist
+		}
+		return sliceString(buf, 2, int(header&0x3f)<<8|int(buf[1]))
+	case 2:
+		if len(buf) < 5 {
+			return nil, 0, errInvalidZiplist
+		}
+		return sliceString(buf, 5, int(binary.BigEndian.Uint32(buf[1:5])))
+	}
+
+	var value int64
+	var size int
+	switch header {
+	case 0xc0:
+		size = 2
+	case 0xd0:
+		size = 4
+	case 0xe0:
+		size = 8
+	case 0xf0:
+		size = 3
+	case 0xfe:
+		size = 1
+	default:
+		// 1111xxxx，xxxx在0001到1101之间，表示0到12
+		if header >= 0xf1 && header <= 0xfd {
+			return []byte(strconv.Itoa(int(header&0x0f) - 1)), 1, nil
+		}
+		return nil, 0, errInvalidZiplist
+	}
+	if len(buf) < 1+size {
+		return nil, 0, errInvalidZiplist
+	}
+	value = readSignedLE(buf[1 : 1+size])
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+func sliceString(buf []byte, headerLen, strLen int) ([]byte, int, error) {
+	if len(buf) < headerLen+strLen {
+		return nil, 0, errInvalidZiplist
+	}
+	return buf[headerLen : headerLen+strLen], headerLen + strLen, nil
+}
+
+// 小端有符号整数，支持1到8字节
+func readSignedLE(buf []byte) int64 {
+	var v uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	shift := uint(64 - 8*len(buf))
+	return int64(v<<shift) >> shift
+}
+
+var errInvalidListpack = errors.New("invalid listpack")
+
+// listpack: <total-bytes><num-elements><element>...<0xff>
+// element: <encoding-type><element-data><element-tot-len>
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errInvalidListpack
+	}
+	count := int(binary.LittleEndian.Uint16(buf[4:6]))
+	entries := make([][]byte, 0, count)
+	pos := 6
+	for {
+		if pos >= len(buf) {
+			return nil, errInvalidListpack
+		}
+		if buf[pos] == 0xff {
+			break
+		}
+		entry, n, err := parseListpackEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+		pos += n + listpackBacklenSize(n)
+	}
+	return entries, nil
+}
+
+// 和redis的lpEncodeBacklen使用相同的分界
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+func parseListpackEntry(buf []byte) ([]byte, int, error) {
+	header := buf[0]
+	switch {
+	case header>>7 == 0:
+		// 7位无符号整数
+		return []byte(strconv.Itoa(int(header & 0x7f))), 1, nil
+	case header>>6 == 2:
+		// 6位长度的字符串
+		return sliceListpackString(buf, 1, int(header&0x3f))
+	case header>>5 == 6:
+		// 13位有符号整数
+		if len(buf) < 2 {
+			return nil, 0, errInvalidListpack
+		}
+		v := int(header&0x1f)<<8 | int(buf[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return []byte(strconv.Itoa(v)), 2, nil
+	case header>>4 == 14:
+		// 12位长度的字符串
+		if len(buf) < 2 {
+			return nil, 0, errInvalidListpack
+		}
+		return sliceListpackString(buf, 2, int(header&0x0f)<<8|int(buf[1]))
+	}
+
+	var size int
+	switch header {
+	case 0xf0:
+		// 32位长度的字符串
+		if len(buf) < 5 {
+			return nil, 0, errInvalidListpack
+		}
+		return sliceListpackString(buf, 5, int(binary.LittleEndian.Uint32(buf[1:5])))
+	case 0xf1:
+		size = 2
+	case 0xf2:
+		size = 3
+	case 0xf3:
+		size = 4
+	case 0xf4:
+		size = 8
+	default:
+		return nil, 0, errInvalidListpack
+	}
+	if len(buf) < 1+size {
+		return nil, 0, errInvalidListpack
+	}
+	value := readSignedLE(buf[1 : 1+size])
+	return []byte(strconv.FormatInt(value, 10)), 1 + size, nil
+}
+
+func sliceListpackString(buf []byte, headerLen, strLen int) ([]byte, int, error) {
+	if len(buf) < headerLen+strLen {
+		return nil, 0, errInvalidListpack
+	}
+	return buf[headerLen : headerLen+strLen], headerLen + strLen, nil
+}
+
+// listpackWriter 按redis的格式生成listpack，整数使用最短的整数编码
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{buf: make([]byte, 6)}
+}
+
+func (lp *listpackWriter) appendInt(v int64) {
+	start := len(lp.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		lp.buf = append(lp.buf, 0xc0|byte(uint64(v)>>8)&0x1f, byte(v))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		lp.buf = append(lp.buf, 0xf1)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(v))
+	case v >= -1<<23 && v < 1<<23:
+		lp.buf = append(lp.buf, 0xf2, byte(v), byte(v>>8), byte(v>>16))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		lp.buf = append(lp.buf, 0xf3)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(v))
+	default:
+		lp.buf = append(lp.buf, 0xf4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(v))
+	}
+	lp.finishEntry(start)
+}
+
+func (lp *listpackWriter) appendString(s []byte) {
+	start := len(lp.buf)
+	switch {
+	case len(s) < 1<<6:
+		lp.buf = append(lp.buf, 0x80|byte(len(s)))
+	case len(s) < 1<<12:
+		lp.buf = append(lp.buf, 0xe0|byte(len(s)>>8), byte(len(s)))
+	default:
+		lp.buf = append(lp.buf, 0xf0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(len(s)))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.finishEntry(start)
+}
+
+// 在entry后面写入backlen，从后往前读时高位在前
+func (lp *listpackWriter) finishEntry(start int) {
+	n := uint64(len(lp.buf) - start)
+	size := listpackBacklenSize(int(n))
+	for i := size - 1; i >= 0; i-- {
+		b := byte(n>>(7*uint(i))) & 127
+		if i != size-1 {
+			b |= 128
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.count++
+}
+
+// 元素数量超过65535时头部记为65535，表示需要遍历才能知道
+func (lp *listpackWriter) bytes() []byte {
+	buf := append(lp.buf, 0xff)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	count := lp.count
+	if count > math.MaxUint16 {
+		count = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(count))
+	return buf
+}
+
+var errInvalidIntset = errors.New("invalid intset")
+
+// intset: <encoding><length><contents>，encoding为每个整数的字节数
+func parseIntset(buf []byte) ([]string, error) {
+	if len(buf) < 8 {
+		return nil, errInvalidIntset
+	}
+	size := int(binary.LittleEndian.Uint32(buf[0:4]))
+	count := int(binary.LittleEndian.Uint32(buf[4:8]))
+	if size != 2 && size != 4 && size != 8 || len(buf) < 8+size*count {
+		return nil, errInvalidIntset
+	}
+	members := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		start := 8 + i*size
+		members = append(members, strconv.FormatInt(readSignedLE(buf[start:start+size]), 10))
+	}
+	return members, nil
+}
@@ -0,0 +1,443 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/HK40404/simpredis/utils/crc64"
+)
+
+func TestEncodeAndDecode(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	longStr := []byte(strings.Repeat("a", 20000))
+	hash := map[string]string{"f1": "v1", "f2": "123", "f3": ""}
+	zset := map[string]float64{"a": 1.5, "b": -2, "c": math.Inf(1)}
+	if err := enc.WriteHeader(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	enc.WriteAux("redis-bits", "64")
+	enc.WriteDBHeader(0, 6, 1)
+	enc.WriteString("str", []byte("value"), 0)
+	enc.WriteString("int", []byte("-100000"), 0)
+	enc.WriteString("notint", []byte("007"), 0)
+	enc.WriteString("long", longStr, 1700000000123)
+	enc.WriteList("list", [][]byte{[]byte("1"), []byte("b"), {}}, 0)
+	enc.WriteSet("set", []string{"a", "b", "300"}, 0)
+	enc.WriteDBHeader(3, 2, 0)
+	enc.WriteHash("hash", hash, 0)
+	enc.WriteZSet("zset", zset, 0)
+	if err := enc.WriteEnd(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")) {
+		t.Log("wrong rdb header")
+		t.Fail()
+	}
+
+	objs := make(map[string]*Object)
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(obj *Object) error {
+		objs[obj.Key] = obj
+		return nil
+	})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(objs) != 8 {
+		t.Logf("should decode 8 keys, got %d", len(objs))
+		t.FailNow()
+	}
+	if string(objs["str"].Value.([]byte)) != "value" ||
+		string(objs["int"].Value.([]byte)) != "-100000" ||
+		string(objs["notint"].Value.([]byte)) != "007" {
+		t.Log("wrong string value")
+		t.Fail()
+	}
+	if !bytes.Equal(objs["long"].Value.([]byte), longStr) || objs["long"].ExpireAt != 1700000000123 {
+		t.Log("wrong long string or expire time")
+		t.Fail()
+	}
+	if objs["str"].ExpireAt != 0 {
+		t.Log("expire time should only belong to one key")
+		t.Fail()
+	}
+	list := objs["list"].Value.([][]byte)
+	if len(list) != 3 || string(list[0]) != "1" || string(list[1]) != "b" || len(list[2]) != 0 {
+		t.Logf("wrong list value: %q", list)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(objs["set"].Value, []string{"a", "b", "300"}) {
+		t.Logf("wrong set value: %v", objs["set"].Value)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(objs["hash"].Value, hash) || objs["hash"].DB != 3 {
+		t.Logf("wrong hash value: %v", objs["hash"].Value)
+		t.Fail()
+	}
+	if !reflect.DeepEqual(objs["zset"].Value, zset) {
+		t.Logf("wrong zset value: %v", objs["zset"].Value)
+		t.Fail()
+	}
+
+	// 修改任意一个字节都应该校验失败
+	data := buf.Bytes()
+	data[20] ^= 0xff
+	err = NewDecoder(bytes.NewReader(data)).Parse(func(obj *Object) error { return nil })
+	if err == nil {
+		t.Log("corrupted rdb should fail to parse")
+		t.Fail()
+	}
+}
+
+func TestInvalidHeader(t *testing.T) {
+	err := NewDecoder(strings.NewReader("RADIS0009")).Parse(func(obj *Object) error { return nil })
+	if err == nil {
+		t.Fail()
+	}
+}
+
+// 用body拼出一个完整的rdb文件
+func buildRdb(body ...[]byte) []byte {
+	data := []byte("REDIS0011")
+	for _, b := range body {
+		data = append(data, b...)
+	}
+	data = append(data, opEOF)
+	return binary.LittleEndian.AppendUint64(data, crc64.Checksum(data))
+}
+
+func rdbString(s []byte) []byte {
+	if len(s) < 1<<6 {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	return append([]byte{byte(len(s)>>8) | len14Bit<<6, byte(len(s))}, s...)
+}
+
+// entries需要自带prevlen
+func ziplist(entries ...[]byte) []byte {
+	buf := make([]byte, 10)
+	for _, e := range entries {
+		buf = append(buf, e...)
+	}
+	buf = append(buf, 0xff)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(len(entries)))
+	return buf
+}
+
+// entries需要自带backlen
+func listpack(entries ...[]byte) []byte {
+	buf := make([]byte, 6)
+	for _, e := range entries {
+		buf = append(buf, e...)
+	}
+	buf = append(buf, 0xff)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(len(entries)))
+	return buf
+}
+
+func keyHeader(valueType byte, key string) []byte {
+	return append([]byte{valueType}, rdbString([]byte(key))...)
+}
+
+func TestDecodeEncodedTypes(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 70)
+	lpLong := append([]byte{0xe0, 70}, long...)
+	lpLong = append(lpLong, 72)
+
+	// LZF压缩的"aaaaaaaa"：一个字面量'a'，再从前一个字节开始复制7个
+	lzf := append(keyHeader(TypeString, "lzf"), lenEnc<<6|encLZF, 4, 8, 0x00, 'a', 0xa0, 0x00)
+
+	zl := ziplist(
+		[]byte{0x00, 0x05, 'h', 'e', 'l', 'l', 'o'},
+		[]byte{0x07, 0xfd},
+		[]byte{0x02, 0xfe, 0xfe},
+		[]byte{0x03, 0xc0, 0xe8, 0x03},
+		[]byte{0x04, 0xf0, 0xa0, 0x86, 0x01},
+	)
+	zlList := append(keyHeader(TypeListZiplist, "zllist"), rdbString(zl)...)
+
+	quicklist := append(keyHeader(TypeListQuicklist, "quicklist"), 2)
+	quicklist = append(quicklist, rdbString(ziplist([]byte{0x00, 0x01, 'a'}, []byte{0x03, 0x01, 'b'}))...)
+	quicklist = append(quicklist, rdbString(ziplist([]byte{0x00, 0x01, 'c'}))...)
+
+	lp := listpack(
+		[]byte{0x81, 'x', 0x02},
+		[]byte{0x05, 0x01},
+		[]byte{0xdf, 0xff, 0x02},
+		[]byte{0xf1, 0x2c, 0x01, 0x04},
+		lpLong,
+	)
+	quicklist2 := append(keyHeader(TypeListQuicklist2, "quicklist2"), 2, quicklistNodePacked)
+	quicklist2 = append(quicklist2, rdbString(lp)...)
+	quicklist2 = append(quicklist2, quicklistNodePlain)
+	quicklist2 = append(quicklist2, rdbString([]byte("plain"))...)
+
+	intset := []byte{2, 0, 0, 0, 3, 0, 0, 0, 0x01, 0x00, 0xfe, 0xff, 0x2c, 0x01}
+	intsetSet := append(keyHeader(TypeSetIntset, "intset"), rdbString(intset)...)
+
+	lpSet := append(keyHeader(TypeSetListpack, "lpset"),
+		rdbString(listpack([]byte{0x82, 'm', '1', 0x03}, []byte{0x07, 0x01}))...)
+
+	lpHash := append(keyHeader(TypeHashListpack, "lphash"), rdbString(listpack(
+		[]byte{0x82, 'f', '1', 0x03}, []byte{0x82, 'v', '1', 0x03},
+		[]byte{0x82, 'f', '2', 0x03}, []byte{0x63, 0x01},
+	))...)
+
+	zlHash := append(keyHeader(TypeHashZiplist, "zlhash"),
+		rdbString(ziplist([]byte{0x00, 0x01, 'a'}, []byte{0x03, 0xf2}))...)
+
+	// 旧格式的zset，分数为字符串或者表示无穷的特殊长度
+	oldZSet := append(keyHeader(TypeZSet, "oldzset"), 3)
+	oldZSet = append(oldZSet, rdbString([]byte("m1"))...)
+	oldZSet = append(oldZSet, 3, '2', '.', '5')
+	oldZSet = append(oldZSet, rdbString([]byte("m2"))...)
+	oldZSet = append(oldZSet, 254)
+	oldZSet = append(oldZSet, rdbString([]byte("m3"))...)
+	oldZSet = append(oldZSet, 255)
+
+	lpZSet := append(keyHeader(TypeZSetListpack, "lpzset"), rdbString(listpack(
+		[]byte{0x81, 'a', 0x02}, []byte{0x83, '1', '.', '5', 0x04},
+		[]byte{0x81, 'b', 0x02}, []byte{0x02, 0x01},
+	))...)
+
+	zlZSet := append(keyHeader(TypeZSetZiplist, "zlzset"),
+		rdbString(ziplist([]byte{0x00, 0x01, 'x'}, []byte{0x03, 0xf4}))...)
+
+	data := buildRdb(
+		[]byte{opSelectDB, 0},
+		[]byte{opIdle, 0x05}, []byte{opFreq, 0x03}, lzf,
+		zlList, quicklist,
+		[]byte{opExpireTime, 0x00, 0xf1, 0x53, 0x65}, quicklist2,
+		intsetSet, lpSet, lpHash, zlHash,
+		oldZSet, lpZSet, zlZSet,
+	)
+
+	objs := make(map[string]*Object)
+	err := NewDecoder(bytes.NewReader(data)).Parse(func(obj *Object) error {
+		objs[obj.Key] = obj
+		return nil
+	})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	expected := map[string]any{
+		"lzf":        []byte("aaaaaaaa"),
+		"zllist":     [][]byte{[]byte("hello"), []byte("12"), []byte("-2"), []byte("1000"), []byte("100000")},
+		"quicklist":  [][]byte{[]byte("a"), []byte("b"), []byte("c")},
+		"quicklist2": [][]byte{[]byte("x"), []byte("5"), []byte("-1"), []byte("300"), long, []byte("plain")},
+		"intset":     []string{"1", "-2", "300"},
+		"lpset":      []string{"m1", "7"},
+		"lphash":     map[string]string{"f1": "v1", "f2": "99"},
+		"zlhash":     map[string]string{"a": "1"},
+		"oldzset":    map[string]float64{"m1": 2.5, "m2": math.Inf(1), "m3": math.Inf(-1)},
+		"lpzset":     map[string]float64{"a": 1.5, "b": 2},
+		"zlzset":     map[string]float64{"x": 3},
+	}
+	for key, value := range expected {
+		if objs[key] == nil || !reflect.DeepEqual(objs[key].Value, value) {
+			t.Logf("wrong value of %s: %v", key, objs[key])
+			t.Fail()
+		}
+	}
+	if objs["quicklist2"].ExpireAt != 1700000000000 || objs["lzf"].ExpireAt != 0 {
+		t.Log("wrong expire time")
+		t.Fail()
+	}
+}
+
+func TestUnsupportedType(t *testing.T) {
+	module := append(keyHeader(TypeModule2, "module"), 0)
+	data := buildRdb([]byte{opSelectDB, 0}, module)
+	err := NewDecoder(bytes.NewReader(data)).Parse(func(obj *Object) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "module") {
+		t.Logf("should report unsupported module, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestDumpAndRestore(t *testing.T) {
+	values := []any{
+		[]byte("value"),
+		[]byte("12345"),
+		[][]byte{[]byte("a"), []byte("1"), {}},
+		[]string{"a", "b"},
+		map[string]string{"f": "v", "n": "1"},
+		map[string]float64{"a": 1, "b": -0.5},
+		&Stream{
+			Entries:      []StreamEntry{{StreamID{1, 0}, [][]byte{[]byte("f"), []byte("v")}}},
+			LastID:       StreamID{5, 1},
+			MaxDeletedID: StreamID{5, 1},
+			EntriesAdded: 3,
+		},
+	}
+	for _, value := range values {
+		payload := Dump(value)
+		restored, err := Restore(payload)
+		if err != nil || !reflect.DeepEqual(restored, value) {
+			t.Logf("restore %v: got %v, %v", value, restored, err)
+			t.Fail()
+		}
+	}
+
+	payload := Dump([]byte("value"))
+	if binary.LittleEndian.Uint16(payload[len(payload)-10:]) != Version {
+		t.Log("wrong payload version")
+		t.Fail()
+	}
+	corrupted := append([]byte{}, payload...)
+	corrupted[1] ^= 0xff
+	if _, err := Restore(corrupted); err != ErrInvalidPayload {
+		t.Log("corrupted payload should fail")
+		t.Fail()
+	}
+	if _, err := Restore(payload[:5]); err != ErrInvalidPayload {
+		t.Log("short payload should fail")
+		t.Fail()
+	}
+
+	// 版本过高的数据无法识别
+	future := append([]byte{}, payload[:len(payload)-10]...)
+	future = binary.LittleEndian.AppendUint16(future, maxVersion+1)
+	future = binary.LittleEndian.AppendUint64(future, crc64.Checksum(future))
+	if _, err := Restore(future); err != ErrInvalidPayload {
+		t.Log("payload with higher version should fail")
+		t.Fail()
+	}
+}
+
+// 长度字段被篡改的value，第一个字节是类型
+var invalidLengthValues = map[string][]byte{
+	"64-bit string length": {TypeString, len64Bit, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 'a'},
+	"32-bit string length": {TypeString, len32Bit, 0xff, 0xff, 0xff, 0xf0, 'a'},
+	"large string length":  {TypeString, len32Bit, 0x10, 0x00, 0x00, 0x00, 'a'},
+	"list count":           {TypeList, len64Bit, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 'a'},
+	"set count":            {TypeSet, len32Bit, 0x7f, 0xff, 0xff, 0xff, 0x01, 'a'},
+	"hash count":           {TypeHash, len32Bit, 0x7f, 0xff, 0xff, 0xff, 0x01, 'a', 0x01, 'b'},
+	"zset count":           {TypeZSet2, len32Bit, 0x7f, 0xff, 0xff, 0xff, 0x01, 'a'},
+	"lzf length":           {TypeString, lenEnc<<6 | encLZF, 0x02, len32Bit, 0x7f, 0xff, 0xff, 0xff, 0x00, 'a'},
+	"lzf 64-bit length":    {TypeString, lenEnc<<6 | encLZF, 0x02, len64Bit, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 'a'},
+}
+
+// f处理value时不能panic，也不能按长度字段分配大量内存
+func checkInvalidLength(t *testing.T, f func(name string, value []byte)) {
+	for name, value := range invalidLengthValues {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		f(name, value)
+		runtime.ReadMemStats(&after)
+		if after.TotalAlloc-before.TotalAlloc > 1<<20 {
+			t.Logf("%s: allocated %d bytes", name, after.TotalAlloc-before.TotalAlloc)
+			t.Fail()
+		}
+	}
+}
+
+func TestDecodeInvalidLength(t *testing.T) {
+	checkInvalidLength(t, func(name string, value []byte) {
+		data := buildRdb(keyHeader(value[0], "k"), value[1:])
+		if err := NewDecoder(bytes.NewReader(data)).Parse(func(obj *Object) error { return nil }); err == nil {
+			t.Logf("%s: corrupted rdb should fail", name)
+			t.Fail()
+		}
+	})
+}
+
+func TestRestoreInvalidLength(t *testing.T) {
+	checkInvalidLength(t, func(name string, value []byte) {
+		payload := binary.LittleEndian.AppendUint16(append([]byte{}, value...), Version)
+		payload = binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload))
+		if _, err := Restore(payload); err != ErrInvalidPayload {
+			t.Logf("%s: want ErrInvalidPayload, got %v", name, err)
+			t.Fail()
+		}
+	})
+}
+
+func TestListpackWriter(t *testing.T) {
+	ints := []int64{0, 127, 128, -1, 4095, -4096, 4096, 32767, -32768, 1 << 20, -(1 << 23), 1 << 30, -(1 << 31), 1 << 40, math.MinInt64}
+	strs := [][]byte{{}, []byte("short"), bytes.Repeat([]byte("m"), 100), bytes.Repeat([]byte("l"), 5000)}
+	lp := newListpackWriter()
+	for _, v := range ints {
+		lp.appendInt(v)
+	}
+	for _, s := range strs {
+		lp.appendString(s)
+	}
+	entries, err := parseListpack(lp.bytes())
+	if err != nil || len(entries) != len(ints)+len(strs) {
+		t.Logf("parse listpack: %d entries, %v", len(entries), err)
+		t.FailNow()
+	}
+	for i, v := range ints {
+		if string(entries[i]) != strconv.FormatInt(v, 10) {
+			t.Logf("want %d, got %s", v, entries[i])
+			t.Fail()
+		}
+	}
+	for i, s := range strs {
+		if !bytes.Equal(entries[len(ints)+i], s) {
+			t.Logf("wrong string of length %d", len(s))
+			t.Fail()
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := &Stream{LastID: StreamID{2000, 5}, MaxDeletedID: StreamID{3, 0}, EntriesAdded: 300}
+	// 超过一个listpack的entry，field有相同也有不同
+	for i := 0; i < 250; i++ {
+		fields := [][]byte{[]byte("name"), []byte("n" + strconv.Itoa(i)), []byte("age"), []byte(strconv.Itoa(i))}
+		if i%7 == 0 {
+			fields = [][]byte{[]byte("other"), []byte("x")}
+		}
+		s.Entries = append(s.Entries, StreamEntry{StreamID{uint64(1000 + i), uint64(i % 3)}, fields})
+	}
+	s.Groups = []StreamGroup{
+		{
+			Name:        "g1",
+			LastID:      StreamID{1001, 1},
+			EntriesRead: 2,
+			Pending: []StreamPending{
+				{ID: StreamID{1000, 0}, DeliveryTime: 1700000000000, DeliveryCount: 1},
+				{ID: StreamID{1001, 1}, DeliveryTime: 1700000000001, DeliveryCount: 3},
+			},
+			Consumers: []StreamConsumer{
+				{Name: "c1", SeenTime: 1700000000002, ActiveTime: 1700000000002, Pending: []StreamID{{1000, 0}, {1001, 1}}},
+				{Name: "c2", SeenTime: 1700000000003, ActiveTime: 1700000000003},
+			},
+		},
+		{Name: "g2", EntriesRead: 0},
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.WriteHeader()
+	enc.WriteDBHeader(0, 1, 0)
+	enc.WriteStream("stream", s, 0)
+	if err := enc.WriteEnd(); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	var got any
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(obj *Object) error {
+		got = obj.Value
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(got, s) {
+		t.Logf("wrong stream: %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,419 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// StreamID 是stream中entry的ID，由毫秒时间和序号组成
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte // field和value交替排列
+}
+
+// Stream 是stream在rdb中保存的内容
+type Stream struct {
+	Entries      []StreamEntry
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []StreamGroup
+}
+
+// StreamGroup 是消费组，EntriesRead为-1表示未知
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     []StreamPending
+	Consumers   []StreamConsumer
+}
+
+// StreamPending 是已经发送但还没有确认的entry
+type StreamPending struct {
+	ID            StreamID
+	DeliveryTime  int64 // unix毫秒
+	DeliveryCount uint64
+}
+
+// StreamConsumer 的Pending是属于这个消费者的entry，需要在消费组的Pending中
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64 // unix毫秒
+	ActiveTime int64 // unix毫秒，-1表示没有读取过，STREAM_LISTPACKS_3之前的格式不保存
+	Pending    []StreamID
+}
+
+// entry的标记
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// 和redis一样，每个listpack最多保存100个entry
+const streamNodeMaxEntries = 100
+
+func appendRawStreamID(buf []byte, id StreamID) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, id.Ms)
+	return binary.BigEndian.AppendUint64(buf, id.Seq)
+}
+
+func parseRawStreamID(buf []byte) StreamID {
+	return StreamID{Ms: binary.BigEndian.Uint64(buf[:8]), Seq: binary.BigEndian.Uint64(buf[8:])}
+}
+
+func (enc *Encoder) WriteStream(key string, s *Stream, expireAt int64) error {
+	enc.writeKeyHeader(TypeStreamListpacks2, key, expireAt)
+	enc.writeStreamValue(s)
+	return enc.err
+}
+
+func (enc *Encoder) writeMillisecondTime(t int64) {
+	enc.write(binary.LittleEndian.AppendUint64(nil, uint64(t)))
+}
+
+func (enc *Encoder) writeStreamID(id StreamID) {
+	enc.writeLength(id.Ms)
+	enc.writeLength(id.Seq)
+}
+
+// STREAM_LISTPACKS_2格式：entry分组保存在以第一个entry的ID为key的listpack中，然后是元信息和消费组
+func (enc *Encoder) writeStreamValue(s *Stream) {
+	nodes := (len(s.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	enc.writeLength(uint64(nodes))
+	for i := 0; i < len(s.Entries); i += streamNodeMaxEntries {
+		end := i + streamNodeMaxEntries
+		if end > len(s.Entries) {
+			end = len(s.Entries)
+		}
+		enc.writeString(appendRawStreamID(nil, s.Entries[i].ID))
+		enc.writeString(buildStreamListpack(s.Entries[i:end]))
+	}
+
+	enc.writeLength(uint64(len(s.Entries)))
+	enc.writeStreamID(s.LastID)
+	var first StreamID
+	if len(s.Entries) > 0 {
+		first = s.Entries[0].ID
+	}
+	enc.writeStreamID(first)
+	enc.writeStreamID(s.MaxDeletedID)
+	enc.writeLength(s.EntriesAdded)
+
+	enc.writeLength(uint64(len(s.Groups)))
+	for _, g := range s.Groups {
+		enc.writeString([]byte(g.Name))
+		enc.writeStreamID(g.LastID)
+		enc.writeLength(uint64(g.EntriesRead))
+		enc.writeLength(uint64(len(g.Pending)))
+		for _, p := range g.Pending {
+			enc.write(appendRawStreamID(nil, p.ID))
+			enc.writeMillisecondTime(p.DeliveryTime)
+			enc.writeLength(p.DeliveryCount)
+		}
+		enc.writeLength(uint64(len(g.Consumers)))
+		for _, c := range g.Consumers {
+			enc.writeString([]byte(c.Name))
+			enc.writeMillisecondTime(c.SeenTime)
+			enc.writeLength(uint64(len(c.Pending)))
+			for _, id := range c.Pending {
+				enc.write(appendRawStreamID(nil, id))
+			}
+		}
+	}
+}
+
+// 第一个entry作为master entry，field和它相同的entry只保存value
+// master: <count><deleted><num-fields><field>...<0>
+// entry: <flags><ms-diff><seq-diff>[<num-fields><field><value>...|<value>...]<lp-count>
+func buildStreamListpack(entries []StreamEntry) []byte {
+	lp := newListpackWriter()
+	master := entries[0]
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(master.Fields) / 2))
+	for i := 0; i < len(master.Fields); i += 2 {
+		lp.appendString(master.Fields[i])
+	}
+	lp.appendInt(0)
+
+	for _, e := range entries {
+		numFields := len(e.Fields) / 2
+		same := sameFields(master.Fields, e.Fields)
+		if same {
+			lp.appendInt(streamItemSameFields)
+		} else {
+			lp.appendInt(0)
+		}
+		lp.appendInt(int64(e.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(e.ID.Seq - master.ID.Seq))
+		if same {
+			for i := 1; i < len(e.Fields); i += 2 {
+				lp.appendString(e.Fields[i])
+			}
+			lp.appendInt(int64(numFields + 3))
+		} else {
+			lp.appendInt(int64(numFields))
+			for _, f := range e.Fields {
+				lp.appendString(f)
+			}
+			lp.appendInt(int64(numFields*2 + 4))
+		}
+	}
+	return lp.bytes()
+}
+
+func sameFields(master, fields [][]byte) bool {
+	if len(master) != len(fields) {
+		return false
+	}
+	for i := 0; i < len(master); i += 2 {
+		if string(master[i]) != string(fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (dec *Decoder) readStreamID() (StreamID, error) {
+	ms, err := dec.readLen64()
+	if err != nil {
+		return StreamID{}, err
+	}
+	seq, err := dec.readLen64()
+	if err != nil {
+		return StreamID{}, err
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (dec *Decoder) readLen64() (uint64, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if isEncoded {
+		return 0, ErrInvalidFormat
+	}
+	return length, nil
+}
+
+func (dec *Decoder) readMillisecondTime() (int64, error) {
+	buf, err := dec.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+func (dec *Decoder) readRawStreamID() (StreamID, error) {
+	buf, err := dec.readFull(16)
+	if err != nil {
+		return StreamID{}, err
+	}
+	return parseRawStreamID(buf), nil
+}
+
+// 三个版本的区别：LISTPACKS_2增加了first-id、max-deleted-id、entries-added和消费组的entries-read，
+// LISTPACKS_3增加了消费者的active-time
+func (dec *Decoder) readStream(valueType byte) (*Stream, error) {
+	nodes, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	s := &Stream{}
+	for i := 0; i < nodes; i++ {
+		key, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, ErrInvalidFormat
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if s.Entries, err = parseStreamListpack(s.Entries, parseRawStreamID(key), buf); err != nil {
+			return nil, err
+		}
+	}
+
+	length, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if length != len(s.Entries) {
+		return nil, ErrInvalidFormat
+	}
+	if s.LastID, err = dec.readStreamID(); err != nil {
+		return nil, err
+	}
+	s.EntriesAdded = uint64(length)
+	if valueType != TypeStreamListpacks {
+		// first-id可以从entry得到
+		if _, err = dec.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.MaxDeletedID, err = dec.readStreamID(); err != nil {
+			return nil, err
+		}
+		if s.EntriesAdded, err = dec.readLen64(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < groups; i++ {
+		g, err := dec.readStreamGroup(valueType)
+		if err != nil {
+			return nil, err
+		}
+		s.Groups = append(s.Groups, g)
+	}
+	return s, nil
+}
+
+func (dec *Decoder) readStreamGroup(valueType byte) (StreamGroup, error) {
+	g := StreamGroup{EntriesRead: -1}
+	name, err := dec.readString()
+	if err != nil {
+		return g, err
+	}
+	g.Name = string(name)
+	if g.LastID, err = dec.readStreamID(); err != nil {
+		return g, err
+	}
+	if valueType != TypeStreamListpacks {
+		entriesRead, err := dec.readLen64()
+		if err != nil {
+			return g, err
+		}
+		g.EntriesRead = int64(entriesRead)
+	}
+
+	pending, err := dec.readLen()
+	if err != nil {
+		return g, err
+	}
+	for i := 0; i < pending; i++ {
+		var p StreamPending
+		if p.ID, err = dec.readRawStreamID(); err != nil {
+			return g, err
+		}
+		if p.DeliveryTime, err = dec.readMillisecondTime(); err != nil {
+			return g, err
+		}
+		if p.DeliveryCount, err = dec.readLen64(); err != nil {
+			return g, err
+		}
+		g.Pending = append(g.Pending, p)
+	}
+
+	consumers, err := dec.readLen()
+	if err != nil {
+		return g, err
+	}
+	for i := 0; i < consumers; i++ {
+		var c StreamConsumer
+		name, err := dec.readString()
+		if err != nil {
+			return g, err
+		}
+		c.Name = string(name)
+		if c.SeenTime, err = dec.readMillisecondTime(); err != nil {
+			return g, err
+		}
+		// 旧的格式没有active-time，和redis一样使用seen-time
+		c.ActiveTime = c.SeenTime
+		if valueType == TypeStreamListpacks3 {
+			if c.ActiveTime, err = dec.readMillisecondTime(); err != nil {
+				return g, err
+			}
+		}
+		n, err := dec.readLen()
+		if err != nil {
+			return g, err
+		}
+		for j := 0; j < n; j++ {
+			id, err := dec.readRawStreamID()
+			if err != nil {
+				return g, err
+			}
+			c.Pending = append(c.Pending, id)
+		}
+		g.Consumers = append(g.Consumers, c)
+	}
+	return g, nil
+}
+
+// 解析buildStreamListpack的格式，跳过标记为删除的entry
+func parseStreamListpack(entries []StreamEntry, master StreamID, buf []byte) ([]StreamEntry, error) {
+	lp, err := parseListpack(buf)
+	if err != nil {
+		return nil, err
+	}
+	pos := 0
+	next := func() (int64, bool) {
+		if pos >= len(lp) {
+			return 0, false
+		}
+		v, err := strconv.ParseInt(string(lp[pos]), 10, 64)
+		pos++
+		return v, err == nil
+	}
+
+	count, ok1 := next()
+	deleted, ok2 := next()
+	numFields, ok3 := next()
+	if !ok1 || !ok2 || !ok3 || numFields < 0 || numFields >= int64(len(lp)-pos) {
+		return nil, ErrInvalidFormat
+	}
+	masterFields := lp[pos : pos+int(numFields)]
+	pos += int(numFields)
+	if terminator, ok := next(); !ok || terminator != 0 {
+		return nil, ErrInvalidFormat
+	}
+
+	for i := int64(0); i < count+deleted; i++ {
+		flags, ok1 := next()
+		msDiff, ok2 := next()
+		seqDiff, ok3 := next()
+		if !ok1 || !ok2 || !ok3 {
+			return nil, ErrInvalidFormat
+		}
+		e := StreamEntry{ID: StreamID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)}}
+		if flags&streamItemSameFields != 0 {
+			if pos+len(masterFields) > len(lp) {
+				return nil, ErrInvalidFormat
+			}
+			for j, f := range masterFields {
+				e.Fields = append(e.Fields, f, lp[pos+j])
+			}
+			pos += len(masterFields)
+		} else {
+			n, ok := next()
+			if !ok || n < 0 || n > int64(len(lp)-pos)/2 {
+				return nil, ErrInvalidFormat
+			}
+			e.Fields = append(e.Fields, lp[pos:pos+int(n)*2]...)
+			pos += int(n) * 2
+		}
+		// lp-count只用于从后往前遍历
+		if _, ok := next(); !ok {
+			return nil, ErrInvalidFormat
+		}
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"strconv"
+	"unsafe"
+)
+
+// BulkArray 用于返回大量BulkString的命令，遍历数据结构时只记录元素的引用，
+// 编码时一次性扩容后直接写入连接的缓冲区，元素只拷贝一次。
+// 元素都是BulkString，不同协议下只有头部和空值的编码不同
+type BulkArray struct {
+	begin byte     // ArrayBegin、SetBegin或MapBegin
+	args  [][]byte // nil表示空值
+	size  int      // 所有元素编码之后的长度
+}
+
+func NewBulkArray() *BulkArray {
+	return &BulkArray{begin: ArrayBegin}
+}
+
+// 在RESP3下编码为集合
+func NewBulkSet() *BulkArray {
+	return &BulkArray{begin: SetBegin}
+}
+
+// 在RESP3下编码为Map，需要按键、值交替添加
+func NewBulkMap() *BulkArray {
+	return &BulkArray{begin: MapBegin}
+}
+
+// arg为nil时添加空值。编码在释放锁之后进行，和GET一样，arg不能再被原地修改
+func (a *BulkArray) Add(arg []byte) {
+	a.args = append(a.args, arg)
+	if arg == nil {
+		a.size += len(EmptyBulkString)
+	} else {
+		a.size += bulkLen(len(arg))
+	}
+}
+
+// 字符串不可变，直接引用它的内容，不需要转换为[]byte时的拷贝
+func (a *BulkArray) AddString(arg string) {
+	if arg == "" {
+		// 空字符串的StringData可能是nil，不能当作空值
+		a.Add([]byte{})
+		return
+	}
+	a.Add(unsafe.Slice(unsafe.StringData(arg), len(arg)))
+}
+
+// 预留n个元素的空间，元素数量已知时避免多次扩容
+func (a *BulkArray) Grow(n int) {
+	if cap(a.args)-len(a.args) < n {
+		args := make([][]byte, len(a.args), len(a.args)+n)
+		copy(args, a.args)
+		a.args = args
+	}
+}
+
+func (a *BulkArray) Len() int {
+	return len(a.args)
+}
+
+// $n\r\n + 内容 + \r\n
+func bulkLen(n int) int {
+	digits := 1
+	for d := n; d >= 10; d /= 10 {
+		digits++
+	}
+	return 1 + digits + 2 + n + 2
+}
+
+// 一次性扩容，保证之后的append不会再分配内存
+func (a *BulkArray) grow(buf []byte) []byte {
+	n := 1 + len(strconv.Itoa(len(a.args))) + 2 + a.size
+	if cap(buf)-len(buf) >= n {
+		return buf
+	}
+	grown := make([]byte, len(buf), len(buf)+n)
+	copy(grown, buf)
+	return grown
+}
+
+func (a *BulkArray) Serialize() []byte {
+	return a.AppendTo(nil)
+}
+
+func (a *BulkArray) AppendTo(buf []byte) []byte {
+	buf = a.grow(buf)
+	buf = appendHeader(buf, ArrayBegin, len(a.args))
+	for _, arg := range a.args {
+		buf = appendBulk(buf, arg)
+	}
+	return buf
+}
+
+func (a *BulkArray) SerializeResp3() []byte {
+	return a.AppendResp3(nil)
+}
+
+func (a *BulkArray) AppendResp3(buf []byte) []byte {
+	count := len(a.args)
+	if a.begin == MapBegin {
+		count /= 2
+	}
+	buf = a.grow(buf)
+	buf = appendHeader(buf, a.begin, count)
+	for _, arg := range a.args {
+		// RESP3的空值是_\r\n
+		if arg == nil {
+			buf = appendNull(buf)
+		} else {
+			buf = appendBulk(buf, arg)
+		}
+	}
+	return buf
+}
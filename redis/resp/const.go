@@ -3,7 +3,7 @@ package parser
 const (
 	CRLF            = "\r\n"
 	EmptyBulkString = "$-1" + CRLF
-	PING            = "PING"
+	EmptyArray      = "*-1" + CRLF
 )
 
 func MakeOKReply() *String {
@@ -13,3 +13,7 @@ func MakeOKReply() *String {
 func MakeNullBulkReply() *BulkString {
 	return NewBulkString(nil)
 }
+
+func MakeNullArrayReply() *NullArray {
+	return &NullArray{}
+}
@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"bufio"
+	"strconv"
+)
+
+// 内联命令一行的最大长度
+const MaxInlineSize = 64 * 1024
+
+// 读取以\n结尾的一行，超过maxLen时丢弃整行并返回格式错误
+func readLine(reader *bufio.Reader, maxLen int) ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := reader.ReadSlice('\n')
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if len(line) > maxLen {
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			if err != nil {
+				return nil, err
+			}
+			return nil, &ProtocolError{"too big inline request"}
+		}
+	}
+	if len(line) > maxLen {
+		return nil, &ProtocolError{"too big inline request"}
+	}
+	return line, nil
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\n', '\r', '\t', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// SplitInline 按照内联命令的规则切分一行，供命令行客户端使用
+func SplitInline(line []byte) ([][]byte, error) {
+	return parseInline(line)
+}
+
+// 按空格切分内联命令，和redis-cli一样支持单引号、双引号和转义字符
+func parseInline(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if inDouble {
+				if i >= len(line) {
+					return nil, &ProtocolError{"unbalanced quotes in request"}
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case line[i] == '"':
+					// 引号结束后必须是空白或者结尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, &ProtocolError{"unbalanced quotes in request"}
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if inSingle {
+				if i >= len(line) {
+					return nil, &ProtocolError{"unbalanced quotes in request"}
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					arg = append(arg, '\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, &ProtocolError{"unbalanced quotes in request"}
+					}
+					done = true
+				default:
+					arg = append(arg, line[i])
+				}
+			} else {
+				if i >= len(line) {
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', '\v', '\f':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{"set key value\r\n", []string{"set", "key", "value"}},
+		{"  PING  \n", []string{"PING"}},
+		{"set k \"hello world\"\r\n", []string{"set", "k", "hello world"}},
+		{"set k \"\\x41\\n\\\"\\\\\"\r\n", []string{"set", "k", "A\n\"\\"}},
+		{"set k 'it\\'s \\n'\r\n", []string{"set", "k", "it's \\n"}},
+		{"set k \"\"\r\n", []string{"set", "k", ""}},
+		{"\r\n", []string{}},
+	}
+	for _, test := range tests {
+		args, err := parseInline([]byte(test.line))
+		if err != nil {
+			t.Logf("%q: %v", test.line, err)
+			t.Fail()
+			continue
+		}
+		got := make([]string, 0, len(args))
+		for _, arg := range args {
+			got = append(got, string(arg))
+		}
+		if !reflect.DeepEqual(got, test.args) {
+			t.Logf("%q: want %q, got %q", test.line, test.args, got)
+			t.Fail()
+		}
+	}
+
+	for _, line := range []string{"set k \"v\r\n", "set k 'v\r\n", "set k \"v\"x\r\n", "set k 'v'x\r\n"} {
+		if _, err := parseInline([]byte(line)); err == nil {
+			t.Logf("%q should be unbalanced", line)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseInlineStream(t *testing.T) {
+	data := "\r\n\nset k v\n" + "get " + strings.Repeat("k", MaxInlineSize) + "\r\n" + "*1\r\n$4\r\nPING\r\nPING\r\n"
+	ch := ParseStream(bytes.NewReader([]byte(data)))
+
+	payload := <-ch
+	if array, ok := payload.Data.(*Array); !ok || len(array.Args) != 3 || string(array.Args[2]) != "v" {
+		t.Logf("fail to parse inline command: %v", payload)
+		t.Fail()
+	}
+	if payload := <-ch; payload.Err == nil || !strings.Contains(payload.Err.Error(), "too big inline request") {
+		t.Logf("should report too big inline request: %v", payload)
+		t.Fail()
+	}
+	for i := 0; i < 2; i++ {
+		payload = <-ch
+		if array, ok := payload.Data.(*Array); !ok || len(array.Args) != 1 || string(array.Args[0]) != "PING" {
+			t.Logf("fail to parse ping: %v", payload)
+			t.Fail()
+		}
+	}
+}
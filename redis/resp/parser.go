@@ -3,7 +3,10 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
 )
 
@@ -12,6 +15,22 @@ type Payload struct {
 	Err  error
 }
 
+// ProtocolError 表示格式错误，跳过出错的数据后可以继续解析
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.msg
+}
+
+func NewProtocolError(msg string) *ProtocolError {
+	return &ProtocolError{msg}
+}
+
+// 长度头超过这个值时边读边分配内存，不直接相信长度头
+const maxPrealloc = 64 * 1024
+
 // 从reader中解析数据流成resp命令
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
@@ -19,121 +38,242 @@ func ParseStream(reader io.Reader) <-chan *Payload {
 	return ch
 }
 
-// suport ping inline
+// 以类型符号开头的是RESP数据，其余的行按内联命令解析
 func parse0(rawreader io.Reader, ch chan<- *Payload) {
 	reader := bufio.NewReader(rawreader)
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := readLine(reader, MaxInlineSize)
+		var data RespData
+		if err == nil {
+			data, err = parseTopLine(reader, line)
+		}
 		if err != nil {
+			var perr *ProtocolError
+			if errors.As(err, &perr) {
+				ch <- &Payload{Err: err}
+				continue
+			}
+			// 只有系统级别的错误才停止解析
 			ch <- &Payload{Err: err}
+			close(ch)
 			return
 		}
-		// 判断是否为空行或者不以CRLF结尾（不符合RESP）
+		if data != nil {
+			ch <- &Payload{Data: data}
+		}
+	}
+}
+
+// ReadReply 同步地读取一个完整的回复，供客户端使用
+func ReadReply(reader *bufio.Reader) (RespData, error) {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(line) <= 2 || line[len(line)-2] != '\r' {
+		return nil, &ProtocolError{"invalid reply format"}
+	}
+	return parseLine(reader, bytes.TrimSuffix(line, []byte(CRLF)))
+}
+
+// 返回nil表示忽略这一行
+func parseTopLine(reader *bufio.Reader, line []byte) (RespData, error) {
+	switch line[0] {
+	case StringBegin, ErrorBegin, IntegerBegin, BulkStringBegin, ArrayBegin:
+		// 不以CRLF结尾，不符合RESP，直接忽略这一行
 		if len(line) <= 2 || line[len(line)-2] != '\r' {
-			// 直接忽略这一行
-			continue
-		}
-		line = bytes.TrimSuffix(line, []byte(CRLF))
-		switch line[0] {
-		case StringBegin:
-			ch <- &Payload{
-				Data: NewString(string(line[1:])),
-			}
-		case ErrorBegin:
-			ch <- &Payload{
-				Data: NewError(string(line[1:])),
-			}
-		case IntegerBegin:
-			num, err := strconv.ParseInt(string(line[1:]), 10, 64)
-			if err != nil {
-				SendProtocolError(ch, "invalid integer format")
-				continue
-			}
-			ch <- &Payload{
-				Data: NewInteger(num),
-			}
-		case BulkStringBegin:
-			// 只返回系统级别的错误
-			err := parseBulkString(reader, string(line[1:]), ch)
-			if err != nil {
-				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-		case ArrayBegin:
-			err := parseArray(reader, string(line[1:]), ch)
-			if err != nil {
-				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-		default:
-			if string(line) == PING {
-				ch <- &Payload{
-					Data: NewString(PING),
-				}
-			}
-			continue
+			return nil, nil
 		}
+		return parseLine(reader, bytes.TrimSuffix(line, []byte(CRLF)))
+	}
+	args, err := parseInline(line)
+	if err != nil || len(args) == 0 {
+		// 空行直接忽略
+		return nil, err
 	}
+	return NewArray(args), nil
 }
 
-func parseBulkString(reader *bufio.Reader, line string, ch chan<- *Payload) error {
-	byteCount, err := strconv.Atoi(line)
+// 根据已经读出的首行解析一个完整的数据，line不含CRLF
+func parseLine(reader *bufio.Reader, line []byte) (RespData, error) {
+	switch line[0] {
+	case StringBegin:
+		return NewString(string(line[1:])), nil
+	case ErrorBegin:
+		return NewError(string(line[1:])), nil
+	case IntegerBegin:
+		num, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, &ProtocolError{"invalid integer format"}
+		}
+		return NewInteger(num), nil
+	case BulkStringBegin:
+		return parseBulkString(reader, string(line[1:]))
+	case ArrayBegin:
+		return parseArray(reader, string(line[1:]))
+	case MapBegin:
+		return parseMap(reader, string(line[1:]))
+	case SetBegin, PushBegin:
+		elems, err := parseElems(reader, string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == SetBegin {
+			return NewSet(elems), nil
+		}
+		return NewPush(elems), nil
+	case DoubleBegin:
+		// ParseFloat可以解析inf、-inf和nan
+		f, err := strconv.ParseFloat(string(line[1:]), 64)
+		if err != nil {
+			return nil, &ProtocolError{"invalid double format"}
+		}
+		return NewDouble(f), nil
+	case BooleanBegin:
+		switch string(line[1:]) {
+		case "t":
+			return NewBoolean(true), nil
+		case "f":
+			return NewBoolean(false), nil
+		}
+		return nil, &ProtocolError{"invalid boolean format"}
+	case NullBegin:
+		if len(line) != 1 {
+			return nil, &ProtocolError{"invalid null format"}
+		}
+		return NewNull(), nil
+	case BigNumberBegin:
+		n, ok := new(big.Int).SetString(string(line[1:]), 10)
+		if !ok {
+			return nil, &ProtocolError{"invalid big number format"}
+		}
+		return NewBigNumber(n), nil
+	case VerbatimBegin, BlobErrorBegin:
+		bs, err := parseBulkString(reader, string(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		if line[0] == BlobErrorBegin {
+			return NewError(string(bs.Arg)), nil
+		}
+		// 前4个字节是格式和冒号，如txt:
+		if len(bs.Arg) < 4 || bs.Arg[3] != ':' {
+			return nil, &ProtocolError{"invalid verbatim string format"}
+		}
+		return NewVerbatim(string(bs.Arg[:3]), bs.Arg[4:]), nil
+	case AttributeBegin:
+		// 属性是附加在回复之前的信息，读出之后丢弃，返回之后真正的回复
+		if _, err := parseMap(reader, string(line[1:])); err != nil {
+			return nil, err
+		}
+		return ReadReply(reader)
+	}
+	return nil, &ProtocolError{"unknown data type"}
+}
+
+func parseBulkString(reader *bufio.Reader, header string) (*BulkString, error) {
+	byteCount, err := strconv.Atoi(header)
 	if err != nil || byteCount < -1 {
-		SendProtocolError(ch, "invalid bulkstring length")
-		return nil
+		return nil, &ProtocolError{"invalid bulkstring length"}
 	} else if byteCount == -1 {
-		ch <- &Payload{Data: NewBulkString(nil)}
-		return nil
+		return NewBulkString(nil), nil
 	}
 
+	if byteCount > maxPrealloc {
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, reader, int64(byteCount+len(CRLF)))
+		if err != nil {
+			if err == io.EOF && n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return NewBulkString(buf.Bytes()[:byteCount]), nil
+	}
 	buf := make([]byte, byteCount+len(CRLF))
 	_, err = io.ReadFull(reader, buf)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ch <- &Payload{Data: NewBulkString(buf[:len(buf)-2])}
-	return nil
+	return NewBulkString(buf[:len(buf)-2]), nil
 }
 
-func parseArray(reader *bufio.Reader, header string, ch chan<- *Payload) error {
-	strCount, err := strconv.Atoi(header)
-	if err != nil || strCount < 0 {
-		SendProtocolError(ch, "invalid bulkstring count of array")
-		return nil
+// 只包含BulkString的数组解析为Array，否则解析为MultiBulk
+func parseArray(reader *bufio.Reader, header string) (RespData, error) {
+	count, err := strconv.Atoi(header)
+	if err != nil || count < -1 {
+		return nil, &ProtocolError{"invalid bulkstring count of array"}
+	} else if count == -1 {
+		return MakeNullArrayReply(), nil
 	}
 
-	array := make([][]byte, strCount)
-	for i := 0; i < strCount; i++ {
+	elems, err := readElems(reader, count)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range elems {
+		if _, ok := elem.(*BulkString); !ok {
+			return NewMultiBulk(elems), nil
+		}
+	}
+	array := make([][]byte, count)
+	for i, elem := range elems {
+		// NullBulkString对应nil
+		array[i] = elem.(*BulkString).Arg
+	}
+	return NewArray(array), nil
+}
+
+// 读取count个元素
+func readElems(reader *bufio.Reader, count int) ([]RespData, error) {
+	prealloc := count
+	if prealloc > 1024 {
+		prealloc = 1024
+	}
+	elems := make([]RespData, 0, prealloc)
+	for i := 0; i < count; i++ {
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
-			return err
+			return nil, err
 		}
 		// 判断是否为空行或者不以CRLF结尾（不符合RESP）
-		if len(line) <= 2 || line[len(line)-2] != '\r' || line[0] != BulkStringBegin {
-			// 直接忽略这一行
-			SendProtocolError(ch, "invalid bulkstring format")
-			return nil
-		}
-		line = bytes.TrimSuffix(line[1:], []byte(CRLF))
-		strLen, err := strconv.Atoi(string(line))
-		if err != nil || strLen < -1 {
-			SendProtocolError(ch, "invalid bulkstring length")
-			return nil
-		} else if strLen == -1 {
-			// NullBulkString后面没有body
-			array[i] = nil
-			continue
-		}
-
-		buf := make([]byte, strLen+len(CRLF))
-		_, err = io.ReadFull(reader, buf)
+		if len(line) <= 2 || line[len(line)-2] != '\r' {
+			return nil, &ProtocolError{"invalid array element format"}
+		}
+		elem, err := parseLine(reader, bytes.TrimSuffix(line, []byte(CRLF)))
 		if err != nil {
-			return err
+			return nil, err
 		}
-		array[i] = buf[:len(buf)-2]
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// RESP3的Set和Push，元素可以是任意类型
+func parseElems(reader *bufio.Reader, header string) ([]RespData, error) {
+	count, err := strconv.Atoi(header)
+	if err != nil || count < 0 {
+		return nil, &ProtocolError{"invalid element count"}
+	}
+	return readElems(reader, count)
+}
+
+// header是键值对的数量
+func parseMap(reader *bufio.Reader, header string) (*Map, error) {
+	count, err := strconv.Atoi(header)
+	if err != nil || count < 0 || count > math.MaxInt/2 {
+		return nil, &ProtocolError{"invalid map length"}
+	}
+	elems, err := readElems(reader, count*2)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMap()
+	for i := 0; i < len(elems); i += 2 {
+		m.Add(elems[i], elems[i+1])
 	}
-	ch <- &Payload{Data: NewArray(array)}
-	return nil
+	return m, nil
 }
@@ -2,6 +2,8 @@ package parser
 
 import (
 	"bytes"
+	"io"
+	"reflect"
 	"testing"
 )
 
@@ -131,3 +133,71 @@ func TestParser(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestParseNestedArray(t *testing.T) {
+	nested := NewMultiBulk([]RespData{
+		NewInteger(1),
+		NewMultiBulk([]RespData{NewString("OK"), MakeNullBulkReply(), NewArray(nil)}),
+		NewError("ERR x"),
+		MakeNullArrayReply(),
+		NewArray([][]byte{[]byte("a"), nil}),
+	})
+	tests := []RespData{nested, MakeNullArrayReply(), NewArray([][]byte{[]byte("a"), nil})}
+
+	var data []byte
+	for _, test := range tests {
+		data = append(data, test.Serialize()...)
+	}
+	// 格式错误的数据不影响后面的解析
+	data = append(data, []byte("*2\r\n:x\r\n")...)
+	data = append(data, nested.Serialize()...)
+
+	ch := ParseStream(bytes.NewReader(data))
+	for _, test := range tests {
+		payload := <-ch
+		if payload.Err != nil || !bytes.Equal(payload.Data.Serialize(), test.Serialize()) {
+			t.Logf("want %q, got %v", test.Serialize(), payload)
+			t.Fail()
+		}
+		// 只包含BulkString的数组解析为Array
+		if reflect.TypeOf(payload.Data) != reflect.TypeOf(test) {
+			t.Logf("want %T, got %T", test, payload.Data)
+			t.Fail()
+		}
+	}
+
+	if payload := <-ch; payload.Err == nil {
+		t.Log("should report protocol error")
+		t.Fail()
+	}
+	payload := <-ch
+	mb, ok := payload.Data.(*MultiBulk)
+	if !ok || len(mb.Args) != 5 {
+		t.Logf("fail to parse nested array after error: %v", payload)
+		t.FailNow()
+	}
+	if _, ok := mb.Args[3].(*NullArray); !ok {
+		t.Log("fail to parse null array")
+		t.Fail()
+	}
+	if inner := mb.Args[1].(*MultiBulk); inner.Args[0].(*String).Arg != "OK" {
+		t.Fail()
+	}
+}
+
+func TestParseHugeHeader(t *testing.T) {
+	// 长度头很大但数据很少时不应该一次分配大量内存
+	data := "*2147483647\r\n$3\r\nget\r\n" + "$4000000000\r\nabc"
+	payload := <-ParseStream(bytes.NewReader([]byte(data)))
+	if payload.Err != io.ErrUnexpectedEOF {
+		t.Logf("want unexpected EOF, got %v", payload)
+		t.Fail()
+	}
+
+	big := bytes.Repeat([]byte("x"), 3*maxPrealloc)
+	payload = <-ParseStream(bytes.NewReader(NewBulkString(big).Serialize()))
+	if bs, ok := payload.Data.(*BulkString); !ok || !bytes.Equal(bs.Arg, big) {
+		t.Log("fail to parse big bulk string")
+		t.Fail()
+	}
+}
@@ -0,0 +1,179 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+)
+
+// 参数读取缓冲区超过这个大小后不再复用，避免一直占用大块内存
+const maxScratchSize = 64 * 1024
+
+// Limits 限制客户端命令的大小，为0表示不限制
+type Limits struct {
+	MaxBulkLen      int // 单个参数的最大长度
+	MaxMultiBulkLen int // 一条命令的最大参数个数
+	MaxQueryLen     int // 一条命令的最大字节数
+}
+
+// Reader 在调用方的goroutine中逐条读取客户端发送的命令
+type Reader struct {
+	reader  *bufio.Reader
+	limits  Limits
+	args    [][]byte // 复用的参数数组
+	scratch []byte   // 复用的读取缓冲区
+	offsets []int    // 每个参数在scratch中的结束位置
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(rd)}
+}
+
+// 在分配内存前按照长度头检查限制
+func (r *Reader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// 缓冲区中还没有解析的字节数
+func (r *Reader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// ReadCommand 读取一条完整的命令，支持多条批量格式和内联格式，空命令会被跳过。
+// 返回的参数数组在下次调用时会被复用，但参数的内容不会被复用，可以直接保存。
+// 返回ProtocolError后数据流已经无法继续解析，调用方应该关闭连接
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		first, err := r.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		var args [][]byte
+		if first[0] == ArrayBegin {
+			args, err = r.readMultiBulk()
+		} else {
+			var line []byte
+			line, err = readLine(r.reader, MaxInlineSize)
+			if err == nil {
+				args, err = parseInline(line)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(args) > 0 {
+			return args, nil
+		}
+	}
+}
+
+// 读取以CRLF结尾的一行，返回的内容不含CRLF，只在下次读取前有效
+func (r *Reader) readHeader() ([]byte, error) {
+	line, err := r.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, &ProtocolError{"too big header"}
+	} else if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, &ProtocolError{"invalid header format"}
+	}
+	return line[:len(line)-2], nil
+}
+
+// 所有参数先读到scratch中，最后一次性拷贝到新分配的内存里，每条命令只分配一次
+func (r *Reader) readMultiBulk() ([][]byte, error) {
+	header, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	count, ok := parseLength(header[1:])
+	if !ok || (r.limits.MaxMultiBulkLen > 0 && count > r.limits.MaxMultiBulkLen) {
+		return nil, &ProtocolError{"invalid multibulk length"}
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	queryLen := len(header) + len(CRLF)
+
+	r.scratch = r.scratch[:0]
+	r.offsets = r.offsets[:0]
+	for i := 0; i < count; i++ {
+		header, err = r.readHeader()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+		if header[0] != BulkStringBegin {
+			return nil, &ProtocolError{"expected '$', got '" + string(header[0]) + "'"}
+		}
+		n, ok := parseLength(header[1:])
+		if !ok || n < 0 || (r.limits.MaxBulkLen > 0 && n > r.limits.MaxBulkLen) {
+			return nil, &ProtocolError{"invalid bulk length"}
+		}
+		queryLen += len(header) + n + 2*len(CRLF)
+		if r.limits.MaxQueryLen > 0 && queryLen > r.limits.MaxQueryLen {
+			return nil, &ProtocolError{"too big query buffer"}
+		}
+
+		start := len(r.scratch)
+		end := start + n + len(CRLF)
+		if end > cap(r.scratch) {
+			size := 2 * end
+			if end > maxScratchSize {
+				// 大参数按实际长度分配，不提前多分配一倍
+				size = end + start
+			}
+			scratch := make([]byte, start, size)
+			copy(scratch, r.scratch)
+			r.scratch = scratch
+		}
+		r.scratch = r.scratch[:end]
+		if _, err := io.ReadFull(r.reader, r.scratch[start:end]); err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		// 去掉结尾的CRLF
+		r.scratch = r.scratch[:end-len(CRLF)]
+		r.offsets = append(r.offsets, len(r.scratch))
+	}
+
+	data := make([]byte, len(r.scratch))
+	copy(data, r.scratch)
+	if cap(r.scratch) > maxScratchSize {
+		r.scratch = nil
+	}
+	r.args = r.args[:0]
+	start := 0
+	for _, end := range r.offsets {
+		r.args = append(r.args, data[start:end:end])
+		start = end
+	}
+	return r.args, nil
+}
+
+// 解析十进制长度，和strconv.Atoi相比不需要先转换成string
+func parseLength(b []byte) (int, bool) {
+	neg := false
+	if len(b) > 0 && b[0] == '-' {
+		neg = true
+		b = b[1:]
+	}
+	if len(b) == 0 || len(b) > 18 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, true
+}
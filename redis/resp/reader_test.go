@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readAll(data string) ([][]string, error) {
+	reader := NewReader(strings.NewReader(data))
+	var cmds [][]string
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			return cmds, err
+		}
+		cmd := make([]string, 0, len(args))
+		for _, arg := range args {
+			cmd = append(cmd, string(arg))
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	data := "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$0\r\n\r\n" + "*0\r\n*-1\r\n\r\n" + "get \"a b\"\n" + "*1\r\n$4\r\nPING\r\n"
+	cmds, err := readAll(data)
+	want := [][]string{{"set", "k", ""}, {"get", "a b"}, {"PING"}}
+	if err != io.EOF || !reflect.DeepEqual(cmds, want) {
+		t.Logf("want %q, got %q %v", want, cmds, err)
+		t.Fail()
+	}
+
+	// 参数的内容不会被之后的命令覆盖
+	reader := NewReader(strings.NewReader("*2\r\n$3\r\nget\r\n$2\r\nk1\r\n*2\r\n$3\r\nget\r\n$2\r\nk2\r\n"))
+	first, _ := reader.ReadCommand()
+	key := first[1]
+	reader.ReadCommand()
+	if string(key) != "k1" {
+		t.Logf("argument is overwritten: %q", key)
+		t.Fail()
+	}
+
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"*x\r\n", "invalid multibulk length"},
+		{"*1\r\n+OK\r\n", "expected '$', got '+'"},
+		{"*1\r\n$-1\r\n", "invalid bulk length"},
+		{"*1\n", "invalid header format"},
+		{"set k \"v\r\n", "unbalanced quotes in request"},
+	}
+	for _, test := range tests {
+		_, err := readAll(test.data)
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || !strings.Contains(err.Error(), test.err) {
+			t.Logf("%q: want %s, got %v", test.data, test.err, err)
+			t.Fail()
+		}
+	}
+
+	if _, err := readAll("*2\r\n$3\r\nget\r\n$2\r\nk"); err != io.ErrUnexpectedEOF {
+		t.Logf("want unexpected EOF, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 4, MaxMultiBulkLen: 3, MaxQueryLen: 32}
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{"*2147483647\r\n", "invalid multibulk length"},
+		{"*4\r\n", "invalid multibulk length"},
+		{"*2\r\n$3\r\nget\r\n$4000000000\r\n", "invalid bulk length"},
+		{"*3\r\n$4\r\nmset\r\n$4\r\nkey1\r\n$4\r\nval1\r\n", "too big query buffer"},
+	}
+	for _, test := range tests {
+		reader := NewReader(strings.NewReader(test.data))
+		reader.SetLimits(limits)
+		_, err := reader.ReadCommand()
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || err.Error() != "Protocol error: "+test.err {
+			t.Logf("%q: want %s, got %v", test.data, test.err, err)
+			t.Fail()
+		}
+	}
+
+	reader := NewReader(strings.NewReader("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$4\r\nabcd\r\n"))
+	reader.SetLimits(limits)
+	if args, err := reader.ReadCommand(); err != nil || len(args) != 3 {
+		t.Logf("command within limits should be accepted: %v", err)
+		t.Fail()
+	}
+}
+
+// 构造n条pipeline的set命令
+func pipelineData(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		buf.Write(NewArray([][]byte{[]byte("set"), []byte("key:000001"), []byte("value")}).Serialize())
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkParseStream(b *testing.B) {
+	data := pipelineData(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for payload := range ParseStream(bytes.NewReader(data)) {
+		if payload.Err != nil {
+			break
+		}
+	}
+}
+
+func BenchmarkReadCommand(b *testing.B) {
+	data := pipelineData(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	reader := NewReader(bytes.NewReader(data))
+	for {
+		if _, err := reader.ReadCommand(); err != nil {
+			break
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package parser
 
 import (
-	"bytes"
-	"errors"
 	"strconv"
 )
 
@@ -16,6 +14,23 @@ const (
 
 type RespData interface {
 	Serialize() []byte
+	// 把编码结果追加到buf后面，避免中间的字符串拼接和拷贝
+	AppendTo(buf []byte) []byte
+}
+
+func appendHeader(buf []byte, begin byte, n int) []byte {
+	buf = append(buf, begin)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, CRLF...)
+}
+
+func appendBulk(buf []byte, arg []byte) []byte {
+	if arg == nil {
+		return append(buf, EmptyBulkString...)
+	}
+	buf = appendHeader(buf, BulkStringBegin, len(arg))
+	buf = append(buf, arg...)
+	return append(buf, CRLF...)
 }
 
 type String struct {
@@ -27,7 +42,13 @@ func NewString(data string) *String {
 }
 
 func (ss *String) Serialize() []byte {
-	return []byte("+" + ss.Arg + CRLF)
+	return ss.AppendTo(nil)
+}
+
+func (ss *String) AppendTo(buf []byte) []byte {
+	buf = append(buf, StringBegin)
+	buf = append(buf, ss.Arg...)
+	return append(buf, CRLF...)
 }
 
 type Error struct {
@@ -39,7 +60,13 @@ func NewError(data string) *Error {
 }
 
 func (e *Error) Serialize() []byte {
-	return []byte("-" + e.Arg + CRLF)
+	return e.AppendTo(nil)
+}
+
+func (e *Error) AppendTo(buf []byte) []byte {
+	buf = append(buf, ErrorBegin)
+	buf = append(buf, e.Arg...)
+	return append(buf, CRLF...)
 }
 
 type Integer struct {
@@ -51,12 +78,13 @@ func NewInteger(num int64) *Integer {
 }
 
 func (e *Integer) Serialize() []byte {
-	return []byte(":" + strconv.FormatInt(e.Arg, 10) + CRLF)
+	return e.AppendTo(nil)
 }
 
-func SendProtocolError(ch chan<- *Payload, msg string) {
-	err := errors.New("Invalid Protocol Syntax: " + msg)
-	ch <- &Payload{Err: err}
+func (e *Integer) AppendTo(buf []byte) []byte {
+	buf = append(buf, IntegerBegin)
+	buf = strconv.AppendInt(buf, e.Arg, 10)
+	return append(buf, CRLF...)
 }
 
 type BulkString struct {
@@ -68,10 +96,11 @@ func NewBulkString(data []byte) *BulkString {
 }
 
 func (bs *BulkString) Serialize() []byte {
-	if bs.Arg == nil {
-		return []byte(EmptyBulkString)
-	}
-	return []byte(string(BulkStringBegin) + strconv.Itoa(len(bs.Arg)) + CRLF + string(bs.Arg) + CRLF)
+	return bs.AppendTo(nil)
+}
+
+func (bs *BulkString) AppendTo(buf []byte) []byte {
+	return appendBulk(buf, bs.Arg)
 }
 
 type Array struct {
@@ -83,15 +112,41 @@ func NewArray(strs [][]byte) *Array {
 }
 
 func (array *Array) Serialize() []byte {
-	argLen := len(array.Args)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	return array.AppendTo(nil)
+}
+
+func (array *Array) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, ArrayBegin, len(array.Args))
 	for _, arg := range array.Args {
-		if arg == nil {
-			buf.WriteString("$-1" + CRLF)
-		} else {
-			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
-		}
+		buf = appendBulk(buf, arg)
 	}
-	return buf.Bytes()
+	return buf
+}
+
+// MultiBulk 是元素可以为任意类型的数组，可以嵌套
+type MultiBulk struct {
+	Args []RespData
+}
+
+func NewMultiBulk(args []RespData) *MultiBulk {
+	return &MultiBulk{Args: args}
+}
+
+func (mb *MultiBulk) Serialize() []byte {
+	return mb.AppendTo(nil)
+}
+
+func (mb *MultiBulk) AppendTo(buf []byte) []byte {
+	return appendAggregate(buf, ArrayBegin, mb.Args, 2)
+}
+
+// NullArray 表示不存在的数组，和空数组不同
+type NullArray struct{}
+
+func (na *NullArray) Serialize() []byte {
+	return na.AppendTo(nil)
+}
+
+func (na *NullArray) AppendTo(buf []byte) []byte {
+	return append(buf, EmptyArray...)
 }
@@ -0,0 +1,376 @@
+package parser
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+const (
+	MapBegin       = '%'
+	SetBegin       = '~'
+	DoubleBegin    = ','
+	BooleanBegin   = '#'
+	NullBegin      = '_'
+	BigNumberBegin = '('
+	VerbatimBegin  = '='
+	PushBegin      = '>'
+	AttributeBegin = '|'
+	BlobErrorBegin = '!'
+)
+
+// Resp3Data 是在RESP3下有不同编码的类型
+// Serialize返回降级后的RESP2编码，SerializeResp3返回RESP3编码
+type Resp3Data interface {
+	RespData
+	SerializeResp3() []byte
+	AppendResp3(buf []byte) []byte
+}
+
+// 按照客户端协商的协议版本编码
+func SerializeWithProtocol(data RespData, protocol int) []byte {
+	return AppendWithProtocol(nil, data, protocol)
+}
+
+// 按照客户端协商的协议版本编码，结果追加到buf后面
+func AppendWithProtocol(buf []byte, data RespData, protocol int) []byte {
+	if protocol == 3 {
+		if d, ok := data.(Resp3Data); ok {
+			return d.AppendResp3(buf)
+		}
+	}
+	return data.AppendTo(buf)
+}
+
+// 把多个字符串转换为BulkString，用于构造Map、Set等
+func NewBulkStrings(args [][]byte) []RespData {
+	data := make([]RespData, 0, len(args))
+	for _, arg := range args {
+		data = append(data, NewBulkString(arg))
+	}
+	return data
+}
+
+func appendNull(buf []byte) []byte {
+	return append(buf, NullBegin, '\r', '\n')
+}
+
+func (bs *BulkString) SerializeResp3() []byte {
+	return bs.AppendResp3(nil)
+}
+
+func (bs *BulkString) AppendResp3(buf []byte) []byte {
+	if bs.Arg == nil {
+		return appendNull(buf)
+	}
+	return appendBulk(buf, bs.Arg)
+}
+
+func (array *Array) SerializeResp3() []byte {
+	return array.AppendResp3(nil)
+}
+
+func (array *Array) AppendResp3(buf []byte) []byte {
+	buf = appendHeader(buf, ArrayBegin, len(array.Args))
+	for _, arg := range array.Args {
+		if arg == nil {
+			buf = appendNull(buf)
+		} else {
+			buf = appendBulk(buf, arg)
+		}
+	}
+	return buf
+}
+
+func (mb *MultiBulk) SerializeResp3() []byte {
+	return mb.AppendResp3(nil)
+}
+
+func (mb *MultiBulk) AppendResp3(buf []byte) []byte {
+	return appendAggregate(buf, ArrayBegin, mb.Args, 3)
+}
+
+func (na *NullArray) SerializeResp3() []byte {
+	return na.AppendResp3(nil)
+}
+
+func (na *NullArray) AppendResp3(buf []byte) []byte {
+	return appendNull(buf)
+}
+
+func appendAggregate(buf []byte, begin byte, elems []RespData, protocol int) []byte {
+	buf = appendHeader(buf, begin, len(elems))
+	for _, elem := range elems {
+		buf = AppendWithProtocol(buf, elem, protocol)
+	}
+	return buf
+}
+
+// Map 在RESP2下编码为键值交替的数组
+type Map struct {
+	Keys   []RespData
+	Values []RespData
+}
+
+func NewMap() *Map {
+	return &Map{}
+}
+
+func (m *Map) Add(key, value RespData) {
+	m.Keys = append(m.Keys, key)
+	m.Values = append(m.Values, value)
+}
+
+func (m *Map) Serialize() []byte {
+	return m.AppendTo(nil)
+}
+
+func (m *Map) AppendTo(buf []byte) []byte {
+	return m.appendPairs(appendHeader(buf, ArrayBegin, len(m.Keys)*2), 2)
+}
+
+func (m *Map) SerializeResp3() []byte {
+	return m.AppendResp3(nil)
+}
+
+func (m *Map) AppendResp3(buf []byte) []byte {
+	return m.appendPairs(appendHeader(buf, MapBegin, len(m.Keys)), 3)
+}
+
+func (m *Map) appendPairs(buf []byte, protocol int) []byte {
+	for i := range m.Keys {
+		buf = AppendWithProtocol(buf, m.Keys[i], protocol)
+		buf = AppendWithProtocol(buf, m.Values[i], protocol)
+	}
+	return buf
+}
+
+// Pairs 是两两一组的元素，如有序集合的成员和分数。RESP2下编码为交替排列的数组，
+// RESP3下和redis 7一样编码为二元组的数组
+type Pairs struct {
+	Args []RespData // 按组交替排列
+}
+
+func NewPairs(args []RespData) *Pairs {
+	return &Pairs{Args: args}
+}
+
+func (p *Pairs) Serialize() []byte {
+	return p.AppendTo(nil)
+}
+
+func (p *Pairs) AppendTo(buf []byte) []byte {
+	return appendAggregate(buf, ArrayBegin, p.Args, 2)
+}
+
+func (p *Pairs) SerializeResp3() []byte {
+	return p.AppendResp3(nil)
+}
+
+func (p *Pairs) AppendResp3(buf []byte) []byte {
+	buf = appendHeader(buf, ArrayBegin, len(p.Args)/2)
+	for i := 0; i+1 < len(p.Args); i += 2 {
+		buf = appendAggregate(buf, ArrayBegin, p.Args[i:i+2], 3)
+	}
+	return buf
+}
+
+// Set 在RESP2下编码为数组
+type Set struct {
+	Args []RespData
+}
+
+func NewSet(args []RespData) *Set {
+	return &Set{Args: args}
+}
+
+func (s *Set) Serialize() []byte {
+	return s.AppendTo(nil)
+}
+
+func (s *Set) AppendTo(buf []byte) []byte {
+	return appendAggregate(buf, ArrayBegin, s.Args, 2)
+}
+
+func (s *Set) SerializeResp3() []byte {
+	return s.AppendResp3(nil)
+}
+
+func (s *Set) AppendResp3(buf []byte) []byte {
+	return appendAggregate(buf, SetBegin, s.Args, 3)
+}
+
+// Push 是服务端主动推送的消息，在RESP2下编码为数组
+type Push struct {
+	Args []RespData
+}
+
+func NewPush(args []RespData) *Push {
+	return &Push{Args: args}
+}
+
+func (p *Push) Serialize() []byte {
+	return p.AppendTo(nil)
+}
+
+func (p *Push) AppendTo(buf []byte) []byte {
+	return appendAggregate(buf, ArrayBegin, p.Args, 2)
+}
+
+func (p *Push) SerializeResp3() []byte {
+	return p.AppendResp3(nil)
+}
+
+func (p *Push) AppendResp3(buf []byte) []byte {
+	return appendAggregate(buf, PushBegin, p.Args, 3)
+}
+
+// Double 在RESP2下编码为BulkString
+type Double struct {
+	Arg float64
+}
+
+func NewDouble(f float64) *Double {
+	return &Double{Arg: f}
+}
+
+func (d *Double) appendFloat(buf []byte) []byte {
+	switch {
+	case math.IsInf(d.Arg, 1):
+		return append(buf, "inf"...)
+	case math.IsInf(d.Arg, -1):
+		return append(buf, "-inf"...)
+	case math.IsNaN(d.Arg):
+		return append(buf, "nan"...)
+	}
+	return strconv.AppendFloat(buf, d.Arg, 'f', -1, 64)
+}
+
+func (d *Double) Serialize() []byte {
+	return d.AppendTo(nil)
+}
+
+func (d *Double) AppendTo(buf []byte) []byte {
+	return appendBulk(buf, d.appendFloat(nil))
+}
+
+func (d *Double) SerializeResp3() []byte {
+	return d.AppendResp3(nil)
+}
+
+func (d *Double) AppendResp3(buf []byte) []byte {
+	buf = append(buf, DoubleBegin)
+	buf = d.appendFloat(buf)
+	return append(buf, CRLF...)
+}
+
+// Boolean 在RESP2下编码为整数1或0
+type Boolean struct {
+	Arg bool
+}
+
+func NewBoolean(b bool) *Boolean {
+	return &Boolean{Arg: b}
+}
+
+func (b *Boolean) Serialize() []byte {
+	return b.AppendTo(nil)
+}
+
+func (b *Boolean) AppendTo(buf []byte) []byte {
+	if b.Arg {
+		return NewInteger(1).AppendTo(buf)
+	}
+	return NewInteger(0).AppendTo(buf)
+}
+
+func (b *Boolean) SerializeResp3() []byte {
+	return b.AppendResp3(nil)
+}
+
+func (b *Boolean) AppendResp3(buf []byte) []byte {
+	if b.Arg {
+		return append(buf, BooleanBegin, 't', '\r', '\n')
+	}
+	return append(buf, BooleanBegin, 'f', '\r', '\n')
+}
+
+// Null 在RESP2下编码为空的BulkString
+type Null struct{}
+
+func NewNull() *Null {
+	return &Null{}
+}
+
+func (n *Null) Serialize() []byte {
+	return n.AppendTo(nil)
+}
+
+func (n *Null) AppendTo(buf []byte) []byte {
+	return append(buf, EmptyBulkString...)
+}
+
+func (n *Null) SerializeResp3() []byte {
+	return n.AppendResp3(nil)
+}
+
+func (n *Null) AppendResp3(buf []byte) []byte {
+	return appendNull(buf)
+}
+
+// BigNumber 在RESP2下编码为BulkString
+type BigNumber struct {
+	Arg *big.Int
+}
+
+func NewBigNumber(n *big.Int) *BigNumber {
+	return &BigNumber{Arg: n}
+}
+
+func (n *BigNumber) Serialize() []byte {
+	return n.AppendTo(nil)
+}
+
+func (n *BigNumber) AppendTo(buf []byte) []byte {
+	return appendBulk(buf, n.Arg.Append(nil, 10))
+}
+
+func (n *BigNumber) SerializeResp3() []byte {
+	return n.AppendResp3(nil)
+}
+
+func (n *BigNumber) AppendResp3(buf []byte) []byte {
+	buf = append(buf, BigNumberBegin)
+	buf = n.Arg.Append(buf, 10)
+	return append(buf, CRLF...)
+}
+
+// Verbatim 是带格式的字符串，Format为3个字符，如txt、mkd
+type Verbatim struct {
+	Format string
+	Arg    []byte
+}
+
+func NewVerbatim(format string, data []byte) *Verbatim {
+	return &Verbatim{Format: format, Arg: data}
+}
+
+func (v *Verbatim) Serialize() []byte {
+	return v.AppendTo(nil)
+}
+
+func (v *Verbatim) AppendTo(buf []byte) []byte {
+	return appendBulk(buf, v.Arg)
+}
+
+func (v *Verbatim) SerializeResp3() []byte {
+	return v.AppendResp3(nil)
+}
+
+func (v *Verbatim) AppendResp3(buf []byte) []byte {
+	buf = appendHeader(buf, VerbatimBegin, len(v.Arg)+4)
+	buf = append(buf, v.Format...)
+	buf = append(buf, ':')
+	buf = append(buf, v.Arg...)
+	return append(buf, CRLF...)
+}
@@ -0,0 +1,116 @@
+package parser
+
+import (
+	"bufio"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResp3Serialize(t *testing.T) {
+	m := NewMap()
+	m.Add(NewBulkString([]byte("a")), NewInteger(1))
+	m.Add(NewBulkString([]byte("b")), NewDouble(1.5))
+	arr := NewBulkArray()
+	arr.Add([]byte("a"))
+	arr.Add(nil)
+	arr.AddString("b")
+	bset := NewBulkSet()
+	bset.AddString("x")
+	bmap := NewBulkMap()
+	bmap.AddString("f")
+	bmap.Add([]byte("v"))
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+
+	tests := []struct {
+		data  RespData
+		resp2 string
+		resp3 string
+	}{
+		{m, "*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n$3\r\n1.5\r\n", "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n,1.5\r\n"},
+		{NewSet(NewBulkStrings([][]byte{[]byte("x")})), "*1\r\n$1\r\nx\r\n", "~1\r\n$1\r\nx\r\n"},
+		{NewPush([]RespData{NewBulkString([]byte("message")), NewNull()}), "*2\r\n$7\r\nmessage\r\n$-1\r\n", ">2\r\n$7\r\nmessage\r\n_\r\n"},
+		{NewDouble(-0.25), "$5\r\n-0.25\r\n", ",-0.25\r\n"},
+		{NewDouble(math.Inf(-1)), "$4\r\n-inf\r\n", ",-inf\r\n"},
+		{NewBoolean(true), ":1\r\n", "#t\r\n"},
+		{NewBoolean(false), ":0\r\n", "#f\r\n"},
+		{NewNull(), "$-1\r\n", "_\r\n"},
+		{NewBigNumber(n), "$43\r\n" + n.String() + "\r\n", "(" + n.String() + "\r\n"},
+		{NewVerbatim("txt", []byte("Some string")), "$11\r\nSome string\r\n", "=15\r\ntxt:Some string\r\n"},
+		{MakeNullBulkReply(), "$-1\r\n", "_\r\n"},
+		{NewArray([][]byte{[]byte("a"), nil}), "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{NewInteger(7), ":7\r\n", ":7\r\n"},
+		{arr, "*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n", "*3\r\n$1\r\na\r\n_\r\n$1\r\nb\r\n"},
+		{bset, "*1\r\n$1\r\nx\r\n", "~1\r\n$1\r\nx\r\n"},
+		{bmap, "*2\r\n$1\r\nf\r\n$1\r\nv\r\n", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{NewMultiBulk([]RespData{NewDouble(1), MakeNullArrayReply()}), "*2\r\n$1\r\n1\r\n*-1\r\n", "*2\r\n,1\r\n_\r\n"},
+		{NewPairs([]RespData{NewBulkString([]byte("a")), NewDouble(1), NewBulkString([]byte("b")), NewDouble(2)}),
+			"*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", "*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2\r\n"},
+	}
+	for _, test := range tests {
+		if resp2 := string(SerializeWithProtocol(test.data, 2)); resp2 != test.resp2 {
+			t.Logf("resp2: want %q, got %q", test.resp2, resp2)
+			t.Fail()
+		}
+		if resp3 := string(SerializeWithProtocol(test.data, 3)); resp3 != test.resp3 {
+			t.Logf("resp3: want %q, got %q", test.resp3, resp3)
+			t.Fail()
+		}
+		// 追加编码时保留buf中已有的内容
+		if buf := string(AppendWithProtocol([]byte("+OK\r\n"), test.data, 3)); buf != "+OK\r\n"+test.resp3 {
+			t.Logf("append: want %q, got %q", "+OK\r\n"+test.resp3, buf)
+			t.Fail()
+		}
+	}
+}
+
+// RESP3编码的回复可以被ReadReply还原
+func TestReadResp3Reply(t *testing.T) {
+	m := NewMap()
+	m.Add(NewBulkString([]byte("a")), NewInteger(1))
+	m.Add(NewBulkString([]byte("b")), NewSet([]RespData{NewDouble(1.5), NewNull()}))
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	tests := []RespData{
+		m,
+		NewSet(NewBulkStrings([][]byte{[]byte("x"), []byte("y")})),
+		NewPush([]RespData{NewBulkString([]byte("message")), NewBoolean(true)}),
+		NewDouble(-0.25),
+		NewDouble(math.Inf(1)),
+		NewBoolean(false),
+		NewNull(),
+		NewBigNumber(n),
+		NewVerbatim("txt", []byte("Some string")),
+		NewMultiBulk([]RespData{NewMap(), NewInteger(2)}),
+	}
+	for _, data := range tests {
+		encoded := data.(Resp3Data).SerializeResp3()
+		reply, err := ReadReply(bufio.NewReader(strings.NewReader(string(encoded))))
+		if err != nil || !reflect.DeepEqual(reply, data) {
+			t.Logf("%q: got %#v %v", encoded, reply, err)
+			t.Fail()
+		}
+	}
+
+	others := []struct {
+		raw  string
+		want RespData
+	}{
+		{"!21\r\nSYNTAX invalid syntax\r\n", NewError("SYNTAX invalid syntax")},
+		{"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n:2\r\n", NewInteger(2)},
+	}
+	for _, test := range others {
+		reply, err := ReadReply(bufio.NewReader(strings.NewReader(test.raw)))
+		if err != nil || !reflect.DeepEqual(reply, test.want) {
+			t.Logf("%q: got %#v %v", test.raw, reply, err)
+			t.Fail()
+		}
+	}
+	for _, raw := range []string{"#x\r\n", ",abc\r\n", "_1\r\n", "(12a\r\n", "=3\r\ntxt\r\n", "%-1\r\n"} {
+		if _, err := ReadReply(bufio.NewReader(strings.NewReader(raw))); err == nil {
+			t.Logf("%q should fail", raw)
+			t.Fail()
+		}
+	}
+}
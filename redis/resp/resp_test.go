@@ -2,6 +2,7 @@ package parser
 
 import (
 	"bytes"
+	"strconv"
 	"testing"
 )
 
@@ -33,3 +34,32 @@ func TestRESP(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// 600个元素的LRANGE回复
+func BenchmarkArraySerialize(b *testing.B) {
+	args := make([][]byte, 600)
+	for i := range args {
+		args[i] = []byte("element:" + strconv.Itoa(i))
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewArray(args).Serialize()
+	}
+}
+
+func BenchmarkBulkArrayAppendTo(b *testing.B) {
+	args := make([][]byte, 600)
+	for i := range args {
+		args[i] = []byte("element:" + strconv.Itoa(i))
+	}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reply := NewBulkArray()
+		reply.Grow(len(args))
+		for _, arg := range args {
+			reply.Add(arg)
+		}
+		buf = reply.AppendTo(buf[:0])
+	}
+}
@@ -1,20 +1,209 @@
 package handler
 
 import (
+	"bufio"
+	"errors"
 	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/HK40404/simpredis/redis/database"
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+const (
+	// 回复缓冲区大小，超过后直接发送
+	replyBufferSize = 16 * 1024
+	// 客户端迟迟不读取回复时，写超时后关闭连接
+	writeTimeout = 10 * time.Second
+	// 编码缓冲区超过这个大小后不再复用
+	maxEncodeBufferSize = 64 * 1024
 )
 
+var clientID atomic.Int64
+
 type Client struct {
-	Conn net.Conn
-	Wg   sync.WaitGroup
+	Conn   net.Conn
+	Wg     sync.WaitGroup
+	mu     sync.Mutex // 其他连接publish时也会写入，保护writer和buf
+	writer *bufio.Writer
+	buf    []byte // 复用的回复编码缓冲区
+
+	ID       int64
+	Protocol int // 通过hello协商的RESP版本
+	Name     string
+	Authed   bool
+	Session  database.Session // 选择的数据库
+
+	// 订阅的频道和模式，只由连接的goroutine修改
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// 阻塞命令等待期间在后台读取连接，及时发现客户端断开
+	closed   chan struct{} // 读到错误时关闭
+	bgDone   chan struct{} // 后台读取结束时关闭
+	aborting atomic.Bool   // 阻塞结束，通过读超时中断后台读取
+	pending  []byte        // 后台读到的数据，留给之后的命令
+	readErr  error         // 后台读取遇到的错误
+	maxQuery int           // pending的上限，即client-query-buffer-limit，为0时不限制
+}
+
+// 每次真正写入连接前设置写超时，防止被读得慢的客户端一直阻塞
+type timeoutWriter struct {
+	conn net.Conn
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	if err := w.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return 0, err
+	}
+	return w.conn.Write(p)
+}
+
+// 需要从连接读取数据时，说明已经处理完缓冲区中的所有命令，先把缓冲的回复发送出去
+type flushReader struct {
+	client *Client
+}
+
+func (r *flushReader) Read(p []byte) (int, error) {
+	c := r.client
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
 }
 
 func NewClient(con net.Conn) *Client {
-	return &Client{
-		Conn: con,
+	c := &Client{
+		Conn:     con,
+		writer:   bufio.NewWriterSize(&timeoutWriter{con}, replyBufferSize),
+		ID:       clientID.Add(1),
+		Protocol: 2,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	c.closed = make(chan struct{})
+	c.Session.Closed = c.closed
+	// 阻塞命令等待期间先把之前命令的回复发送出去，并在后台读取连接
+	c.Session.BeforeBlock = func() {
+		_ = c.Flush()
+		c.startBackgroundRead()
+	}
+	c.Session.AfterBlock = c.abortBackgroundRead
+	return c
+}
+
+// 连接断开时读取会返回错误，读到的命令保存在pending中，阻塞结束后再处理
+func (c *Client) startBackgroundRead() {
+	if c.readErr != nil {
+		return
 	}
+	c.bgDone = make(chan struct{})
+	c.aborting.Store(false)
+	go func() {
+		defer close(c.bgDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := c.Conn.Read(buf)
+			c.pending = append(c.pending, buf[:n]...)
+			// 和正常读取一样，超过限制的客户端回复协议错误后断开，已经读到的数据直接丢弃
+			if err == nil && c.maxQuery > 0 && len(c.pending) > c.maxQuery {
+				c.pending = nil
+				err = parser.NewProtocolError("too big query buffer")
+			}
+			if err != nil {
+				if c.aborting.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+					return
+				}
+				c.readErr = err
+				close(c.closed)
+				return
+			}
+		}
+	}()
+}
+
+// 设置一个已经过去的读超时让后台读取返回，等待它结束后恢复
+func (c *Client) abortBackgroundRead() {
+	if c.bgDone == nil {
+		return
+	}
+	c.aborting.Store(true)
+	_ = c.Conn.SetReadDeadline(time.Unix(1, 0))
+	<-c.bgDone
+	c.bgDone = nil
+	_ = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// 回复先写入缓冲区，缓冲区满时才会写入连接
+func (c *Client) Write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.write(data)
+}
+
+func (c *Client) write(data []byte) error {
+	c.Wg.Add(1)
+	defer c.Wg.Done()
+	_, err := c.writer.Write(data)
+	return err
+}
+
+// 按照客户端协商的协议编码回复，写入缓冲区
+func (c *Client) WriteReply(reply parser.RespData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeReply(reply)
+}
+
+func (c *Client) writeReply(reply parser.RespData) error {
+	c.buf = parser.AppendWithProtocol(c.buf[:0], reply, c.Protocol)
+	err := c.write(c.buf)
+	if cap(c.buf) > maxEncodeBufferSize {
+		c.buf = nil
+	}
+	return err
+}
+
+// 把缓冲区中的回复全部发送出去
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+func (c *Client) flush() error {
+	if c.writer.Buffered() == 0 {
+		return nil
+	}
+	c.Wg.Add(1)
+	defer c.Wg.Done()
+	return c.writer.Flush()
+}
+
+// 发送订阅的消息。连接可能正在等待读取新的命令，需要立即发送
+func (c *Client) Push(reply parser.RespData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.writeReply(reply); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// 订阅的频道和模式总数
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
 }
 
 // 给还在传输数据的连接一些时间处理
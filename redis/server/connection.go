@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"strings"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+const (
+	serverName    = "simpredis"
+	serverVersion = "1.0.0"
+)
+
+// 需要连接状态的命令在这里处理，ok为false时交给数据库执行
+func (handler *RedisServer) execConnCmd(client *Client, args [][]byte) (reply parser.RespData, ok bool) {
+	cmd := strings.ToLower(string(args[0]))
+	switch cmd {
+	case "hello":
+		return execHello(client, args), true
+	case "auth":
+		return execAuth(client, args), true
+	}
+	if config.Cfg.RequirePass != "" && !client.Authed {
+		return parser.NewError("NOAUTH Authentication required."), true
+	}
+	if client.Protocol == 2 && client.subscriptions() > 0 && !subscribedAllowed(cmd) {
+		return parser.NewError("ERR Can't execute '" + cmd + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context"), true
+	}
+	switch cmd {
+	case "client":
+		return execClient(client, args), true
+	case "subscribe":
+		return handler.execSubscribe(client, args, false), true
+	case "psubscribe":
+		return handler.execSubscribe(client, args, true), true
+	case "unsubscribe":
+		return handler.execUnsubscribe(client, args, false), true
+	case "punsubscribe":
+		return handler.execUnsubscribe(client, args, true), true
+	case "publish":
+		return handler.execPublish(args), true
+	case "pubsub":
+		return handler.execPubSubInfo(args), true
+	case "ping":
+		if client.Protocol == 2 && client.subscriptions() > 0 {
+			return execSubscribedPing(args), true
+		}
+	}
+	return nil, false
+}
+
+// 只有default一个用户，密码为requirepass
+func checkPassword(username, password string) parser.RespData {
+	if config.Cfg.RequirePass == "" {
+		return parser.NewError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if username != "default" || subtle.ConstantTimeCompare([]byte(password), []byte(config.Cfg.RequirePass)) != 1 {
+		return parser.NewError("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	return nil
+}
+
+// auth [username] password
+func execAuth(client *Client, args [][]byte) parser.RespData {
+	var errReply parser.RespData
+	switch len(args) {
+	case 2:
+		errReply = checkPassword("default", string(args[1]))
+	case 3:
+		errReply = checkPassword(string(args[1]), string(args[2]))
+	default:
+		return parser.NewError("Invalid command format")
+	}
+	if errReply != nil {
+		return errReply
+	}
+	client.Authed = true
+	return parser.MakeOKReply()
+}
+
+// hello [protover [AUTH username password] [SETNAME clientname]]
+// 切换协议版本，回复使用新的协议编码
+func execHello(client *Client, args [][]byte) parser.RespData {
+	protocol := client.Protocol
+	if len(args) >= 2 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return parser.NewError("ERR Protocol version is not an integer or out of range")
+		}
+		if v != 2 && v != 3 {
+			return parser.NewError("NOPROTO unsupported protocol version")
+		}
+		protocol = v
+	}
+
+	var username, password, name []byte
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if i+2 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			username, password = args[i+1], args[i+2]
+			i += 2
+		case "setname":
+			if i+1 >= len(args) {
+				return parser.NewError("Invalid command format")
+			}
+			name = args[i+1]
+			if !validClientName(name) {
+				return parser.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		default:
+			return parser.NewError("Invalid command format")
+		}
+	}
+
+	if username != nil {
+		if errReply := checkPassword(string(username), string(password)); errReply != nil {
+			return errReply
+		}
+		client.Authed = true
+	}
+	if config.Cfg.RequirePass != "" && !client.Authed {
+		return parser.NewError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		client.Name = string(name)
+	}
+	client.Protocol = protocol
+
+	info := parser.NewMap()
+	info.Add(parser.NewBulkString([]byte("server")), parser.NewBulkString([]byte(serverName)))
+	info.Add(parser.NewBulkString([]byte("version")), parser.NewBulkString([]byte(serverVersion)))
+	info.Add(parser.NewBulkString([]byte("proto")), parser.NewInteger(int64(protocol)))
+	info.Add(parser.NewBulkString([]byte("id")), parser.NewInteger(client.ID))
+	info.Add(parser.NewBulkString([]byte("mode")), parser.NewBulkString([]byte("standalone")))
+	info.Add(parser.NewBulkString([]byte("role")), parser.NewBulkString([]byte("master")))
+	info.Add(parser.NewBulkString([]byte("modules")), parser.NewArray(nil))
+	return info
+}
+
+// client id|getname|setname name
+func execClient(client *Client, args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	switch strings.ToLower(string(args[1])) {
+	case "id":
+		if len(args) != 2 {
+			return parser.NewError("Invalid command format")
+		}
+		return parser.NewInteger(client.ID)
+	case "getname":
+		if len(args) != 2 {
+			return parser.NewError("Invalid command format")
+		}
+		if client.Name == "" {
+			return parser.MakeNullBulkReply()
+		}
+		return parser.NewBulkString([]byte(client.Name))
+	case "setname":
+		if len(args) != 3 {
+			return parser.NewError("Invalid command format")
+		}
+		if !validClientName(args[2]) {
+			return parser.NewError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.Name = string(args[2])
+		return parser.MakeOKReply()
+	}
+	return parser.NewError("ERR unknown subcommand '" + string(args[1]) + "'")
+}
+
+// 名字只能包含空格以外的可见字符
+func validClientName(name []byte) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
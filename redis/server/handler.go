@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"errors"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
 	"github.com/HK40404/simpredis/redis/database"
 	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/config"
 	"github.com/HK40404/simpredis/utils/logger"
 )
 
@@ -15,6 +18,8 @@ type RedisServer struct {
 	conns   sync.Map // 管理连接
 	closing atomic.Bool
 	engine  *database.DBEngine // 用于操作数据库的manager
+	limits  parser.Limits      // 客户端命令的大小限制
+	pubsub  *pubSub
 }
 
 func (handler *RedisServer) Handle(conn net.Conn) {
@@ -24,54 +29,44 @@ func (handler *RedisServer) Handle(conn net.Conn) {
 	}
 
 	client := NewClient(conn)
+	client.maxQuery = handler.limits.MaxQueryLen
 	defer client.Close()
 	handler.conns.Store(client, struct{}{})
+	defer handler.pubsub.removeClient(client)
 
-	ch := parser.ParseStream(conn)
-	for request := range ch {
-		if request.Err != nil {
-			if request.Err == io.EOF {
+	// 只有命令都处理完，需要从连接读取新数据时才发送缓冲的回复，
+	// 这样客户端pipeline发送的多条命令的回复可以合并成一次写入
+	reader := parser.NewReader(&flushReader{client})
+	reader.SetLimits(handler.limits)
+	// 退出前把还没发送的回复发送出去
+	defer client.Flush()
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			var perr *parser.ProtocolError
+			if err == io.EOF {
 				logger.Info("Connection closed: %s", conn.RemoteAddr().String())
+			} else if errors.As(err, &perr) {
+				// 格式错误时告知客户端再关闭连接
+				logger.Warn("Protocol error from %s: %v", conn.RemoteAddr().String(), perr)
+				client.WriteReply(parser.NewError("ERR " + perr.Error()))
 			} else {
-				logger.Error("Get error: %v", request.Err)
+				logger.Error("Get error: %v", err)
 			}
 			return
 		}
-		if request.Data == nil {
-			logger.Error("Parsed empty payload")
-			continue
-		}
 
-		var reply parser.RespData
-		if _, ok := request.Data.(*parser.String); ok {
-			// ping inline
-			reply = parser.NewString("PONG")
-		} else {
-			// array command
-			array, ok := request.Data.(*parser.Array)
-			if !ok {
-				logger.Error("command format is not RESP array")
-				return
-			}
-			reply = handler.engine.ExecCmd(array.Args)
+		reply, ok := handler.execConnCmd(client, args)
+		if !ok {
+			reply = handler.engine.Exec(&client.Session, args)
+		}
+		if reply == nil {
+			reply = parser.NewError("ERR Unknow")
 		}
 
-		if reply != nil {
-			client.Wg.Add(1)
-			if _, err := conn.Write(reply.Serialize()); err != nil {
-				logger.Error("Fail to send data: %v, closing connection", err)
-				client.Wg.Done()
-				return
-			}
-			client.Wg.Done()
-		} else {
-			client.Wg.Add(1)
-			if _, err := conn.Write(parser.NewError("ERR Unknow").Serialize()); err != nil {
-				logger.Error("Fail to send data: %v, closing connection", err)
-				client.Wg.Done()
-				return
-			}
-			client.Wg.Done()
+		if err := client.WriteReply(reply); err != nil {
+			logger.Error("Fail to send data: %v, closing connection", err)
+			return
 		}
 	}
 }
@@ -85,10 +80,34 @@ func (handler *RedisServer) Close() {
 		client.Close()
 		return true
 	})
+	handler.engine.Close()
 }
 
-func NewHandler() *RedisServer {
-	ser := &RedisServer{engine: database.NewDBEngine()}
+func NewHandler(engine *database.DBEngine) *RedisServer {
+	ser := &RedisServer{engine: engine, limits: loadLimits(), pubsub: newPubSub()}
 	ser.closing.Store(false)
 	return ser
 }
+
+func loadLimits() parser.Limits {
+	bulkLen, err := config.ParseMemory(config.Cfg.ProtoMaxBulkLen)
+	if err != nil || bulkLen <= 0 {
+		logger.Warn("Invalid proto-max-bulk-len from config, set proto-max-bulk-len = 512mb")
+		bulkLen = 512 << 20
+	}
+	multiBulkLen, err := strconv.Atoi(config.Cfg.MaxMultibulkLen)
+	if err != nil || multiBulkLen <= 0 {
+		logger.Warn("Invalid max-multibulk-len from config, set max-multibulk-len = 1048576")
+		multiBulkLen = 1 << 20
+	}
+	queryLen, err := config.ParseMemory(config.Cfg.ClientQueryBufferLimit)
+	if err != nil || queryLen <= 0 {
+		logger.Warn("Invalid client-query-buffer-limit from config, set client-query-buffer-limit = 1gb")
+		queryLen = 1 << 30
+	}
+	return parser.Limits{
+		MaxBulkLen:      int(bulkLen),
+		MaxMultiBulkLen: multiBulkLen,
+		MaxQueryLen:     int(queryLen),
+	}
+}
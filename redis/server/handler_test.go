@@ -0,0 +1,354 @@
+package handler
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HK40404/simpredis/redis/database"
+	parser "github.com/HK40404/simpredis/redis/resp"
+	cli "github.com/HK40404/simpredis/utils/client"
+	"github.com/HK40404/simpredis/utils/config"
+)
+
+type testConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestConn(t *testing.T) *testConn {
+	server, client := net.Pipe()
+	handler := NewHandler(database.NewDBEngine())
+	go handler.Handle(server)
+	t.Cleanup(func() { client.Close() })
+	return &testConn{conn: client, reader: bufio.NewReader(client)}
+}
+
+// 发送一条命令，读取n字节的回复
+func (c *testConn) do(t *testing.T, line string, n int) string {
+	if _, err := c.conn.Write(parser.NewArray(cli.LineToArgs(line)).Serialize()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return string(buf)
+}
+
+func (c *testConn) expect(t *testing.T, line, want string) {
+	if got := c.do(t, line, len(want)); got != want {
+		t.Logf("%s: want %q, got %q", line, want, got)
+		t.Fail()
+	}
+}
+
+func TestHello(t *testing.T) {
+	c := newTestConn(t)
+	c.expect(t, "hset h f 1.5", ":1\r\n")
+	c.expect(t, "hgetall h", "*2\r\n$1\r\nf\r\n$3\r\n1.5\r\n")
+	c.expect(t, "hincrbyfloat h f 1", "$3\r\n2.5\r\n")
+	c.expect(t, "hello 4", "-NOPROTO unsupported protocol version\r\n")
+
+	reply := c.do(t, "hello 3 setname conn1", 128)
+	if !strings.HasPrefix(reply, "%7\r\n$6\r\nserver\r\n$9\r\nsimpredis\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:3\r\n") {
+		t.Logf("wrong hello reply %q", reply)
+		t.FailNow()
+	}
+	// 读掉剩余的回复
+	c.reader.ReadString('*')
+	c.reader.ReadString('\n')
+
+	c.expect(t, "hgetall h", "%1\r\n$1\r\nf\r\n$3\r\n2.5\r\n")
+	c.expect(t, "hincrbyfloat h f 1", ",3.5\r\n")
+	c.expect(t, "sadd s a", ":1\r\n")
+	c.expect(t, "smembers s", "~1\r\n$1\r\na\r\n")
+	c.expect(t, "get nokey", "_\r\n")
+	// 和redis 7一样，带分数时每个成员和分数组成一个二元组
+	c.expect(t, "zadd z 1 a 2.5 b", ":2\r\n")
+	c.expect(t, "zrange z 0 -1 withscores", "*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2.5\r\n")
+	c.expect(t, "zpopmin z", "*2\r\n$1\r\na\r\n,1\r\n")
+	c.expect(t, "client getname", "$5\r\nconn1\r\n")
+}
+
+func TestAuth(t *testing.T) {
+	old := config.Cfg.RequirePass
+	config.Cfg.RequirePass = "secret"
+	t.Cleanup(func() { config.Cfg.RequirePass = old })
+
+	c := newTestConn(t)
+	c.expect(t, "get k", "-NOAUTH Authentication required.\r\n")
+	c.expect(t, "auth wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	reply := c.do(t, "hello 3", 7)
+	if reply != "-NOAUTH" {
+		t.Logf("hello without auth should fail, got %q", reply)
+		t.FailNow()
+	}
+	c.reader.ReadString('\n')
+
+	if reply := c.do(t, "hello 3 auth default secret", 4); reply != "%7\r\n" {
+		t.Logf("wrong hello reply %q", reply)
+		t.FailNow()
+	}
+	c.reader.ReadString('*')
+	c.reader.ReadString('\n')
+	c.expect(t, "get k", "_\r\n")
+	c.expect(t, "auth default secret", "+OK\r\n")
+}
+
+func TestInlineCommand(t *testing.T) {
+	c := newTestConn(t)
+	want := "+OK\r\n$3\r\na b\r\n+PONG\r\n"
+	go c.conn.Write([]byte("set k \"a b\"\r\nget k\n\r\nPING\r\n"))
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+		t.Logf("want %q, got %q", want, buf)
+		t.Fail()
+	}
+
+	want = "-ERR Protocol error: unbalanced quotes in request\r\n"
+	go c.conn.Write([]byte("set k \"x\r\n"))
+	buf = make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+		t.Logf("want %q, got %q", want, buf)
+		t.Fail()
+	}
+}
+
+// 统计服务端写入连接的次数
+type countConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestPipeline(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := &countConn{Conn: server}
+	go NewHandler(database.NewDBEngine()).Handle(conn)
+
+	n := 100
+	var req, want strings.Builder
+	for i := 1; i <= n; i++ {
+		req.Write(parser.NewArray(cli.LineToArgs("incr k")).Serialize())
+		want.WriteString(":" + strconv.Itoa(i) + "\r\n")
+	}
+	go client.Write([]byte(req.String()))
+	buf := make([]byte, want.Len())
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != want.String() {
+		t.Logf("want %q, got %q", want.String(), buf)
+		t.FailNow()
+	}
+	// pipeline的回复应该合并发送
+	if writes := conn.writes.Load(); writes >= int64(n/2) {
+		t.Logf("%d commands cost %d writes", n, writes)
+		t.Fail()
+	}
+}
+
+// 连接复用参数数组，保存参数的命令不能受之后的命令影响
+func TestArgsReuse(t *testing.T) {
+	c := newTestConn(t)
+	c.expect(t, "xadd s 1-0 f v", "$3\r\n1-0\r\n")
+	c.expect(t, "set key value", "+OK\r\n")
+	c.expect(t, "xrange s - +", "*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n")
+}
+
+func TestPartialCommand(t *testing.T) {
+	c := newTestConn(t)
+	// 第二条命令只发送了一部分，第一条命令的回复也要及时发送
+	go c.conn.Write([]byte("*2\r\n$4\r\nincr\r\n$1\r\nk\r\n*2\r\n$4\r\nincr\r\n"))
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := c.reader.ReadString('\n'); err != nil || line != ":1\r\n" {
+		t.Logf("want :1, got %q %v", line, err)
+		t.FailNow()
+	}
+	go c.conn.Write([]byte("$1\r\nk\r\n"))
+	if line, err := c.reader.ReadString('\n'); err != nil || line != ":2\r\n" {
+		t.Logf("want :2, got %q %v", line, err)
+		t.Fail()
+	}
+}
+
+func TestProtocolLimits(t *testing.T) {
+	old := *config.Cfg
+	config.Cfg.ProtoMaxBulkLen = "1k"
+	t.Cleanup(func() { *config.Cfg = old })
+
+	c := newTestConn(t)
+	c.expect(t, "set k v", "+OK\r\n")
+	want := "-ERR Protocol error: invalid bulk length\r\n"
+	go c.conn.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$4000000000\r\n"))
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+		t.Logf("want %q, got %q", want, buf)
+		t.FailNow()
+	}
+	// 超过限制的客户端会被断开
+	if _, err := c.reader.ReadByte(); err != io.EOF {
+		t.Logf("connection should be closed, got %v", err)
+		t.Fail()
+	}
+}
+
+// 每个连接单独保存选择的数据库
+func TestSelectPerConnection(t *testing.T) {
+	handler := NewHandler(database.NewDBEngine())
+	conns := make([]*testConn, 2)
+	for i := range conns {
+		server, client := net.Pipe()
+		go handler.Handle(server)
+		t.Cleanup(func() { client.Close() })
+		conns[i] = &testConn{conn: client, reader: bufio.NewReader(client)}
+	}
+	conns[0].expect(t, "select 3", "+OK\r\n")
+	conns[0].expect(t, "set k v3", "+OK\r\n")
+	conns[1].expect(t, "get k", "$-1\r\n")
+	conns[1].expect(t, "set k v0", "+OK\r\n")
+	conns[0].expect(t, "get k", "$2\r\nv3\r\n")
+	conns[1].expect(t, "select 16", "-ERR DB index is out of range\r\n")
+	conns[1].expect(t, "get k", "$2\r\nv0\r\n")
+}
+
+// 阻塞期间发送的命令在阻塞结束后处理
+func TestCommandWhileBlocked(t *testing.T) {
+	c := newTestConn(t)
+	req := parser.NewArray(cli.LineToArgs("xread block 100 streams s $")).Serialize()
+	req = append(req, parser.NewArray(cli.LineToArgs("ping")).Serialize()...)
+	if _, err := c.conn.Write(req); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	want := "*-1\r\n+PONG\r\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+		t.Logf("want %q, got %q", want, buf)
+		t.Fail()
+	}
+}
+
+// 客户端断开后阻塞的命令不再等待，连接的goroutine退出
+func TestDisconnectWhileBlocked(t *testing.T) {
+	server, client := net.Pipe()
+	engine := database.NewDBEngine()
+	defer engine.Close()
+	exited := make(chan struct{})
+	go func() {
+		NewHandler(engine).Handle(server)
+		close(exited)
+	}()
+	if _, err := client.Write(parser.NewArray(cli.LineToArgs("xread block 0 streams s $")).Serialize()); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Log("blocked connection should exit after the client disconnects")
+		t.Fail()
+	}
+}
+
+// 阻塞期间后台读到的数据同样受client-query-buffer-limit限制，超过时回复协议错误后断开
+func TestQueryLimitWhileBlocked(t *testing.T) {
+	old := *config.Cfg
+	config.Cfg.ClientQueryBufferLimit = "1k"
+	t.Cleanup(func() { *config.Cfg = old })
+
+	c := newTestConn(t)
+	if _, err := c.conn.Write(parser.NewArray(cli.LineToArgs("xread block 0 streams s $")).Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	var req []byte
+	for len(req) < 8192 {
+		req = append(req, parser.NewArray(cli.LineToArgs("ping")).Serialize()...)
+	}
+	// 超过限制之后服务端不再读取，剩下的数据写不进去
+	go c.conn.Write(req)
+	want := "*-1\r\n-ERR Protocol error: too big query buffer\r\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+		t.Logf("want %q, got %q %v", want, buf, err)
+		t.FailNow()
+	}
+	if _, err := c.reader.ReadByte(); err != io.EOF {
+		t.Logf("connection should be closed, got %v", err)
+		t.Fail()
+	}
+}
+
+// 订阅之后收到其他连接publish的消息，RESP2下只能执行订阅相关的命令，RESP3下消息是push
+func TestPubSub(t *testing.T) {
+	handler := NewHandler(database.NewDBEngine())
+	conns := make([]*testConn, 3)
+	for i := range conns {
+		server, client := net.Pipe()
+		go handler.Handle(server)
+		t.Cleanup(func() { client.Close() })
+		conns[i] = &testConn{conn: client, reader: bufio.NewReader(client)}
+	}
+	sub, psub, pub := conns[0], conns[1], conns[2]
+	sub.expect(t, "subscribe news sports", "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$6\r\nsports\r\n:2\r\n")
+	sub.expect(t, "get k", "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n")
+	sub.expect(t, "ping", "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+	pub.expect(t, "pubsub channels", "*2\r\n$4\r\nnews\r\n$6\r\nsports\r\n")
+	pub.expect(t, "pubsub numsub news nokey", "*4\r\n$4\r\nnews\r\n:1\r\n$5\r\nnokey\r\n:0\r\n")
+
+	psub.expect(t, "hello 3", "%7\r\n")
+	psub.reader.ReadString('*')
+	psub.reader.ReadString('\n')
+	psub.expect(t, "psubscribe n*", ">3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n")
+	// RESP3下订阅之后可以执行其他命令
+	psub.expect(t, "set k v", "+OK\r\n")
+	pub.expect(t, "pubsub numpat", ":1\r\n")
+
+	// net.Pipe是同步的，publish等订阅者读取消息之后才会回复
+	if _, err := pub.conn.Write(parser.NewArray(cli.LineToArgs("publish news hello")).Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	readExpect := func(c *testConn, want string) {
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(c.reader, buf); err != nil || string(buf) != want {
+			t.Logf("want %q, got %q %v", want, buf, err)
+			t.Fail()
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		readExpect(sub, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	}()
+	readExpect(psub, ">4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	<-done
+	readExpect(pub, ":2\r\n")
+
+	sub.expect(t, "unsubscribe news", "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	sub.expect(t, "unsubscribe", "*3\r\n$11\r\nunsubscribe\r\n$6\r\nsports\r\n:0\r\n")
+	sub.expect(t, "get k", "$1\r\nv\r\n")
+	pub.expect(t, "publish sports hello", ":0\r\n")
+
+	// 断开的连接取消所有订阅
+	psub.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for pub.do(t, "pubsub numpat", 4) != ":0\r\n" {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions are not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+	"github.com/HK40404/simpredis/utils/glob"
+)
+
+// pubSub 记录每个频道和模式的订阅者，连接自己的订阅在Client中，只由连接的goroutine修改
+type pubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+func subscribeIn(m map[string]map[*Client]struct{}, name string, client *Client) {
+	clients, ok := m[name]
+	if !ok {
+		clients = make(map[*Client]struct{})
+		m[name] = clients
+	}
+	clients[client] = struct{}{}
+}
+
+func unsubscribeIn(m map[string]map[*Client]struct{}, name string, client *Client) {
+	delete(m[name], client)
+	if len(m[name]) == 0 {
+		delete(m, name)
+	}
+}
+
+// 连接断开时取消所有订阅
+func (ps *pubSub) removeClient(client *Client) {
+	if len(client.channels) == 0 && len(client.patterns) == 0 {
+		return
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for name := range client.channels {
+		unsubscribeIn(ps.channels, name, client)
+	}
+	for name := range client.patterns {
+		unsubscribeIn(ps.patterns, name, client)
+	}
+}
+
+// 多个回复依次发送，用于一次订阅多个频道时每个频道一条确认
+type replies []parser.RespData
+
+func (r replies) Serialize() []byte {
+	return r.AppendTo(nil)
+}
+
+func (r replies) AppendTo(buf []byte) []byte {
+	for _, reply := range r {
+		buf = reply.AppendTo(buf)
+	}
+	return buf
+}
+
+func (r replies) SerializeResp3() []byte {
+	return r.AppendResp3(nil)
+}
+
+func (r replies) AppendResp3(buf []byte) []byte {
+	for _, reply := range r {
+		buf = parser.AppendWithProtocol(buf, reply, 3)
+	}
+	return buf
+}
+
+// 订阅和取消订阅的确认：[kind, name, 当前订阅的频道和模式总数]
+func subscriptionReply(kind string, name []byte, client *Client) parser.RespData {
+	var nameReply parser.RespData = parser.NewBulkString(name)
+	if name == nil {
+		nameReply = parser.NewNull()
+	}
+	return parser.NewPush([]parser.RespData{
+		parser.NewBulkString([]byte(kind)),
+		nameReply,
+		parser.NewInteger(int64(client.subscriptions())),
+	})
+}
+
+// subscribe channel [channel ...]和psubscribe pattern [pattern ...]
+func (handler *RedisServer) execSubscribe(client *Client, args [][]byte, pattern bool) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	kind, own, all := "subscribe", client.channels, handler.pubsub.channels
+	if pattern {
+		kind, own, all = "psubscribe", client.patterns, handler.pubsub.patterns
+	}
+	result := make(replies, 0, len(args)-1)
+	handler.pubsub.mu.Lock()
+	for _, name := range args[1:] {
+		if _, ok := own[string(name)]; !ok {
+			own[string(name)] = struct{}{}
+			subscribeIn(all, string(name), client)
+		}
+		result = append(result, subscriptionReply(kind, name, client))
+	}
+	handler.pubsub.mu.Unlock()
+	return result
+}
+
+// unsubscribe [channel ...]和punsubscribe [pattern ...]，不指定时取消所有订阅
+func (handler *RedisServer) execUnsubscribe(client *Client, args [][]byte, pattern bool) parser.RespData {
+	kind, own, all := "unsubscribe", client.channels, handler.pubsub.channels
+	if pattern {
+		kind, own, all = "punsubscribe", client.patterns, handler.pubsub.patterns
+	}
+	names := args[1:]
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, []byte(name))
+		}
+		// 和redis一样，没有任何订阅时也回复一次
+		if len(names) == 0 {
+			return replies{subscriptionReply(kind, nil, client)}
+		}
+	}
+	result := make(replies, 0, len(names))
+	handler.pubsub.mu.Lock()
+	for _, name := range names {
+		if _, ok := own[string(name)]; ok {
+			delete(own, string(name))
+			unsubscribeIn(all, string(name), client)
+		}
+		result = append(result, subscriptionReply(kind, name, client))
+	}
+	handler.pubsub.mu.Unlock()
+	return result
+}
+
+// publish channel message：返回收到消息的连接数，通过模式订阅的连接每个匹配的模式收到一次
+func (handler *RedisServer) execPublish(args [][]byte) parser.RespData {
+	if len(args) != 3 {
+		return parser.NewError("Invalid command format")
+	}
+	// 连接复用参数数组，发送给其他连接的数据需要拷贝
+	channel, payload := args[1], args[2]
+	type delivery struct {
+		client  *Client
+		message parser.RespData
+	}
+	var deliveries []delivery
+
+	// 持有读锁时只收集订阅者，发送时不持有锁，读得慢的连接不会阻塞其他订阅操作
+	ps := handler.pubsub
+	ps.mu.RLock()
+	if clients, ok := ps.channels[string(channel)]; ok {
+		message := parser.NewPush([]parser.RespData{
+			parser.NewBulkString([]byte("message")),
+			parser.NewBulkString(bytes.Clone(channel)),
+			parser.NewBulkString(bytes.Clone(payload)),
+		})
+		for client := range clients {
+			deliveries = append(deliveries, delivery{client, message})
+		}
+	}
+	for pattern, clients := range ps.patterns {
+		if !glob.Match(pattern, string(channel)) {
+			continue
+		}
+		message := parser.NewPush([]parser.RespData{
+			parser.NewBulkString([]byte("pmessage")),
+			parser.NewBulkString([]byte(pattern)),
+			parser.NewBulkString(bytes.Clone(channel)),
+			parser.NewBulkString(bytes.Clone(payload)),
+		})
+		for client := range clients {
+			deliveries = append(deliveries, delivery{client, message})
+		}
+	}
+	ps.mu.RUnlock()
+
+	// 不读取的订阅者最多阻塞writeTimeout，写入失败之后bufio.Writer不能再使用，关闭连接让它的goroutine清理订阅
+	for _, d := range deliveries {
+		if err := d.client.Push(d.message); err != nil {
+			d.client.Conn.Close()
+		}
+	}
+	return parser.NewInteger(int64(len(deliveries)))
+}
+
+// pubsub channels [pattern] | numsub [channel ...] | numpat
+func (handler *RedisServer) execPubSubInfo(args [][]byte) parser.RespData {
+	if len(args) < 2 {
+		return parser.NewError("Invalid command format")
+	}
+	ps := handler.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	switch strings.ToLower(string(args[1])) {
+	case "channels":
+		if len(args) > 3 {
+			return parser.NewError("Invalid command format")
+		}
+		var names []string
+		for name := range ps.channels {
+			if len(args) == 2 || glob.Match(string(args[2]), name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		result := parser.NewBulkArray()
+		for _, name := range names {
+			result.AddString(name)
+		}
+		return result
+	case "numsub":
+		result := make([]parser.RespData, 0, (len(args)-2)*2)
+		for _, name := range args[2:] {
+			result = append(result, parser.NewBulkString(name), parser.NewInteger(int64(len(ps.channels[string(name)]))))
+		}
+		return parser.NewMultiBulk(result)
+	case "numpat":
+		if len(args) != 2 {
+			return parser.NewError("Invalid command format")
+		}
+		return parser.NewInteger(int64(len(ps.patterns)))
+	}
+	return parser.NewError("ERR unknown subcommand '" + string(args[1]) + "'")
+}
+
+// RESP2下订阅之后只能执行订阅相关的命令，RESP3下回复和推送可以区分，不受限制
+func subscribedAllowed(cmd string) bool {
+	switch cmd {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ping":
+		return true
+	}
+	return false
+}
+
+// 订阅状态下的ping回复数组["pong", message]
+func execSubscribedPing(args [][]byte) parser.RespData {
+	if len(args) > 2 {
+		return parser.NewError("Invalid command format")
+	}
+	message := []byte{}
+	if len(args) == 2 {
+		message = args[1]
+	}
+	return parser.NewMultiBulk([]parser.RespData{parser.NewBulkString([]byte("pong")), parser.NewBulkString(message)})
+}
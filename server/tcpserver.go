@@ -32,7 +32,9 @@ func ListenAndServe(cfg *config.Config, handler Handler) error {
 	logger.Info("Server start listening at %s", addr)
 
 	// 优雅关闭
+	closedCh := make(chan struct{})
 	go func() {
+		defer close(closedCh)
 		select {
 		case s := <-closeCh:
 			logger.Error("Get signal:%v", s)
@@ -78,5 +80,7 @@ func ListenAndServe(cfg *config.Config, handler Handler) error {
 	case <-time.After(10 * time.Second):
 		logger.Info("Timeout: shutting down server")
 	}
+	// 等待handler关闭完成，保证持久化数据落盘
+	<-closedCh
 	return nil
 }
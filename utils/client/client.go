@@ -1,7 +1,20 @@
 package client
 
-import "strings"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 只按空格切分，用于测试。需要支持引号和转义时使用SplitArgs
 func LineToArgs(line string) [][]byte {
 	line = strings.Trim(line, " ")
 	argstrs := strings.Split(line, " ")
@@ -11,3 +24,156 @@ func LineToArgs(line string) [][]byte {
 	}
 	return args
 }
+
+// SplitArgs 和redis-cli一样切分命令行，支持单引号、双引号和转义字符，引号不匹配时返回错误
+func SplitArgs(line string) ([][]byte, error) {
+	return parser.SplitInline([]byte(line))
+}
+
+// Error 是服务端返回的错误回复
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Client 通过连接池访问simpredis，可以被多个goroutine同时使用
+type Client struct {
+	opts Options
+	pool *pool
+}
+
+func NewClient(opts *Options) *Client {
+	c := &Client{opts: *opts}
+	c.opts.init()
+	c.pool = newPool(&c.opts)
+	return c
+}
+
+// Do 发送一条命令并等待回复，参数可以是string、[]byte、整数和浮点数。
+// 服务端返回的错误会转换为Error，网络错误时会按照配置重连重试，因此非幂等的命令可能被执行多次
+func (c *Client) Do(ctx context.Context, args ...any) (parser.RespData, error) {
+	cmd, err := toArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var reply parser.RespData
+	err = c.withRetry(ctx, func(cn *conn) error {
+		replies, err := cn.roundTrip(ctx, &c.opts, [][][]byte{cmd})
+		if err != nil {
+			return err
+		}
+		reply = replies[0]
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(*parser.Error); ok {
+		return nil, Error(e.Arg)
+	}
+	return reply, nil
+}
+
+// 关闭连接池，之后的命令都会返回ErrClosed
+func (c *Client) Close() error {
+	c.pool.close()
+	return nil
+}
+
+func (c *Client) withRetry(ctx context.Context, fn func(cn *conn) error) error {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff(attempt)); err != nil {
+				return err
+			}
+		}
+		cn, err := c.pool.get(ctx)
+		if err == nil {
+			err = fn(cn)
+			c.pool.put(cn)
+		}
+		if err == nil || attempt >= c.opts.MaxRetries || !shouldRetry(err) {
+			return err
+		}
+	}
+}
+
+// 第n次重试前等待的时间，带有随机抖动，防止大量客户端同时重连
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.MinRetryBackoff
+	for i := 1; i < attempt && d < c.opts.MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > c.opts.MaxRetryBackoff {
+		d = c.opts.MaxRetryBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 只有连接断开之类的网络错误才重试，超时和服务端的错误回复不重试
+func shouldRetry(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return !ne.Timeout()
+	}
+	return false
+}
+
+func toArgs(args []any) ([][]byte, error) {
+	if len(args) == 0 {
+		return nil, errors.New("simpredis: empty command")
+	}
+	cmd := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		b := formatArg(arg)
+		if b == nil {
+			return nil, fmt.Errorf("simpredis: unsupported argument type %T", arg)
+		}
+		cmd = append(cmd, b)
+	}
+	return cmd, nil
+}
+
+// 不支持的类型返回nil
+func formatArg(arg any) []byte {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		if v == nil {
+			return []byte{}
+		}
+		return v
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case uint64:
+		return strconv.AppendUint(nil, v, 10)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', -1, 64)
+	case bool:
+		if v {
+			return []byte("1")
+		}
+		return []byte("0")
+	case fmt.Stringer:
+		return []byte(v.String())
+	}
+	return nil
+}
@@ -1,7 +1,18 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/HK40404/simpredis/redis/database"
+	parser "github.com/HK40404/simpredis/redis/resp"
+	handler "github.com/HK40404/simpredis/redis/server"
+	"github.com/HK40404/simpredis/utils/config"
 )
 
 func TestLineToArgs(t *testing.T) {
@@ -24,3 +35,255 @@ func TestLineToArgs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSplitArgs(t *testing.T) {
+	args, err := SplitArgs(`  set "hello world" 'it\'s' "\x41\n"  `)
+	if err != nil || len(args) != 4 || string(args[1]) != "hello world" || string(args[2]) != "it's" || string(args[3]) != "A\n" {
+		t.Logf("wrong args %q %v", args, err)
+		t.Fail()
+	}
+	if _, err := SplitArgs(`get "k`); err == nil {
+		t.Log("unbalanced quotes should fail")
+		t.Fail()
+	}
+}
+
+// 启动一个进程内的simpredis，返回地址和断开所有已建立连接的函数
+func startServer(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler.NewHandler(database.NewDBEngine())
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go h.Handle(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return ln.Addr().String(), func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		conns = nil
+	}
+}
+
+func TestDo(t *testing.T) {
+	addr, _ := startServer(t)
+	c := NewClient(&Options{Addr: addr})
+	defer c.Close()
+	ctx := context.Background()
+
+	if s, err := String(c.Do(ctx, "set", "k", []byte("v"))); err != nil || s != "OK" {
+		t.Logf("set: %q %v", s, err)
+		t.Fail()
+	}
+	if s, err := String(c.Do(ctx, "get", "k")); err != nil || s != "v" {
+		t.Logf("get: %q %v", s, err)
+		t.Fail()
+	}
+	if n, err := Int64(c.Do(ctx, "incrby", "n", 5)); err != nil || n != 5 {
+		t.Logf("incrby: %d %v", n, err)
+		t.Fail()
+	}
+	if f, err := Float64(c.Do(ctx, "incrbyfloat", "f", 1.5)); err != nil || f != 1.5 {
+		t.Logf("incrbyfloat: %v %v", f, err)
+		t.Fail()
+	}
+	if _, err := String(c.Do(ctx, "get", "nokey")); err != ErrNil {
+		t.Logf("want ErrNil, got %v", err)
+		t.Fail()
+	}
+	if _, err := c.Do(ctx, "lpush", "k", "x"); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Logf("want WRONGTYPE, got %v", err)
+		t.Fail()
+	}
+	c.Do(ctx, "rpush", "l", "a", "b")
+	if strs, err := Strings(c.Do(ctx, "lrange", "l", 0, -1)); err != nil || strings.Join(strs, ",") != "a,b" {
+		t.Logf("lrange: %q %v", strs, err)
+		t.Fail()
+	}
+
+	// 并发使用连接池
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Do(ctx, "incr", "counter")
+		}()
+	}
+	wg.Wait()
+	if n, err := Int64(c.Do(ctx, "get", "counter")); err != nil || n != 50 {
+		t.Logf("counter: %d %v", n, err)
+		t.Fail()
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	addr, _ := startServer(t)
+	c := NewClient(&Options{Addr: addr})
+	defer c.Close()
+
+	p := c.Pipeline()
+	for i := 0; i < 100; i++ {
+		p.Do("incr", "k")
+	}
+	p.Do("lpush", "k", "v")
+	replies, err := p.Exec(context.Background())
+	if err != nil || len(replies) != 101 {
+		t.Fatalf("exec: %d replies, %v", len(replies), err)
+	}
+	if n, _ := Int64(replies[99], nil); n != 100 {
+		t.Logf("want 100, got %d", n)
+		t.Fail()
+	}
+	if _, ok := replies[100].(*parser.Error); !ok {
+		t.Logf("want error reply, got %v", replies[100])
+		t.Fail()
+	}
+	if p.Len() != 0 {
+		t.Log("pipeline should be empty after exec")
+		t.Fail()
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	addr, disconnect := startServer(t)
+	c := NewClient(&Options{Addr: addr, PoolSize: 1})
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Do(ctx, "set", "k", "v")
+	// 连接池里的连接被服务端断开后，自动重连
+	disconnect()
+	if s, err := String(c.Do(ctx, "get", "k")); err != nil || s != "v" {
+		t.Logf("get after reconnect: %q %v", s, err)
+		t.Fail()
+	}
+
+	// 服务端不可用时重试后返回错误
+	down := NewClient(&Options{Addr: "127.0.0.1:1", MaxRetries: 2, MinRetryBackoff: time.Millisecond})
+	defer down.Close()
+	if _, err := down.Do(ctx, "ping"); err == nil {
+		t.Log("should fail to connect")
+		t.Fail()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// 只接受连接不回复
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := NewClient(&Options{Addr: ln.Addr().String(), ReadTimeout: 50 * time.Millisecond})
+	defer c.Close()
+	var ne net.Error
+	if _, err := c.Do(context.Background(), "ping"); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Logf("want timeout, got %v", err)
+		t.Fail()
+	}
+
+	c = NewClient(&Options{Addr: ln.Addr().String(), ReadTimeout: -1})
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := c.Do(ctx, "ping"); err != context.Canceled {
+		t.Logf("want canceled, got %v", err)
+		t.Fail()
+	}
+}
+
+func TestAuth(t *testing.T) {
+	old := config.Cfg.RequirePass
+	config.Cfg.RequirePass = "secret"
+	t.Cleanup(func() { config.Cfg.RequirePass = old })
+	addr, _ := startServer(t)
+	ctx := context.Background()
+
+	c := NewClient(&Options{Addr: addr, Password: "wrong", MaxRetries: -1})
+	defer c.Close()
+	if _, err := c.Do(ctx, "ping"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Logf("want WRONGPASS, got %v", err)
+		t.Fail()
+	}
+	c = NewClient(&Options{Addr: addr, Password: "secret"})
+	defer c.Close()
+	if s, err := String(c.Do(ctx, "ping")); err != nil || s != "PONG" {
+		t.Logf("ping: %q %v", s, err)
+		t.Fail()
+	}
+}
+
+// 用hello切换到RESP3之后，hgetall返回Map，smembers返回Set
+func TestResp3(t *testing.T) {
+	addr, _ := startServer(t)
+	c := NewClient(&Options{Addr: addr, Protocol: 3})
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Do(ctx, "hmset", "h", "f1", "v1", "f2", "v2")
+	reply, err := c.Do(ctx, "hgetall", "h")
+	m, ok := reply.(*parser.Map)
+	if err != nil || !ok || len(m.Keys) != 2 {
+		t.Logf("hgetall: %#v %v", reply, err)
+		t.FailNow()
+	}
+	fields := map[string]string{}
+	for i := range m.Keys {
+		fields[string(m.Keys[i].(*parser.BulkString).Arg)] = string(m.Values[i].(*parser.BulkString).Arg)
+	}
+	if fields["f1"] != "v1" || fields["f2"] != "v2" {
+		t.Logf("wrong fields %v", fields)
+		t.Fail()
+	}
+	if strs, err := Strings(c.Do(ctx, "hgetall", "h")); err != nil || len(strs) != 4 {
+		t.Logf("hgetall: %q %v", strs, err)
+		t.Fail()
+	}
+
+	c.Do(ctx, "sadd", "s", "a", "b", "c")
+	reply, err = c.Do(ctx, "smembers", "s")
+	if s, ok := reply.(*parser.Set); err != nil || !ok || len(s.Args) != 3 {
+		t.Logf("smembers: %#v %v", reply, err)
+		t.Fail()
+	}
+	if strs, err := Strings(c.Do(ctx, "smembers", "s")); err != nil || len(strs) != 3 {
+		t.Logf("smembers: %q %v", strs, err)
+		t.Fail()
+	}
+
+	c.Do(ctx, "zadd", "z", 1.5, "m")
+	if f, err := Float64(c.Do(ctx, "zscore", "z", "m")); err != nil || f != 1.5 {
+		t.Logf("zscore: %v %v", f, err)
+		t.Fail()
+	}
+	if _, err := String(c.Do(ctx, "get", "nokey")); err != ErrNil {
+		t.Logf("want ErrNil, got %v", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// 用于立即打断阻塞中的读写
+var aLongTimeAgo = time.Unix(1, 0)
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	buf     []byte // 复用的命令编码缓冲区
+	broken  bool   // 出现网络错误后连接状态未知，不能再放回连接池
+}
+
+// 建立连接，按照配置完成认证和选择数据库
+func dial(ctx context.Context, opts *Options) (*conn, error) {
+	dialer := net.Dialer{Timeout: opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{
+		netConn: netConn,
+		reader:  bufio.NewReader(netConn),
+		writer:  bufio.NewWriter(netConn),
+	}
+
+	var cmds [][][]byte
+	if opts.Password != "" {
+		cmds = append(cmds, [][]byte{[]byte("auth"), []byte(opts.Password)})
+	}
+	if opts.Protocol == 3 {
+		cmds = append(cmds, [][]byte{[]byte("hello"), []byte("3")})
+	}
+	if opts.DB != 0 {
+		cmds = append(cmds, [][]byte{[]byte("select"), formatArg(opts.DB)})
+	}
+	if len(cmds) > 0 {
+		replies, err := cn.roundTrip(ctx, opts, cmds)
+		if err == nil {
+			for _, reply := range replies {
+				if e, ok := reply.(*parser.Error); ok {
+					err = Error(e.Arg)
+					break
+				}
+			}
+		}
+		if err != nil {
+			cn.close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// 发送多条命令，并按顺序读取每条命令的回复
+func (cn *conn) roundTrip(ctx context.Context, opts *Options, cmds [][][]byte) ([]parser.RespData, error) {
+	stop := cn.watch(ctx)
+	defer stop()
+
+	if err := cn.write(ctx, opts.WriteTimeout, cmds); err != nil {
+		return nil, err
+	}
+	cn.netConn.SetReadDeadline(deadline(ctx, opts.ReadTimeout))
+	replies := make([]parser.RespData, 0, len(cmds))
+	for range cmds {
+		reply, err := parser.ReadReply(cn.reader)
+		if err != nil {
+			return nil, cn.fail(ctx, err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// 只发送命令不读取回复，用于订阅
+func (cn *conn) send(ctx context.Context, timeout time.Duration, cmds [][][]byte) error {
+	stop := cn.watch(ctx)
+	defer stop()
+	return cn.write(ctx, timeout, cmds)
+}
+
+func (cn *conn) write(ctx context.Context, timeout time.Duration, cmds [][][]byte) error {
+	// 命令较多时缓冲区写满会自动发送，所以要先设置超时
+	cn.netConn.SetWriteDeadline(deadline(ctx, timeout))
+	for _, args := range cmds {
+		cn.buf = parser.NewArray(args).AppendTo(cn.buf[:0])
+		if _, err := cn.writer.Write(cn.buf); err != nil {
+			return cn.fail(ctx, err)
+		}
+	}
+	if err := cn.writer.Flush(); err != nil {
+		return cn.fail(ctx, err)
+	}
+	return nil
+}
+
+// 读取一个回复，timeout<=0时只受ctx限制
+func (cn *conn) readReply(ctx context.Context, timeout time.Duration) (parser.RespData, error) {
+	stop := cn.watch(ctx)
+	defer stop()
+
+	cn.netConn.SetReadDeadline(deadline(ctx, timeout))
+	reply, err := parser.ReadReply(cn.reader)
+	if err != nil {
+		return nil, cn.fail(ctx, err)
+	}
+	return reply, nil
+}
+
+// ctx被取消时打断阻塞中的读写，返回的函数用于停止监听，
+// 它会等待监听的goroutine退出，保证之后不会再修改连接的超时时间
+func (cn *conn) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			cn.netConn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// 读写出错后连接中可能残留数据，标记为不可用。因为ctx取消而出错时返回ctx的错误
+func (cn *conn) fail(ctx context.Context, err error) error {
+	cn.broken = true
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (cn *conn) close() error {
+	return cn.netConn.Close()
+}
+
+// 取超时时间和ctx截止时间中较早的一个，都没有时返回零值表示不超时
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
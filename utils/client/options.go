@@ -0,0 +1,58 @@
+package client
+
+import "time"
+
+// Options 是客户端的配置，零值字段使用默认值
+type Options struct {
+	Addr     string // 默认127.0.0.1:7000
+	Password string // 不为空时建立连接后先认证
+	DB       int    // 不为0时建立连接后先select
+	Protocol int    // 为3时建立连接后先用hello切换到RESP3，默认为RESP2
+
+	DialTimeout  time.Duration // 默认5秒
+	ReadTimeout  time.Duration // 默认3秒，-1表示不超时
+	WriteTimeout time.Duration // 默认和ReadTimeout相同
+
+	PoolSize    int           // 最多同时打开的连接数，默认10
+	PoolTimeout time.Duration // 连接都在使用时等待空闲连接的时间，默认ReadTimeout+1秒
+
+	// 连接断开等网络错误时重试，每次重试前等待的时间按指数增长
+	MaxRetries      int           // 默认3，-1表示不重试
+	MinRetryBackoff time.Duration // 默认8毫秒
+	MaxRetryBackoff time.Duration // 默认512毫秒
+}
+
+func (opts *Options) init() {
+	if opts.Addr == "" {
+		opts.Addr = "127.0.0.1:7000"
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = 3 * time.Second
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = opts.ReadTimeout
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.PoolTimeout == 0 {
+		opts.PoolTimeout = time.Second
+		if opts.ReadTimeout > 0 {
+			opts.PoolTimeout += opts.ReadTimeout
+		}
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.MinRetryBackoff == 0 {
+		opts.MinRetryBackoff = 8 * time.Millisecond
+	}
+	if opts.MaxRetryBackoff == 0 {
+		opts.MaxRetryBackoff = 512 * time.Millisecond
+	}
+}
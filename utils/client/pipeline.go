@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// Pipeline 缓存多条命令，Exec时一次性发送，再按顺序读取所有回复
+type Pipeline struct {
+	client *Client
+	cmds   [][][]byte
+	err    error // 加入命令时的参数错误，在Exec时返回
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// 把命令加入队列，不会立即发送
+func (p *Pipeline) Do(args ...any) {
+	cmd, err := toArgs(args)
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return
+	}
+	p.cmds = append(p.cmds, cmd)
+}
+
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec 发送队列中的命令并清空队列，返回的回复和命令一一对应。
+// 单条命令的错误以*parser.Error的形式放在结果中，只有网络错误才会返回error
+func (p *Pipeline) Exec(ctx context.Context) ([]parser.RespData, error) {
+	cmds, err := p.cmds, p.err
+	p.cmds, p.err = nil, nil
+	if err != nil {
+		return nil, err
+	}
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	var replies []parser.RespData
+	err = p.client.withRetry(ctx, func(cn *conn) error {
+		var err error
+		replies, err = cn.roundTrip(ctx, &p.client.opts, cmds)
+		return err
+	})
+	return replies, err
+}
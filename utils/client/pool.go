@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed      = errors.New("simpredis: client is closed")
+	ErrPoolTimeout = errors.New("simpredis: connection pool timeout")
+)
+
+// 连接池，sem中的令牌数等于已经打开的连接数
+type pool struct {
+	opts *Options
+	sem  chan struct{}
+	idle chan *conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newPool(opts *Options) *pool {
+	return &pool{
+		opts: opts,
+		sem:  make(chan struct{}, opts.PoolSize),
+		idle: make(chan *conn, opts.PoolSize),
+	}
+}
+
+// 优先使用空闲连接，连接数未达到上限时新建连接，否则等待其他连接归还
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	if p.isClosed() {
+		return nil, ErrClosed
+	}
+	select {
+	case cn := <-p.idle:
+		return cn, nil
+	default:
+	}
+
+	timer := time.NewTimer(p.opts.PoolTimeout)
+	defer timer.Stop()
+	select {
+	case cn := <-p.idle:
+		return cn, nil
+	case p.sem <- struct{}{}:
+		cn, err := dial(ctx, p.opts)
+		if err != nil {
+			<-p.sem
+			return nil, err
+		}
+		return cn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, ErrPoolTimeout
+	}
+}
+
+// 归还连接，出错的连接直接关闭
+func (p *pool) put(cn *conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cn.broken || p.closed {
+		cn.close()
+		<-p.sem
+		return
+	}
+	p.idle <- cn
+}
+
+func (p *pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// 关闭空闲连接，正在使用的连接在归还时关闭
+func (p *pool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for {
+		select {
+		case cn := <-p.idle:
+			cn.close()
+			<-p.sem
+		default:
+			return
+		}
+	}
+}
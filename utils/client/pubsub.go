@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// Message 是订阅的频道收到的消息，Pattern只有通过psubscribe收到时才不为空
+type Message struct {
+	Channel string
+	Pattern string
+	Payload []byte
+}
+
+// Subscription 是订阅和取消订阅的确认，Count为当前连接订阅的频道和模式总数
+type Subscription struct {
+	Kind    string // subscribe、unsubscribe、psubscribe或punsubscribe
+	Channel string
+	Count   int64
+}
+
+// PubSub 使用一条单独的连接接收订阅消息，连接断开后会自动重连并重新订阅
+type PubSub struct {
+	client *Client
+
+	mu       sync.Mutex
+	cn       *conn
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	closed   bool
+
+	closeCh chan struct{}
+	once    sync.Once
+	msgCh   chan *Message
+}
+
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	ps := c.newPubSub()
+	if err := ps.Subscribe(ctx, channels...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (*PubSub, error) {
+	ps := c.newPubSub()
+	if err := ps.PSubscribe(ctx, patterns...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *Client) newPubSub() *PubSub {
+	return &PubSub{
+		client:   c,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		closeCh:  make(chan struct{}),
+	}
+}
+
+// 订阅结果需要通过Receive或者Channel获取
+func (ps *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return ps.subscribe(ctx, "subscribe", channels)
+}
+
+func (ps *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	return ps.subscribe(ctx, "psubscribe", patterns)
+}
+
+// 不指定频道时取消所有订阅
+func (ps *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return ps.subscribe(ctx, "unsubscribe", channels)
+}
+
+func (ps *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return ps.subscribe(ctx, "punsubscribe", patterns)
+}
+
+func (ps *PubSub) subscribe(ctx context.Context, kind string, names []string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return ErrClosed
+	}
+
+	set := ps.channels
+	if strings.HasPrefix(kind, "p") {
+		set = ps.patterns
+	}
+	if strings.Contains(kind, "unsubscribe") {
+		if len(names) == 0 {
+			for name := range set {
+				delete(set, name)
+			}
+		}
+		for _, name := range names {
+			delete(set, name)
+		}
+	} else {
+		for _, name := range names {
+			set[name] = struct{}{}
+		}
+	}
+
+	if ps.cn == nil {
+		cn, err := dial(ctx, &ps.client.opts)
+		if err != nil {
+			return err
+		}
+		ps.cn = cn
+	}
+	args := [][]byte{[]byte(kind)}
+	for _, name := range names {
+		args = append(args, []byte(name))
+	}
+	return ps.cn.send(ctx, ps.client.opts.WriteTimeout, [][][]byte{args})
+}
+
+// Receive 等待下一条消息，返回*Message、*Subscription或者其他回复，没有消息时会一直阻塞直到ctx取消
+func (ps *PubSub) Receive(ctx context.Context) (any, error) {
+	ps.mu.Lock()
+	cn, closed := ps.cn, ps.closed
+	ps.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if cn == nil {
+		return nil, Error("ERR not subscribed")
+	}
+
+	reply, err := cn.readReply(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(*parser.Error); ok {
+		return nil, Error(e.Arg)
+	}
+	return parseMessage(reply), nil
+}
+
+func parseMessage(reply parser.RespData) any {
+	var args []parser.RespData
+	switch r := reply.(type) {
+	case *parser.Array:
+		args = parser.NewBulkStrings(r.Args)
+	case *parser.MultiBulk:
+		args = r.Args
+	case *parser.Push:
+		// RESP3下订阅的确认和消息都是push
+		args = r.Args
+	default:
+		return reply
+	}
+	if len(args) < 3 {
+		return reply
+	}
+	kind, _ := String(args[0], nil)
+	switch kind {
+	case "message":
+		channel, _ := String(args[1], nil)
+		payload, _ := Bytes(args[2], nil)
+		return &Message{Channel: channel, Payload: payload}
+	case "pmessage":
+		if len(args) < 4 {
+			return reply
+		}
+		pattern, _ := String(args[1], nil)
+		channel, _ := String(args[2], nil)
+		payload, _ := Bytes(args[3], nil)
+		return &Message{Channel: channel, Pattern: pattern, Payload: payload}
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe":
+		channel, _ := String(args[1], nil)
+		count, _ := Int64(args[2], nil)
+		return &Subscription{Kind: kind, Channel: channel, Count: count}
+	}
+	return reply
+}
+
+// Channel 返回接收消息的channel，第一次调用时启动接收循环。
+// 连接断开后按照重试的退避时间重连并重新订阅，Close之后channel会被关闭
+func (ps *PubSub) Channel() <-chan *Message {
+	ps.once.Do(func() {
+		ps.msgCh = make(chan *Message, 100)
+		go ps.receiveLoop()
+	})
+	return ps.msgCh
+}
+
+func (ps *PubSub) receiveLoop() {
+	defer close(ps.msgCh)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-ps.closeCh
+		cancel()
+	}()
+
+	attempt := 0
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			if ps.isClosed() {
+				return
+			}
+			attempt++
+			if err := sleep(ctx, ps.client.backoff(attempt)); err != nil {
+				return
+			}
+			// 服务端的错误回复不需要重连
+			if _, ok := err.(Error); !ok {
+				ps.reconnect(ctx)
+			}
+			continue
+		}
+		attempt = 0
+		if m, ok := msg.(*Message); ok {
+			select {
+			case ps.msgCh <- m:
+			case <-ps.closeCh:
+				return
+			}
+		}
+	}
+}
+
+// 建立新连接并重新订阅所有频道和模式，失败时保留旧连接，下次接收失败后再重试
+func (ps *PubSub) reconnect(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, ps.client.opts.DialTimeout+time.Second)
+	defer cancel()
+	cn, err := dial(ctx, &ps.client.opts)
+	if err != nil {
+		return
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var cmds [][][]byte
+	for kind, set := range map[string]map[string]struct{}{"subscribe": ps.channels, "psubscribe": ps.patterns} {
+		if len(set) == 0 {
+			continue
+		}
+		args := [][]byte{[]byte(kind)}
+		for name := range set {
+			args = append(args, []byte(name))
+		}
+		cmds = append(cmds, args)
+	}
+	if ps.closed || (len(cmds) > 0 && cn.send(ctx, ps.client.opts.WriteTimeout, cmds) != nil) {
+		cn.close()
+		return
+	}
+	if ps.cn != nil {
+		ps.cn.close()
+	}
+	ps.cn = cn
+}
+
+func (ps *PubSub) isClosed() bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.closed
+}
+
+// 关闭连接，正在阻塞的Receive会返回错误
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return nil
+	}
+	ps.closed = true
+	close(ps.closeCh)
+	if ps.cn != nil {
+		return ps.cn.close()
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// 等待channel中的下一条消息，publish可能早于重新订阅，所以每次等待时重新publish
+func waitMessage(t *testing.T, c *Client, ch <-chan *Message, channel, payload string) *Message {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		c.Do(context.Background(), "publish", channel, payload)
+		select {
+		case m := <-ch:
+			return m
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatalf("timeout waiting for %s on %s", payload, channel)
+		}
+	}
+}
+
+func testPubSub(t *testing.T, protocol int) {
+	addr, disconnect := startServer(t)
+	ctx := context.Background()
+	c := NewClient(&Options{Addr: addr, Protocol: protocol, MinRetryBackoff: time.Millisecond})
+	defer c.Close()
+
+	ps, err := c.Subscribe(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := ps.Receive(ctx)
+	if sub, ok := msg.(*Subscription); err != nil || !ok || sub.Kind != "subscribe" || sub.Channel != "news" || sub.Count != 1 {
+		t.Logf("want subscription, got %v %v", msg, err)
+		t.Fail()
+	}
+	if err := ps.PSubscribe(ctx, "n*"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = ps.Receive(ctx)
+	if sub, ok := msg.(*Subscription); err != nil || !ok || sub.Kind != "psubscribe" || sub.Channel != "n*" || sub.Count != 2 {
+		t.Logf("want psubscription, got %v %v", msg, err)
+		t.Fail()
+	}
+
+	// 频道和模式都匹配时收到两条消息
+	if n, err := Int64(c.Do(ctx, "publish", "news", "hello")); err != nil || n != 2 {
+		t.Logf("publish: %d %v", n, err)
+		t.Fail()
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg, err := ps.Receive(ctx)
+		m, ok := msg.(*Message)
+		if err != nil || !ok || m.Channel != "news" || string(m.Payload) != "hello" {
+			t.Fatalf("want message, got %v %v", msg, err)
+		}
+		got[m.Pattern] = true
+	}
+	if !got[""] || !got["n*"] {
+		t.Logf("want message and pmessage, got %v", got)
+		t.Fail()
+	}
+
+	ch := ps.Channel()
+	if m := waitMessage(t, c, ch, "nba", "score"); m.Pattern != "n*" || m.Channel != "nba" {
+		t.Logf("wrong message %+v", m)
+		t.Fail()
+	}
+
+	// 服务端断开后自动重连并重新订阅
+	disconnect()
+	if m := waitMessage(t, c, ch, "news", "world"); string(m.Payload) != "world" {
+		t.Logf("wrong message after reconnect %+v", m)
+		t.Fail()
+	}
+
+	ps.Close()
+	select {
+	case <-ch:
+		// 关闭前可能还有没取走的消息
+		for range ch {
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("channel is not closed")
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	testPubSub(t, 2)
+}
+
+func TestPubSubResp3(t *testing.T) {
+	testPubSub(t, 3)
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	parser "github.com/HK40404/simpredis/redis/resp"
+)
+
+// ErrNil 表示回复为空，如get一个不存在的key
+var ErrNil = errors.New("simpredis: nil reply")
+
+// 以下函数把Do的结果转换为Go类型，可以直接包裹Do调用，如 client.String(c.Do(ctx, "get", "k"))
+
+func Bytes(reply parser.RespData, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch r := reply.(type) {
+	case *parser.BulkString:
+		if r.Arg == nil {
+			return nil, ErrNil
+		}
+		return r.Arg, nil
+	case *parser.String:
+		return []byte(r.Arg), nil
+	case *parser.Integer:
+		return strconv.AppendInt(nil, r.Arg, 10), nil
+	case *parser.Null:
+		return nil, ErrNil
+	case *parser.Verbatim:
+		return r.Arg, nil
+	case *parser.Double:
+		return strconv.AppendFloat(nil, r.Arg, 'f', -1, 64), nil
+	case *parser.BigNumber:
+		return r.Arg.Append(nil, 10), nil
+	}
+	return nil, fmt.Errorf("simpredis: unexpected reply type %T", reply)
+}
+
+func String(reply parser.RespData, err error) (string, error) {
+	b, err := Bytes(reply, err)
+	return string(b), err
+}
+
+func Int64(reply parser.RespData, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch r := reply.(type) {
+	case *parser.Integer:
+		return r.Arg, nil
+	case *parser.Boolean:
+		if r.Arg {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	b, err := Bytes(reply, nil)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+func Float64(reply parser.RespData, err error) (float64, error) {
+	if r, ok := reply.(*parser.Double); ok && err == nil {
+		return r.Arg, nil
+	}
+	b, err := Bytes(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// 数组中的空值转换为空字符串，RESP3的Map转换为键值交替的数组
+func Strings(reply parser.RespData, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch r := reply.(type) {
+	case *parser.Set:
+		return Strings(parser.NewMultiBulk(r.Args), nil)
+	case *parser.Map:
+		elems := make([]parser.RespData, 0, len(r.Keys)*2)
+		for i := range r.Keys {
+			elems = append(elems, r.Keys[i], r.Values[i])
+		}
+		return Strings(parser.NewMultiBulk(elems), nil)
+	case *parser.Array:
+		strs := make([]string, 0, len(r.Args))
+		for _, arg := range r.Args {
+			strs = append(strs, string(arg))
+		}
+		return strs, nil
+	case *parser.MultiBulk:
+		strs := make([]string, 0, len(r.Args))
+		for _, arg := range r.Args {
+			s, err := String(arg, nil)
+			if err != nil && err != ErrNil {
+				return nil, err
+			}
+			strs = append(strs, s)
+		}
+		return strs, nil
+	case *parser.NullArray:
+		return nil, ErrNil
+	}
+	return nil, fmt.Errorf("simpredis: unexpected reply type %T", reply)
+}
@@ -6,22 +6,61 @@ import (
 	"io"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
 type Config struct {
-	Bind       string `cfg:"bind"`
-	Port       string `cfg:"port"`
-	Logdir     string `cfg:"logdir"`
-	ShardCount string `cfg:"shardcount"`
+	Bind           string `cfg:"bind"`
+	Port           string `cfg:"port"`
+	Logdir         string `cfg:"logdir"`
+	ShardCount     string `cfg:"shardcount"`
+	Databases      string `cfg:"databases"`
+	AppendOnly     string `cfg:"appendonly"`
+	AppendFilename string `cfg:"appendfilename"`
+	AppendFsync    string `cfg:"appendfsync"`
+
+	AutoAofRewritePercentage string `cfg:"auto-aof-rewrite-percentage"`
+	AutoAofRewriteMinSize    string `cfg:"auto-aof-rewrite-min-size"`
+
+	DBFilename string `cfg:"dbfilename"`
+	Save       string `cfg:"save,multi"` // 可以出现多次，值会拼接在一起
+
+	RequirePass string `cfg:"requirepass"` // 为空时不需要认证
+
+	// 协议限制，防止恶意的长度头耗尽内存
+	ProtoMaxBulkLen        string `cfg:"proto-max-bulk-len"`
+	MaxMultibulkLen        string `cfg:"max-multibulk-len"`
+	ClientQueryBufferLimit string `cfg:"client-query-buffer-limit"`
 }
 
 // 提供默认配置，应对无配置文件的情况
-var Cfg = &Config{Bind: "0.0.0.0", Port: "7000", Logdir: "logs", ShardCount: "16"}
+var Cfg = &Config{
+	Bind:           "0.0.0.0",
+	Port:           "7000",
+	Logdir:         "logs",
+	ShardCount:     "16",
+	Databases:      "16",
+	AppendOnly:     "no",
+	AppendFilename: "appendonly.aof",
+	AppendFsync:    "everysec",
+
+	AutoAofRewritePercentage: "100",
+	AutoAofRewriteMinSize:    "64mb",
+
+	DBFilename: "dump.rdb",
+	Save:       "3600 1 300 100 60 10000",
+
+	RequirePass: "",
+
+	ProtoMaxBulkLen:        "512mb",
+	MaxMultibulkLen:        "1048576",
+	ClientQueryBufferLimit: "1gb",
+}
 
 // 自动parse
 func parse(file io.Reader) error {
-	cfgmap := make(map[string]string)
+	cfgmap := make(map[string][]string)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -36,7 +75,7 @@ func parse(file io.Reader) error {
 		}
 		key := line[:pivot]
 		value := strings.TrimLeft(line[pivot+1:], " ")
-		cfgmap[key] = value
+		cfgmap[key] = append(cfgmap[key], value)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -53,13 +92,45 @@ func parse(file io.Reader) error {
 		if !ok {
 			key = filed.Name
 		}
-		if v, ok := cfgmap[key]; ok {
-			filedVal.SetString(v)
+		key, option, _ := strings.Cut(key, ",")
+		if values, ok := cfgmap[key]; ok {
+			if option == "multi" {
+				filedVal.SetString(strings.Join(values, " "))
+			} else {
+				// 重复的配置以最后一个为准
+				filedVal.SetString(values[len(values)-1])
+			}
 		}
 	}
 	return nil
 }
 
+// 解析带单位的内存大小，如 1k、64mb、1gb，不区分大小写
+func ParseMemory(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	mul := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSuffix(s, u.suffix)
+			mul = u.mul
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory size")
+	}
+	return n * mul, nil
+}
+
 func LoadConfig(cfgpath string) error {
 	cfgFile, err := os.Open(cfgpath)
 	if err != nil {
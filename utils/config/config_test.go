@@ -18,6 +18,8 @@ func TestLoadConfig(t *testing.T) {
     bind 1.2.3.4
     port             10086
     shardcount 19
+    save 900 1
+    save 300 10
     `
 	_, err = tmpfile.Write([]byte(cfgstr))
 	if err != nil {
@@ -31,8 +33,30 @@ func TestLoadConfig(t *testing.T) {
 	}
 
 	LoadConfig(tmpfile.Name())
-	if Cfg.Bind != "1.2.3.4" || Cfg.Port != "10086" || Cfg.Logdir != "logs" || Cfg.ShardCount != "19" {
+	if Cfg.Bind != "1.2.3.4" || Cfg.Port != "10086" || Cfg.Logdir != "logs" || Cfg.ShardCount != "19" ||
+		Cfg.Save != "900 1 300 10" {
 		t.Logf("parsed content not match, parsed content: %+v", *Cfg)
 		t.Fail()
 	}
 }
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{
+		"100":  100,
+		"1k":   1000,
+		"1kb":  1024,
+		"64mb": 64 * 1024 * 1024,
+		"2GB":  2 * 1024 * 1024 * 1024,
+		"1m":   1000 * 1000,
+	}
+	for s, want := range cases {
+		got, err := ParseMemory(s)
+		if err != nil || got != want {
+			t.Logf("ParseMemory(%s) = %d, %v, want %d", s, got, err, want)
+			t.Fail()
+		}
+	}
+	if _, err := ParseMemory("12xb"); err == nil {
+		t.Fail()
+	}
+}
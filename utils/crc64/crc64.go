@@ -0,0 +1,32 @@
+package crc64
+
+// Redis使用的crc-64-jones算法，RDB文件和DUMP的校验和都基于它
+// 参数：poly = 0xad93d23594c935a9，输入输出反转，初始值和输出异或值都为0
+const reflectedPoly = 0x95ac9329ac4bc9b5
+
+var table [256]uint64
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = (crc >> 1) ^ reflectedPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+}
+
+func Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = table[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+func Checksum(p []byte) uint64 {
+	return Update(0, p)
+}
@@ -0,0 +1,17 @@
+package crc64
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// redis源码crc64.c中的测试用例
+	if v := Checksum([]byte("123456789")); v != 0xe9c6d914c4b8d9ca {
+		t.Logf("crc64 of 123456789 should be e9c6d914c4b8d9ca, got %x", v)
+		t.Fail()
+	}
+
+	data := []byte("This is a test of the emergency broadcast system.")
+	if v := Update(Checksum(data[:10]), data[10:]); v != Checksum(data) {
+		t.Log("incremental update should equal to whole checksum")
+		t.Fail()
+	}
+}
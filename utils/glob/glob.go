@@ -0,0 +1,96 @@
+package glob
+
+// Match 判断str是否匹配glob风格的pattern，规则和redis的keys相同：
+// * 匹配任意多个字符，? 匹配一个字符，[abc]、[a-z]、[^a] 匹配字符集合，\ 转义下一个字符
+func Match(pattern, str string) bool {
+	p, s := 0, 0
+	// 最近一个*之后的pattern位置和*匹配到的str位置。
+	// 之后的匹配失败时只需要让这个*多匹配一个字符，不需要回溯更早的*，时间为O(len(pattern)*len(str))
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				// 连续的*等价于一个
+				for p+1 < len(pattern) && pattern[p+1] == '*' {
+					p++
+				}
+				p++
+				starP, starS = p, s
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if end, matched := matchClass(pattern, p+1, str[s]); matched {
+					p = end + 1
+					s++
+					continue
+				}
+			case '\\':
+				q := p
+				if p+1 < len(pattern) {
+					q++
+				}
+				if pattern[q] == str[s] {
+					p = q + 1
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, s = starP, starS
+	}
+	// str已经匹配完，剩下的pattern只能是*
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// 从[之后开始匹配字符集合，返回]的位置。没有]时集合一直延续到pattern末尾
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+	matched := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			p += 2
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+		}
+	}
+	if p == len(pattern) {
+		// 和redis一样，缺少]时把最后一个字符当作]
+		p--
+	}
+	return p, matched != not
+}
@@ -0,0 +1,65 @@
+package glob
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hellox", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"user:*:name", "user:1000:name", true},
+		{"user:*:name", "user:1000:age", false},
+		{"**a", "bba", true},
+		{"[abc", "b", true},
+		{"ab[", "ab", false},
+		{"", "", true},
+		{"", "a", false},
+		{"*a*b", "aaab", true},
+		{"*a*b", "aaba", false},
+		{"a*b*c", "abxbxc", true},
+		{"*?", "", false},
+		{"h*\\", "hi\\", true},
+		{"*[", "a", false},
+	}
+	for _, c := range cases {
+		if got := Match(c.pattern, c.str); got != c.want {
+			t.Logf("Match(%q, %q): want %v, got %v", c.pattern, c.str, c.want, got)
+			t.Fail()
+		}
+	}
+}
+
+// 多个*时不能回溯所有组合，否则匹配时间随*的数量指数增长
+func TestMatchManyStars(t *testing.T) {
+	pattern := strings.Repeat("*a", 10) + "*b"
+	str := strings.Repeat("a", 40)
+	start := time.Now()
+	if Match(pattern, str) || !Match(pattern, str+"b") {
+		t.Fail()
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Logf("match took %v", d)
+		t.Fail()
+	}
+}
@@ -1,5 +1,7 @@
 package hash
 
+import "encoding/binary"
+
 const (
 	prime = uint32(16777619)
 )
@@ -12,3 +14,47 @@ func Fnv(key string) uint32 {
 	}
 	return uint32(hash)
 }
+
+const (
+	offset64 = uint64(14695981039346656037)
+	prime64  = uint64(1099511628211)
+)
+
+// 64位FNV-1a，冲突比Fnv少，用于按哈希值顺序遍历key
+func Fnv64(key string) uint64 {
+	hash := offset64
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= prime64
+	}
+	return hash
+}
+
+// MurmurHash64A，按小端读取8字节的块，和redis的HyperLogLog使用同样的哈希
+func MurmurHash64A(key []byte, seed uint64) uint64 {
+	const (
+		m = uint64(0xc6a4a7935bd1e995)
+		r = 47
+	)
+	h := seed ^ (uint64(len(key)) * m)
+	n := len(key) / 8 * 8
+	for i := 0; i < n; i += 8 {
+		k := binary.LittleEndian.Uint64(key[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+	tail := key[n:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -10,7 +10,8 @@ import (
 	"time"
 )
 
-var logger *log.Logger
+// Init之前先输出到标准输出
+var logger = log.New(os.Stdout, "[SIMP REDIS]", log.LstdFlags)
 var once sync.Once
 
 func FileExists(path string) bool {
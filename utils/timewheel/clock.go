@@ -0,0 +1,116 @@
+package timewheel
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 提供当前时间和周期通知，测试时可以用FakeClock手动推进时间
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// RealClock 使用系统时间
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t *realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// FakeClock 只有调用Advance时时间才会前进
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// 不论周期是多少，每次Advance都通知一次，接收方根据Now计算经过的时间
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{c: make(chan time.Time), done: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// Advance 把时间推进d并通知所有ticker，返回时接收方已经处理完这次通知
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	tickers := c.tickers[:0]
+	for _, t := range c.tickers {
+		if !t.stopped() {
+			tickers = append(tickers, t)
+		}
+	}
+	c.tickers = tickers
+	tickers = append([]*fakeTicker(nil), tickers...)
+	c.mu.Unlock()
+
+	for _, t := range tickers {
+		// 通道没有缓冲，接收方处理完上一次通知才会接收下一次，所以第二次发送返回时第一次已经处理完
+		t.send(now)
+		t.send(now)
+	}
+}
+
+type fakeTicker struct {
+	c    chan time.Time
+	done chan struct{}
+	once sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *fakeTicker) stopped() bool {
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *fakeTicker) send(now time.Time) {
+	select {
+	case t.c <- now:
+	case <-t.done:
+	}
+}
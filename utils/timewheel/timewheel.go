@@ -6,153 +6,200 @@ import (
 	"time"
 )
 
+// TimeWheel 是分层的时间轮，由使用者创建和启动，不同的实例互不影响。
+// 最底层每格为一个interval，一圈为1秒，往上三层分别以秒、分钟、小时为一格。
+// 任务先放在能容纳它的最低一层，上层的格子到期时把其中的任务重新放到下层，添加和删除都是O(1)的
 type TimeWheel struct {
 	interval time.Duration
-	slotnum  int
-	hand     int // 指针
-	ticker   *time.Ticker
-	slots    []*list.List
-	tasks    map[string]*Location // 保存列表元素位置
-
-	closeCh      chan struct{}
-	addtaskCh    chan *Task
-	removetaskCh chan string
+	clock    Clock
+	start    time.Time // 第0个tick的时间
+	levels   []*level
+
+	mu      sync.Mutex
+	current int64 // 已经走过的tick数
+	tasks   map[string]*task
+	stopCh  chan struct{} // 为nil时表示没有运行
+	doneCh  chan struct{}
 }
 
-type Location struct {
-	slotIndex int
-	ele       *list.Element
+type level struct {
+	span  int64 // 每一格的tick数
+	slots []*list.List
 }
 
-type Task struct {
-	key      string
-	interval time.Duration // 延迟的时间
-	pos      int           // 在时间轮盘上的位置
-	circle   int           // 需要在时间轮盘上走的圈数
-	job      func()
+type task struct {
+	key    string
+	expire int64 // 到期的tick
+	job    func()
+	slot   *list.List
+	ele    *list.Element
 }
 
-var once sync.Once
-var Tw *TimeWheel
-
-func InitTimeWheel(interval time.Duration, slotnum int) *TimeWheel {
-	once.Do(func() {
-		Tw = &TimeWheel{
-			interval: interval,
-			slotnum:  slotnum,
-			hand:     0,
-			slots:    make([]*list.List, slotnum),
-			tasks:    make(map[string]*Location),
-		}
-		for i := 0; i < slotnum; i++ {
-			Tw.slots[i] = &list.List{}
+// New 创建一个精度为interval的时间轮，需要调用Start才会开始走
+func New(interval time.Duration, clock Clock) *TimeWheel {
+	perSecond := int(time.Second / interval)
+	if perSecond < 1 {
+		perSecond = 1
+	}
+	tw := &TimeWheel{
+		interval: interval,
+		clock:    clock,
+		start:    clock.Now(),
+		tasks:    make(map[string]*task),
+	}
+	span := int64(1)
+	for _, n := range []int{perSecond, 60, 60, 24} {
+		lv := &level{span: span, slots: make([]*list.List, n)}
+		for i := range lv.slots {
+			lv.slots[i] = list.New()
 		}
-	})
-	return Tw
+		tw.levels = append(tw.levels, lv)
+		span *= int64(n)
+	}
+	return tw
 }
 
-func (Tw *TimeWheel) Start() {
-	Tw.ticker = time.NewTicker(Tw.interval)
-	Tw.closeCh = make(chan struct{})
-	Tw.addtaskCh = make(chan *Task)
-	Tw.removetaskCh = make(chan string)
-	go Tw.run()
+// Start 开始走动，已经在运行时不做任何事
+func (tw *TimeWheel) Start() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopCh != nil {
+		return
+	}
+	tw.stopCh = make(chan struct{})
+	tw.doneCh = make(chan struct{})
+	go tw.run(tw.clock.NewTicker(tw.interval), tw.stopCh, tw.doneCh)
 }
 
-func (Tw *TimeWheel) Stop() {
-	Tw.closeCh <- struct{}{}
+// Stop 停止走动并等待正在执行的任务结束，不能在任务中调用。
+// 没有到期的任务会保留，再次Start后停止期间到期的任务会立即执行
+func (tw *TimeWheel) Stop() {
+	tw.mu.Lock()
+	if tw.stopCh == nil {
+		tw.mu.Unlock()
+		return
+	}
+	close(tw.stopCh)
+	done := tw.doneCh
+	tw.stopCh, tw.doneCh = nil, nil
+	tw.mu.Unlock()
+	<-done
 }
 
-func (Tw *TimeWheel) run() {
+func (tw *TimeWheel) run(ticker Ticker, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-Tw.ticker.C:
-			Tw.movehand()
-		case task := <-Tw.addtaskCh:
-			Tw.addTask(task)
-		case key := <-Tw.removetaskCh:
-			Tw.removeTask(key)
-		case <-Tw.closeCh:
-			Tw.ticker.Stop()
+		case <-ticker.C():
+			tw.advance()
+		case <-stopCh:
 			return
 		}
 	}
 }
 
-func (Tw *TimeWheel) movehand() {
-	Tw.hand++
-	if Tw.hand == Tw.slotnum {
-		Tw.hand = 0
+// 走到当前时间对应的tick，然后在锁外执行到期的任务，任务中可以添加新的任务
+func (tw *TimeWheel) advance() {
+	tw.mu.Lock()
+	target := int64(tw.clock.Now().Sub(tw.start) / tw.interval)
+	var due []*task
+	for tw.current < target {
+		due = append(due, tw.tick()...)
 	}
-	l := Tw.slots[Tw.hand]
-
-	for item := l.Front(); item != nil; {
-		task, _ := item.Value.(*Task)
-		if task.circle > 0 {
-			task.circle--
-			item = item.Next()
-			continue
-		}
+	tw.mu.Unlock()
 
-		task.job()
-		delete(Tw.tasks, task.key)
-		next := item.Next()
-		l.Remove(item)
-		item = next
+	for _, t := range due {
+		t.job()
 	}
 }
 
-func (Tw *TimeWheel) addTask(task *Task) {
-	delaySec := int(task.interval.Seconds())
-	intervalSec := int(Tw.interval.Seconds())
-	task.circle = delaySec / (intervalSec * Tw.slotnum)
-	task.pos = (Tw.hand + delaySec/intervalSec) % Tw.slotnum
-
-	if task.pos == Tw.hand {
-		if task.circle > 0 {
-			// 已经走过当前位置了,circle要减1
-			task.circle--
-		} else {
-			// 最小延时时间为1个interval
-			task.pos++
+// 走一格：先把上层到期的格子放到下层，再取出最底层当前格子中的任务
+func (tw *TimeWheel) tick() []*task {
+	tw.current++
+	for _, lv := range tw.levels[1:] {
+		if tw.current%lv.span != 0 {
+			break
+		}
+		slot := lv.slots[(tw.current/lv.span)%int64(len(lv.slots))]
+		for e := slot.Front(); e != nil; {
+			next := e.Next()
+			slot.Remove(e)
+			tw.place(e.Value.(*task))
+			e = next
 		}
 	}
 
-	ele := Tw.slots[task.pos].PushBack(task)
-	Tw.tasks[task.key] = &Location{
-		ele:       ele,
-		slotIndex: task.pos,
+	lv := tw.levels[0]
+	slot := lv.slots[tw.current%int64(len(lv.slots))]
+	due := make([]*task, 0, slot.Len())
+	for e := slot.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*task)
+		delete(tw.tasks, t.key)
+		due = append(due, t)
 	}
+	slot.Init()
+	return due
 }
 
-func (Tw *TimeWheel) AddTask(key string, delay time.Duration, job func()) {
-	if delay < 0 {
+// 放到能容纳剩余时间的最低一层，超过最高一层范围的任务先放到最远的格子，到时再重新放置
+func (tw *TimeWheel) place(t *task) {
+	d := t.expire - tw.current
+	for i, lv := range tw.levels {
+		n := int64(len(lv.slots))
+		if d >= lv.span*n && i < len(tw.levels)-1 {
+			continue
+		}
+		target := t.expire
+		if d >= lv.span*n {
+			target = tw.current + lv.span*(n-1)
+		}
+		t.slot = lv.slots[(target/lv.span)%n]
+		t.ele = t.slot.PushBack(t)
 		return
 	}
-	Tw.addtaskCh <- &Task{
-		key:      key,
-		interval: delay,
-		job:      job,
-	}
 }
 
-func (Tw *TimeWheel) removeTask(key string) {
-	v, ok := Tw.tasks[key]
-	if !ok {
-		return
+// AddTask 在delay之后执行job，key相同的任务会被替换。任务不会提前执行，最多延迟一个interval
+func (tw *TimeWheel) AddTask(key string, delay time.Duration, job func()) {
+	if delay < 0 {
+		delay = 0
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.removeTask(key)
+
+	// 从当前时间开始计算并向上取整
+	elapsed := tw.clock.Now().Sub(tw.start) + delay
+	expire := int64((elapsed + tw.interval - 1) / tw.interval)
+	if expire <= tw.current {
+		expire = tw.current + 1
 	}
-	Tw.slots[v.slotIndex].Remove(v.ele)
-	delete(Tw.tasks, key)
+	t := &task{key: key, expire: expire, job: job}
+	tw.place(t)
+	tw.tasks[key] = t
 }
 
-func (Tw *TimeWheel) RemoveTask(key string) {
-	if key == "" {
-		return
+// RemoveTask 删除还没有执行的任务，任务不存在时返回false
+func (tw *TimeWheel) RemoveTask(key string) bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.removeTask(key)
+}
+
+func (tw *TimeWheel) removeTask(key string) bool {
+	t, ok := tw.tasks[key]
+	if !ok {
+		return false
 	}
-	Tw.removetaskCh <- key
+	t.slot.Remove(t.ele)
+	delete(tw.tasks, key)
+	return true
 }
 
-func init() {
-	InitTimeWheel(time.Second, 60)
-	Tw.Start()
+// Len 返回还没有执行的任务数量
+func (tw *TimeWheel) Len() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return len(tw.tasks)
 }
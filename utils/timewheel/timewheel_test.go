@@ -6,51 +6,171 @@ import (
 	"time"
 )
 
+// 记录执行过的任务
+type recorder struct {
+	mu   sync.Mutex
+	done []string
+}
+
+func (r *recorder) job(key string) func() {
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.done = append(r.done, key)
+	}
+}
+
+func (r *recorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	done := r.done
+	r.done = nil
+	return done
+}
+
+func newTestWheel() (*TimeWheel, *FakeClock) {
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	tw := New(10*time.Millisecond, clock)
+	tw.Start()
+	return tw, clock
+}
+
 func TestTimeWheel(t *testing.T) {
+	tw, clock := newTestWheel()
+	defer tw.Stop()
+	r := &recorder{}
 
-	counter := 0
+	// 覆盖每一层以及超过最高一层范围的任务
+	delays := map[string]time.Duration{
+		"15ms": 15 * time.Millisecond,
+		"1s":   time.Second,
+		"61s":  61 * time.Second,
+		"2h":   2 * time.Hour,
+		"30h":  30 * time.Hour,
+	}
+	for key, delay := range delays {
+		tw.AddTask(key, delay, r.job(key))
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(3)
-	job1 := func() {
-		defer wg.Done()
-		if counter < 1 {
-			t.Log("job1", counter)
+	elapsed := time.Duration(0)
+	for _, key := range []string{"15ms", "1s", "61s", "2h", "30h"} {
+		// 到期前一刻不会执行
+		step := delays[key] - elapsed - time.Millisecond
+		clock.Advance(step)
+		elapsed += step
+		if done := r.take(); len(done) != 0 {
+			t.Logf("%v should not run before %s", done, key)
 			t.Fail()
 		}
-		// t.Log("Job1 has been done!")
-	}
-	job5 := func() {
-		defer wg.Done()
-		if counter < 5 {
-			t.Log("job1", counter)
+		clock.Advance(10 * time.Millisecond)
+		elapsed += 10 * time.Millisecond
+		if done := r.take(); len(done) != 1 || done[0] != key {
+			t.Logf("want %s, got %v", key, done)
 			t.Fail()
 		}
-		// t.Log("Job5 has been done!")
 	}
-	job11 := func() {
-		defer wg.Done()
-		if counter < 11 {
-			t.Log("job1", counter)
-			t.Fail()
-		}
-		// t.Log("Job11 has been done!")
+	if tw.Len() != 0 {
+		t.Fail()
 	}
-	job3 := func() {
+}
+
+func TestTimeWheelRemoveAndReplace(t *testing.T) {
+	tw, clock := newTestWheel()
+	defer tw.Stop()
+	r := &recorder{}
+
+	tw.AddTask("a", time.Second, r.job("a"))
+	tw.AddTask("b", time.Second, r.job("b"))
+	if !tw.RemoveTask("a") || tw.RemoveTask("a") {
+		t.Fail()
+	}
+	// 相同的key替换原来的任务
+	tw.AddTask("b", 2*time.Second, r.job("b2"))
+	clock.Advance(1500 * time.Millisecond)
+	if done := r.take(); len(done) != 0 {
+		t.Logf("got %v", done)
+		t.Fail()
+	}
+	clock.Advance(time.Second)
+	if done := r.take(); len(done) != 1 || done[0] != "b2" {
+		t.Logf("got %v", done)
 		t.Fail()
 	}
 
-	go func() {
-		for range time.NewTicker(time.Second).C {
-			counter++
-			// t.Log(counter)
+	// 任务中可以添加新的任务
+	tw.AddTask("outer", 50*time.Millisecond, func() {
+		tw.AddTask("inner", 50*time.Millisecond, r.job("inner"))
+	})
+	clock.Advance(60 * time.Millisecond)
+	clock.Advance(60 * time.Millisecond)
+	if done := r.take(); len(done) != 1 || done[0] != "inner" {
+		t.Logf("got %v", done)
+		t.Fail()
+	}
+}
+
+func TestTimeWheelStopAndRestart(t *testing.T) {
+	tw, clock := newTestWheel()
+	r := &recorder{}
+	tw.AddTask("a", time.Second, r.job("a"))
+
+	tw.Stop()
+	tw.Stop()
+	clock.Advance(2 * time.Second)
+	if done := r.take(); len(done) != 0 {
+		t.Log("stopped wheel should not run tasks")
+		t.Fail()
+	}
+
+	// 重新开始后补上停止期间到期的任务
+	tw.Start()
+	defer tw.Stop()
+	clock.Advance(0)
+	if done := r.take(); len(done) != 1 || done[0] != "a" {
+		t.Logf("got %v", done)
+		t.Fail()
+	}
+}
+
+// 不同的实例互不影响
+func TestTimeWheelInstances(t *testing.T) {
+	tw1, clock1 := newTestWheel()
+	defer tw1.Stop()
+	tw2, clock2 := newTestWheel()
+	defer tw2.Stop()
+	r1, r2 := &recorder{}, &recorder{}
+
+	tw1.AddTask("k", 100*time.Millisecond, r1.job("k"))
+	tw2.AddTask("k", 100*time.Millisecond, r2.job("k"))
+	clock1.Advance(time.Second)
+	if len(r1.take()) != 1 || len(r2.take()) != 0 {
+		t.Fail()
+	}
+	clock2.Advance(time.Second)
+	if len(r2.take()) != 1 {
+		t.Fail()
+	}
+}
+
+// 使用系统时钟时任务不会提前执行
+func TestTimeWheelRealClock(t *testing.T) {
+	tw := New(5*time.Millisecond, RealClock{})
+	tw.Start()
+	defer tw.Stop()
+
+	start := time.Now()
+	done := make(chan time.Duration, 1)
+	tw.AddTask("k", 30*time.Millisecond, func() {
+		done <- time.Since(start)
+	})
+	select {
+	case elapsed := <-done:
+		if elapsed < 30*time.Millisecond {
+			t.Logf("task runs too early: %v", elapsed)
+			t.Fail()
 		}
-	}()
-	Tw.AddTask("1", 1*time.Second, job1)
-	Tw.AddTask("3", 3*time.Second, job3)
-	Tw.AddTask("5", 5*time.Second, job5)
-	Tw.AddTask("11", 11*time.Second, job11)
-	Tw.RemoveTask("3")
-
-	wg.Wait()
+	case <-time.After(time.Second):
+		t.Log("task does not run")
+		t.Fail()
+	}
 }